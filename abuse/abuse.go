@@ -0,0 +1,145 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package abuse
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Scopes of the subjects of failures.
+const (
+	// ScopeKey is the scope of public keys.
+	ScopeKey = "key"
+	// ScopeIP is the scope of client IPs.
+	ScopeIP = "ip"
+	// ScopeSubnet is the scope of the /24 network of IPv4 clients and the
+	// /48 network of IPv6 clients.
+	ScopeSubnet = "subnet"
+)
+
+// Policy configures the delays and lockouts of a Detector.
+type Policy struct {
+	// Window is how long failures are counted.
+	Window time.Duration
+	// KeyFailures, IPFailures and SubnetFailures are the numbers of
+	// failures in the Window that lock a subject of the scope out.
+	KeyFailures    int
+	IPFailures     int
+	SubnetFailures int
+	// Delay is the delay after the first failure of a key or IP. It doubles
+	// with each further failure in the Window up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Lockout is the duration of the first lockout of a subject. It
+	// doubles with each consecutive lockout up to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Trust is how long the client IP of a successful login remains a
+	// trusted context of the key.
+	Trust time.Duration
+}
+
+// DefaultPolicy is the default Policy.
+var DefaultPolicy = Policy{
+	Window:         15 * time.Minute,
+	KeyFailures:    10,
+	IPFailures:     20,
+	SubnetFailures: 100,
+	Delay:          time.Second,
+	MaxDelay:       30 * time.Second,
+	Lockout:        5 * time.Minute,
+	MaxLockout:     24 * time.Hour,
+	Trust:          30 * 24 * time.Hour,
+}
+
+// Validate checks that the policy counts failures and limits its delays and
+// lockouts.
+func (p Policy) Validate() error {
+	switch {
+	case p.Window <= 0:
+		return errors.New("window must be positive")
+	case p.KeyFailures <= 0 || p.IPFailures <= 0 || p.SubnetFailures <= 0:
+		return errors.New("failure thresholds must be positive")
+	case p.Delay < 0 || p.MaxDelay < p.Delay:
+		return errors.New("delay must not be negative or exceed the max delay")
+	case p.Lockout <= 0 || p.MaxLockout < p.Lockout:
+		return errors.New("lockout must be positive and not exceed the max lockout")
+	case p.Trust < 0:
+		return errors.New("trust must not be negative")
+	}
+	return nil
+}
+
+// threshold returns the number of failures that lock a subject of scope
+// out.
+func (p Policy) threshold(scope string) int {
+	switch scope {
+	case ScopeKey:
+		return p.KeyFailures
+	case ScopeIP:
+		return p.IPFailures
+	default:
+		return p.SubnetFailures
+	}
+}
+
+// delay returns the delay after n failures in the window.
+func (p Policy) delay(n int) time.Duration {
+	return backoff(p.Delay, p.MaxDelay, n)
+}
+
+// lockout returns the duration of the n-th consecutive lockout.
+func (p Policy) lockout(n int) time.Duration {
+	return backoff(p.Lockout, p.MaxLockout, n)
+}
+
+// backoff returns base doubled n-1 times, but at most max.
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// Decision is the decision of a Detector on a login attempt.
+type Decision struct {
+	// Allowed reports whether the attempt may be made.
+	Allowed bool
+	// Scope is the scope of the subject that is delayed or locked out if
+	// the attempt is not allowed.
+	Scope string
+	// Locked reports whether the subject is locked out rather than
+	// delayed.
+	Locked bool
+	// RetryAfter is how long it takes until the attempt is allowed.
+	RetryAfter time.Duration
+}
+
+// Lockout is an active lockout of a subject.
+type Lockout struct {
+	Scope   string    `json:"scope"`
+	Subject string    `json:"subject"`
+	Until   time.Time `json:"until"`
+	// Count is the number of consecutive lockouts of the subject.
+	Count int `json:"count"`
+}
+
+// Subnet returns the subnet of the IP address ip, or an empty string if ip
+// is not an IP address.
+func Subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
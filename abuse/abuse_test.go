@@ -0,0 +1,49 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package abuse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate())
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*Policy)
+		err    string
+	}{
+		{"no window", func(p *Policy) { p.Window = 0 }, "window must be positive"},
+		{"no threshold", func(p *Policy) { p.SubnetFailures = 0 }, "failure thresholds must be positive"},
+		{"delay over max", func(p *Policy) { p.Delay = time.Minute }, "delay must not be negative or exceed the max delay"},
+		{"no lockout", func(p *Policy) { p.Lockout = 0 }, "lockout must be positive and not exceed the max lockout"},
+		{"negative trust", func(p *Policy) { p.Trust = -time.Hour }, "trust must not be negative"},
+	} {
+		p := DefaultPolicy
+		tt.modify(&p)
+		assert.EqualError(t, p.Validate(), tt.err, tt.name)
+	}
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	p := DefaultPolicy
+	for n, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second} {
+		assert.Equal(t, delay, p.delay(n+1), "failure %d", n+1)
+	}
+	assert.Equal(t, 5*time.Minute, p.lockout(1))
+	assert.Equal(t, 10*time.Minute, p.lockout(2))
+	assert.Equal(t, 24*time.Hour, p.lockout(100))
+}
+
+func TestSubnet(t *testing.T) {
+	assert.Equal(t, "203.0.113.0/24", Subnet("203.0.113.7"))
+	assert.Equal(t, "203.0.113.0/24", Subnet("::ffff:203.0.113.7"))
+	assert.Equal(t, "2001:db8:1::/48", Subnet("2001:db8:1:2::1"))
+	assert.Empty(t, Subnet("unknown"))
+	assert.Empty(t, Subnet(""))
+}
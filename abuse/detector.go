@@ -0,0 +1,252 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package abuse
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/logging"
+)
+
+const (
+	// sweepInterval is how often Detector removes expired records.
+	sweepInterval = time.Minute
+	// maxTrusted is the maximum number of trusted IPs of a key. The IP
+	// trusted for the shortest time is replaced first.
+	maxTrusted = 8
+)
+
+var abuseLog = logging.Component("abuse")
+
+// Detector tracks failed logins in memory. Its state is not shared between
+// processes.
+type Detector struct {
+	mu        sync.Mutex
+	records   map[subject]*record
+	trusted   map[string]map[string]time.Time
+	lastSweep time.Time
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// subject is a key, IP or subnet.
+type subject struct {
+	scope string
+	value string
+}
+
+// record is the state of a subject. A record that is not updated until
+// expires is removed, which also resets the count of consecutive lockouts.
+type record struct {
+	failures     []time.Time
+	delayedUntil time.Time
+	lockedUntil  time.Time
+	lockouts     int
+	expires      time.Time
+}
+
+// NewDetector returns a Detector without failures.
+func NewDetector() *Detector {
+	return &Detector{
+		records: make(map[subject]*record),
+		trusted: make(map[string]map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// subjects returns the subjects of a login attempt for key from ip that are
+// known.
+func subjects(key, ip string) []subject {
+	var s []subject
+	for _, sub := range []subject{{ScopeKey, key}, {ScopeIP, ip}, {ScopeSubnet, Subnet(ip)}} {
+		if sub.value != "" {
+			s = append(s, sub)
+		}
+	}
+	return s
+}
+
+// Check returns whether a login attempt for key from ip is allowed. The
+// failures of key are ignored if ip is a trusted context of key.
+func (d *Detector) Check(key, ip string) Decision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+
+	decision := Decision{Allowed: true}
+	for _, sub := range subjects(key, ip) {
+		if sub.scope == ScopeKey && d.isTrusted(key, ip, now) {
+			continue
+		}
+		r, ok := d.records[sub]
+		if !ok {
+			continue
+		}
+
+		until, locked := r.delayedUntil, false
+		if r.lockedUntil.After(now) {
+			until, locked = r.lockedUntil, true
+		}
+		if retryAfter := until.Sub(now); retryAfter > decision.RetryAfter {
+			decision = Decision{Scope: sub.scope, Locked: locked, RetryAfter: retryAfter}
+		}
+	}
+	return decision
+}
+
+// Failure records a failed login attempt for key from ip with the policy p
+// and returns the lockouts it caused.
+func (d *Detector) Failure(p Policy, key, ip string) []Lockout {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+
+	var lockouts []Lockout
+	for _, sub := range subjects(key, ip) {
+		r, ok := d.records[sub]
+		if !ok || !now.Before(r.expires) {
+			r = &record{}
+			d.records[sub] = r
+		}
+
+		failures := r.failures[:0]
+		for _, t := range r.failures {
+			if now.Sub(t) < p.Window {
+				failures = append(failures, t)
+			}
+		}
+		r.failures = append(failures, now)
+		r.expires = now.Add(p.Window)
+
+		if len(r.failures) >= p.threshold(sub.scope) {
+			r.lockouts++
+			lockout := p.lockout(r.lockouts)
+			r.lockedUntil = now.Add(lockout)
+			r.failures = nil
+			if expires := r.lockedUntil.Add(lockout); expires.After(r.expires) {
+				r.expires = expires
+			}
+			lockouts = append(lockouts, Lockout{Scope: sub.scope, Subject: sub.value, Until: r.lockedUntil, Count: r.lockouts})
+			continue
+		}
+		if sub.scope != ScopeSubnet {
+			// the subnets of clients are shared, so only their lockouts
+			// apply
+			r.delayedUntil = now.Add(p.delay(len(r.failures)))
+		}
+	}
+	return lockouts
+}
+
+// Success records a successful login for key from ip with the policy p. It
+// clears the failures and lockouts of key and trusts ip as a context of key.
+// The failures of ip are kept, as they may be for other keys.
+func (d *Detector) Success(p Policy, key, ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+
+	delete(d.records, subject{ScopeKey, key})
+	if key == "" || ip == "" || p.Trust <= 0 {
+		return
+	}
+
+	trusted := d.trusted[key]
+	if trusted == nil {
+		trusted = make(map[string]time.Time)
+		d.trusted[key] = trusted
+	}
+	if _, ok := trusted[ip]; !ok && len(trusted) >= maxTrusted {
+		var oldest string
+		for other, until := range trusted {
+			if oldest == "" || until.Before(trusted[oldest]) {
+				oldest = other
+			}
+		}
+		delete(trusted, oldest)
+	}
+	trusted[ip] = now.Add(p.Trust)
+}
+
+// Lockouts returns the active lockouts ordered by their end.
+func (d *Detector) Lockouts() []Lockout {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	lockouts := []Lockout{}
+	for sub, r := range d.records {
+		if r.lockedUntil.After(now) {
+			lockouts = append(lockouts, Lockout{Scope: sub.scope, Subject: sub.value, Until: r.lockedUntil, Count: r.lockouts})
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		if !lockouts[i].Until.Equal(lockouts[j].Until) {
+			return lockouts[i].Until.Before(lockouts[j].Until)
+		}
+		if lockouts[i].Scope != lockouts[j].Scope {
+			return lockouts[i].Scope < lockouts[j].Scope
+		}
+		return lockouts[i].Subject < lockouts[j].Subject
+	})
+	return lockouts
+}
+
+// LockoutsResponse is the response of Detector.ServeHTTP.
+type LockoutsResponse struct {
+	Lockouts []Lockout `json:"lockouts"`
+}
+
+// ServeHTTP implements http.Handler. It responds with the active lockouts
+// as LockoutsResponse. The subjects are not redacted, so it must be served
+// only to operators.
+func (d *Detector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(LockoutsResponse{Lockouts: d.Lockouts()})
+	if err != nil {
+		abuseLog.Warn("error writing response to client", "error", err)
+	}
+}
+
+// isTrusted reports whether ip is a trusted context of key.
+func (d *Detector) isTrusted(key, ip string, now time.Time) bool {
+	until, ok := d.trusted[key][ip]
+	return ok && now.Before(until)
+}
+
+// sweep removes the expired records and trusted IPs. It must be called with
+// d.mu held.
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < sweepInterval {
+		return
+	}
+	for sub, r := range d.records {
+		if !now.Before(r.expires) {
+			delete(d.records, sub)
+		}
+	}
+	for key, trusted := range d.trusted {
+		for ip, until := range trusted {
+			if !now.Before(until) {
+				delete(trusted, ip)
+			}
+		}
+		if len(trusted) == 0 {
+			delete(d.trusted, key)
+		}
+	}
+	d.lastSweep = now
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package abuse
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDetector returns a detector and a function that advances its clock.
+func testDetector() (*Detector, func(time.Duration)) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDetector()
+	d.now = func() time.Time { return now }
+	return d, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestDetector_Delay(t *testing.T) {
+	d, advance := testDetector()
+	p := DefaultPolicy
+
+	assert.Equal(t, Decision{Allowed: true}, d.Check("023a47", "203.0.113.7"))
+
+	assert.Empty(t, d.Failure(p, "023a47", "203.0.113.7"))
+	assert.Equal(t, Decision{Scope: ScopeKey, RetryAfter: time.Second}, d.Check("023a47", "203.0.113.7"))
+	assert.Equal(t, Decision{Scope: ScopeIP, RetryAfter: time.Second}, d.Check("03b9c1", "203.0.113.7"))
+	assert.Equal(t, Decision{Allowed: true}, d.Check("03b9c1", "203.0.113.8"), "subnets are not delayed")
+
+	advance(time.Second)
+	assert.True(t, d.Check("023a47", "203.0.113.7").Allowed)
+
+	d.Failure(p, "023a47", "198.51.100.1")
+	assert.Equal(t, Decision{Scope: ScopeKey, RetryAfter: 2 * time.Second}, d.Check("023a47", "192.0.2.1"), "the delay doubles")
+
+	// the failures leave the window
+	advance(p.Window)
+	d.Failure(p, "023a47", "192.0.2.1")
+	assert.Equal(t, time.Second, d.Check("023a47", "192.0.2.1").RetryAfter)
+}
+
+func TestDetector_Lockout(t *testing.T) {
+	d, advance := testDetector()
+	p := DefaultPolicy
+	p.Delay, p.MaxDelay = 0, 0
+
+	for i := 1; i < p.KeyFailures; i++ {
+		assert.Empty(t, d.Failure(p, "023a47", fmt.Sprintf("192.0.2.%d", i)))
+	}
+	assert.True(t, d.Check("023a47", "198.51.100.1").Allowed)
+
+	start := d.now()
+	lockouts := d.Failure(p, "023a47", "198.51.100.1")
+	require.Equal(t, []Lockout{{Scope: ScopeKey, Subject: "023a47", Until: start.Add(5 * time.Minute), Count: 1}}, lockouts)
+	assert.Equal(t, Decision{Scope: ScopeKey, Locked: true, RetryAfter: 5 * time.Minute}, d.Check("023a47", "203.0.113.7"))
+	assert.True(t, d.Check("03b9c1", "198.51.100.1").Allowed, "other keys are not locked out")
+	assert.Equal(t, lockouts, d.Lockouts())
+
+	// the lockout doubles if the key is locked out again
+	advance(5 * time.Minute)
+	assert.Empty(t, d.Lockouts())
+	for i := 0; i < p.KeyFailures; i++ {
+		lockouts = d.Failure(p, "023a47", "198.51.100.1")
+	}
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, 2, lockouts[0].Count)
+	assert.Equal(t, 10*time.Minute, d.Check("023a47", "203.0.113.7").RetryAfter)
+
+	// the count resets after the key stayed quiet for as long as its lockout
+	advance(20 * time.Minute)
+	for i := 0; i < p.KeyFailures; i++ {
+		lockouts = d.Failure(p, "023a47", "198.51.100.1")
+	}
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, 1, lockouts[0].Count)
+}
+
+func TestDetector_Subnet(t *testing.T) {
+	d, _ := testDetector()
+	p := DefaultPolicy
+	p.SubnetFailures = 3
+
+	for i := 1; i <= 3; i++ {
+		d.Failure(p, fmt.Sprintf("key%d", i), fmt.Sprintf("203.0.113.%d", i))
+	}
+	assert.Equal(t, Decision{Scope: ScopeSubnet, Locked: true, RetryAfter: 5 * time.Minute}, d.Check("03b9c1", "203.0.113.200"))
+	assert.True(t, d.Check("03b9c1", "203.0.114.1").Allowed)
+}
+
+func TestDetector_Success(t *testing.T) {
+	d, advance := testDetector()
+	p := DefaultPolicy
+	p.KeyFailures = 2
+
+	d.Success(p, "023a47", "203.0.113.7")
+	d.Failure(p, "023a47", "198.51.100.1")
+	d.Failure(p, "023a47", "198.51.100.2")
+	assert.Equal(t, ScopeKey, d.Check("023a47", "192.0.2.1").Scope)
+	assert.True(t, d.Check("023a47", "203.0.113.7").Allowed, "the key holder logs in from a trusted context")
+
+	d.Success(p, "023a47", "203.0.113.7")
+	assert.True(t, d.Check("023a47", "192.0.2.1").Allowed, "a successful login clears the lockout")
+
+	// the failures of the IP are kept
+	d.Failure(p, "03b9c1", "198.51.100.3")
+	d.Success(p, "023a47", "198.51.100.3")
+	assert.Equal(t, ScopeIP, d.Check("023a47", "198.51.100.3").Scope)
+
+	// the trust expires
+	advance(p.Trust)
+	d.Failure(p, "023a47", "198.51.100.1")
+	d.Failure(p, "023a47", "198.51.100.2")
+	assert.Equal(t, ScopeKey, d.Check("023a47", "203.0.113.7").Scope)
+}
+
+func TestDetector_MaxTrusted(t *testing.T) {
+	d, advance := testDetector()
+	p := DefaultPolicy
+
+	for i := 0; i <= maxTrusted; i++ {
+		d.Success(p, "023a47", fmt.Sprintf("203.0.113.%d", i))
+		advance(time.Second)
+	}
+	assert.Len(t, d.trusted["023a47"], maxTrusted)
+	assert.NotContains(t, d.trusted["023a47"], "203.0.113.0", "the oldest IP is replaced")
+}
+
+func TestDetector_Sweep(t *testing.T) {
+	d, advance := testDetector()
+	p := DefaultPolicy
+
+	d.Failure(p, "023a47", "203.0.113.7")
+	d.Success(p, "03b9c1", "203.0.113.7")
+	assert.Len(t, d.records, 3)
+
+	advance(p.Window)
+	d.Check("", "")
+	assert.Empty(t, d.records)
+	assert.Len(t, d.trusted, 1)
+
+	advance(p.Trust)
+	d.Check("", "")
+	assert.Empty(t, d.trusted)
+}
+
+func TestDetector_ServeHTTP(t *testing.T) {
+	d, _ := testDetector()
+	p := DefaultPolicy
+	p.IPFailures = 1
+
+	rr := httptest.NewRecorder()
+	d.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/lockouts", nil))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"lockouts": []}`, rr.Body.String())
+
+	d.Failure(p, "", "203.0.113.7")
+	rr = httptest.NewRecorder()
+	d.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/lockouts", nil))
+	assert.JSONEq(t, `{"lockouts": [{"scope": "ip", "subject": "203.0.113.7", "until": "2021-06-01T12:05:00Z", "count": 1}]}`, rr.Body.String())
+}
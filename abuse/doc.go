@@ -0,0 +1,16 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package abuse detects repeated failed logins.
+//
+// A Detector counts the failed logins of each public key, client IP and
+// subnet of the client IP in a sliding window. Every failure of a key or IP
+// delays its next attempt, exponentially in the number of failures in the
+// window. Reaching the failure threshold of a scope locks the subject out
+// for a period that doubles with each consecutive lockout.
+//
+// A successful login clears the failures and lockouts of its key, and makes
+// the client IP a trusted context of the key. Attempts for a key from its
+// trusted IPs are not delayed or locked out by the failures of the key, so
+// an attacker cannot lock the key holder out.
+package abuse
@@ -0,0 +1,147 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The events of the records.
+const (
+	// EventChallengeIssued is recorded when a login challenge is issued.
+	EventChallengeIssued = "challenge_issued"
+	// EventLoginSuccess is recorded when a login signature verifies.
+	EventLoginSuccess = "login_success"
+	// EventLoginFailure is recorded when a login is rejected.
+	EventLoginFailure = "login_failure"
+	// EventCredentialRegistered is recorded when a credential is registered
+	// for a user.
+	EventCredentialRegistered = "credential_registered"
+)
+
+// ErrTampered is returned by Verify if the chain of records is broken.
+var ErrTampered = errors.New("audit log was tampered with")
+
+// maxRecordSize is the maximum size of a line of the log.
+const maxRecordSize = 1 << 20
+
+// Record is an entry of the log.
+type Record struct {
+	// Seq is the number of the record, starting with 1.
+	Seq uint64 `json:"seq"`
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+	// Event is one of the events, e.g. EventLoginSuccess.
+	Event string `json:"event"`
+	// Method is the login method, e.g. "trezor" or "webauthn".
+	Method string `json:"method,omitempty"`
+	// RequestID identifies the request in the log of the server.
+	RequestID string `json:"requestId,omitempty"`
+	// ClientIP is the IP address of the client.
+	ClientIP string `json:"clientIp,omitempty"`
+	// Subject is the public key or user of a login or credential.
+	Subject string `json:"subject,omitempty"`
+	// Credential is the ID of a registered credential.
+	Credential string `json:"credential,omitempty"`
+	// Error is the error class of a failed login.
+	Error string `json:"error,omitempty"`
+	// Proof is the signed proof of a login, or the challenge of an issued
+	// challenge.
+	Proof *Proof `json:"proof,omitempty"`
+	// Prev is the hash of the previous record, or empty for the first.
+	Prev string `json:"prev"`
+	// Hash is the hash of the record. It is computed by the Log.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Proof is a signed challenge as sent by the client. Message is the signed
+// message of logins that sign the challenge within a message, i.e. the
+// Sign-In With Ethereum message or the NIP-98 event in JSON.
+type Proof struct {
+	ChallengeHidden string `json:"challengeHidden"`
+	ChallengeVisual string `json:"challengeVisual"`
+	PublicKey       string `json:"publicKey,omitempty"`
+	Signature       string `json:"signature,omitempty"`
+	Version         int    `json:"version,omitempty"`
+	Origin          string `json:"origin,omitempty"`
+	Message         string `json:"message,omitempty"`
+}
+
+// hash returns the hash of the fields of r except Hash, keyed with key if
+// it is not empty.
+func hash(r Record, key []byte) (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode record: %v", err)
+	}
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Summary is the result of a successful verification.
+type Summary struct {
+	// Records is the number of records.
+	Records uint64
+	// Head is the hash of the last record, or empty if there is none.
+	Head string
+}
+
+// Verify reads the records of a log from r and checks their chain with
+// key. It returns an error wrapping ErrTampered at the first record that is
+// modified or out of sequence.
+func Verify(r io.Reader, key []byte) (Summary, error) {
+	var s Summary
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		if err := unmarshalStrict(scanner.Bytes(), &rec); err != nil {
+			return s, fmt.Errorf("%w: line %d: %v", ErrTampered, line, err)
+		}
+		if rec.Seq != s.Records+1 {
+			return s, fmt.Errorf("%w: line %d: record %d follows record %d", ErrTampered, line, rec.Seq, s.Records)
+		}
+		if rec.Prev != s.Head {
+			return s, fmt.Errorf("%w: line %d: record %d does not follow the previous record", ErrTampered, line, rec.Seq)
+		}
+		sum, err := hash(rec, key)
+		if err != nil {
+			return s, err
+		}
+		if !hmac.Equal([]byte(sum), []byte(rec.Hash)) {
+			return s, fmt.Errorf("%w: line %d: record %d does not match its hash", ErrTampered, line, rec.Seq)
+		}
+		s.Records, s.Head = rec.Seq, rec.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return s, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return s, nil
+}
+
+// unmarshalStrict decodes a record and rejects unknown fields and trailing
+// data, which would not be covered by the hash.
+func unmarshalStrict(data []byte, v interface{}) error {
+	if !json.Valid(data) {
+		return errors.New("invalid JSON")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
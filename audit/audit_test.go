@@ -0,0 +1,131 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package audit_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/audit"
+)
+
+// writeLog returns the lines of a log of n records with key.
+func writeLog(t *testing.T, n int, key []byte) []string {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(path, key)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, l.Append(audit.Record{
+			Event:   audit.EventLoginSuccess,
+			Method:  "trezor",
+			Subject: "02ab",
+			Proof:   &audit.Proof{ChallengeHidden: "00", ChallengeVisual: "2021-06-01 12:00:00", PublicKey: "02ab", Signature: "1f", Version: 2},
+		}))
+	}
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// edit returns line with the field of its record set to value.
+func edit(t *testing.T, line, field string, value interface{}) string {
+	var rec map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(line), &rec))
+	rec[field] = value
+	data, err := json.Marshal(rec)
+	require.NoError(t, err)
+	return string(data) + "\n"
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	lines := writeLog(t, 3, key)
+
+	summary, err := audit.Verify(strings.NewReader(strings.Join(lines, "")), key)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), summary.Records)
+	assert.Len(t, summary.Head, 64)
+
+	summary, err = audit.Verify(strings.NewReader(""), key)
+	require.NoError(t, err)
+	assert.Equal(t, audit.Summary{}, summary)
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	lines := writeLog(t, 3, key)
+
+	for _, tt := range []struct {
+		name  string
+		lines []string
+		key   []byte
+		err   string
+	}{
+		{
+			name:  "modified",
+			lines: []string{lines[0], edit(t, lines[1], "subject", "03cd"), lines[2]},
+			err:   "line 2: record 2 does not match its hash",
+		},
+		{
+			name:  "modified proof",
+			lines: []string{lines[0], lines[1], edit(t, lines[2], "proof", map[string]interface{}{"challengeHidden": "01", "challengeVisual": "x"})},
+			err:   "line 3: record 3 does not match its hash",
+		},
+		{
+			name:  "deleted",
+			lines: []string{lines[0], lines[2]},
+			err:   "line 2: record 3 follows record 1",
+		},
+		{
+			name:  "renumbered",
+			lines: []string{lines[0], edit(t, lines[2], "seq", 2)},
+			err:   "line 2: record 2 does not follow the previous record",
+		},
+		{
+			name:  "reordered",
+			lines: []string{lines[1], lines[0], lines[2]},
+			err:   "line 1: record 2 follows record 0",
+		},
+		{
+			name:  "added field",
+			lines: []string{lines[0], edit(t, lines[1], "note", "ok")},
+			err:   `line 2: json: unknown field "note"`,
+		},
+		{
+			name:  "truncated",
+			lines: []string{lines[0], lines[1][:20]},
+			err:   "line 2: invalid JSON",
+		},
+		{
+			name:  "wrong key",
+			lines: lines,
+			key:   []byte("fedcba9876543210"),
+			err:   "line 1: record 1 does not match its hash",
+		},
+		{
+			name:  "without key",
+			lines: lines,
+			key:   []byte{},
+			err:   "line 1: record 1 does not match its hash",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			k := key
+			if tt.key != nil {
+				k = tt.key
+			}
+			_, err := audit.Verify(strings.NewReader(strings.Join(tt.lines, "")), k)
+			assert.ErrorIs(t, err, audit.ErrTampered)
+			assert.EqualError(t, err, "audit log was tampered with: "+tt.err)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package audit provides a tamper-evident, append-only log of
+// authentication events.
+//
+// A Log writes each Record as a line of JSON to a file. Records are numbered
+// and chained by hash: each record carries the hash of the previous record
+// and its own hash, which covers all of its fields. Verify recomputes the
+// chain, so modifying, inserting, reordering or deleting a record is
+// detected. Deleting the last records is detected by comparing the head
+// hash with one recorded elsewhere, e.g. in the log of the server.
+//
+// With a key, the hashes are HMAC-SHA256, so the chain cannot be recomputed
+// by someone who can write the file but does not know the key.
+//
+// Logins are recorded with their full signed proof, so auditors can verify
+// the signatures again later.
+package audit
@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/logging"
+)
+
+var auditLog = logging.Component("audit")
+
+// Log appends records to a file. The methods of a nil Log do nothing.
+type Log struct {
+	key []byte
+
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+	head string
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// Open opens the log in the file at path with key, which may be empty, and
+// creates the file if it does not exist. It fails if the records in the file
+// do not verify, so the chain is not continued from a tampered log.
+func Open(path string, key []byte) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	summary, err := Verify(file, key)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	auditLog.Info("opened audit log", "path", path, "records", summary.Records, "head", summary.Head)
+	return &Log{key: key, file: file, seq: summary.Records, head: summary.Head, now: time.Now}, nil
+}
+
+// Append sets the sequence number, time and hashes of r and writes it to
+// the log. The record is synced to the disk before Append returns.
+func (l *Log) Append(r Record) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.Seq = l.seq + 1
+	r.Time = l.now().UTC()
+	r.Prev = l.head
+	sum, err := hash(r, l.key)
+	if err != nil {
+		return err
+	}
+	r.Hash = sum
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %v", err)
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync audit log: %v", err)
+	}
+	l.seq, l.head = r.Seq, r.Hash
+	return nil
+}
+
+// Head returns the number of records and the hash of the last record.
+func (l *Log) Head() Summary {
+	if l == nil {
+		return Summary{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Summary{Records: l.seq, Head: l.head}
+}
+
+// Close implements io.Closer. It logs the head of the chain, so deleting
+// the last records can be detected.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	head := l.Head()
+	auditLog.Info("closed audit log", "records", head.Records, "head", head.Head)
+	return l.file.Close()
+}
+
+var _ io.Closer = (*Log)(nil)
@@ -0,0 +1,79 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLog returns a log in a temporary file with key and the path of the
+// file. The clock of the log advances by a second with each record.
+func testLog(t *testing.T, key []byte) (*Log, string) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, key)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return l, path
+}
+
+func TestLog_Append(t *testing.T) {
+	l, path := testLog(t, nil)
+
+	require.NoError(t, l.Append(Record{Event: EventChallengeIssued, Proof: &Proof{ChallengeHidden: "00", ChallengeVisual: "2021-06-01 12:00:00"}}))
+	require.NoError(t, l.Append(Record{Event: EventLoginSuccess, Method: "trezor", Subject: "02ab"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"seq":1,"time":"2021-06-01T12:00:01Z","event":"challenge_issued"`)
+	assert.Contains(t, lines[0], `"prev":""`)
+
+	head := l.Head()
+	assert.Equal(t, uint64(2), head.Records)
+	assert.Len(t, head.Head, 64)
+
+	summary, err := Verify(strings.NewReader(string(data)), nil)
+	require.NoError(t, err)
+	assert.Equal(t, head, summary)
+}
+
+func TestLog_Reopen(t *testing.T) {
+	l, path := testLog(t, []byte("0123456789abcdef"))
+	require.NoError(t, l.Append(Record{Event: EventLoginFailure, Error: "invalid_signature"}))
+	head := l.Head()
+	require.NoError(t, l.Close())
+
+	// the chain continues after a restart
+	l, err := Open(path, []byte("0123456789abcdef"))
+	require.NoError(t, err)
+	assert.Equal(t, head, l.Head())
+	require.NoError(t, l.Append(Record{Event: EventLoginSuccess}))
+	assert.Equal(t, uint64(2), l.Head().Records)
+	require.NoError(t, l.Close())
+
+	// a log with another key does not verify
+	_, err = Open(path, []byte("fedcba9876543210"))
+	assert.ErrorIs(t, err, ErrTampered)
+}
+
+func TestLog_Nil(t *testing.T) {
+	var l *Log
+	assert.NoError(t, l.Append(Record{Event: EventLoginSuccess}))
+	assert.Equal(t, Summary{}, l.Head())
+	assert.NoError(t, l.Close())
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with the -X linker flag, see the package documentation.
+var (
+	version   string
+	revision  string
+	buildTime string
+)
+
+// Devel is the version of builds without version information.
+const Devel = "(devel)"
+
+// Info is the build information of the server.
+type Info struct {
+	// Version is the version of the module, e.g. "v1.2.3", or Devel.
+	Version string `json:"version"`
+	// Revision is the VCS revision the server was built from.
+	Revision string `json:"revision,omitempty"`
+	// Time is the build time in RFC 3339 format.
+	Time string `json:"time,omitempty"`
+	// GoVersion is the version of Go the server was built with.
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return get(version, debug.ReadBuildInfo)
+}
+
+func get(version string, readBuildInfo func() (*debug.BuildInfo, bool)) Info {
+	info := Info{
+		Version:   version,
+		Revision:  revision,
+		Time:      buildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.Version == "" {
+		if bi, ok := readBuildInfo(); ok {
+			info.Version = bi.Main.Version
+		}
+	}
+	if info.Version == "" {
+		info.Version = Devel
+	}
+	return info
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	buildInfo := func(version string, ok bool) func() (*debug.BuildInfo, bool) {
+		return func() (*debug.BuildInfo, bool) {
+			return &debug.BuildInfo{Main: debug.Module{Path: "phobia.cloud/api", Version: version}}, ok
+		}
+	}
+
+	for _, tt := range []struct {
+		name          string
+		version       string
+		readBuildInfo func() (*debug.BuildInfo, bool)
+		expected      string
+	}{
+		{"linker flag", "v1.2.3", buildInfo("v1.0.0", true), "v1.2.3"},
+		{"module version", "", buildInfo("v1.0.0", true), "v1.0.0"},
+		{"no build info", "", buildInfo("", false), Devel},
+	} {
+		info := get(tt.version, tt.readBuildInfo)
+		assert.Equal(t, tt.expected, info.Version, tt.name)
+		assert.Equal(t, runtime.Version(), info.GoVersion, tt.name)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package buildinfo provides the version of the server embedded at build
+// time.
+//
+// The version, VCS revision and build time are set with the -X linker flag:
+//
+//	go build -ldflags "\
+//	  -X phobia.cloud/api/buildinfo.version=$(git describe --tags) \
+//	  -X phobia.cloud/api/buildinfo.revision=$(git rev-parse HEAD) \
+//	  -X phobia.cloud/api/buildinfo.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// If the version is not set, the module version recorded by the go command
+// is used, e.g. for binaries installed with go install.
+package buildinfo
@@ -0,0 +1,998 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webhook"
+)
+
+// Storage backends.
+const (
+	// StorageMemory keeps the server state in memory. It is lost when the
+	// server exits.
+	StorageMemory = "memory"
+	// StorageFile keeps the server state in files of the Storage.Path
+	// directory.
+	StorageFile = "file"
+)
+
+// Tracing exporters.
+const (
+	// TracingNone does not trace requests.
+	TracingNone = "none"
+	// TracingOTLP exports spans to an OpenTelemetry collector with OTLP/HTTP
+	// and JSON encoding.
+	TracingOTLP = "otlp"
+)
+
+// Config is the configuration of the server.
+type Config struct {
+	// Listen is the TCP address the server listens on, e.g. ":5050".
+	Listen    string
+	HTTP      HTTP
+	TLS       TLS
+	CORS      CORS
+	Challenge Challenge
+	Login     Login
+	WebAuthn  WebAuthn
+	Storage   Storage
+	Metrics   Metrics
+	Log       Log
+	Tracing   Tracing
+	OpenAPI   OpenAPI
+	RateLimit RateLimit
+	Proxy     Proxy
+	Abuse     Abuse
+	PoW       PoW
+	Security  Security
+	Audit     Audit
+	Webhook   Webhook
+	Operator  Operator
+}
+
+// HTTP configures the limits of the HTTP server. See server.Timeouts.
+type HTTP struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ShutdownTimeout is how long in-flight requests are waited for when
+	// the server stops.
+	ShutdownTimeout time.Duration
+}
+
+// TLS configures HTTPS. If Cert and Key are empty, the server serves plain
+// HTTP. See server.TLS.
+type TLS struct {
+	// Cert is the path of the PEM-encoded certificate chain.
+	Cert string
+	// Key is the path of the PEM-encoded private key.
+	Key string
+	// MinVersion is the minimum TLS version, "1.2" or "1.3".
+	MinVersion string
+	// Ciphers are the names of the TLS 1.2 cipher suites, e.g.
+	// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". If empty, the defaults of
+	// the crypto/tls package are used.
+	Ciphers []string
+	// ClientCA is the path of the PEM-encoded authorities of client
+	// certificates. If set, clients must present a certificate issued by
+	// one of them, unless ClientAuth says otherwise.
+	ClientCA string
+	// ClientAuth is the policy for client certificates: "request",
+	// "require_any", "verify_if_given" or "require_and_verify". If empty,
+	// client certificates are verified if ClientCA is set.
+	ClientAuth string
+	// Redirect is the TCP address of a plain HTTP listener that redirects
+	// to HTTPS, e.g. ":80". If empty, there is no such listener.
+	Redirect string
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t *TLS) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// CORS configures the cross-origin resource sharing policy. See server.CORS.
+type CORS struct {
+	Origins        []string
+	Methods        []string
+	Headers        []string
+	ExposedHeaders []string
+	Credentials    bool
+	MaxAge         time.Duration
+}
+
+// Challenge configures the challenges issued by the server.
+type Challenge struct {
+	// MaxAge is how long a login challenge is accepted after it was issued.
+	MaxAge time.Duration
+	// Visual is the template of the challenge visual. See
+	// login.VisualTemplate.
+	Visual string
+	// Layout is the layout of the time in the challenge visual.
+	Layout string
+	// Timezone is the IANA time zone of the time in the challenge visual,
+	// e.g. "Europe/Sofia".
+	Timezone string
+	// LNURLTTL is how long an LNURL-auth challenge remains valid.
+	LNURLTTL time.Duration
+	// WebAuthnTTL is how long a WebAuthn challenge remains valid.
+	WebAuthnTTL time.Duration
+}
+
+// Login configures the verification of login requests.
+type Login struct {
+	// Versions are the allowed versions of the login challenge.
+	Versions []int
+	// Origins are the public origins of the server, e.g.
+	// "https://phobia.cloud", allowed in version 3 challenges, Nostr events
+	// and Sign-In With Ethereum messages. They are required by these
+	// logins, as the Host header of a request is set by the client.
+	Origins []string
+	// RequireOrigin rejects versions of the challenge that do not commit to
+	// the origin.
+	RequireOrigin bool
+}
+
+// WebAuthn configures the WebAuthn relying party. See
+// webauthn.RelyingParty.
+type WebAuthn struct {
+	// Enabled serves the WebAuthn routes. It requires RPID, RPName and
+	// Origins, as the Host header of a request is set by the client.
+	Enabled bool
+	// RPID is the relying party ID, usually the domain of the site, e.g.
+	// "phobia.cloud".
+	RPID string
+	// RPName is the human-readable name of the relying party.
+	RPName string
+	// Origins are the allowed origins of the client data, e.g.
+	// "https://phobia.cloud". Their hosts must be RPID or its subdomains.
+	Origins []string
+}
+
+// Storage configures where the server keeps its state.
+type Storage struct {
+	// Backend is StorageMemory or StorageFile.
+	Backend string
+	// Path is the directory of StorageFile.
+	Path string
+}
+
+// Metrics configures the metrics of the server.
+type Metrics struct {
+	// Enabled serves the metrics in the Prometheus text format at /metrics.
+	Enabled bool
+}
+
+// Log configures the logging of the server.
+type Log struct {
+	// Level is the minimum level of logged entries: debug, info, warn or
+	// error.
+	Level string
+	// Components are the levels of components as "component=level", e.g.
+	// "access=warn".
+	Components []string
+}
+
+// Tracing configures the tracing of requests.
+type Tracing struct {
+	// Exporter is TracingNone or TracingOTLP.
+	Exporter string
+	// Endpoint is the URL spans are posted to by TracingOTLP, e.g.
+	// "http://localhost:4318/v1/traces".
+	Endpoint string
+	// Service is the service name of the exported spans.
+	Service string
+	// Headers are sent with each export as "name=value", e.g. for
+	// authentication with the collector.
+	Headers []string
+}
+
+// OpenAPI configures the documentation of the API. The OpenAPI document is
+// always served at /openapi.json.
+type OpenAPI struct {
+	// Viewer serves a viewer of the document at /docs.
+	Viewer bool
+}
+
+// RateLimit configures the rate limits of the API. See server.RateLimit.
+type RateLimit struct {
+	Enabled bool
+	// IP are the limits per client IP as "route=requests/period[:burst]",
+	// e.g. "/v1/login=30/1m:10".
+	IP []string
+	// Key are the limits per public key or address of a login in the same
+	// format.
+	Key []string
+}
+
+// Proxy configures the reverse proxies in front of the server.
+type Proxy struct {
+	// Trusted are the IP addresses and CIDR networks of the proxies trusted
+	// to forward the address and scheme of the client in the Forwarded or
+	// X-Forwarded-For and X-Forwarded-Proto headers.
+	Trusted []string
+	// Protocol accepts the PROXY protocol v1 and v2 on the connections of
+	// the trusted proxies.
+	Protocol bool
+}
+
+// Abuse configures the delays and lockouts after repeated failed logins. See
+// abuse.Policy.
+type Abuse struct {
+	Enabled        bool
+	Window         time.Duration
+	KeyFailures    int
+	IPFailures     int
+	SubnetFailures int
+	Delay          time.Duration
+	MaxDelay       time.Duration
+	Lockout        time.Duration
+	MaxLockout     time.Duration
+	Trust          time.Duration
+	// Endpoint serves the active lockouts at /lockouts to operators. It
+	// requires Operator.Token.
+	Endpoint bool
+}
+
+// PoW configures the proof-of-work gate of challenges. See pow.Policy.
+type PoW struct {
+	Enabled       bool
+	Difficulty    int
+	MaxDifficulty int
+	TargetRate    int
+	TTL           time.Duration
+	// Key is the hex-encoded key of the salts of the puzzles, shared by the
+	// instances of the server. If empty, a random key is used.
+	Key string
+}
+
+// Security configures the security headers of the responses. See
+// server.Security.
+type Security struct {
+	Enabled bool
+	// Headers override the default headers of all routes as "name=value",
+	// e.g. "Referrer-Policy=same-origin". An empty value removes a header.
+	Headers []string
+	// Routes override the headers of a route as "route=name=value", e.g.
+	// "/v1/challenge/qr=Cross-Origin-Resource-Policy=cross-site".
+	Routes []string
+}
+
+// Audit configures the audit log of authentication events. See audit.Log.
+type Audit struct {
+	Enabled bool
+	// Path is the file of the log.
+	Path string
+	// Key is the hex-encoded key of the hashes of the records. If empty,
+	// the hashes are not keyed.
+	Key string
+}
+
+// Webhook configures the outgoing webhooks of login and account events.
+// See webhook.Dispatcher.
+type Webhook struct {
+	// Endpoints are the receivers of the events as "url [type...]", e.g.
+	// "https://example.com/hook login.success login.locked_out". Without
+	// types, all events are delivered. If empty, no events are published.
+	// Endpoints require the StorageFile backend, so the queued deliveries
+	// survive a restart.
+	Endpoints []string
+	// Secret is the key of the signatures, shared with the receivers.
+	Secret      string
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+	// DeadLetters serves the dead letters at /webhooks/dead-letters to
+	// operators. It requires Operator.Token.
+	DeadLetters bool
+}
+
+// Operator configures the access to the operator endpoints, e.g.
+// /lockouts and /webhooks/dead-letters.
+type Operator struct {
+	// Token is the bearer token required by the operator endpoints.
+	Token string
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Listen: ":5050",
+		HTTP: HTTP{
+			ReadHeaderTimeout: server.DefaultTimeouts.ReadHeader,
+			ReadTimeout:       server.DefaultTimeouts.Read,
+			WriteTimeout:      server.DefaultTimeouts.Write,
+			IdleTimeout:       server.DefaultTimeouts.Idle,
+			MaxHeaderBytes:    server.DefaultTimeouts.MaxHeaderBytes,
+			ShutdownTimeout:   server.DefaultShutdownTimeout,
+		},
+		TLS: TLS{
+			MinVersion: "1.2",
+		},
+		CORS: CORS{
+			Origins: []string{"*"},
+		},
+		Challenge: Challenge{
+			MaxAge:      5 * time.Minute,
+			Layout:      login.DefaultVisualLayout,
+			Timezone:    "UTC",
+			LNURLTTL:    5 * time.Minute,
+			WebAuthnTTL: 5 * time.Minute,
+		},
+		Login: Login{
+			Versions: []int{login.Version1, login.Version2},
+		},
+		Storage: Storage{
+			Backend: StorageMemory,
+		},
+		Metrics: Metrics{
+			Enabled: true,
+		},
+		Log: Log{
+			Level: "info",
+		},
+		Tracing: Tracing{
+			Exporter: TracingNone,
+			Service:  "phobia-api",
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			IP: []string{
+				"/v1/challenge=60/1m:20",
+				"/v1/login=30/1m:10",
+				"/v1/login/nostr=30/1m:10",
+				"/v1/login/ethereum=30/1m:10",
+				"/v1/lnurl=30/1m:10",
+				"/v1/webauthn/register/begin=30/1m:10",
+				"/v1/webauthn/register/finish=30/1m:10",
+				"/v1/webauthn/login/begin=30/1m:10",
+				"/v1/webauthn/login/finish=30/1m:10",
+			},
+			Key: []string{
+				"/v1/login=10/1m:5",
+				"/v1/login/nostr=10/1m:5",
+				"/v1/login/ethereum=10/1m:5",
+			},
+		},
+		Abuse: Abuse{
+			Enabled:        true,
+			Window:         abuse.DefaultPolicy.Window,
+			KeyFailures:    abuse.DefaultPolicy.KeyFailures,
+			IPFailures:     abuse.DefaultPolicy.IPFailures,
+			SubnetFailures: abuse.DefaultPolicy.SubnetFailures,
+			Delay:          abuse.DefaultPolicy.Delay,
+			MaxDelay:       abuse.DefaultPolicy.MaxDelay,
+			Lockout:        abuse.DefaultPolicy.Lockout,
+			MaxLockout:     abuse.DefaultPolicy.MaxLockout,
+			Trust:          abuse.DefaultPolicy.Trust,
+		},
+		PoW: PoW{
+			Difficulty:    pow.DefaultPolicy.Difficulty,
+			MaxDifficulty: pow.DefaultPolicy.MaxDifficulty,
+			TargetRate:    pow.DefaultPolicy.TargetRate,
+			TTL:           pow.DefaultPolicy.TTL,
+		},
+		Security: Security{
+			Enabled: true,
+		},
+		Webhook: Webhook{
+			MaxAttempts: webhook.DefaultPolicy.MaxAttempts,
+			Backoff:     webhook.DefaultPolicy.Backoff,
+			MaxBackoff:  webhook.DefaultPolicy.MaxBackoff,
+			Timeout:     webhook.DefaultPolicy.Timeout,
+		},
+	}
+}
+
+// Errors are the errors found by Config.Validate.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Validate checks the configuration and returns Errors with all problems
+// found.
+func (c *Config) Validate() error {
+	var errs Errors
+	add := func(key string, format string, args ...interface{}) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if err := validateAddress(c.Listen); err != nil {
+		add("listen", "%v", err)
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		add("tls", "cert and key must be set together")
+	}
+	if _, err := c.TLSPolicy(); err != nil {
+		add("tls", "%v", err)
+	}
+	if c.TLS.Redirect != "" {
+		if err := validateAddress(c.TLS.Redirect); err != nil {
+			add("tls.redirect", "%v", err)
+		}
+	}
+
+	if err := c.CORSPolicy().Validate(); err != nil {
+		add("cors", "%v", strings.TrimPrefix(err.Error(), "cors: "))
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"http.read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"http.read_timeout", c.HTTP.ReadTimeout},
+		{"http.write_timeout", c.HTTP.WriteTimeout},
+		{"http.idle_timeout", c.HTTP.IdleTimeout},
+		{"http.shutdown_timeout", c.HTTP.ShutdownTimeout},
+		{"challenge.max_age", c.Challenge.MaxAge},
+		{"challenge.lnurl_ttl", c.Challenge.LNURLTTL},
+		{"challenge.webauthn_ttl", c.Challenge.WebAuthnTTL},
+	} {
+		if d.value <= 0 {
+			add(d.key, "must be positive, got %v", d.value)
+		}
+	}
+	if c.HTTP.MaxHeaderBytes <= 0 {
+		add("http.max_header_bytes", "must be positive, got %d", c.HTTP.MaxHeaderBytes)
+	}
+	if _, err := time.LoadLocation(c.Challenge.Timezone); err != nil {
+		add("challenge.timezone", "%v", err)
+	} else if _, err := c.VisualTemplate(); err != nil {
+		add("challenge.visual", "%v", err)
+	}
+
+	if len(c.Login.Versions) == 0 {
+		add("login.versions", "at least one version must be allowed")
+	}
+	version3 := false
+	for _, v := range c.Login.Versions {
+		switch v {
+		case login.Version1, login.Version2:
+		case login.Version3:
+			version3 = true
+		default:
+			add("login.versions", "unsupported version: %d", v)
+		}
+	}
+	for _, origin := range c.Login.Origins {
+		if err := login.ValidateOrigin(origin); err != nil {
+			add("login.origins", "%v", err)
+		}
+	}
+	if c.Login.RequireOrigin && !version3 {
+		add("login.require_origin", "requires version %d to be allowed", login.Version3)
+	}
+	if (version3 || c.Login.RequireOrigin) && len(c.Login.Origins) == 0 {
+		add("login.origins", "required by version %d", login.Version3)
+	}
+
+	if c.WebAuthn.Enabled {
+		if c.WebAuthn.RPID == "" {
+			add("webauthn.rp_id", "required by webauthn")
+		}
+		if c.WebAuthn.RPName == "" {
+			add("webauthn.rp_name", "required by webauthn")
+		}
+		if len(c.WebAuthn.Origins) == 0 {
+			add("webauthn.origins", "required by webauthn")
+		}
+		for _, origin := range c.WebAuthn.Origins {
+			if err := login.ValidateOrigin(origin); err != nil {
+				add("webauthn.origins", "%v", err)
+			} else if c.WebAuthn.RPID != "" && !onRPID(origin, c.WebAuthn.RPID) {
+				add("webauthn.origins", "origin %q is not on the rp_id %q", origin, c.WebAuthn.RPID)
+			}
+		}
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		add("log.level", "%v", err)
+	} else if _, err := c.LogLevels(); err != nil {
+		add("log.components", "%v", err)
+	}
+
+	switch c.Storage.Backend {
+	case StorageMemory:
+	case StorageFile:
+		if c.Storage.Path == "" {
+			add("storage.path", "required by the %s backend", StorageFile)
+		}
+	default:
+		add("storage.backend", "unsupported backend: %q", c.Storage.Backend)
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingNone:
+	case TracingOTLP:
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("tracing.endpoint", "must be an http or https url, got %q", c.Tracing.Endpoint)
+		}
+		if c.Tracing.Service == "" {
+			add("tracing.service", "required by the %s exporter", TracingOTLP)
+		}
+	default:
+		add("tracing.exporter", "unsupported exporter: %q", c.Tracing.Exporter)
+	}
+	if _, err := c.tracingHeaders(); err != nil {
+		add("tracing.headers", "%v", err)
+	}
+
+	if _, err := parseLimits(c.RateLimit.IP); err != nil {
+		add("ratelimit.ip", "%v", err)
+	}
+	if _, err := parseLimits(c.RateLimit.Key); err != nil {
+		add("ratelimit.key", "%v", err)
+	}
+
+	if _, err := server.ParseTrustedProxies(c.Proxy.Trusted); err != nil {
+		add("proxy.trusted", "%v", err)
+	}
+
+	if err := c.AbusePolicy().Validate(); c.Abuse.Enabled && err != nil {
+		add("abuse", "%v", err)
+	}
+
+	if c.Abuse.Enabled && c.Abuse.Endpoint && len(c.Operator.Token) < minKeySize {
+		// the token is not quoted, as it is a secret
+		add("operator.token", "must have at least %d bytes to serve /lockouts", minKeySize)
+	}
+
+	if err := c.PoWPolicy().Validate(); c.PoW.Enabled && err != nil {
+		add("pow", "%v", err)
+	}
+	if _, err := c.PoWKey(); err != nil {
+		add("pow.key", "%v", err)
+	}
+
+	if c.Audit.Enabled && c.Audit.Path == "" {
+		add("audit.path", "required by the audit log")
+	}
+	if _, err := c.AuditKey(); err != nil {
+		add("audit.key", "%v", err)
+	}
+
+	if _, err := c.SecurityHeaders(); err != nil {
+		add("security", "%v", strings.TrimPrefix(err.Error(), "security: "))
+	}
+
+	if len(c.Webhook.Endpoints) > 0 {
+		if _, err := c.WebhookEndpoints(); err != nil {
+			add("webhook.endpoints", "%v", err)
+		}
+		if len(c.Webhook.Secret) < minKeySize {
+			// the secret is not quoted, as it is a secret
+			add("webhook.secret", "must have at least %d bytes", minKeySize)
+		}
+		if err := c.WebhookPolicy().Validate(); err != nil {
+			add("webhook", "%v", err)
+		}
+		if c.Storage.Backend == StorageMemory {
+			add("webhook.endpoints", "requires the %s storage backend, the %s backend loses the queued deliveries on restart", StorageFile, StorageMemory)
+		}
+	} else if c.Webhook.DeadLetters {
+		add("webhook.dead_letters", "requires webhook.endpoints")
+	}
+	if c.Webhook.DeadLetters && len(c.Operator.Token) < minKeySize {
+		add("operator.token", "must have at least %d bytes to serve /webhooks/dead-letters", minKeySize)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// onRPID reports whether the host of origin is the relying party ID rpID or
+// one of its subdomains.
+func onRPID(origin, rpID string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	return host == rpID || strings.HasSuffix(host, "."+rpID)
+}
+
+// validateAddress checks that address is a host and port to listen on.
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %q", port)
+	}
+	return nil
+}
+
+// Timeouts returns the timeouts of the HTTP server.
+func (c *Config) Timeouts() server.Timeouts {
+	return server.Timeouts{
+		ReadHeader:     c.HTTP.ReadHeaderTimeout,
+		Read:           c.HTTP.ReadTimeout,
+		Write:          c.HTTP.WriteTimeout,
+		Idle:           c.HTTP.IdleTimeout,
+		MaxHeaderBytes: c.HTTP.MaxHeaderBytes,
+	}
+}
+
+// clientAuthTypes are the policies for client certificates by name.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"request":            tls.RequestClientCert,
+	"require_any":        tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// TLSPolicy returns the HTTPS configuration of the server, or nil if HTTPS
+// is not enabled.
+func (c *Config) TLSPolicy() (*server.TLS, error) {
+	if !c.TLS.Enabled() {
+		if c.TLS.ClientCA != "" || c.TLS.ClientAuth != "" || c.TLS.Redirect != "" {
+			return nil, errors.New("client certificates and redirect require cert and key")
+		}
+		return nil, nil
+	}
+
+	t := &server.TLS{
+		CertFile:     c.TLS.Cert,
+		KeyFile:      c.TLS.Key,
+		ClientCAFile: c.TLS.ClientCA,
+	}
+
+	switch c.TLS.MinVersion {
+	case "1.2", "":
+		t.MinVersion = tls.VersionTLS12
+	case "1.3":
+		t.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported min version: %q", c.TLS.MinVersion)
+	}
+
+	for _, name := range c.TLS.Ciphers {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher: %q", name)
+		}
+		t.CipherSuites = append(t.CipherSuites, id)
+	}
+
+	if c.TLS.ClientAuth != "" {
+		auth, ok := clientAuthTypes[c.TLS.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("unsupported client auth: %q", c.TLS.ClientAuth)
+		}
+		if auth >= tls.VerifyClientCertIfGiven && c.TLS.ClientCA == "" {
+			return nil, fmt.Errorf("client auth %s requires client_ca", c.TLS.ClientAuth)
+		}
+		t.ClientAuth = auth
+	}
+
+	return t, nil
+}
+
+// cipherSuite returns the ID of the secure cipher suite with name.
+func cipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// LogLevels returns the levels of the loggers.
+func (c *Config) LogLevels() (logging.Levels, error) {
+	level, err := logging.ParseLevel(c.Log.Level)
+	if err != nil {
+		return logging.Levels{}, err
+	}
+	levels := logging.Levels{Default: level, Components: make(map[string]logging.Level)}
+	for _, component := range c.Log.Components {
+		i := strings.Index(component, "=")
+		if i <= 0 {
+			return logging.Levels{}, fmt.Errorf("invalid component level: %q", component)
+		}
+		level, err := logging.ParseLevel(component[i+1:])
+		if err != nil {
+			return logging.Levels{}, err
+		}
+		levels.Components[component[:i]] = level
+	}
+	return levels, nil
+}
+
+// CORSPolicy returns the CORS policy of the server.
+func (c *Config) CORSPolicy() *server.CORS {
+	return &server.CORS{
+		AllowedOrigins:   c.CORS.Origins,
+		AllowedMethods:   c.CORS.Methods,
+		AllowedHeaders:   c.CORS.Headers,
+		ExposedHeaders:   c.CORS.ExposedHeaders,
+		AllowCredentials: c.CORS.Credentials,
+		MaxAge:           c.CORS.MaxAge,
+	}
+}
+
+// VisualTemplate returns the template of the challenge visual.
+func (c *Config) VisualTemplate() (*login.VisualTemplate, error) {
+	location, err := time.LoadLocation(c.Challenge.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone: %v", err)
+	}
+
+	t := &login.VisualTemplate{
+		Text:     c.Challenge.Visual,
+		Layout:   c.Challenge.Layout,
+		Location: location,
+	}
+	err = t.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenStore returns the store of the storage backend.
+func (c *Config) OpenStore() (store.Store, error) {
+	switch c.Storage.Backend {
+	case StorageMemory:
+		return store.NewMemory(), nil
+	case StorageFile:
+		return store.NewFile(c.Storage.Path)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %q", c.Storage.Backend)
+	}
+}
+
+// TracingExporter returns the exporter of the spans, or nil if tracing is
+// disabled.
+func (c *Config) TracingExporter() (tracing.Exporter, error) {
+	switch c.Tracing.Exporter {
+	case TracingNone:
+		return nil, nil
+	case TracingOTLP:
+		headers, err := c.tracingHeaders()
+		if err != nil {
+			return nil, err
+		}
+		return &tracing.OTLP{
+			Endpoint: c.Tracing.Endpoint,
+			Headers:  headers,
+			Service:  c.Tracing.Service,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %q", c.Tracing.Exporter)
+	}
+}
+
+// tracingHeaders returns the headers of the tracing exporter by name.
+func (c *Config) tracingHeaders() (map[string]string, error) {
+	headers := make(map[string]string, len(c.Tracing.Headers))
+	for n, header := range c.Tracing.Headers {
+		i := strings.Index(header, "=")
+		if i <= 0 {
+			// the header is not quoted, as it may contain a secret
+			return nil, fmt.Errorf("header %d is not name=value", n+1)
+		}
+		headers[header[:i]] = header[i+1:]
+	}
+	return headers, nil
+}
+
+// RateLimiter returns the rate limits of the API with their buckets in s, or
+// nil if rate limiting is disabled.
+func (c *Config) RateLimiter(s ratelimit.Store) (*server.RateLimit, error) {
+	if !c.RateLimit.Enabled {
+		return nil, nil
+	}
+	ip, err := parseLimits(c.RateLimit.IP)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseLimits(c.RateLimit.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &server.RateLimit{Store: s, IP: ip, Key: key}, nil
+}
+
+// parseLimits returns the rate limits of "route=requests/period[:burst]"
+// entries by route.
+func parseLimits(entries []string) (map[string]ratelimit.Limit, error) {
+	limits := make(map[string]ratelimit.Limit, len(entries))
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i <= 0 || !strings.HasPrefix(entry, "/") {
+			return nil, fmt.Errorf("limit is not route=requests/period[:burst]: %q", entry)
+		}
+		limit, err := ratelimit.ParseLimit(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit of %s: %v", entry[:i], err)
+		}
+		limits[entry[:i]] = limit
+	}
+	return limits, nil
+}
+
+// SecurityHeaders returns the security headers of the responses, or nil if
+// they are disabled.
+func (c *Config) SecurityHeaders() (*server.Security, error) {
+	if !c.Security.Enabled {
+		return nil, nil
+	}
+	s := server.NewSecurity()
+	for _, header := range c.Security.Headers {
+		i := strings.Index(header, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("header is not name=value: %q", header)
+		}
+		s.Set(header[:i], header[i+1:])
+	}
+	for _, entry := range c.Security.Routes {
+		parts := strings.SplitN(entry, "=", 3)
+		if len(parts) != 3 || parts[1] == "" {
+			return nil, fmt.Errorf("header is not route=name=value: %q", entry)
+		}
+		s.Override(parts[0], parts[1], parts[2])
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TrustedProxies returns the trusted reverse proxies.
+func (c *Config) TrustedProxies() (*server.TrustedProxies, error) {
+	return server.ParseTrustedProxies(c.Proxy.Trusted)
+}
+
+// AbusePolicy returns the policy of the delays and lockouts after repeated
+// failed logins.
+func (c *Config) AbusePolicy() abuse.Policy {
+	return abuse.Policy{
+		Window:         c.Abuse.Window,
+		KeyFailures:    c.Abuse.KeyFailures,
+		IPFailures:     c.Abuse.IPFailures,
+		SubnetFailures: c.Abuse.SubnetFailures,
+		Delay:          c.Abuse.Delay,
+		MaxDelay:       c.Abuse.MaxDelay,
+		Lockout:        c.Abuse.Lockout,
+		MaxLockout:     c.Abuse.MaxLockout,
+		Trust:          c.Abuse.Trust,
+	}
+}
+
+// RelyingParty returns the WebAuthn relying party.
+func (c *Config) RelyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{
+		ID:      c.WebAuthn.RPID,
+		Name:    c.WebAuthn.RPName,
+		Origins: c.WebAuthn.Origins,
+	}
+}
+
+// PoWPolicy returns the policy of the proof-of-work puzzles.
+func (c *Config) PoWPolicy() pow.Policy {
+	return pow.Policy{
+		Difficulty:    c.PoW.Difficulty,
+		MaxDifficulty: c.PoW.MaxDifficulty,
+		TargetRate:    c.PoW.TargetRate,
+		TTL:           c.PoW.TTL,
+	}
+}
+
+// minKeySize is the minimum size of the keys of the proof-of-work salts, of
+// the audit log and of the webhook signatures.
+const minKeySize = 16
+
+// PoWKey returns the key of the proof-of-work salts, or nil if a random key
+// is used.
+func (c *Config) PoWKey() ([]byte, error) {
+	return decodeKey(c.PoW.Key)
+}
+
+// decodeKey returns the hex-encoded key, or nil if it is empty.
+func decodeKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		// the key is not quoted, as it is a secret
+		return nil, errors.New("key is not hex-encoded")
+	}
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("key must have at least %d bytes", minKeySize)
+	}
+	return key, nil
+}
+
+// AuditKey returns the key of the hashes of the audit log, or nil if they
+// are not keyed.
+func (c *Config) AuditKey() ([]byte, error) {
+	return decodeKey(c.Audit.Key)
+}
+
+// OpenAudit opens the audit log, or returns nil if it is disabled.
+func (c *Config) OpenAudit() (*audit.Log, error) {
+	if !c.Audit.Enabled {
+		return nil, nil
+	}
+	key, err := c.AuditKey()
+	if err != nil {
+		return nil, err
+	}
+	return audit.Open(c.Audit.Path, key)
+}
+
+// WebhookEndpoints returns the receivers of the webhooks, which share the
+// secret.
+func (c *Config) WebhookEndpoints() ([]webhook.Endpoint, error) {
+	var endpoints []webhook.Endpoint
+	for _, entry := range c.Webhook.Endpoints {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid endpoint: %q", entry)
+		}
+		e := webhook.Endpoint{URL: fields[0], Events: fields[1:], Secret: []byte(c.Webhook.Secret)}
+		if len(e.Events) == 0 {
+			e.Events = nil
+		}
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// WebhookPolicy returns the retry policy of the webhooks.
+func (c *Config) WebhookPolicy() webhook.Policy {
+	return webhook.Policy{
+		MaxAttempts: c.Webhook.MaxAttempts,
+		Backoff:     c.Webhook.Backoff,
+		MaxBackoff:  c.Webhook.MaxBackoff,
+		Timeout:     c.Webhook.Timeout,
+	}
+}
+
+// WebhookDispatcher returns a dispatcher of the webhooks with its outbox in
+// s, or nil if there are no endpoints. With the memory storage backend, the
+// queued deliveries are lost on restart.
+func (c *Config) WebhookDispatcher(s store.Store) (*webhook.Dispatcher, error) {
+	endpoints, err := c.WebhookEndpoints()
+	if err != nil || len(endpoints) == 0 {
+		return nil, err
+	}
+	return webhook.NewDispatcher(s, endpoints, c.WebhookPolicy()), nil
+}
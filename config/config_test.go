@@ -0,0 +1,619 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config_test
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/config"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
+)
+
+func TestDefault(t *testing.T) {
+	c := config.Default()
+	require.NoError(t, c.Validate())
+
+	template, err := c.VisualTemplate()
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, template.Location)
+
+	s, err := c.OpenStore()
+	require.NoError(t, err)
+	assert.IsType(t, &store.Memory{}, s)
+}
+
+func TestValidate(t *testing.T) {
+	durable := config.Storage{Backend: config.StorageFile, Path: "/var/lib/phobia"}
+	for _, tt := range []struct {
+		name   string
+		modify func(*config.Config)
+		errors config.Errors
+	}{
+		{
+			name:   "listen without port",
+			modify: func(c *config.Config) { c.Listen = "localhost" },
+			errors: config.Errors{"listen: address localhost: missing port in address"},
+		},
+		{
+			name:   "invalid port",
+			modify: func(c *config.Config) { c.Listen = ":http" },
+			errors: config.Errors{`listen: invalid port: "http"`},
+		},
+		{
+			name:   "tls key without cert",
+			modify: func(c *config.Config) { c.TLS.Key = "key.pem" },
+			errors: config.Errors{"tls: cert and key must be set together"},
+		},
+		{
+			name: "tls policy",
+			modify: func(c *config.Config) {
+				c.TLS.Cert, c.TLS.Key = "cert.pem", "key.pem"
+				c.TLS.MinVersion = "1.1"
+			},
+			errors: config.Errors{`tls: unsupported min version: "1.1"`},
+		},
+		{
+			name: "insecure cipher",
+			modify: func(c *config.Config) {
+				c.TLS.Cert, c.TLS.Key = "cert.pem", "key.pem"
+				c.TLS.Ciphers = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+			},
+			errors: config.Errors{`tls: unsupported cipher: "TLS_RSA_WITH_RC4_128_SHA"`},
+		},
+		{
+			name: "client auth without ca",
+			modify: func(c *config.Config) {
+				c.TLS.Cert, c.TLS.Key = "cert.pem", "key.pem"
+				c.TLS.ClientAuth = "require_and_verify"
+			},
+			errors: config.Errors{"tls: client auth require_and_verify requires client_ca"},
+		},
+		{
+			name: "redirect without tls",
+			modify: func(c *config.Config) {
+				c.TLS.Redirect = ":80"
+			},
+			errors: config.Errors{"tls: client certificates and redirect require cert and key"},
+		},
+		{
+			name: "cors credentials with any origin",
+			modify: func(c *config.Config) {
+				c.CORS.Credentials = true
+			},
+			errors: config.Errors{"cors: the * origin cannot be combined with credentials"},
+		},
+		{
+			name: "non-positive durations",
+			modify: func(c *config.Config) {
+				c.Challenge.MaxAge = 0
+				c.Challenge.WebAuthnTTL = -time.Second
+			},
+			errors: config.Errors{
+				"challenge.max_age: must be positive, got 0s",
+				"challenge.webauthn_ttl: must be positive, got -1s",
+			},
+		},
+		{
+			name: "http limits",
+			modify: func(c *config.Config) {
+				c.HTTP.ReadHeaderTimeout = 0
+				c.HTTP.MaxHeaderBytes = -1
+			},
+			errors: config.Errors{
+				"http.read_header_timeout: must be positive, got 0s",
+				"http.max_header_bytes: must be positive, got -1",
+			},
+		},
+		{
+			name:   "unknown timezone",
+			modify: func(c *config.Config) { c.Challenge.Timezone = "Mars/Olympus" },
+			errors: config.Errors{"challenge.timezone: unknown time zone Mars/Olympus"},
+		},
+		{
+			name:   "visual without time",
+			modify: func(c *config.Config) { c.Challenge.Visual = "Login to phobia.cloud" },
+			errors: config.Errors{"challenge.visual: visual template must contain {time} exactly once"},
+		},
+		{
+			name: "login versions",
+			modify: func(c *config.Config) {
+				c.Login.Versions = []int{2, 4}
+				c.Login.RequireOrigin = true
+			},
+			errors: config.Errors{
+				"login.versions: unsupported version: 4",
+				"login.require_origin: requires version 3 to be allowed",
+				"login.origins: required by version 3",
+			},
+		},
+		{
+			name:   "no login versions",
+			modify: func(c *config.Config) { c.Login.Versions = nil },
+			errors: config.Errors{"login.versions: at least one version must be allowed"},
+		},
+		{
+			name: "version 3 without origins",
+			modify: func(c *config.Config) {
+				c.Login.Versions = []int{2, 3}
+				c.Login.RequireOrigin = true
+			},
+			errors: config.Errors{"login.origins: required by version 3"},
+		},
+		{
+			name:   "login origin with path",
+			modify: func(c *config.Config) { c.Login.Origins = []string{"https://phobia.cloud/login"} },
+			errors: config.Errors{`login.origins: invalid origin: "https://phobia.cloud/login"`},
+		},
+		{
+			name:   "webauthn without relying party",
+			modify: func(c *config.Config) { c.WebAuthn.Enabled = true },
+			errors: config.Errors{
+				"webauthn.rp_id: required by webauthn",
+				"webauthn.rp_name: required by webauthn",
+				"webauthn.origins: required by webauthn",
+			},
+		},
+		{
+			name: "webauthn origin of another site",
+			modify: func(c *config.Config) {
+				c.WebAuthn = config.WebAuthn{
+					Enabled: true,
+					RPID:    "phobia.cloud",
+					RPName:  "Phobia",
+					Origins: []string{"https://app.phobia.cloud", "https://phobia.club", "https://notphobia.cloud"},
+				}
+			},
+			errors: config.Errors{
+				`webauthn.origins: origin "https://phobia.club" is not on the rp_id "phobia.cloud"`,
+				`webauthn.origins: origin "https://notphobia.cloud" is not on the rp_id "phobia.cloud"`,
+			},
+		},
+		{
+			name:   "log level",
+			modify: func(c *config.Config) { c.Log.Level = "verbose" },
+			errors: config.Errors{`log.level: unknown log level: "verbose"`},
+		},
+		{
+			name:   "log component without level",
+			modify: func(c *config.Config) { c.Log.Components = []string{"access"} },
+			errors: config.Errors{`log.components: invalid component level: "access"`},
+		},
+		{
+			name:   "log component level",
+			modify: func(c *config.Config) { c.Log.Components = []string{"access=loud"} },
+			errors: config.Errors{`log.components: unknown log level: "loud"`},
+		},
+		{
+			name:   "file storage without path",
+			modify: func(c *config.Config) { c.Storage.Backend = config.StorageFile },
+			errors: config.Errors{"storage.path: required by the file backend"},
+		},
+		{
+			name:   "unknown storage",
+			modify: func(c *config.Config) { c.Storage.Backend = "redis" },
+			errors: config.Errors{`storage.backend: unsupported backend: "redis"`},
+		},
+		{
+			name:   "otlp without endpoint",
+			modify: func(c *config.Config) { c.Tracing.Exporter = config.TracingOTLP },
+			errors: config.Errors{`tracing.endpoint: must be an http or https url, got ""`},
+		},
+		{
+			name: "otlp without service",
+			modify: func(c *config.Config) {
+				c.Tracing.Exporter = config.TracingOTLP
+				c.Tracing.Endpoint = "http://localhost:4318/v1/traces"
+				c.Tracing.Service = ""
+			},
+			errors: config.Errors{"tracing.service: required by the otlp exporter"},
+		},
+		{
+			name:   "unknown tracing exporter",
+			modify: func(c *config.Config) { c.Tracing.Exporter = "jaeger" },
+			errors: config.Errors{`tracing.exporter: unsupported exporter: "jaeger"`},
+		},
+		{
+			name:   "tracing header without value",
+			modify: func(c *config.Config) { c.Tracing.Headers = []string{"x-api-key=1", "Bearer s3cr3t"} },
+			errors: config.Errors{"tracing.headers: header 2 is not name=value"},
+		},
+		{
+			name:   "rate limit without route",
+			modify: func(c *config.Config) { c.RateLimit.IP = []string{"60/1m"} },
+			errors: config.Errors{`ratelimit.ip: limit is not route=requests/period[:burst]: "60/1m"`},
+		},
+		{
+			name:   "invalid rate limit",
+			modify: func(c *config.Config) { c.RateLimit.Key = []string{"/v1/login=10/1m:0"} },
+			errors: config.Errors{"ratelimit.key: invalid limit of /v1/login: requests, period and burst of limits must be positive"},
+		},
+		{
+			name:   "invalid trusted proxy",
+			modify: func(c *config.Config) { c.Proxy.Trusted = []string{"10.0.0.0/8", "proxy"} },
+			errors: config.Errors{`proxy.trusted: invalid proxy address: "proxy"`},
+		},
+		{
+			name:   "abuse delay over max",
+			modify: func(c *config.Config) { c.Abuse.MaxDelay = 0 },
+			errors: config.Errors{"abuse: delay must not be negative or exceed the max delay"},
+		},
+		{
+			name: "lockouts endpoint without operator token",
+			modify: func(c *config.Config) {
+				c.Abuse.Enabled = true
+				c.Abuse.Endpoint = true
+				c.Operator.Token = "s3cr3t"
+			},
+			errors: config.Errors{"operator.token: must have at least 16 bytes to serve /lockouts"},
+		},
+		{
+			name: "pow max difficulty under difficulty",
+			modify: func(c *config.Config) {
+				c.PoW.Enabled = true
+				c.PoW.MaxDifficulty = 8
+			},
+			errors: config.Errors{"pow: difficulty must be between 0 and the max difficulty, which is at most 255"},
+		},
+		{
+			name:   "pow key not hex",
+			modify: func(c *config.Config) { c.PoW.Key = "s3cr3t" },
+			errors: config.Errors{"pow.key: key is not hex-encoded"},
+		},
+		{
+			name:   "short pow key",
+			modify: func(c *config.Config) { c.PoW.Key = "00112233" },
+			errors: config.Errors{"pow.key: key must have at least 16 bytes"},
+		},
+		{
+			name:   "audit without path",
+			modify: func(c *config.Config) { c.Audit.Enabled = true },
+			errors: config.Errors{"audit.path: required by the audit log"},
+		},
+		{
+			name:   "short audit key",
+			modify: func(c *config.Config) { c.Audit.Key = "00112233" },
+			errors: config.Errors{"audit.key: key must have at least 16 bytes"},
+		},
+		{
+			name: "webhook with invalid endpoints",
+			modify: func(c *config.Config) {
+				c.Storage = durable
+				c.Webhook.Endpoints = []string{"example.com/hook"}
+				c.Webhook.Secret = "0123456789abcdef"
+			},
+			errors: config.Errors{`webhook.endpoints: invalid endpoint URL: "example.com/hook"`},
+		},
+		{
+			name: "webhook with unknown event",
+			modify: func(c *config.Config) {
+				c.Storage = durable
+				c.Webhook.Endpoints = []string{"https://example.com/hook login.failure"}
+				c.Webhook.Secret = "0123456789abcdef"
+			},
+			errors: config.Errors{`webhook.endpoints: unknown event type "login.failure", must be one of login.success, login.locked_out, credential.registered`},
+		},
+		{
+			name: "webhook without secret",
+			modify: func(c *config.Config) {
+				c.Storage = durable
+				c.Webhook.Endpoints = []string{"https://example.com/hook"}
+			},
+			errors: config.Errors{"webhook.secret: must have at least 16 bytes"},
+		},
+		{
+			name: "webhook with memory storage",
+			modify: func(c *config.Config) {
+				c.Webhook.Endpoints = []string{"https://example.com/hook"}
+				c.Webhook.Secret = "0123456789abcdef"
+			},
+			errors: config.Errors{"webhook.endpoints: requires the file storage backend, the memory backend loses the queued deliveries on restart"},
+		},
+		{
+			name: "webhook backoff",
+			modify: func(c *config.Config) {
+				c.Storage = durable
+				c.Webhook.Endpoints = []string{"https://example.com/hook"}
+				c.Webhook.Secret = "0123456789abcdef"
+				c.Webhook.MaxBackoff = time.Second
+			},
+			errors: config.Errors{"webhook: max backoff must not be less than backoff 10s, got 1s"},
+		},
+		{
+			name: "webhook dead letters without endpoints",
+			modify: func(c *config.Config) {
+				c.Webhook.DeadLetters = true
+				c.Operator.Token = "0123456789abcdef"
+			},
+			errors: config.Errors{"webhook.dead_letters: requires webhook.endpoints"},
+		},
+		{
+			name: "webhook dead letters without operator token",
+			modify: func(c *config.Config) {
+				c.Storage = durable
+				c.Webhook.Endpoints = []string{"https://example.com/hook"}
+				c.Webhook.Secret = "0123456789abcdef"
+				c.Webhook.DeadLetters = true
+			},
+			errors: config.Errors{"operator.token: must have at least 16 bytes to serve /webhooks/dead-letters"},
+		},
+		{
+			name:   "security header not name=value",
+			modify: func(c *config.Config) { c.Security.Headers = []string{"X-Frame-Options"} },
+			errors: config.Errors{`security: header is not name=value: "X-Frame-Options"`},
+		},
+		{
+			name:   "security header of invalid route",
+			modify: func(c *config.Config) { c.Security.Routes = []string{"docs=X-Frame-Options=DENY"} },
+			errors: config.Errors{`security: invalid route: "docs"`},
+		},
+	} {
+		c := config.Default()
+		tt.modify(c)
+
+		err := c.Validate()
+		assert.Equal(t, tt.errors, err, tt.name)
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	c := config.Default()
+	policy, err := c.TLSPolicy()
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+
+	c.TLS = config.TLS{
+		Cert:       "cert.pem",
+		Key:        "key.pem",
+		MinVersion: "1.3",
+		Ciphers:    []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		ClientCA:   "ca.pem",
+		ClientAuth: "verify_if_given",
+		Redirect:   ":80",
+	}
+	require.NoError(t, c.Validate())
+
+	policy, err = c.TLSPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, &server.TLS{
+		CertFile:     "cert.pem",
+		KeyFile:      "key.pem",
+		MinVersion:   tls.VersionTLS13,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		ClientCAFile: "ca.pem",
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, policy)
+}
+
+func TestTimeouts(t *testing.T) {
+	c := config.Default()
+	assert.Equal(t, server.DefaultTimeouts, c.Timeouts())
+	assert.Equal(t, server.DefaultShutdownTimeout, c.HTTP.ShutdownTimeout)
+
+	c.HTTP.IdleTimeout = time.Minute
+	c.HTTP.MaxHeaderBytes = 1 << 10
+	assert.Equal(t, time.Minute, c.Timeouts().Idle)
+	assert.Equal(t, 1<<10, c.Timeouts().MaxHeaderBytes)
+}
+
+func TestChanged(t *testing.T) {
+	c, other := config.Default(), config.Default()
+	assert.Empty(t, c.Changed(other))
+
+	other.Listen = ":8080"
+	other.HTTP.MaxHeaderBytes = 1 << 10
+	other.Login.Versions = []int{3}
+	assert.Equal(t, []string{"listen", "http.max_header_bytes", "login.versions"}, c.Changed(other))
+}
+
+func TestLogLevels(t *testing.T) {
+	c := config.Default()
+	c.Log.Level = "warn"
+	c.Log.Components = []string{"access=error", "tls=debug"}
+	require.NoError(t, c.Validate())
+
+	levels, err := c.LogLevels()
+	require.NoError(t, err)
+	assert.Equal(t, logging.Levels{
+		Default:    logging.LevelWarn,
+		Components: map[string]logging.Level{"access": logging.LevelError, "tls": logging.LevelDebug},
+	}, levels)
+}
+
+func TestTracingExporter(t *testing.T) {
+	c := config.Default()
+	exporter, err := c.TracingExporter()
+	require.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	c.Tracing.Exporter = config.TracingOTLP
+	c.Tracing.Endpoint = "https://collector.example.com/v1/traces"
+	c.Tracing.Headers = []string{"Authorization=Bearer a=b"}
+	require.NoError(t, c.Validate())
+
+	exporter, err = c.TracingExporter()
+	require.NoError(t, err)
+	assert.Equal(t, &tracing.OTLP{
+		Endpoint: "https://collector.example.com/v1/traces",
+		Headers:  map[string]string{"Authorization": "Bearer a=b"},
+		Service:  "phobia-api",
+	}, exporter)
+}
+
+func TestRateLimiter(t *testing.T) {
+	c := config.Default()
+	s := ratelimit.NewMemory()
+
+	limiter, err := c.RateLimiter(s)
+	require.NoError(t, err)
+	assert.Equal(t, &server.RateLimit{
+		Store: s,
+		IP: map[string]ratelimit.Limit{
+			"/v1/challenge":                {Requests: 60, Period: time.Minute, Burst: 20},
+			"/v1/login":                    {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/login/nostr":              {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/login/ethereum":           {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/lnurl":                    {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/webauthn/register/begin":  {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/webauthn/register/finish": {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/webauthn/login/begin":     {Requests: 30, Period: time.Minute, Burst: 10},
+			"/v1/webauthn/login/finish":    {Requests: 30, Period: time.Minute, Burst: 10},
+		},
+		Key: map[string]ratelimit.Limit{
+			"/v1/login":          {Requests: 10, Period: time.Minute, Burst: 5},
+			"/v1/login/nostr":    {Requests: 10, Period: time.Minute, Burst: 5},
+			"/v1/login/ethereum": {Requests: 10, Period: time.Minute, Burst: 5},
+		},
+	}, limiter)
+
+	c.RateLimit.Enabled = false
+	limiter, err = c.RateLimiter(s)
+	require.NoError(t, err)
+	assert.Nil(t, limiter)
+}
+
+func TestAbusePolicy(t *testing.T) {
+	c := config.Default()
+	assert.Equal(t, abuse.DefaultPolicy, c.AbusePolicy())
+
+	c.Abuse.KeyFailures = 5
+	c.Abuse.Trust = 0
+	policy := c.AbusePolicy()
+	assert.Equal(t, 5, policy.KeyFailures)
+	assert.Zero(t, policy.Trust)
+
+	// the policy is not validated if it is disabled
+	c.Abuse.Enabled = false
+	c.Abuse.Window = 0
+	assert.NoError(t, c.Validate())
+}
+
+func TestPoW(t *testing.T) {
+	c := config.Default()
+	assert.False(t, c.PoW.Enabled)
+	assert.Equal(t, pow.DefaultPolicy, c.PoWPolicy())
+
+	key, err := c.PoWKey()
+	require.NoError(t, err)
+	assert.Nil(t, key)
+
+	c.PoW.Key = "000102030405060708090a0b0c0d0e0f"
+	key, err = c.PoWKey()
+	require.NoError(t, err)
+	assert.Len(t, key, 16)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	c := config.Default()
+	security, err := c.SecurityHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, server.NewSecurity(), security)
+
+	c.Security.Headers = []string{"referrer-policy=same-origin", "Cross-Origin-Resource-Policy="}
+	c.Security.Routes = []string{"/docs=X-Frame-Options=DENY", "/v1/challenge=Content-Security-Policy=default-src 'none'; img-src 'sha256-AA=='"}
+	security, err = c.SecurityHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, "same-origin", security.Headers[server.ReferrerPolicyHeader])
+	assert.Equal(t, "", security.Headers[server.CrossOriginResourcePolicyHeader])
+	assert.Equal(t, "DENY", security.Routes["/docs"]["X-Frame-Options"])
+	assert.Equal(t, "default-src 'none'; img-src 'sha256-AA=='", security.Routes["/v1/challenge"][server.ContentSecurityPolicyHeader])
+	assert.Equal(t, "no-store", security.Routes["/v1/challenge"][server.CacheControlHeader])
+
+	// invalid headers are not validated if they are disabled
+	c.Security.Enabled = false
+	c.Security.Headers = []string{"X-Frame-Options"}
+	security, err = c.SecurityHeaders()
+	require.NoError(t, err)
+	assert.Nil(t, security)
+	assert.NoError(t, c.Validate())
+}
+
+func TestOpenAudit(t *testing.T) {
+	c := config.Default()
+	l, err := c.OpenAudit()
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	c.Audit.Enabled = true
+	c.Audit.Path = filepath.Join(t.TempDir(), "audit.log")
+	c.Audit.Key = "000102030405060708090a0b0c0d0e0f"
+	require.NoError(t, c.Validate())
+	l, err = c.OpenAudit()
+	require.NoError(t, err)
+	require.NoError(t, l.Append(audit.Record{Event: audit.EventLoginSuccess}))
+	require.NoError(t, l.Close())
+
+	key, err := c.AuditKey()
+	require.NoError(t, err)
+	f, err := os.Open(c.Audit.Path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	summary, err := audit.Verify(f, key)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), summary.Records)
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	c := config.Default()
+	endpoints, err := c.WebhookEndpoints()
+	require.NoError(t, err)
+	assert.Empty(t, endpoints)
+	d, err := c.WebhookDispatcher(store.NewMemory())
+	require.NoError(t, err)
+	assert.Nil(t, d)
+
+	c.Webhook.Endpoints = []string{
+		"https://example.com/all",
+		"https://example.com/logins  login.success login.locked_out",
+	}
+	c.Webhook.Secret = "0123456789abcdef"
+	c.Webhook.MaxAttempts = 3
+	c.Storage.Backend = config.StorageFile
+	c.Storage.Path = t.TempDir()
+	require.NoError(t, c.Validate())
+
+	endpoints, err = c.WebhookEndpoints()
+	require.NoError(t, err)
+	secret := []byte("0123456789abcdef")
+	assert.Equal(t, []webhook.Endpoint{
+		{URL: "https://example.com/all", Secret: secret},
+		{URL: "https://example.com/logins", Events: []string{webhook.EventLoginSuccess, webhook.EventLockedOut}, Secret: secret},
+	}, endpoints)
+
+	policy := webhook.DefaultPolicy
+	policy.MaxAttempts = 3
+	assert.Equal(t, policy, c.WebhookPolicy())
+
+	d, err = c.WebhookDispatcher(store.NewMemory())
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	require.NoError(t, d.Close())
+}
+
+func TestOpenStore_File(t *testing.T) {
+	c := config.Default()
+	c.Storage.Backend = config.StorageFile
+	c.Storage.Path = t.TempDir()
+	require.NoError(t, c.Validate())
+
+	s, err := c.OpenStore()
+	require.NoError(t, err)
+	require.NoError(t, s.Put(context.Background(), "key", []byte("value"), 0))
+	assert.IsType(t, &store.File{}, s)
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package config provides the configuration of the server.
+//
+// Settings are identified by dotted keys, e.g. "tls.cert", and are loaded
+// from the following sources. Later sources take precedence over earlier
+// ones:
+//
+//  1. the defaults returned by Default
+//  2. the configuration file given by the -config flag or the PHOBIA_CONFIG
+//     environment variable
+//  3. environment variables, named PHOBIA_ followed by the key in upper case
+//     with dots replaced by underscores, e.g. PHOBIA_TLS_CERT
+//  4. command-line flags, named as the key with dots and underscores replaced
+//     by dashes, e.g. -tls-cert
+//
+// The configuration file is either JSON, with a nested object per section,
+// or a flat TOML- or YAML-like format:
+//
+//	listen = ":5050"
+//
+//	[cors]
+//	origins = ["https://phobia.cloud", "https://*.phobia.cloud"]
+//
+//	login:
+//	  versions: [2, 3]
+//
+// Lists are given in brackets or as comma-separated values. Comments start
+// with "#".
+//
+// On SIGHUP the server loads the configuration again from the same sources.
+// Changes of the listen address, the pow.key setting and the http, tls,
+// storage, tracing, proxy, audit and webhook sections, except
+// webhook.dead_letters, take effect only after a restart.
+package config
@@ -0,0 +1,289 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables of settings.
+const EnvPrefix = "PHOBIA_"
+
+// FileEnv is the environment variable with the path of the configuration
+// file. The -config flag takes precedence over it.
+const FileEnv = EnvPrefix + "CONFIG"
+
+// Flags are the command-line flags of the settings.
+type Flags struct {
+	file string
+	// values are the values of the flags set on the command line by key.
+	values map[string]string
+}
+
+// NewFlags defines the -config flag and a flag for each setting in set.
+func NewFlags(set *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+
+	set.StringVar(&f.file, "config", "", "path of the configuration file (env "+FileEnv+")")
+	for _, s := range Default().settings() {
+		_, isBool := s.value.(*boolValue)
+		set.Var(&flagValue{key: s.key, values: f.values, isBool: isBool, def: s.value.String()},
+			flagName(s.key), s.usage+" (env "+envName(s.key)+")")
+	}
+	return f
+}
+
+// flagValue records the value of a flag, so it can be applied after the
+// configuration file and the environment.
+type flagValue struct {
+	key    string
+	values map[string]string
+	isBool bool
+	def    string
+}
+
+func (v *flagValue) Set(s string) error {
+	// check the value early, so the flag package reports the flag
+	err := Default().setting(v.key).value.Set(s)
+	if err != nil {
+		return err
+	}
+	v.values[v.key] = s
+	return nil
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.values == nil {
+		return ""
+	}
+	if s, ok := v.values[v.key]; ok {
+		return s
+	}
+	return v.def
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+// Load returns the configuration from the defaults, the configuration file,
+// the environment and the flags parsed by the flag set of f, in increasing
+// precedence. lookupEnv is usually os.LookupEnv. The configuration is
+// validated.
+func (f *Flags) Load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+
+	file := f.file
+	if file == "" {
+		file, _ = lookupEnv(FileEnv)
+	}
+	if file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		err = c.apply(values, func(key string) string {
+			return fmt.Sprintf("%s: %s", file, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, s := range c.settings() {
+		if v, ok := lookupEnv(envName(s.key)); ok {
+			env[s.key] = v
+		}
+	}
+	err := c.apply(env, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.apply(f.values, func(key string) string { return "-" + flagName(key) })
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setting returns the setting of key, or nil if there is no such setting.
+func (c *Config) setting(key string) *setting {
+	for _, s := range c.settings() {
+		if s.key == key {
+			return &s
+		}
+	}
+	return nil
+}
+
+// apply sets the settings to values by key. Errors are reported for the
+// source of the value as returned by source.
+func (c *Config) apply(values map[string]string, source func(key string) string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := c.setting(key)
+		if s == nil {
+			return fmt.Errorf("%s: unknown setting", source(key))
+		}
+		err := s.value.Set(values[key])
+		if err != nil {
+			return fmt.Errorf("%s: %v", source(key), err)
+		}
+	}
+	return nil
+}
+
+// readFile reads the settings of the configuration file at path by key.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %v", err)
+	}
+
+	if filepath.Ext(path) == ".json" || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		values, err := parseJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		return values, nil
+	}
+
+	values, err := parseFlat(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// parseJSON parses a JSON configuration file with an object per section.
+func parseJSON(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var root map[string]interface{}
+	err := decoder.Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	var flatten func(prefix string, m map[string]interface{}) error
+	flatten = func(prefix string, m map[string]interface{}) error {
+		for name, v := range m {
+			key := prefix + name
+			switch v := v.(type) {
+			case map[string]interface{}:
+				err := flatten(key+".", v)
+				if err != nil {
+					return err
+				}
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					if _, ok := item.(map[string]interface{}); ok {
+						return fmt.Errorf("%s: unexpected object in list", key)
+					}
+					items[i] = fmt.Sprintf("%q", fmt.Sprint(item))
+				}
+				values[key] = "[" + strings.Join(items, ", ") + "]"
+			case nil:
+				values[key] = ""
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+		return nil
+	}
+
+	err = flatten("", root)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseFlat parses a configuration file in the TOML- or YAML-like format
+// described in the package documentation.
+func parseFlat(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	// section is the prefix of a [section] header, block the prefix of a
+	// "section:" line of the YAML-like format, which applies to the
+	// following indented lines
+	var section, block string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section, block = strings.TrimSpace(trimmed[1:len(trimmed)-1])+".", ""
+			continue
+		}
+
+		i := strings.IndexAny(trimmed, "=:")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.TrimSpace(trimmed[:i])
+		value := stripComment(strings.TrimSpace(trimmed[i+1:]))
+
+		if !indented {
+			block = ""
+		}
+		if value == "" && trimmed[i] == ':' && !indented {
+			block = key + "."
+			continue
+		}
+
+		values[section+block+key] = unquote(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripComment removes a "#" comment after a value, unless it is quoted.
+func stripComment(value string) string {
+	quote := byte(0)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config_test
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/config"
+)
+
+// load loads the configuration with the command-line args and the
+// environment given as name-value pairs.
+func load(t *testing.T, args []string, env ...string) (*config.Config, error) {
+	set := flag.NewFlagSet("api", flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	flags := config.NewFlags(set)
+	require.NoError(t, set.Parse(args))
+
+	return flags.Load(func(name string) (string, bool) {
+		for i := 0; i+1 < len(env); i += 2 {
+			if env[i] == name {
+				return env[i+1], true
+			}
+		}
+		return "", false
+	})
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := load(t, nil)
+	require.NoError(t, err)
+	assert.Equal(t, config.Default(), c)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "api.toml", `
+listen = ":6000"
+
+[challenge]
+max_age = "1m"
+lnurl_ttl = "2m"
+webauthn_ttl = "3m"
+`)
+
+	c, err := load(t, []string{"-config", path, "-challenge-max-age", "10m"},
+		"PHOBIA_CHALLENGE_MAX_AGE", "20m",
+		"PHOBIA_CHALLENGE_LNURL_TTL", "30m")
+	require.NoError(t, err)
+	assert.Equal(t, ":6000", c.Listen)
+	assert.Equal(t, 10*time.Minute, c.Challenge.MaxAge)
+	assert.Equal(t, 30*time.Minute, c.Challenge.LNURLTTL)
+	assert.Equal(t, 3*time.Minute, c.Challenge.WebAuthnTTL)
+
+	// the file can be given in the environment
+	c, err = load(t, nil, config.FileEnv, path)
+	require.NoError(t, err)
+	assert.Equal(t, ":6000", c.Listen)
+}
+
+func TestLoad_Formats(t *testing.T) {
+	expected := config.Default()
+	expected.Listen = "127.0.0.1:6000"
+	expected.TLS.Cert = "/etc/api/cert.pem"
+	expected.TLS.Key = "/etc/api/key.pem"
+	expected.CORS.Origins = []string{"https://phobia.cloud", "https://*.phobia.cloud"}
+	expected.CORS.Credentials = true
+	expected.CORS.MaxAge = 10 * time.Minute
+	expected.Challenge.Visual = "Login to phobia.cloud\n{time}"
+	expected.Challenge.Timezone = "Europe/Sofia"
+	expected.Login.Versions = []int{2, 3}
+	expected.Login.Origins = []string{"https://phobia.cloud"}
+
+	for name, content := range map[string]string{
+		"api.json": `{
+			"listen": "127.0.0.1:6000",
+			"tls": {"cert": "/etc/api/cert.pem", "key": "/etc/api/key.pem"},
+			"cors": {
+				"origins": ["https://phobia.cloud", "https://*.phobia.cloud"],
+				"credentials": true,
+				"max_age": "10m"
+			},
+			"challenge": {"visual": "Login to phobia.cloud\n{time}", "timezone": "Europe/Sofia"},
+			"login": {"versions": [2, 3], "origins": ["https://phobia.cloud"]}
+		}`,
+		"api.toml": `
+# phobia.cloud api
+listen = "127.0.0.1:6000"
+
+[tls]
+cert = "/etc/api/cert.pem"
+key = '/etc/api/key.pem'
+
+[cors]
+origins = ["https://phobia.cloud", "https://*.phobia.cloud"] # the app
+credentials = true
+max_age = 10m
+
+[challenge]
+visual = "Login to phobia.cloud\n{time}"
+timezone = "Europe/Sofia"
+
+[login]
+versions = [2, 3]
+origins = ["https://phobia.cloud"]
+`,
+		"api.yaml": `
+listen: 127.0.0.1:6000
+tls:
+  cert: /etc/api/cert.pem
+  key: /etc/api/key.pem
+cors:
+  origins: https://phobia.cloud, https://*.phobia.cloud
+  credentials: true
+  max_age: 10m
+challenge:
+  visual: "Login to phobia.cloud\n{time}"
+  timezone: Europe/Sofia
+login.versions: [2, 3]
+login.origins: [https://phobia.cloud]
+`,
+	} {
+		c, err := load(t, []string{"-config", writeFile(t, name, content)})
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, c, name)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		args []string
+		env  []string
+		err  string
+	}{
+		{
+			name: "missing file",
+			args: []string{"-config", "/nonexistent/api.toml"},
+			err:  "failed to read configuration file: open /nonexistent/api.toml: no such file or directory",
+		},
+		{
+			name: "unknown setting in file",
+			file: "[tls]\ncertificate = cert.pem\n",
+			err:  "tls.certificate: unknown setting",
+		},
+		{
+			name: "invalid line",
+			file: "listen\n",
+			err:  "line 1: expected key = value",
+		},
+		{
+			name: "invalid json",
+			file: `{"listen": }`,
+			err:  "invalid character '}' looking for beginning of value",
+		},
+		{
+			name: "invalid environment variable",
+			env:  []string{"PHOBIA_CORS_CREDENTIALS", "maybe"},
+			err:  `PHOBIA_CORS_CREDENTIALS: invalid boolean: "maybe"`,
+		},
+		{
+			name: "invalid configuration",
+			env:  []string{"PHOBIA_STORAGE_BACKEND", "file"},
+			err:  "invalid configuration: storage.path: required by the file backend",
+		},
+	} {
+		args := tt.args
+		if tt.file != "" {
+			args = []string{"-config", writeFile(t, "api.conf", tt.file)}
+		}
+
+		_, err := load(t, args, tt.env...)
+		if assert.Error(t, err, tt.name) {
+			assert.Contains(t, err.Error(), tt.err, tt.name)
+		}
+	}
+}
+
+func TestNewFlags_InvalidValue(t *testing.T) {
+	set := flag.NewFlagSet("api", flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	config.NewFlags(set)
+
+	err := set.Parse([]string{"-login-versions", "two"})
+	assert.EqualError(t, err, `invalid value "two" for flag -login-versions: invalid integer: "two"`)
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Redacted replaces the values of secret settings in the output of Print.
+const Redacted = `"REDACTED"`
+
+// Print writes the configuration to w in the format of the configuration
+// file. The values of secret settings are replaced by Redacted.
+func (c *Config) Print(w io.Writer) error {
+	return printSettings(w, c.settings())
+}
+
+func printSettings(w io.Writer, settings []setting) error {
+	bw := bufio.NewWriter(w)
+
+	section := ""
+	for _, s := range settings {
+		name := s.key
+		if i := strings.LastIndexByte(s.key, '.'); i >= 0 {
+			if s.key[:i] != section {
+				section = s.key[:i]
+				bw.WriteString("\n[" + section + "]\n")
+			}
+			name = s.key[i+1:]
+		}
+
+		value := s.value.String()
+		if s.secret && value != `""` && value != "[]" {
+			value = Redacted
+		}
+		bw.WriteString(name + " = " + value + "\n")
+	}
+
+	return bw.Flush()
+}
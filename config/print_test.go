@@ -0,0 +1,58 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	c.CORS.Origins = []string{"https://phobia.cloud"}
+	c.Challenge.Visual = "Login to phobia.cloud\n{time}"
+	c.Login.Versions = []int{3}
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Print(&buf))
+	assert.Contains(t, buf.String(), "listen = \":5050\"\n\n[http]\n")
+	assert.Contains(t, buf.String(), "read_header_timeout = \"5s\"\n")
+	assert.Contains(t, buf.String(), "max_header_bytes = 65536\n")
+	assert.Contains(t, buf.String(), "origins = [\"https://phobia.cloud\"]\n")
+	assert.Contains(t, buf.String(), "visual = \"Login to phobia.cloud\\n{time}\"\n")
+	assert.Contains(t, buf.String(), "versions = [3]\n")
+
+	// the output is a valid configuration file
+	path := filepath.Join(t.TempDir(), "api.toml")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	values, err := readFile(path)
+	require.NoError(t, err)
+
+	loaded := Default()
+	require.NoError(t, loaded.apply(values, func(key string) string { return key }))
+	assert.Equal(t, c, loaded)
+}
+
+func TestPrint_Redacted(t *testing.T) {
+	var token, empty string
+	var headers, noHeaders []string
+	settings := []setting{
+		{key: "webhook.name", value: (*stringValue)(&token)},
+		{key: "webhook.secret", secret: true, value: (*stringValue)(&token)},
+		{key: "webhook.other_secret", secret: true, value: (*stringValue)(&empty)},
+		{key: "webhook.headers", secret: true, value: (*listValue)(&headers)},
+		{key: "webhook.other_headers", secret: true, value: (*listValue)(&noHeaders)},
+	}
+	token = "s3cr3t"
+	headers = []string{"Authorization=Bearer s3cr3t"}
+
+	var buf bytes.Buffer
+	require.NoError(t, printSettings(&buf, settings))
+	assert.Equal(t, "\n[webhook]\nname = \"s3cr3t\"\nsecret = \"REDACTED\"\nother_secret = \"\"\nheaders = \"REDACTED\"\nother_headers = []\n", buf.String())
+}
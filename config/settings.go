@@ -0,0 +1,274 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a configuration setting that can be set from the configuration
+// file, the environment and command-line flags.
+type setting struct {
+	// key is the dotted key of the setting, e.g. "tls.cert".
+	key   string
+	usage string
+	// secret settings are redacted by Config.Print.
+	secret bool
+	value  value
+}
+
+// value is the value of a setting. Set parses the textual form of the value
+// and String formats it in the syntax of the configuration file.
+type value interface {
+	Set(string) error
+	String() string
+}
+
+// settings returns the settings of c. Setting them modifies c.
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "listen", usage: "TCP address to listen on", value: (*stringValue)(&c.Listen)},
+
+		{key: "http.read_header_timeout", usage: "how long reading the request headers may take", value: (*durationValue)(&c.HTTP.ReadHeaderTimeout)},
+		{key: "http.read_timeout", usage: "how long reading the whole request may take", value: (*durationValue)(&c.HTTP.ReadTimeout)},
+		{key: "http.write_timeout", usage: "how long writing the response may take", value: (*durationValue)(&c.HTTP.WriteTimeout)},
+		{key: "http.idle_timeout", usage: "how long a keep-alive connection may be idle", value: (*durationValue)(&c.HTTP.IdleTimeout)},
+		{key: "http.max_header_bytes", usage: "maximum size of the request headers", value: (*intValue)(&c.HTTP.MaxHeaderBytes)},
+		{key: "http.shutdown_timeout", usage: "how long in-flight requests are waited for on shutdown", value: (*durationValue)(&c.HTTP.ShutdownTimeout)},
+
+		{key: "tls.cert", usage: "path of the PEM-encoded TLS certificate chain", value: (*stringValue)(&c.TLS.Cert)},
+		{key: "tls.key", usage: "path of the PEM-encoded TLS private key", value: (*stringValue)(&c.TLS.Key)},
+		{key: "tls.min_version", usage: "minimum TLS version: 1.2 or 1.3", value: (*stringValue)(&c.TLS.MinVersion)},
+		{key: "tls.ciphers", usage: "names of the allowed TLS 1.2 cipher suites", value: (*listValue)(&c.TLS.Ciphers)},
+		{key: "tls.client_ca", usage: "path of the PEM-encoded authorities of client certificates", value: (*stringValue)(&c.TLS.ClientCA)},
+		{key: "tls.client_auth", usage: "client certificate policy: request, require_any, verify_if_given or require_and_verify", value: (*stringValue)(&c.TLS.ClientAuth)},
+		{key: "tls.redirect", usage: "TCP address of a plain HTTP listener that redirects to HTTPS", value: (*stringValue)(&c.TLS.Redirect)},
+
+		{key: "cors.origins", usage: "origins allowed to make cross-origin requests", value: (*listValue)(&c.CORS.Origins)},
+		{key: "cors.methods", usage: "methods allowed in cross-origin requests", value: (*listValue)(&c.CORS.Methods)},
+		{key: "cors.headers", usage: "request headers allowed in cross-origin requests", value: (*listValue)(&c.CORS.Headers)},
+		{key: "cors.exposed_headers", usage: "response headers exposed to cross-origin scripts", value: (*listValue)(&c.CORS.ExposedHeaders)},
+		{key: "cors.credentials", usage: "allow cross-origin requests with credentials", value: (*boolValue)(&c.CORS.Credentials)},
+		{key: "cors.max_age", usage: "how long browsers may cache preflight responses", value: (*durationValue)(&c.CORS.MaxAge)},
+
+		{key: "challenge.max_age", usage: "how long a login challenge is accepted", value: (*durationValue)(&c.Challenge.MaxAge)},
+		{key: "challenge.visual", usage: "template of the challenge visual with a {time} placeholder", value: (*stringValue)(&c.Challenge.Visual)},
+		{key: "challenge.layout", usage: "Go time layout of the time in the challenge visual", value: (*stringValue)(&c.Challenge.Layout)},
+		{key: "challenge.timezone", usage: "IANA time zone of the time in the challenge visual", value: (*stringValue)(&c.Challenge.Timezone)},
+		{key: "challenge.lnurl_ttl", usage: "how long an LNURL-auth challenge remains valid", value: (*durationValue)(&c.Challenge.LNURLTTL)},
+		{key: "challenge.webauthn_ttl", usage: "how long a WebAuthn challenge remains valid", value: (*durationValue)(&c.Challenge.WebAuthnTTL)},
+
+		{key: "login.versions", usage: "allowed versions of the login challenge", value: (*intListValue)(&c.Login.Versions)},
+		{key: "login.origins", usage: "public origins of the server, allowed in version 3 login challenges, nostr events and siwe messages", value: (*listValue)(&c.Login.Origins)},
+		{key: "login.require_origin", usage: "reject login challenges that do not commit to the origin", value: (*boolValue)(&c.Login.RequireOrigin)},
+
+		{key: "webauthn.enabled", usage: "serve the WebAuthn passkey routes", value: (*boolValue)(&c.WebAuthn.Enabled)},
+		{key: "webauthn.rp_id", usage: "WebAuthn relying party ID, usually the domain of the site", value: (*stringValue)(&c.WebAuthn.RPID)},
+		{key: "webauthn.rp_name", usage: "human-readable name of the WebAuthn relying party", value: (*stringValue)(&c.WebAuthn.RPName)},
+		{key: "webauthn.origins", usage: "origins allowed in WebAuthn client data", value: (*listValue)(&c.WebAuthn.Origins)},
+
+		{key: "storage.backend", usage: "storage backend: memory or file", value: (*stringValue)(&c.Storage.Backend)},
+		{key: "storage.path", usage: "directory of the file storage backend", value: (*stringValue)(&c.Storage.Path)},
+
+		{key: "metrics.enabled", usage: "serve prometheus metrics at /metrics", value: (*boolValue)(&c.Metrics.Enabled)},
+
+		{key: "log.level", usage: "minimum level of log entries: debug, info, warn or error", value: (*stringValue)(&c.Log.Level)},
+		{key: "log.components", usage: "levels of components as component=level, e.g. access=warn", value: (*listValue)(&c.Log.Components)},
+
+		{key: "tracing.exporter", usage: "exporter of request spans: none or otlp", value: (*stringValue)(&c.Tracing.Exporter)},
+		{key: "tracing.endpoint", usage: "URL of the OTLP/HTTP traces endpoint of the collector", value: (*stringValue)(&c.Tracing.Endpoint)},
+		{key: "tracing.service", usage: "service name of the exported spans", value: (*stringValue)(&c.Tracing.Service)},
+		{key: "tracing.headers", usage: "headers sent to the collector as name=value", secret: true, value: (*listValue)(&c.Tracing.Headers)},
+
+		{key: "openapi.viewer", usage: "serve a viewer of the OpenAPI document at /docs", value: (*boolValue)(&c.OpenAPI.Viewer)},
+
+		{key: "ratelimit.enabled", usage: "limit the rate of challenge and login requests", value: (*boolValue)(&c.RateLimit.Enabled)},
+		{key: "ratelimit.ip", usage: "limits per client IP as route=requests/period[:burst]", value: (*listValue)(&c.RateLimit.IP)},
+		{key: "ratelimit.key", usage: "limits per login public key or address as route=requests/period[:burst]", value: (*listValue)(&c.RateLimit.Key)},
+
+		{key: "proxy.trusted", usage: "IP addresses and CIDR networks of trusted reverse proxies", value: (*listValue)(&c.Proxy.Trusted)},
+		{key: "proxy.protocol", usage: "accept the PROXY protocol v1/v2 from trusted proxies", value: (*boolValue)(&c.Proxy.Protocol)},
+
+		{key: "abuse.enabled", usage: "delay and lock out repeated failed logins", value: (*boolValue)(&c.Abuse.Enabled)},
+		{key: "abuse.window", usage: "how long failed logins are counted", value: (*durationValue)(&c.Abuse.Window)},
+		{key: "abuse.key_failures", usage: "failed logins in the window that lock a public key out", value: (*intValue)(&c.Abuse.KeyFailures)},
+		{key: "abuse.ip_failures", usage: "failed logins in the window that lock a client IP out", value: (*intValue)(&c.Abuse.IPFailures)},
+		{key: "abuse.subnet_failures", usage: "failed logins in the window that lock a subnet out", value: (*intValue)(&c.Abuse.SubnetFailures)},
+		{key: "abuse.delay", usage: "delay after the first failed login, doubled with each further one", value: (*durationValue)(&c.Abuse.Delay)},
+		{key: "abuse.max_delay", usage: "maximum delay after failed logins", value: (*durationValue)(&c.Abuse.MaxDelay)},
+		{key: "abuse.lockout", usage: "duration of the first lockout, doubled with each consecutive one", value: (*durationValue)(&c.Abuse.Lockout)},
+		{key: "abuse.max_lockout", usage: "maximum duration of a lockout", value: (*durationValue)(&c.Abuse.MaxLockout)},
+		{key: "abuse.trust", usage: "how long the client IP of a successful login is trusted for the key", value: (*durationValue)(&c.Abuse.Trust)},
+		{key: "abuse.endpoint", usage: "serve the active lockouts at /lockouts, for operators only", value: (*boolValue)(&c.Abuse.Endpoint)},
+
+		{key: "pow.enabled", usage: "require a proof of work for challenges", value: (*boolValue)(&c.PoW.Enabled)},
+		{key: "pow.difficulty", usage: "leading zero bits of proofs of work at the target rate", value: (*intValue)(&c.PoW.Difficulty)},
+		{key: "pow.max_difficulty", usage: "maximum leading zero bits of proofs of work", value: (*intValue)(&c.PoW.MaxDifficulty)},
+		{key: "pow.target_rate", usage: "challenges per minute above which the difficulty grows", value: (*intValue)(&c.PoW.TargetRate)},
+		{key: "pow.ttl", usage: "how long a proof-of-work puzzle can be solved", value: (*durationValue)(&c.PoW.TTL)},
+		{key: "pow.key", usage: "hex-encoded key of the puzzles shared by the instances of the server", secret: true, value: (*stringValue)(&c.PoW.Key)},
+
+		{key: "security.enabled", usage: "send security headers like HSTS and a content security policy", value: (*boolValue)(&c.Security.Enabled)},
+		{key: "security.headers", usage: "headers of all responses as name=value, an empty value removes a default", value: (*listValue)(&c.Security.Headers)},
+		{key: "security.routes", usage: "headers of the responses of a route as route=name=value", value: (*listValue)(&c.Security.Routes)},
+
+		{key: "audit.enabled", usage: "record challenges, logins and credential changes in a hash-chained log", value: (*boolValue)(&c.Audit.Enabled)},
+		{key: "audit.path", usage: "file of the audit log", value: (*stringValue)(&c.Audit.Path)},
+		{key: "audit.key", usage: "hex-encoded key of the hashes of the audit log", secret: true, value: (*stringValue)(&c.Audit.Key)},
+
+		{key: "webhook.endpoints", usage: "receivers of login and account events as \"url [type...]\", requires the file storage backend", value: (*listValue)(&c.Webhook.Endpoints)},
+		{key: "webhook.secret", usage: "key of the HMAC-SHA256 signatures of the webhooks", secret: true, value: (*stringValue)(&c.Webhook.Secret)},
+		{key: "webhook.max_attempts", usage: "attempts of a delivery before it becomes a dead letter", value: (*intValue)(&c.Webhook.MaxAttempts)},
+		{key: "webhook.backoff", usage: "delay after the first failed attempt, doubled with each further one", value: (*durationValue)(&c.Webhook.Backoff)},
+		{key: "webhook.max_backoff", usage: "maximum delay between attempts of a delivery", value: (*durationValue)(&c.Webhook.MaxBackoff)},
+		{key: "webhook.timeout", usage: "how long an attempt of a delivery may take", value: (*durationValue)(&c.Webhook.Timeout)},
+		{key: "webhook.dead_letters", usage: "serve and replay the dead letters at /webhooks/dead-letters, for operators only", value: (*boolValue)(&c.Webhook.DeadLetters)},
+
+		{key: "operator.token", usage: "bearer token of the operator endpoints", secret: true, value: (*stringValue)(&c.Operator.Token)},
+	}
+}
+
+// Changed returns the keys of the settings that differ between c and other.
+func (c *Config) Changed(other *Config) []string {
+	var changed []string
+	otherSettings := other.settings()
+	for i, s := range c.settings() {
+		if s.value.String() != otherSettings[i].value.String() {
+			changed = append(changed, s.key)
+		}
+	}
+	return changed
+}
+
+// envName returns the name of the environment variable of key.
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flagName returns the name of the command-line flag of key.
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string { return strconv.Quote(string(*v)) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean: %q", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid integer: %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return strconv.Quote(time.Duration(*v).String()) }
+
+// listValue is a list of strings, given as comma-separated values.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	*v = splitList(s)
+	return nil
+}
+
+func (v *listValue) String() string {
+	quoted := make([]string, len(*v))
+	for i, s := range *v {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// intListValue is a list of integers, given as comma-separated values.
+type intListValue []int
+
+func (v *intListValue) Set(s string) error {
+	var list []int
+	for _, item := range splitList(s) {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("invalid integer: %q", item)
+		}
+		list = append(list, n)
+	}
+	*v = list
+	return nil
+}
+
+func (v *intListValue) String() string {
+	items := make([]string, len(*v))
+	for i, n := range *v {
+		items[i] = strconv.Itoa(n)
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// splitList splits comma-separated values, optionally in brackets and
+// quoted.
+func splitList(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = unquote(strings.TrimSpace(item))
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// unquote removes the double or single quotes around s, if any.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' && !strings.Contains(s[1:len(s)-1], "'") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/remote"
+	"phobia.cloud/api/webhook"
+)
+
+// abuseGuard delays and locks out the keys, clients and networks of
+// repeated failed logins of a login method. The same Detector is shared by
+// all methods, so failures with one method count against the client for
+// the others too. A guard without a Detector allows all logins.
+type abuseGuard struct {
+	detector *abuse.Detector
+	policy   abuse.Policy
+	// method is the login method, e.g. "trezor".
+	method string
+	// noun names the keys of the method in problems, e.g. "public key".
+	noun     string
+	metrics  *Metrics
+	webhooks *webhook.Dispatcher
+}
+
+func (g abuseGuard) abusePolicy() abuse.Policy {
+	if g.policy == (abuse.Policy{}) {
+		return abuse.DefaultPolicy
+	}
+	return g.policy
+}
+
+// check returns the problem of a login with key from the client of r that
+// is not allowed and sets the Retry-After header of w, or nil if the login
+// is allowed.
+func (g abuseGuard) check(w http.ResponseWriter, r *http.Request, key string) *problem.Problem {
+	if g.detector == nil {
+		return nil
+	}
+	decision := g.detector.Check(key, remote.IP(r))
+	if decision.Allowed {
+		return nil
+	}
+	return g.throttled(w, decision)
+}
+
+// failure counts a failed login with key from the client of r if its error
+// class shows a forged or malformed signature, and handles the lockouts it
+// causes.
+func (g abuseGuard) failure(r *http.Request, key, class string) {
+	if g.detector == nil || (class != ErrorClassInvalidSignature && class != ErrorClassParse) {
+		return
+	}
+	for _, lockout := range g.detector.Failure(g.abusePolicy(), key, remote.IP(r)) {
+		g.lockedOut(r, lockout)
+	}
+}
+
+// success clears the failures of key after a login from the client of r.
+func (g abuseGuard) success(r *http.Request, key string) {
+	if g.detector == nil {
+		return
+	}
+	g.detector.Success(g.abusePolicy(), key, remote.IP(r))
+}
+
+// lockedOut logs, counts and publishes a lockout caused by the login
+// request r.
+func (g abuseGuard) lockedOut(r *http.Request, lockout abuse.Lockout) {
+	subject := lockout.Subject
+	if lockout.Scope == abuse.ScopeKey {
+		subject = logging.Fingerprint(subject)
+	}
+	logger(r).Warn("locked out after repeated failed logins",
+		"method", g.method,
+		"scope", lockout.Scope,
+		"subject", subject,
+		"until", lockout.Until.UTC().Format(time.RFC3339),
+		"count", lockout.Count)
+	g.metrics.lockout(lockout.Scope)
+	until := lockout.Until.UTC()
+	publish(g.webhooks, r, webhook.EventLockedOut, webhook.Data{
+		Method:  g.method,
+		Subject: lockout.Subject,
+		Scope:   lockout.Scope,
+		Until:   &until,
+	})
+}
+
+// throttled returns the problem of a login attempt that is not allowed by
+// decision and sets the Retry-After header of w.
+func (g abuseGuard) throttled(w http.ResponseWriter, decision abuse.Decision) *problem.Problem {
+	retryAfter := int((decision.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	subject := map[string]string{
+		abuse.ScopeKey:    g.noun,
+		abuse.ScopeIP:     "client",
+		abuse.ScopeSubnet: "network of the client",
+	}[decision.Scope]
+	reason := "delayed"
+	if decision.Locked {
+		reason = "locked out"
+	}
+	return problem.New(http.StatusTooManyRequests, problem.TooManyRequests,
+		fmt.Sprintf("the %s is %s after repeated failed logins, retry in %d seconds", subject, reason, retryAfter))
+}
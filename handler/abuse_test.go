@@ -0,0 +1,150 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+// abusePolicy locks a key out after a single failure without delays.
+func abusePolicy() abuse.Policy {
+	policy := abuse.DefaultPolicy
+	policy.Delay, policy.MaxDelay = 0, 0
+	policy.KeyFailures = 1
+	return policy
+}
+
+func TestAbuse_NostrLogin(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges, Abuse: abuse.NewDetector(), AbusePolicy: abusePolicy()}
+
+	forged := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), []string{"challenge", issue()})
+	forged.Sig = nostrKey.NostrAuthEvent(http.MethodGet, nostrLoginURL, time.Now()).Sig
+	rr := nostrLogin(t, h, http.MethodPost, nostrAuthorization(t, forged))
+	assertProblem(t, rr, http.StatusUnauthorized, problem.Unauthorized)
+
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), []string{"challenge", issue()})
+	rr = nostrLogin(t, h, http.MethodPost, nostrAuthorization(t, event))
+	assertProblem(t, rr, http.StatusTooManyRequests, problem.TooManyRequests)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "the public key is locked out")
+
+	lockouts := h.Abuse.Lockouts()
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, nostrKey.PublicKey(), lockouts[0].Subject)
+}
+
+func TestAbuse_EthereumLogin(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges, Abuse: abuse.NewDetector(), AbusePolicy: abusePolicy()}
+	message := func() string {
+		m := &login.SIWEMessage{
+			Domain:   "phobia.cloud",
+			Address:  ethereumKey.Address(),
+			URI:      "http://phobia.cloud/login/ethereum",
+			Version:  login.SIWEVersion,
+			ChainID:  1,
+			Nonce:    issue(),
+			IssuedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		return m.String()
+	}
+
+	forged, err := json.Marshal(handler.EthereumLoginRequest{
+		Message:   message(),
+		Signature: ethereumKey.PersonalSign([]byte("phobia.cloud")),
+	})
+	require.NoError(t, err)
+	rr := ethereumLogin(t, h, http.MethodPost, string(forged))
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidSignature)
+
+	m, err := login.ParseSIWEMessage(message())
+	require.NoError(t, err)
+	rr = ethereumLogin(t, h, http.MethodPost, signedSIWE(t, m))
+	assertProblem(t, rr, http.StatusTooManyRequests, problem.TooManyRequests)
+	assert.Contains(t, rr.Body.String(), "the address is locked out")
+
+	lockouts := h.Abuse.Lockouts()
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, strings.ToLower(ethereumKey.Address()), lockouts[0].Subject)
+}
+
+func TestAbuse_WebAuthn(t *testing.T) {
+	h := newWebAuthn()
+	h.Abuse, h.AbusePolicy = abuse.NewDetector(), abusePolicy()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	other := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{4}, 32))
+
+	options := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// an assertion of the credential signed by another key
+	forged := assertion(other, loginBegin(t, h, "alice").Challenge, nil)
+	forged.ID = auth.CredentialID
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, forged)
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidSignature)
+
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, loginBegin(t, h, "alice").Challenge, nil))
+	assertProblem(t, rr, http.StatusTooManyRequests, problem.TooManyRequests)
+	assert.Contains(t, rr.Body.String(), "the user is locked out")
+
+	// the user cannot be named after the public key of another method
+	lockouts := h.Abuse.Lockouts()
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, "webauthn:alice", lockouts[0].Subject)
+}
+
+func TestAbuse_SharedDetector(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	detector := abuse.NewDetector()
+	policy := abusePolicy()
+	policy.KeyFailures, policy.IPFailures = 10, 2
+	nostr := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges, Abuse: detector, AbusePolicy: policy}
+	ethereum := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges, Abuse: detector, AbusePolicy: policy}
+
+	// failures of the client with nostr count for ethereum too
+	for i := 0; i < policy.IPFailures; i++ {
+		forged := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), []string{"challenge", issue()})
+		forged.Sig = nostrKey.NostrAuthEvent(http.MethodGet, nostrLoginURL, time.Now()).Sig
+		req, err := http.NewRequest(http.MethodPost, nostrLoginURL, nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "203.0.113.7:52114"
+		req.Header.Set("Authorization", nostrAuthorization(t, forged))
+		nostr.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	m := &login.SIWEMessage{
+		Domain:   "phobia.cloud",
+		Address:  ethereumKey.Address(),
+		URI:      "http://phobia.cloud/login/ethereum",
+		Version:  login.SIWEVersion,
+		ChainID:  1,
+		Nonce:    issue(),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://phobia.cloud/login/ethereum", strings.NewReader(signedSIWE(t, m)))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.7:52114"
+	rr := httptest.NewRecorder()
+	ethereum.ServeHTTP(rr, req)
+	assertProblem(t, rr, http.StatusTooManyRequests, problem.TooManyRequests)
+	assert.Contains(t, rr.Body.String(), "the client is locked out")
+}
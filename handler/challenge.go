@@ -4,11 +4,19 @@
 package handler
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"phobia.cloud/api/audit"
 	"phobia.cloud/api/login"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/remote"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
 )
 
 // ChallengeResponse is a pair of ChallengeHidden and ChallengeVisual for
@@ -16,24 +24,299 @@ import (
 type ChallengeResponse struct {
 	ChallengeHidden string `json:"challengeHidden"`
 	ChallengeVisual string `json:"challengeVisual"`
+	URI             string `json:"uri,omitempty"`
+	LNURL           string `json:"lnurl,omitempty"`
+	// LNURLSecret is the secret for picking up the LNURL-auth login with
+	// LNURLAuth.Status. It must not be shown to the wallet.
+	LNURLSecret string `json:"lnurlSecret,omitempty"`
+	SIWEMessage string `json:"siweMessage,omitempty"`
 }
 
-// Challenge is a HTTP handler that takes a GET request without parameters and
-// returns a ChallengeResponse for Trezor login.
+// Challenges keeps the issued challenges in a store.Store, so the login
+// methods that embed a challenge hidden in a signed message, Nostr and
+// Ethereum, accept each challenge only once.
+type Challenges struct {
+	// Store keeps the issued challenges.
+	Store store.Store
+	// TTL is how long an issued challenge can be redeemed. If zero,
+	// DefaultChallengeMaxAge is used.
+	TTL time.Duration
+}
+
+func (c *Challenges) ttl() time.Duration {
+	if c.TTL == 0 {
+		return DefaultChallengeMaxAge
+	}
+	return c.TTL
+}
+
+func challengeKey(challengeHidden string) string {
+	return "challenge/" + challengeHidden
+}
+
+// issue registers challengeHidden as issued.
+func (c *Challenges) issue(r *http.Request, challengeHidden string) error {
+	if c == nil {
+		return nil
+	}
+	return c.Store.Put(r.Context(), challengeKey(challengeHidden), []byte{}, c.ttl())
+}
+
+// redeem removes challengeHidden from the issued challenges. It returns
+// store.ErrNotFound if the challenge was not issued, has expired or was
+// already redeemed, or if c is nil.
+func (c *Challenges) redeem(r *http.Request, challengeHidden string) error {
+	if c == nil {
+		return store.ErrNotFound
+	}
+	_, err := c.Store.Take(r.Context(), challengeKey(challengeHidden))
+	return err
+}
+
+// redeemProblem returns the problem of a challenge that could not be
+// redeemed with err.
+func redeemProblem(r *http.Request, err error) *problem.Problem {
+	if errors.Is(err, store.ErrNotFound) {
+		return problem.New(http.StatusBadRequest, problem.ChallengeExpired, "challenge has expired or was already used")
+	}
+	logger(r).Error("error redeeming challenge", "error", err)
+	return problem.New(http.StatusInternalServerError, problem.Internal, "")
+}
+
+// Challenge is a HTTP handler that takes a GET request and returns a
+// ChallengeResponse for Trezor login. It is a ChallengeHandler with no
+// optional login methods enabled.
 func Challenge(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	(&ChallengeHandler{}).ServeHTTP(w, r)
+}
+
+// ChallengeAuditLimit is how many issued challenges per client IP are
+// recorded in the audit log by a ChallengeHandler with AuditLimits.
+var ChallengeAuditLimit = ratelimit.Limit{Requests: 10, Period: time.Minute, Burst: 10}
+
+// ChallengeHandler is a HTTP handler that takes a GET request and returns a
+// ChallengeResponse for Trezor login.
+//
+// If the "uri" query parameter is true, the response also contains the login
+// URI for the challenge, which can be passed to a device as a deep link or
+// rendered as a QR code with ChallengeQR.
+//
+// If LNURL is set and the "lnurl" query parameter is true, the response also
+// contains an LNURL-auth lnurl with ChallengeHidden as k1 and the secret for
+// picking up the login.
+//
+// If the "ethereum" query parameter is an Ethereum address, the response also
+// contains a Sign-In With Ethereum message for EthereumLogin with
+// ChallengeHidden as nonce. The optional "chainId" query parameter sets the
+// chain ID of the message and defaults to 1.
+//
+// If Visual is set, ChallengeVisual is rendered with the template instead of
+// login.ChallengeVisual.
+//
+// If Metrics is set, the issued challenges are counted.
+//
+// If PoW is set, a challenge is issued only for a solution of a puzzle
+// issued by ProofOfWork, given as the "powSalt" and "powNonce" query
+// parameters.
+//
+// If Audit is set, the issued challenges are recorded. If AuditLimits is
+// also set, at most ChallengeAuditLimit challenges per client IP are
+// recorded, so anonymous requests cannot flood the audit log; the challenges
+// above the limit are still issued.
+//
+// If Challenges is set, the issued challenges are kept for NostrLogin and
+// EthereumLogin, which accept only challenges kept there.
+type ChallengeHandler struct {
+	Challenges *Challenges
+	LNURL      *LNURLAuth
+	Visual     *login.VisualTemplate
+	Metrics    *Metrics
+	Audit      *audit.Log
+	// AuditLimits keeps the buckets of ChallengeAuditLimit.
+	AuditLimits ratelimit.Store
+	PoW         *pow.Gate
+	// PoWPolicy is the policy of the puzzles of PoW. If zero,
+	// pow.DefaultPolicy is used.
+	PoWPolicy pow.Policy
+}
+
+// ProofOfWorkResponse is a puzzle that must be solved to request a
+// challenge. The client finds a nonce such that the hash of the salt, a
+// colon and the nonce with Algorithm starts with Difficulty zero bits.
+type ProofOfWorkResponse struct {
+	Algorithm  string    `json:"algorithm"`
+	Salt       string    `json:"salt"`
+	Difficulty int       `json:"difficulty"`
+	Expires    time.Time `json:"expires"`
+}
+
+func (h *ChallengeHandler) powPolicy() pow.Policy {
+	if h.PoWPolicy == (pow.Policy{}) {
+		return pow.DefaultPolicy
+	}
+	return h.PoWPolicy
+}
+
+// ProofOfWork is a HTTP handler that takes a GET request and returns a
+// ProofOfWorkResponse with a new puzzle of PoW.
+func (h *ChallengeHandler) ProofOfWork(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	puzzle, err := h.PoW.Puzzle(h.powPolicy())
+	if err != nil {
+		logger(r).Error("error issuing proof of work puzzle", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
 		return
 	}
 
-	err := json.NewEncoder(w).Encode(ChallengeResponse{
-		ChallengeHidden: login.ChallengeHidden(),
-		ChallengeVisual: login.ChallengeVisual(),
+	writeJSON(w, ProofOfWorkResponse{
+		Algorithm:  pow.Algorithm,
+		Salt:       puzzle.Salt,
+		Difficulty: puzzle.Difficulty,
+		Expires:    puzzle.Expires.UTC(),
 	})
+}
+
+// proofOfWork returns the problem if r has no valid solution of a puzzle of
+// PoW, or nil if it has or PoW is not set.
+func (h *ChallengeHandler) proofOfWork(r *http.Request) *problem.Problem {
+	if h.PoW == nil {
+		return nil
+	}
+	query := r.URL.Query()
+	salt, nonce := query.Get("powSalt"), query.Get("powNonce")
+	if salt == "" {
+		return problem.New(http.StatusForbidden, problem.ProofOfWorkRequired, "missing proof of work")
+	}
+	err := h.PoW.Verify(salt, nonce)
+	if err != nil {
+		return problem.New(http.StatusForbidden, problem.ProofOfWorkRequired, err.Error())
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	withURI, err := boolParam(r, "uri")
+	if err != nil {
+		problem.Write(w, invalidField("uri", err.Error()))
+		return
+	}
+
+	withLNURL, err := boolParam(r, "lnurl")
+	if err != nil {
+		problem.Write(w, invalidField("lnurl", err.Error()))
+		return
+	}
+	if withLNURL && h.LNURL == nil {
+		problem.Write(w, invalidField("lnurl", "lnurl-auth is not enabled"))
+		return
+	}
+
+	address, chainID, err := siweParams(r)
 	if err != nil {
-		log.Printf("error writing response to client: %v", err)
+		problem.Write(w, invalidField("chainId", err.Error()))
+		return
+	}
+
+	if p := h.proofOfWork(r); p != nil {
+		problem.Write(w, p)
+		return
+	}
+
+	challengeVisual := login.ChallengeVisual()
+	if h.Visual != nil {
+		challengeVisual, err = h.Visual.Render(time.Now())
+		if err != nil {
+			logger(r).Error("error rendering challenge visual", "error", err)
+			problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+			return
+		}
+	}
+
+	_, span := tracing.Start(r.Context(), "challenge.generate")
+	resp := ChallengeResponse{
+		ChallengeHidden: login.ChallengeHidden(),
+		ChallengeVisual: challengeVisual,
+	}
+	span.End()
+	if withURI {
+		resp.URI = loginURI(r, resp.ChallengeHidden, resp.ChallengeVisual).String()
+	}
+	if address != "" {
+		m, err := siweMessage(r, address, chainID, resp.ChallengeHidden)
+		if err != nil {
+			problem.Write(w, invalidField("ethereum", err.Error()))
+			return
+		}
+		resp.SIWEMessage = m.String()
 	}
+	err = h.Challenges.issue(r, resp.ChallengeHidden)
+	if err != nil {
+		logger(r).Error("error issuing challenge", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+	if withLNURL {
+		resp.LNURL, resp.LNURLSecret, err = h.LNURL.issue(r, resp.ChallengeHidden)
+		if err != nil {
+			logger(r).Error("error issuing lnurl", "error", err)
+			problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+			return
+		}
+	}
+
+	h.Metrics.challengeIssued()
+	if h.auditAllowed(r) {
+		record(h.Audit, r, audit.Record{
+			Event: audit.EventChallengeIssued,
+			Proof: &audit.Proof{ChallengeHidden: resp.ChallengeHidden, ChallengeVisual: resp.ChallengeVisual},
+		})
+	}
+	writeJSON(w, resp)
+}
+
+// auditAllowed reports whether the challenge issued for r is recorded
+// within ChallengeAuditLimit. Errors of AuditLimits are logged and allow
+// the record.
+func (h *ChallengeHandler) auditAllowed(r *http.Request) bool {
+	if h.Audit == nil || h.AuditLimits == nil {
+		return true
+	}
+	result, err := h.AuditLimits.Take(r.Context(), "audit/challenge/"+remote.IP(r), ChallengeAuditLimit)
+	if err != nil {
+		logger(r).Error("error limiting audit records", "error", err)
+		return true
+	}
+	return result.Allowed
+}
+
+// boolParam returns the boolean value of the query parameter name, or false
+// if it is not set.
+func boolParam(r *http.Request, name string) (bool, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(param)
+}
+
+// loginURI returns the login URI for the challenge with the host of the
+// request as relying party and its login endpoint as callback.
+func loginURI(r *http.Request, challengeHidden, challengeVisual string) login.URI {
+	return login.URI{
+		Host:            r.Host,
+		ChallengeHidden: challengeHidden,
+		ChallengeVisual: challengeVisual,
+		Callback:        baseURL(r) + "/login",
+	}
+}
+
+// baseURL returns the scheme and host of the server as requested by the
+// client of r.
+func baseURL(r *http.Request) string {
+	return remote.Scheme(r) + "://" + r.Host
 }
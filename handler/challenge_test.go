@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -15,8 +16,28 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/remote"
+	"phobia.cloud/api/store"
 )
 
+// newChallenges returns the issued challenges of a ChallengeHandler and a
+// function that issues a challenge with it.
+func newChallenges(t *testing.T) (*handler.Challenges, func() string) {
+	challenges := &handler.Challenges{Store: store.NewMemory()}
+	h := &handler.ChallengeHandler{Challenges: challenges}
+	return challenges, func() string {
+		rr := serve(t, h.ServeHTTP, http.MethodGet, "http://phobia.cloud/challenge")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp handler.ChallengeResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp.ChallengeHidden
+	}
+}
+
 func TestChallenge(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, "", nil)
 	require.NoError(t, err)
@@ -44,24 +65,135 @@ func TestChallenge(t *testing.T) {
 	assert.WithinDuration(t, time.Now(), asTime, time.Minute)
 }
 
-func TestChallenge_MethodNotAllowed(t *testing.T) {
-	for _, method := range []string{
-		http.MethodConnect,
-		http.MethodDelete,
-		http.MethodHead,
-		http.MethodOptions,
-		http.MethodPatch,
-		http.MethodPost,
-		http.MethodPut,
-		http.MethodTrace,
+func TestChallenge_URI(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://phobia.cloud:5050/challenge?uri=true", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h := http.HandlerFunc(handler.Challenge)
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp handler.ChallengeResponse
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	require.NoError(t, err)
+
+	uri, err := login.ParseURI(resp.URI)
+	require.NoError(t, err)
+	assert.Equal(t, login.URI{
+		Scheme:          login.URIScheme,
+		Host:            "phobia.cloud:5050",
+		ChallengeHidden: resp.ChallengeHidden,
+		ChallengeVisual: resp.ChallengeVisual,
+		Callback:        "http://phobia.cloud:5050/login",
+	}, uri)
+}
+
+func TestChallenge_URIForwardedScheme(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/challenge?uri=true", nil)
+	req = req.WithContext(remote.NewContext(req.Context(), remote.Client{IP: "198.51.100.1", Scheme: "https"}))
+
+	rr := httptest.NewRecorder()
+	handler.Challenge(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp handler.ChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	uri, err := login.ParseURI(resp.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "https://phobia.cloud/login", uri.Callback)
+}
+
+func TestChallenge_InvalidURIParameter(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/challenge?uri=maybe", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h := http.HandlerFunc(handler.Challenge)
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestChallenge_LNURLNotEnabled(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/challenge?lnurl=true", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h := http.HandlerFunc(handler.Challenge)
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestChallenge_InvalidEthereumParameters(t *testing.T) {
+	for _, target := range []string{
+		"/challenge?ethereum=0x1234",
+		"/challenge?ethereum=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed&chainId=one",
 	} {
-		req, err := http.NewRequest(method, "", nil)
+		req, err := http.NewRequest(http.MethodGet, target, nil)
 		require.NoError(t, err)
 
 		rr := httptest.NewRecorder()
 		h := http.HandlerFunc(handler.Challenge)
 		h.ServeHTTP(rr, req)
 
-		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, method)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+	}
+}
+
+func TestChallenge_VisualTemplate(t *testing.T) {
+	template := &login.VisualTemplate{Text: "Login to phobia.cloud\n{time}"}
+	h := &handler.ChallengeHandler{Visual: template}
+
+	rr := serve(t, h.ServeHTTP, http.MethodGet, "http://phobia.cloud/challenge")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp handler.ChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Regexp(t, `^Login to phobia.cloud\n\d{4}-\d\d-\d\d \d\d:\d\d:\d\d \+00:00$`, resp.ChallengeVisual)
+
+	issued, err := template.Parse(resp.ChallengeVisual)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), issued, time.Minute)
+
+	h.Visual = &login.VisualTemplate{Text: "no time"}
+	rr = serve(t, h.ServeHTTP, http.MethodGet, "http://phobia.cloud/challenge")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestChallenge_ProofOfWork(t *testing.T) {
+	gate, err := pow.NewGate(nil)
+	require.NoError(t, err)
+	h := &handler.ChallengeHandler{
+		PoW:       gate,
+		PoWPolicy: pow.Policy{Difficulty: 8, MaxDifficulty: 8, TargetRate: 60, TTL: time.Minute},
+	}
+
+	challenge := func(query url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/challenge?"+query.Encode(), nil))
+		return rr
 	}
+
+	p := assertProblem(t, challenge(nil), http.StatusForbidden, problem.ProofOfWorkRequired)
+	assert.Equal(t, "missing proof of work", p.Detail)
+
+	rr := httptest.NewRecorder()
+	h.ProofOfWork(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/challenge/pow", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	var puzzle handler.ProofOfWorkResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&puzzle))
+	assert.Equal(t, "sha256", puzzle.Algorithm)
+	assert.Equal(t, 8, puzzle.Difficulty)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), puzzle.Expires, 2*time.Second)
+
+	solution := url.Values{"powSalt": {puzzle.Salt}, "powNonce": {pow.Solve(puzzle.Salt, puzzle.Difficulty)}}
+	assert.Equal(t, http.StatusOK, challenge(solution).Code)
+
+	p = assertProblem(t, challenge(solution), http.StatusForbidden, problem.ProofOfWorkRequired)
+	assert.Equal(t, "proof of work was already used", p.Detail)
 }
@@ -3,4 +3,11 @@
 
 // Package handler provides handlers for handling incoming HTTP request to the
 // web server.
+//
+// The handlers expect to be served by the router of the server package,
+// which checks the request method and answers CORS preflight requests.
+//
+// Failed requests are answered with the problem details of the problem
+// package. Failures of the LNURL-auth callback are answered in the format
+// of the LNURL specification instead, as wallets expect.
 package handler
@@ -0,0 +1,253 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
+)
+
+const (
+	// siweTTL is how long a Sign-In With Ethereum message is accepted after
+	// it was issued.
+	siweTTL = 5 * time.Minute
+	// siweClockSkew is how far in the future the issue time of a Sign-In
+	// With Ethereum message may be.
+	siweClockSkew = time.Minute
+)
+
+// EthereumLoginRequest contains a Sign-In With Ethereum (EIP-4361) message
+// and its personal_sign signature.
+type EthereumLoginRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// siweMessage returns a Sign-In With Ethereum message for the account with
+// the provided address and chain ID, with challengeHidden as nonce.
+func siweMessage(r *http.Request, address string, chainID int64, challengeHidden string) (*login.SIWEMessage, error) {
+	checksummed, err := login.ChecksumAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	return &login.SIWEMessage{
+		Domain:         r.Host,
+		Address:        checksummed,
+		Statement:      "Sign in to " + r.Host + ".",
+		URI:            baseURL(r) + "/login/ethereum",
+		Version:        login.SIWEVersion,
+		ChainID:        chainID,
+		Nonce:          challengeHidden,
+		IssuedAt:       now,
+		ExpirationTime: now.Add(siweTTL),
+	}, nil
+}
+
+// siweParams returns the address and chain ID from the "ethereum" and
+// "chainId" query parameters of r. The chain ID defaults to 1.
+func siweParams(r *http.Request) (string, int64, error) {
+	query := r.URL.Query()
+
+	chainID := int64(1)
+	if param := query.Get("chainId"); param != "" {
+		var err error
+		chainID, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	return query.Get("ethereum"), chainID, nil
+}
+
+// EthereumLogin is a HTTP handler that takes a POST request with
+// EthereumLoginRequest in the body and verifies the signature of the Sign-In
+// With Ethereum message. If the signature is valid it logs in the user with
+// the Ethereum address.
+//
+// The message must be issued for one of Origins, must have a challenge
+// hidden issued by a ChallengeHandler with the same Challenges as nonce and
+// must not be older than five minutes. Each challenge can be used only once.
+type EthereumLogin struct {
+	// Origins are the public origins of the server, e.g.
+	// "https://phobia.cloud". The domain of the message must be the host of
+	// one of them and its URI must be on the same origin. If empty, all
+	// logins are rejected. The Host header of the request is never trusted,
+	// as it is set by the client.
+	Origins []string
+	// Challenges are the issued challenges. If nil, all logins are
+	// rejected.
+	Challenges *Challenges
+	// Audit records the logins with their signed message if set.
+	Audit *audit.Log
+	// Webhooks publishes the successful logins and the lockouts if set.
+	Webhooks *webhook.Dispatcher
+	// Abuse delays and locks out the keys, clients and networks of
+	// repeated failed logins if set.
+	Abuse *abuse.Detector
+	// AbusePolicy is the policy of Abuse. If zero, abuse.DefaultPolicy is
+	// used.
+	AbusePolicy abuse.Policy
+}
+
+func (h *EthereumLogin) guard() abuseGuard {
+	return abuseGuard{
+		detector: h.Abuse,
+		policy:   h.AbusePolicy,
+		method:   "ethereum",
+		noun:     "address",
+		webhooks: h.Webhooks,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *EthereumLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		problem.Error(w, http.StatusBadRequest, problem.MalformedRequest, "missing request body")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req EthereumLoginRequest
+	err := decoder.Decode(&req)
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, problem.MalformedRequest, fmt.Sprintf("failed to decode request: %v", err))
+		return
+	}
+
+	m, err := login.ParseSIWEMessage(req.Message)
+	if err != nil {
+		problem.Write(w, loginProblem(err))
+		return
+	}
+
+	key := strings.ToLower(m.Address)
+	if p := h.guard().check(w, r, key); p != nil {
+		problem.Write(w, p)
+		return
+	}
+
+	err = verify(r, "ethereum", func(*tracing.Span) (err error) {
+		_, err = login.VerifySIWE(req.Message, req.Signature)
+		return err
+	})
+	if err != nil {
+		class := loginErrorClass(err)
+		h.guard().failure(r, key, class)
+		h.audit(r, req, m, class)
+		problem.Write(w, loginProblem(err))
+		return
+	}
+
+	if !h.allowedOrigin(m) {
+		h.audit(r, req, m, ErrorClassOrigin)
+		problem.Error(w, http.StatusBadRequest, problem.OriginNotAllowed, fmt.Sprintf("siwe message is not issued for an allowed origin: %q", m.Domain))
+		return
+	}
+
+	if nonce, err := hex.DecodeString(m.Nonce); err != nil || len(nonce) != 32 {
+		h.audit(r, req, m, ErrorClassParse)
+		problem.Write(w, invalidField("message", "siwe message nonce is not a challenge hidden"))
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(m.IssuedAt) > siweTTL || m.IssuedAt.Sub(now) > siweClockSkew || m.Valid(now) != nil {
+		h.audit(r, req, m, ErrorClassExpired)
+		problem.Error(w, http.StatusBadRequest, problem.ChallengeExpired, "siwe message has expired or is not yet valid")
+		return
+	}
+
+	err = h.Challenges.redeem(r, m.Nonce)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			h.audit(r, req, m, ErrorClassExpired)
+		}
+		problem.Write(w, redeemProblem(r, err))
+		return
+	}
+
+	h.guard().success(r, key)
+	h.audit(r, req, m, ErrorClassNone)
+	authenticated(r, m.Address)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// allowedOrigin reports whether the domain of m is the host of one of the
+// origins and the URI of m is on the same origin.
+func (h *EthereumLogin) allowedOrigin(m *login.SIWEMessage) bool {
+	uri, err := url.Parse(m.URI)
+	if err != nil {
+		return false
+	}
+	for _, origin := range h.Origins {
+		o, err := url.Parse(origin)
+		if err != nil {
+			continue
+		}
+		if m.Domain == o.Host && uri.Scheme == o.Scheme && uri.Host == o.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// EthereumKey returns the address of the Sign-In With Ethereum message of
+// the EthereumLoginRequest in the body of r in lower case, or an empty
+// string if the body has no such message. The body of r can still be read
+// afterwards.
+func EthereumKey(r *http.Request) string {
+	var req EthereumLoginRequest
+	if peekJSON(r, &req) != nil {
+		return ""
+	}
+	m, err := login.ParseSIWEMessage(req.Message)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(m.Address)
+}
+
+// audit records the login request req with its message m and the error
+// class of its outcome, and publishes it if it succeeded.
+func (h *EthereumLogin) audit(r *http.Request, req EthereumLoginRequest, m *login.SIWEMessage, class string) {
+	rec := audit.Record{
+		Event:   audit.EventLoginFailure,
+		Method:  "ethereum",
+		Subject: m.Address,
+		Error:   class,
+		Proof: &audit.Proof{
+			ChallengeHidden: m.Nonce,
+			PublicKey:       m.Address,
+			Signature:       req.Signature,
+			Message:         req.Message,
+		},
+	}
+	if class == ErrorClassNone {
+		rec.Event, rec.Error = audit.EventLoginSuccess, ""
+	}
+	record(h.Audit, r, rec)
+	if class == ErrorClassNone {
+		publish(h.Webhooks, r, webhook.EventLoginSuccess, webhook.Data{Method: rec.Method, Subject: rec.Subject})
+	}
+}
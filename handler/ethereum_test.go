@@ -0,0 +1,220 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+)
+
+var ethereumKey = logintest.NewEthereumKey([]byte("phobia.cloud ethereum test key 1"))
+
+var ethereumOrigins = []string{"http://phobia.cloud"}
+
+func ethereumLogin(t *testing.T, h *handler.EthereumLogin, method string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "http://phobia.cloud/login/ethereum", strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func signedSIWE(t *testing.T, m *login.SIWEMessage) string {
+	message := m.String()
+	body, err := json.Marshal(handler.EthereumLoginRequest{
+		Message:   message,
+		Signature: ethereumKey.PersonalSign([]byte(message)),
+	})
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestEthereumLogin(t *testing.T) {
+	challenges := &handler.Challenges{Store: store.NewMemory()}
+	h := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges}
+	rr := serve(t, (&handler.ChallengeHandler{Challenges: challenges}).ServeHTTP, http.MethodGet,
+		"http://phobia.cloud/challenge?chainId=5&ethereum="+strings.ToLower(ethereumKey.Address()))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var challenge handler.ChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+
+	m, err := login.ParseSIWEMessage(challenge.SIWEMessage)
+	require.NoError(t, err)
+	assert.Equal(t, "phobia.cloud", m.Domain)
+	assert.Equal(t, ethereumKey.Address(), m.Address)
+	assert.Equal(t, "http://phobia.cloud/login/ethereum", m.URI)
+	assert.Equal(t, int64(5), m.ChainID)
+	assert.Equal(t, challenge.ChallengeHidden, m.Nonce)
+	assert.WithinDuration(t, time.Now(), m.IssuedAt, time.Minute)
+
+	body := signedSIWE(t, m)
+	rr = ethereumLogin(t, h, http.MethodPost, body)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Empty(t, rr.Body)
+
+	// the nonce can be used only once
+	rr = ethereumLogin(t, h, http.MethodPost, body)
+	assertProblem(t, rr, http.StatusBadRequest, problem.ChallengeExpired)
+}
+
+func TestEthereumLogin_BadRequest(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges}
+	valid := func() *login.SIWEMessage {
+		now := time.Now().UTC().Truncate(time.Second)
+		return &login.SIWEMessage{
+			Domain:   "phobia.cloud",
+			Address:  ethereumKey.Address(),
+			URI:      "http://phobia.cloud/login/ethereum",
+			Version:  login.SIWEVersion,
+			ChainID:  1,
+			Nonce:    issue(),
+			IssuedAt: now,
+		}
+	}
+
+	otherDomain := valid()
+	otherDomain.Domain = "evil.example"
+
+	otherURI := valid()
+	otherURI.URI = "http://evil.example/login/ethereum"
+
+	shortNonce := valid()
+	shortNonce.Nonce = "abcdefgh"
+
+	old := valid()
+	old.IssuedAt = old.IssuedAt.Add(-time.Hour)
+
+	future := valid()
+	future.IssuedAt = future.IssuedAt.Add(time.Hour)
+
+	expired := valid()
+	expired.ExpirationTime = expired.IssuedAt.Add(-time.Second)
+
+	notIssued := valid()
+	notIssued.Nonce = login.ChallengeHidden()
+
+	wrongSignature := string(mustJSON(t, handler.EthereumLoginRequest{
+		Message:   valid().String(),
+		Signature: ethereumKey.PersonalSign([]byte("other")),
+	}))
+
+	for _, tt := range []struct {
+		name string
+		body string
+	}{
+		{name: "invalid json", body: "{"},
+		{name: "unknown field", body: `{"message":"","signature":"","version":2}`},
+		{name: "invalid message", body: `{"message":"hello","signature":"0x00"}`},
+		{name: "wrong signature", body: wrongSignature},
+		{name: "other domain", body: signedSIWE(t, otherDomain)},
+		{name: "other uri", body: signedSIWE(t, otherURI)},
+		{name: "nonce is not a challenge", body: signedSIWE(t, shortNonce)},
+		{name: "too old", body: signedSIWE(t, old)},
+		{name: "issued in the future", body: signedSIWE(t, future)},
+		{name: "expired", body: signedSIWE(t, expired)},
+		{name: "nonce not issued", body: signedSIWE(t, notIssued)},
+	} {
+		rr := ethereumLogin(t, h, http.MethodPost, tt.body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+	}
+}
+
+func TestEthereumLogin_Origin(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	message := func(domain string) *login.SIWEMessage {
+		return &login.SIWEMessage{
+			Domain:   domain,
+			Address:  ethereumKey.Address(),
+			URI:      "http://" + domain + "/login/ethereum",
+			Version:  login.SIWEVersion,
+			ChainID:  1,
+			Nonce:    issue(),
+			IssuedAt: time.Now().UTC().Truncate(time.Second),
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		origins []string
+		target  string
+		message *login.SIWEMessage
+	}{
+		{
+			name:    "issued for another site with a spoofed host",
+			origins: ethereumOrigins,
+			target:  "http://evil.example/login/ethereum",
+			message: message("evil.example"),
+		},
+		{
+			name:    "no configured origins",
+			target:  "http://phobia.cloud/login/ethereum",
+			message: message("phobia.cloud"),
+		},
+	} {
+		req, err := http.NewRequest(http.MethodPost, tt.target, strings.NewReader(signedSIWE(t, tt.message)))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		(&handler.EthereumLogin{Origins: tt.origins, Challenges: challenges}).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+		assert.Contains(t, rr.Body.String(), `"code":"`+problem.OriginNotAllowed+`"`, tt.name)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+func TestEthereumKey(t *testing.T) {
+	signed := signedSIWE(t, &login.SIWEMessage{
+		Domain:   "phobia.cloud",
+		Address:  ethereumKey.Address(),
+		URI:      "http://phobia.cloud/login/ethereum",
+		Version:  login.SIWEVersion,
+		ChainID:  1,
+		Nonce:    login.ChallengeHidden(),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	for _, tt := range []struct {
+		name string
+		body string
+		key  string
+	}{
+		{name: "message", body: signed, key: strings.ToLower(ethereumKey.Address())},
+		{name: "malformed message", body: `{"message": "phobia.cloud"}`, key: ""},
+		{name: "malformed", body: `{"message": `, key: ""},
+		{name: "empty", body: "", key: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "", strings.NewReader(tt.body))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.key, handler.EthereumKey(req))
+
+			body := new(strings.Builder)
+			_, err = io.Copy(body, req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, body.String())
+		})
+	}
+}
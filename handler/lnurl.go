@@ -0,0 +1,281 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
+)
+
+// DefaultLNURLTTL is how long an LNURL-auth challenge remains valid if
+// LNURLAuth.TTL is not set.
+const DefaultLNURLTTL = 5 * time.Minute
+
+// LNURL-auth states reported by LNURLAuth.Status.
+const (
+	LNURLStatusPending   = "pending"
+	LNURLStatusCompleted = "completed"
+)
+
+// LNURLResponse is the response of the LNURL-auth callback as defined by
+// LUD-04.
+type LNURLResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// LNURLStatusResponse is the state of an LNURL-auth login. Key is the linking
+// key of the wallet once the login is completed.
+type LNURLStatusResponse struct {
+	Status string `json:"status"`
+	Key    string `json:"key,omitempty"`
+}
+
+// lnurlSession is the stored state of an issued LNURL-auth challenge.
+type lnurlSession struct {
+	// Secret is the hex SHA-256 hash of the secret of the browser.
+	Secret string `json:"secret"`
+	Key    string `json:"key,omitempty"`
+}
+
+// LNURLAuth handles LNURL-auth (LUD-04) logins for Lightning wallets.
+//
+// The browser requests a challenge with an lnurl from a ChallengeHandler
+// and shows it to the wallet. The wallet signs the challenge as k1 and sends
+// the signature to Callback. The browser polls Status with k1 and the secret
+// it got with the lnurl to pick up the completed login. The lnurl and k1 are
+// public, as they are shown to the wallet, so only the secret allows picking
+// up the login.
+type LNURLAuth struct {
+	// Store keeps the state of issued challenges.
+	Store store.Store
+	// TTL is how long an issued challenge remains valid. If zero,
+	// DefaultLNURLTTL is used.
+	TTL time.Duration
+	// Audit records the logins of the wallets with their signature if set.
+	Audit *audit.Log
+	// Webhooks publishes the successful logins if set.
+	Webhooks *webhook.Dispatcher
+}
+
+func (a *LNURLAuth) ttl() time.Duration {
+	if a.TTL == 0 {
+		return DefaultLNURLTTL
+	}
+	return a.TTL
+}
+
+func lnurlKey(k1 string) string {
+	return "lnurl/" + k1
+}
+
+// lnurlSecretHash returns the hash of the secret of the browser kept in
+// the session.
+func lnurlSecretHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// issue registers k1 as a pending challenge and returns the bech32-encoded
+// lnurl pointing to the callback of the server that received r and the
+// secret of the browser for Status.
+func (a *LNURLAuth) issue(r *http.Request, k1 string) (string, string, error) {
+	secret := login.ChallengeHidden()
+	value, err := json.Marshal(lnurlSession{Secret: lnurlSecretHash(secret)})
+	if err != nil {
+		return "", "", err
+	}
+
+	err = a.Store.Put(r.Context(), lnurlKey(k1), value, a.ttl())
+	if err != nil {
+		return "", "", err
+	}
+
+	query := url.Values{}
+	query.Set("tag", "login")
+	query.Set("k1", k1)
+	query.Set("action", "login")
+
+	lnurl, err := login.EncodeLNURL(baseURL(r) + "/lnurl?" + query.Encode())
+	return lnurl, secret, err
+}
+
+// session returns the state of the challenge k1 and its stored value.
+func (a *LNURLAuth) session(r *http.Request, k1 string) (lnurlSession, []byte, error) {
+	var session lnurlSession
+
+	value, err := a.Store.Get(r.Context(), lnurlKey(k1))
+	if err != nil {
+		return session, nil, err
+	}
+
+	err = json.Unmarshal(value, &session)
+	return session, value, err
+}
+
+// Callback is a HTTP handler that takes the GET request of the wallet with
+// the "k1", "sig" and "key" query parameters and verifies the signature of
+// the challenge. If the signature is valid, it completes the login for the
+// waiting browser. Of concurrent callbacks for the same k1, only one
+// completes the login, which keeps the expiration time of the challenge.
+func (a *LNURLAuth) Callback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	if tag := query.Get("tag"); tag != "" && tag != "login" {
+		lnurlError(w, http.StatusBadRequest, "unsupported tag: "+tag)
+		return
+	}
+
+	k1, sig, key := query.Get("k1"), query.Get("sig"), query.Get("key")
+	if k1 == "" || sig == "" || key == "" {
+		lnurlError(w, http.StatusBadRequest, "missing k1, sig or key")
+		return
+	}
+
+	session, old, err := a.session(r, k1)
+	if errors.Is(err, store.ErrNotFound) {
+		lnurlError(w, http.StatusBadRequest, "unknown or expired k1")
+		return
+	}
+	if err != nil {
+		logger(r).Error("error reading lnurl session", "error", err)
+		lnurlError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if session.Key != "" {
+		a.audit(r, k1, sig, key, ErrorClassExpired)
+		lnurlError(w, http.StatusBadRequest, "k1 already used")
+		return
+	}
+
+	err = verify(r, "lnurl", func(*tracing.Span) error {
+		return login.VerifyLNURL(k1, sig, key)
+	})
+	if err != nil {
+		a.audit(r, k1, sig, key, loginErrorClass(err))
+		lnurlError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session.Key = key
+	value, err := json.Marshal(session)
+	if err == nil {
+		err = a.Store.Swap(r.Context(), lnurlKey(k1), old, value)
+	}
+	if errors.Is(err, store.ErrConflict) {
+		a.audit(r, k1, sig, key, ErrorClassExpired)
+		lnurlError(w, http.StatusBadRequest, "k1 already used")
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		lnurlError(w, http.StatusBadRequest, "unknown or expired k1")
+		return
+	}
+	if err != nil {
+		logger(r).Error("error writing lnurl session", "error", err)
+		lnurlError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	a.audit(r, k1, sig, key, ErrorClassNone)
+	authenticated(r, key)
+	writeJSON(w, LNURLResponse{Status: "OK"})
+}
+
+// audit records the login of the wallet with the linking key, its signature
+// sig of k1 and the error class of its outcome, and publishes it if it
+// succeeded.
+func (a *LNURLAuth) audit(r *http.Request, k1, sig, key, class string) {
+	rec := audit.Record{
+		Event:   audit.EventLoginFailure,
+		Method:  "lnurl",
+		Subject: key,
+		Error:   class,
+		Proof:   &audit.Proof{ChallengeHidden: k1, PublicKey: key, Signature: sig},
+	}
+	if class == ErrorClassNone {
+		rec.Event, rec.Error = audit.EventLoginSuccess, ""
+	}
+	record(a.Audit, r, rec)
+	if class == ErrorClassNone {
+		publish(a.Webhooks, r, webhook.EventLoginSuccess, webhook.Data{Method: rec.Method, Subject: rec.Subject})
+	}
+}
+
+// Status is a HTTP handler that takes a GET request with the "k1" and
+// "secret" query parameters and returns the LNURLStatusResponse of the login.
+// The secret is the one returned with the lnurl of k1; logins with another
+// secret are not found. A completed login can be picked up only once.
+func (a *LNURLAuth) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	k1, secret := query.Get("k1"), query.Get("secret")
+	if k1 == "" {
+		problem.Write(w, missingField("k1"))
+		return
+	}
+	if secret == "" {
+		problem.Write(w, missingField("secret"))
+		return
+	}
+
+	session, _, err := a.session(r, k1)
+	if err == nil && subtle.ConstantTimeCompare([]byte(session.Secret), []byte(lnurlSecretHash(secret))) != 1 {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Error(w, http.StatusNotFound, problem.NotFound, "lnurl session not found")
+		return
+	}
+	if err != nil {
+		logger(r).Error("error reading lnurl session", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	if session.Key == "" {
+		writeJSON(w, LNURLStatusResponse{Status: LNURLStatusPending})
+		return
+	}
+
+	_, err = a.Store.Take(r.Context(), lnurlKey(k1))
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Error(w, http.StatusNotFound, problem.NotFound, "lnurl session not found")
+		return
+	}
+	if err != nil {
+		logger(r).Error("error deleting lnurl session", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	writeJSON(w, LNURLStatusResponse{Status: LNURLStatusCompleted, Key: session.Key})
+}
+
+func lnurlError(w http.ResponseWriter, code int, reason string) {
+	w.WriteHeader(code)
+	writeJSON(w, LNURLResponse{Status: "ERROR", Reason: reason})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		handlerLog.Warn("error writing response to client", "error", err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+)
+
+type lnurlWallet struct {
+	privKey *btcec.PrivateKey
+}
+
+func newLNURLWallet(t *testing.T) *lnurlWallet {
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	return &lnurlWallet{privKey: privKey}
+}
+
+func (wallet *lnurlWallet) key() string {
+	return hex.EncodeToString(wallet.privKey.PubKey().SerializeCompressed())
+}
+
+// callbackURL returns the URL the wallet calls to log in with lnurl.
+func (wallet *lnurlWallet) callbackURL(t *testing.T, lnurl string) string {
+	decoded, err := login.DecodeLNURL(lnurl)
+	require.NoError(t, err)
+
+	u, err := url.Parse(decoded)
+	require.NoError(t, err)
+	require.Equal(t, "login", u.Query().Get("tag"))
+
+	k1, err := hex.DecodeString(u.Query().Get("k1"))
+	require.NoError(t, err)
+	sig, err := wallet.privKey.Sign(k1)
+	require.NoError(t, err)
+
+	query := u.Query()
+	query.Set("sig", hex.EncodeToString(sig.Serialize()))
+	query.Set("key", wallet.key())
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func serve(t *testing.T, h http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, target, nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func issueLNURL(t *testing.T, auth *handler.LNURLAuth) handler.ChallengeResponse {
+	h := &handler.ChallengeHandler{LNURL: auth}
+	rr := serve(t, h.ServeHTTP, http.MethodGet, "http://phobia.cloud/challenge?lnurl=true")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp handler.ChallengeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.NotEmpty(t, resp.LNURL)
+	return resp
+}
+
+func decodeLNURLResponse(t *testing.T, rr *httptest.ResponseRecorder) handler.LNURLResponse {
+	var resp handler.LNURLResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	return resp
+}
+
+func TestLNURLAuth(t *testing.T) {
+	auth := &handler.LNURLAuth{Store: store.NewMemory()}
+	wallet := newLNURLWallet(t)
+	challenge := issueLNURL(t, auth)
+
+	decoded, err := login.DecodeLNURL(challenge.LNURL)
+	require.NoError(t, err)
+	assert.Equal(t, "http://phobia.cloud/lnurl?action=login&k1="+challenge.ChallengeHidden+"&tag=login", decoded)
+
+	require.Len(t, challenge.LNURLSecret, 64)
+	assert.NotEqual(t, challenge.ChallengeHidden, challenge.LNURLSecret)
+	assert.NotContains(t, decoded, challenge.LNURLSecret)
+
+	statusURL := "http://phobia.cloud/lnurl/status?k1=" + challenge.ChallengeHidden + "&secret=" + challenge.LNURLSecret
+	rr := serve(t, auth.Status, http.MethodGet, statusURL)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"pending"}`, rr.Body.String())
+
+	rr = serve(t, auth.Callback, http.MethodGet, wallet.callbackURL(t, challenge.LNURL))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, handler.LNURLResponse{Status: "OK"}, decodeLNURLResponse(t, rr))
+
+	// the challenge cannot be used twice
+	rr = serve(t, auth.Callback, http.MethodGet, newLNURLWallet(t).callbackURL(t, challenge.LNURL))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, handler.LNURLResponse{Status: "ERROR", Reason: "k1 already used"}, decodeLNURLResponse(t, rr))
+
+	// the login cannot be picked up with k1 alone or another secret
+	rr = serve(t, auth.Status, http.MethodGet, "http://phobia.cloud/lnurl/status?k1="+challenge.ChallengeHidden)
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidRequest, "secret")
+	rr = serve(t, auth.Status, http.MethodGet, "http://phobia.cloud/lnurl/status?k1="+challenge.ChallengeHidden+"&secret="+login.ChallengeHidden())
+	assertProblem(t, rr, http.StatusNotFound, problem.NotFound)
+
+	rr = serve(t, auth.Status, http.MethodGet, statusURL)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var status handler.LNURLStatusResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&status))
+	assert.Equal(t, handler.LNURLStatusResponse{Status: handler.LNURLStatusCompleted, Key: wallet.key()}, status)
+
+	// the completed login can be picked up only once
+	rr = serve(t, auth.Status, http.MethodGet, statusURL)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestLNURLAuth_ConcurrentCallbacks(t *testing.T) {
+	auth := &handler.LNURLAuth{Store: store.NewMemory()}
+	challenge := issueLNURL(t, auth)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		callbackURL := newLNURLWallet(t).callbackURL(t, challenge.LNURL)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+			rr := httptest.NewRecorder()
+			auth.Callback(rr, req)
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	completed := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			completed++
+		}
+	}
+	assert.Equal(t, 1, completed)
+}
+
+func TestLNURLAuth_CallbackErrors(t *testing.T) {
+	auth := &handler.LNURLAuth{Store: store.NewMemory()}
+	wallet := newLNURLWallet(t)
+	challenge := issueLNURL(t, auth)
+
+	valid, err := url.Parse(wallet.callbackURL(t, challenge.LNURL))
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		modify func(url.Values)
+		reason string
+	}{
+		{
+			name:   "unsupported tag",
+			modify: func(q url.Values) { q.Set("tag", "withdrawRequest") },
+			reason: "unsupported tag: withdrawRequest",
+		},
+		{
+			name:   "missing k1",
+			modify: func(q url.Values) { q.Del("k1") },
+			reason: "missing k1, sig or key",
+		},
+		{
+			name:   "missing sig",
+			modify: func(q url.Values) { q.Del("sig") },
+			reason: "missing k1, sig or key",
+		},
+		{
+			name:   "missing key",
+			modify: func(q url.Values) { q.Del("key") },
+			reason: "missing k1, sig or key",
+		},
+		{
+			name:   "unknown k1",
+			modify: func(q url.Values) { q.Set("k1", login.ChallengeHidden()) },
+			reason: "unknown or expired k1",
+		},
+		{
+			name:   "wrong key",
+			modify: func(q url.Values) { q.Set("key", newLNURLWallet(t).key()) },
+			reason: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:   "invalid key",
+			modify: func(q url.Values) { q.Set("key", "XX") },
+			reason: "failed to decode linking key: encoding/hex: invalid byte: U+0058 'X'",
+		},
+	} {
+		u := *valid
+		query := u.Query()
+		tt.modify(query)
+		u.RawQuery = query.Encode()
+
+		rr := serve(t, auth.Callback, http.MethodGet, u.String())
+		require.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+		assert.Equal(t, handler.LNURLResponse{Status: "ERROR", Reason: tt.reason}, decodeLNURLResponse(t, rr), tt.name)
+	}
+
+	// failed attempts do not consume the challenge
+	rr := serve(t, auth.Callback, http.MethodGet, valid.String())
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLNURLAuth_StatusErrors(t *testing.T) {
+	auth := &handler.LNURLAuth{Store: store.NewMemory()}
+
+	rr := serve(t, auth.Status, http.MethodGet, "/lnurl/status")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve(t, auth.Status, http.MethodGet, "/lnurl/status?k1="+login.ChallengeHidden()+"&secret="+login.ChallengeHidden())
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"net/http"
+
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/remote"
+)
+
+var handlerLog = logging.Component("handler")
+
+// logger returns the logger of the handlers for the request r.
+func logger(r *http.Request) *logging.Logger {
+	return handlerLog.Context(r.Context())
+}
+
+// authenticated records the fingerprint of the key or address that
+// authenticated with r in the access log.
+func authenticated(r *http.Request, key string) {
+	logging.Set(r.Context(), "key", logging.Fingerprint(key))
+}
+
+// record appends rec to the audit log with the ID and client of the
+// request r. Errors are logged, so a failing audit log does not fail the
+// request.
+func record(log *audit.Log, r *http.Request, rec audit.Record) {
+	if log == nil {
+		return
+	}
+	rec.ClientIP = remote.IP(r)
+	if req := logging.FromContext(r.Context()); req != nil {
+		rec.RequestID = req.ID
+	}
+	err := log.Append(rec)
+	if err != nil {
+		logger(r).Error("error writing audit log", "event", rec.Event, "error", err)
+	}
+}
+
+// audited reports whether logins with the error class are recorded in the
+// audit log. Requests that cannot be decoded and throttled requests are
+// not, as anyone can send them without a signature or at a high rate.
+func audited(class string) bool {
+	return class != ErrorClassDecode && class != ErrorClassThrottled
+}
@@ -0,0 +1,249 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/store"
+)
+
+// openAudit returns an audit log in a temporary file and a function that
+// returns its records after verifying them.
+func openAudit(t *testing.T) (*audit.Log, func() []audit.Record) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.Open(path, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	return l, func() []audit.Record {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		_, err = audit.Verify(bytes.NewReader(data), nil)
+		require.NoError(t, err)
+
+		var records []audit.Record
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var rec audit.Record
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+			records = append(records, rec)
+		}
+		return records
+	}
+}
+
+func TestLogin_AuthenticatedKey(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+
+	for _, tt := range []struct {
+		name      string
+		signature func(hidden, visual string) string
+		logged    bool
+	}{
+		{
+			name:      "valid signature",
+			signature: func(hidden, visual string) string { return key.Sign(hidden, visual, "", login.Version2) },
+			logged:    true,
+		},
+		{
+			name:      "invalid signature",
+			signature: func(hidden, visual string) string { return key.Sign(hidden, visual, "", login.Version1) },
+		},
+	} {
+		hidden, visual := login.ChallengeHidden(), login.ChallengeVisual()
+		body := mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: hidden,
+			ChallengeVisual: visual,
+			PublicKey:       key.PublicKey(),
+			Signature:       tt.signature(hidden, visual),
+			Version:         login.Version2,
+		})
+		req, err := http.NewRequest(http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(body))
+		require.NoError(t, err)
+
+		logReq := &logging.Request{ID: "9f86d081884c7d65"}
+		req = req.WithContext(logging.NewContext(req.Context(), logReq))
+		(&handler.LoginHandler{}).ServeHTTP(httptest.NewRecorder(), req)
+
+		if tt.logged {
+			assert.Equal(t, logging.Fingerprint(key.PublicKey()), logReq.Get("key"), tt.name)
+		} else {
+			assert.Nil(t, logReq.Get("key"), tt.name)
+		}
+	}
+}
+
+func TestAudit_Login(t *testing.T) {
+	l, records := openAudit(t)
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	challenge := &handler.ChallengeHandler{Audit: l}
+	h := &handler.LoginHandler{Audit: l}
+
+	serve := func(h http.Handler, method, target string, body []byte) int {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.RemoteAddr = "203.0.113.7:52114"
+		req = req.WithContext(logging.NewContext(req.Context(), &logging.Request{ID: "9f86d081884c7d65"}))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve(challenge, http.MethodGet, "http://phobia.cloud/challenge", nil))
+	issued := records()[0]
+	require.NotNil(t, issued.Proof)
+	hidden, visual := issued.Proof.ChallengeHidden, issued.Proof.ChallengeVisual
+
+	valid := handler.LoginRequest{
+		ChallengeHidden: hidden,
+		ChallengeVisual: visual,
+		PublicKey:       key.PublicKey(),
+		Signature:       key.Sign(hidden, visual, "", login.Version2),
+		Version:         login.Version2,
+	}
+	invalid := valid
+	invalid.Signature = key.Sign(hidden, visual, "", login.Version1)
+
+	require.Equal(t, http.StatusCreated, serve(h, http.MethodPost, "http://phobia.cloud/login", mustJSON(t, valid)))
+	require.Equal(t, http.StatusBadRequest, serve(h, http.MethodPost, "http://phobia.cloud/login", mustJSON(t, invalid)))
+	// requests that cannot be decoded are not recorded
+	require.Equal(t, http.StatusBadRequest, serve(h, http.MethodPost, "http://phobia.cloud/login", []byte("{")))
+
+	recs := records()
+	require.Len(t, recs, 3)
+	for i, rec := range recs {
+		assert.Equal(t, uint64(i+1), rec.Seq)
+		assert.Equal(t, "9f86d081884c7d65", rec.RequestID)
+		assert.Equal(t, "203.0.113.7", rec.ClientIP)
+	}
+
+	assert.Equal(t, audit.EventChallengeIssued, recs[0].Event)
+
+	assert.Equal(t, audit.EventLoginSuccess, recs[1].Event)
+	assert.Equal(t, "trezor", recs[1].Method)
+	assert.Equal(t, key.PublicKey(), recs[1].Subject)
+	assert.Empty(t, recs[1].Error)
+	// the proof can be verified again
+	proof := recs[1].Proof
+	require.NotNil(t, proof)
+	assert.NoError(t, login.VerifyOrigin(proof.ChallengeHidden, proof.ChallengeVisual, proof.Origin, proof.PublicKey, proof.Signature, proof.Version))
+
+	assert.Equal(t, audit.EventLoginFailure, recs[2].Event)
+	assert.Equal(t, handler.ErrorClassInvalidSignature, recs[2].Error)
+	require.NotNil(t, recs[2].Proof)
+	assert.Equal(t, invalid.Signature, recs[2].Proof.Signature)
+}
+
+func TestAudit_ChallengeLimit(t *testing.T) {
+	l, records := openAudit(t)
+	h := &handler.ChallengeHandler{Audit: l, AuditLimits: ratelimit.NewMemory()}
+
+	serve := func(remoteAddr string) {
+		req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/challenge", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	// challenges above the limit are issued but not recorded
+	for i := 0; i < handler.ChallengeAuditLimit.Burst+5; i++ {
+		serve("203.0.113.7:52114")
+	}
+	assert.Len(t, records(), handler.ChallengeAuditLimit.Burst)
+
+	serve("198.51.100.23:40312")
+	recs := records()
+	require.Len(t, recs, handler.ChallengeAuditLimit.Burst+1)
+	assert.Equal(t, "198.51.100.23", recs[len(recs)-1].ClientIP)
+}
+
+func TestAudit_SignedMessages(t *testing.T) {
+	l, records := openAudit(t)
+	challenges, issue := newChallenges(t)
+
+	nostr := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges, Audit: l}
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), []string{"challenge", issue()})
+	require.Equal(t, http.StatusCreated, nostrLogin(t, nostr, http.MethodPost, nostrAuthorization(t, event)).Code)
+	require.Equal(t, http.StatusBadRequest, nostrLogin(t, nostr, http.MethodPost, nostrAuthorization(t, event)).Code)
+	// authorizations that cannot be parsed are not recorded
+	require.Equal(t, http.StatusUnauthorized, nostrLogin(t, nostr, http.MethodPost, "Nostr e30").Code)
+
+	ethereum := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges, Audit: l}
+	now := time.Now().UTC().Truncate(time.Second)
+	body := signedSIWE(t, &login.SIWEMessage{
+		Domain:   "phobia.cloud",
+		Address:  ethereumKey.Address(),
+		URI:      "http://phobia.cloud/login/ethereum",
+		Version:  login.SIWEVersion,
+		ChainID:  1,
+		Nonce:    issue(),
+		IssuedAt: now,
+	})
+	require.Equal(t, http.StatusCreated, ethereumLogin(t, ethereum, http.MethodPost, body).Code)
+	require.Equal(t, http.StatusBadRequest, ethereumLogin(t, ethereum, http.MethodPost, body).Code)
+
+	lnurl := &handler.LNURLAuth{Store: store.NewMemory(), Audit: l}
+	wallet := newLNURLWallet(t)
+	challenge := issueLNURL(t, lnurl)
+	callbackURL := wallet.callbackURL(t, challenge.LNURL)
+	require.Equal(t, http.StatusOK, serve(t, lnurl.Callback, http.MethodGet, callbackURL).Code)
+	require.Equal(t, http.StatusBadRequest, serve(t, lnurl.Callback, http.MethodGet, callbackURL).Code)
+
+	recs := records()
+	require.Len(t, recs, 6)
+	for i, tt := range []struct {
+		method  string
+		subject string
+		event   string
+		class   string
+	}{
+		{"nostr", nostrKey.PublicKey(), audit.EventLoginSuccess, ""},
+		{"nostr", nostrKey.PublicKey(), audit.EventLoginFailure, handler.ErrorClassExpired},
+		{"ethereum", ethereumKey.Address(), audit.EventLoginSuccess, ""},
+		{"ethereum", ethereumKey.Address(), audit.EventLoginFailure, handler.ErrorClassExpired},
+		{"lnurl", wallet.key(), audit.EventLoginSuccess, ""},
+		{"lnurl", wallet.key(), audit.EventLoginFailure, handler.ErrorClassExpired},
+	} {
+		assert.Equal(t, tt.method, recs[i].Method, i)
+		assert.Equal(t, tt.subject, recs[i].Subject, i)
+		assert.Equal(t, tt.event, recs[i].Event, i)
+		assert.Equal(t, tt.class, recs[i].Error, i)
+		require.NotNil(t, recs[i].Proof, i)
+	}
+
+	// the proofs can be verified again
+	var recorded login.NostrEvent
+	require.NoError(t, json.Unmarshal([]byte(recs[0].Proof.Message), &recorded))
+	assert.NoError(t, recorded.Verify())
+	assert.Equal(t, event.Sig, recs[0].Proof.Signature)
+	challengeHidden, err := recorded.Challenge()
+	require.NoError(t, err)
+	assert.Equal(t, challengeHidden, recs[0].Proof.ChallengeHidden)
+
+	m, err := login.VerifySIWE(recs[2].Proof.Message, recs[2].Proof.Signature)
+	require.NoError(t, err)
+	assert.Equal(t, m.Nonce, recs[2].Proof.ChallengeHidden)
+
+	proof := recs[4].Proof
+	assert.Equal(t, challenge.ChallengeHidden, proof.ChallengeHidden)
+	assert.NoError(t, login.VerifyLNURL(proof.ChallengeHidden, proof.Signature, proof.PublicKey))
+}
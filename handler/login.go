@@ -4,58 +4,345 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
 	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
 )
 
+// DefaultChallengeMaxAge is how long a challenge is accepted after it was
+// issued if LoginHandler.MaxAge is not set.
+const DefaultChallengeMaxAge = 5 * time.Minute
+
 // LoginRequest contains the login information and signature to verify for
-// Trezor login.
+// Trezor login. Origin is required by login.Version3.
 type LoginRequest struct {
 	ChallengeHidden string `json:"challengeHidden"`
 	ChallengeVisual string `json:"challengeVisual"`
 	PublicKey       string `json:"publicKey"`
 	Signature       string `json:"signature"`
 	Version         int    `json:"version"`
+	Origin          string `json:"origin,omitempty"`
 }
 
-// Challenge is a HTTP handler that takes a POST request with LoginRequest in
-// the body and verifies the signature of the provided challenge. If the
-// signature is valid it logs in the user with the public key.
+// Login is a HTTP handler that takes a POST request with LoginRequest in
+// the body and verifies the signature of the provided challenge. It is a
+// LoginHandler without allowed origins, so version 3 logins are rejected.
 func Login(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	(&LoginHandler{}).ServeHTTP(w, r)
+}
 
-	if r.Method == http.MethodOptions {
-		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
-		w.Header().Set("Access-Control-Allow-Headers", "content-type")
-		return
+// LoginHandler is a HTTP handler that takes a POST request with LoginRequest
+// in the body and verifies the signature of the provided challenge. If the
+// signature is valid it logs in the user with the public key.
+//
+// Signatures of login.Version3 commit to the origin of the relying party.
+// The origin in the request must be one of Origins and must match the Origin
+// header of the request, so signatures relayed by a site on another origin
+// are rejected.
+type LoginHandler struct {
+	// Origins are the allowed origins, e.g. "https://phobia.cloud". If
+	// empty, no origin is allowed. The Host header of the request is never
+	// trusted, as it is set by the client.
+	Origins []string
+	// RequireOrigin rejects versions of the challenge that do not commit to
+	// the origin.
+	RequireOrigin bool
+	// Visual is the template of the challenge visual issued by the
+	// ChallengeHandler. If set, challenges older than MaxAge are rejected.
+	Visual *login.VisualTemplate
+	// MaxAge is how long a challenge is accepted after it was issued. If
+	// zero, DefaultChallengeMaxAge is used.
+	MaxAge time.Duration
+	// Versions are the allowed versions of the challenge. If empty, all
+	// versions supported by the login package are allowed.
+	Versions []int
+	// Metrics counts the logins by version and outcome if set.
+	Metrics *Metrics
+	// Abuse delays and locks out the keys, clients and networks of
+	// repeated failed logins if set.
+	Abuse *abuse.Detector
+	// AbusePolicy is the policy of Abuse. If zero, abuse.DefaultPolicy is
+	// used.
+	AbusePolicy abuse.Policy
+	// Audit records the logins with their signed proof if set.
+	Audit *audit.Log
+	// Webhooks publishes the successful logins and the lockouts if set.
+	Webhooks *webhook.Dispatcher
+}
+
+func (h *LoginHandler) maxAge() time.Duration {
+	if h.MaxAge == 0 {
+		return DefaultChallengeMaxAge
 	}
+	return h.MaxAge
+}
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (h *LoginHandler) guard() abuseGuard {
+	return abuseGuard{
+		detector: h.Abuse,
+		policy:   h.AbusePolicy,
+		method:   "trezor",
+		noun:     "public key",
+		metrics:  h.Metrics,
+		webhooks: h.Webhooks,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, class, p := h.login(w, r)
+	h.Metrics.login(req.Version, class)
+	h.audit(r, req, class)
+	if p != nil {
+		problem.Write(w, p)
 		return
 	}
 
+	publish(h.Webhooks, r, webhook.EventLoginSuccess, webhook.Data{Method: "trezor", Subject: req.PublicKey})
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// login verifies the login request r. It returns the decoded request, which
+// is zero if it cannot be decoded, the error class for the metrics and the
+// problem if the login fails.
+func (h *LoginHandler) login(w http.ResponseWriter, r *http.Request) (LoginRequest, string, *problem.Problem) {
+	var req LoginRequest
 	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		return req, ErrorClassDecode, problem.New(http.StatusBadRequest, problem.MalformedRequest, "missing request body")
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 
-	var req LoginRequest
 	err := decoder.Decode(&req)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		return LoginRequest{}, ErrorClassDecode, problem.New(http.StatusBadRequest, problem.MalformedRequest, fmt.Sprintf("failed to decode request: %v", err))
 	}
 
-	err = login.Verify(req.ChallengeHidden, req.ChallengeVisual, req.PublicKey, req.Signature, req.Version)
+	if p := req.validate(); p != nil {
+		return req, ErrorClassDecode, p
+	}
+
+	key := strings.ToLower(req.PublicKey)
+	if p := h.guard().check(w, r, key); p != nil {
+		return req, ErrorClassThrottled, p
+	}
+
+	if !h.allowedVersion(req.Version) {
+		return req, ErrorClassUnsupportedVersion, problem.New(http.StatusBadRequest, problem.UnsupportedVersion, fmt.Sprintf("version %d is not allowed", req.Version))
+	}
+
+	if req.Version == login.Version3 {
+		if !h.allowedOrigin(req.Origin) || r.Header.Get("Origin") != req.Origin {
+			return req, ErrorClassOrigin, problem.New(http.StatusBadRequest, problem.OriginNotAllowed, fmt.Sprintf("origin not allowed: %q", req.Origin))
+		}
+	} else if h.RequireOrigin {
+		return req, ErrorClassUnsupportedVersion, problem.New(http.StatusBadRequest, problem.UnsupportedVersion, fmt.Sprintf("version %d does not commit to the origin", req.Version))
+	}
+
+	if h.Visual != nil {
+		err = h.Visual.CheckFresh(req.ChallengeVisual, time.Now(), h.maxAge())
+		if errors.Is(err, login.ErrChallengeExpired) {
+			return req, ErrorClassExpired, problem.New(http.StatusBadRequest, problem.ChallengeExpired, err.Error())
+		}
+		if err != nil {
+			return req, ErrorClassDecode, invalidField("challengeVisual", err.Error())
+		}
+	}
+
+	start := time.Now()
+	err = verify(r, "trezor", func(span *tracing.Span) error {
+		span.SetAttribute("login.version", req.Version)
+		return login.VerifyOrigin(req.ChallengeHidden, req.ChallengeVisual, req.Origin, req.PublicKey, req.Signature, req.Version)
+	})
+	h.Metrics.verified(start)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		class := loginErrorClass(err)
+		h.guard().failure(r, key, class)
+		return req, class, loginProblem(err)
+	}
+
+	h.guard().success(r, key)
+	authenticated(r, req.PublicKey)
+	return req, ErrorClassNone, nil
+}
+
+// audit records the login request req with the error class of its outcome
+// if the class is audited.
+func (h *LoginHandler) audit(r *http.Request, req LoginRequest, class string) {
+	if !audited(class) {
 		return
 	}
+	rec := audit.Record{
+		Event:   audit.EventLoginFailure,
+		Method:  "trezor",
+		Subject: req.PublicKey,
+		Error:   class,
+	}
+	if class == ErrorClassNone {
+		rec.Event, rec.Error = audit.EventLoginSuccess, ""
+	}
+	if req.ChallengeHidden != "" {
+		rec.Proof = &audit.Proof{
+			ChallengeHidden: req.ChallengeHidden,
+			ChallengeVisual: req.ChallengeVisual,
+			PublicKey:       req.PublicKey,
+			Signature:       req.Signature,
+			Version:         req.Version,
+			Origin:          req.Origin,
+		}
+	}
+	record(h.Audit, r, rec)
+}
 
-	w.WriteHeader(http.StatusCreated)
+// validate returns a problem that lists every missing or malformed field of
+// req, or nil if there is none. The fields are verified by the login package
+// afterwards.
+func (req *LoginRequest) validate() *problem.Problem {
+	p := problem.New(http.StatusBadRequest, problem.InvalidRequest, "request has missing or invalid fields")
+
+	hexField := func(field, value string) {
+		if value == "" {
+			p.Field(field, problem.FieldMissing, "")
+			return
+		}
+		_, err := hex.DecodeString(value)
+		if err != nil {
+			p.Field(field, problem.FieldInvalid, fmt.Sprintf("failed to decode %s: %v", field, err))
+		}
+	}
+
+	hexField("challengeHidden", req.ChallengeHidden)
+	if req.ChallengeVisual == "" {
+		p.Field("challengeVisual", problem.FieldMissing, "")
+	}
+	hexField("publicKey", req.PublicKey)
+	hexField("signature", req.Signature)
+	if req.Version == 0 {
+		p.Field("version", problem.FieldMissing, "")
+	}
+	if req.Origin != "" {
+		err := login.ValidateOrigin(req.Origin)
+		if err != nil {
+			p.Field("origin", problem.FieldInvalid, err.Error())
+		}
+	} else if req.Version == login.Version3 {
+		p.Field("origin", problem.FieldMissing, "")
+	}
+
+	if len(p.Errors) == 0 {
+		return nil
+	}
+	return p
+}
+
+// loginProblem returns the problem for an error returned by the login
+// package.
+func loginProblem(err error) *problem.Problem {
+	var fieldErr *login.FieldError
+	switch {
+	case errors.Is(err, login.ErrInvalidSignature):
+		return problem.New(http.StatusBadRequest, problem.InvalidSignature, err.Error())
+	case errors.Is(err, login.ErrUnsupportedVersion):
+		return problem.New(http.StatusBadRequest, problem.UnsupportedVersion, err.Error())
+	case errors.As(err, &fieldErr):
+		return invalidField(fieldErr.Field, fieldErr.Error())
+	default:
+		return problem.New(http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	}
+}
+
+// loginErrorClass returns the error class of an error returned by the login
+// package.
+func loginErrorClass(err error) string {
+	switch {
+	case errors.Is(err, login.ErrInvalidSignature):
+		return ErrorClassInvalidSignature
+	case errors.Is(err, login.ErrUnsupportedVersion):
+		return ErrorClassUnsupportedVersion
+	default:
+		return ErrorClassParse
+	}
+}
+
+// missingField returns an invalid request problem for a single missing field.
+func missingField(field string) *problem.Problem {
+	return problem.New(http.StatusBadRequest, problem.InvalidRequest, "request has missing or invalid fields").
+		Field(field, problem.FieldMissing, "")
+}
+
+// invalidField returns an invalid request problem for a single invalid field.
+func invalidField(field, detail string) *problem.Problem {
+	return problem.New(http.StatusBadRequest, problem.InvalidRequest, "request has missing or invalid fields").
+		Field(field, problem.FieldInvalid, detail)
+}
+
+// allowedVersion reports whether version is one of the allowed versions.
+func (h *LoginHandler) allowedVersion(version int) bool {
+	if len(h.Versions) == 0 {
+		return true
+	}
+	for _, v := range h.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin reports whether origin is one of the allowed origins.
+func (h *LoginHandler) allowedOrigin(origin string) bool {
+	for _, o := range h.Origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLoginKeySize is the maximum size of a request body read by LoginKey
+// and EthereumKey.
+const maxLoginKeySize = 64 << 10
+
+// LoginKey returns the public key of the LoginRequest in the body of r in
+// lower case, or an empty string if the body is not a LoginRequest. The body
+// of r can still be read afterwards.
+func LoginKey(r *http.Request) string {
+	var req LoginRequest
+	if peekJSON(r, &req) != nil {
+		return ""
+	}
+	return strings.ToLower(req.PublicKey)
+}
+
+// peekJSON decodes the start of the JSON body of r into v and puts the body
+// back, so it can still be read afterwards.
+func peekJSON(r *http.Request, v interface{}) error {
+	if r.Body == nil {
+		return errors.New("missing request body")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoginKeySize))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
 }
@@ -4,15 +4,22 @@
 package handler_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"phobia.cloud/api/abuse"
 	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/problem"
 )
 
 func TestLogin(t *testing.T) {
@@ -35,18 +42,6 @@ func TestLogin(t *testing.T) {
 	assert.Empty(t, rr.Body)
 }
 
-func TestLogin_Options(t *testing.T) {
-	req, err := http.NewRequest(http.MethodOptions, "", nil)
-	require.NoError(t, err)
-
-	rr := httptest.NewRecorder()
-	h := http.HandlerFunc(handler.Login)
-	h.ServeHTTP(rr, req)
-
-	require.Equal(t, http.StatusOK, rr.Code)
-	assert.Equal(t, http.MethodPost, rr.Header().Get("Access-Control-Allow-Methods"))
-}
-
 func TestLogin_MissingBody(t *testing.T) {
 	req, err := http.NewRequest(http.MethodPost, "", nil)
 	require.NoError(t, err)
@@ -58,43 +53,27 @@ func TestLogin_MissingBody(t *testing.T) {
 	require.Equal(t, http.StatusBadRequest, rr.Code)
 }
 
-func TestLogin_MethodNotAllowed(t *testing.T) {
-	for _, method := range []string{
-		http.MethodConnect,
-		http.MethodDelete,
-		http.MethodGet,
-		http.MethodHead,
-		http.MethodPatch,
-		http.MethodPut,
-		http.MethodTrace,
-	} {
-		req, err := http.NewRequest(method, "", nil)
-		require.NoError(t, err)
-
-		rr := httptest.NewRecorder()
-		h := http.HandlerFunc(handler.Login)
-		h.ServeHTTP(rr, req)
-
-		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, method)
-	}
-}
-
 func TestLogin_BadRequest(t *testing.T) {
 	for _, tt := range []struct {
-		name string
-		body string
+		name   string
+		body   string
+		code   string
+		fields []string
 	}{
 		{
 			name: "empty body",
 			body: "",
+			code: problem.MalformedRequest,
 		},
 		{
 			name: "invalid json #1",
 			body: "{",
+			code: problem.MalformedRequest,
 		},
 		{
 			name: "invalid json #2",
 			body: "}",
+			code: problem.MalformedRequest,
 		},
 		{
 			name: "missing challenge hidden",
@@ -106,6 +85,8 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code:   problem.InvalidRequest,
+			fields: []string{"challengeHidden"},
 		},
 		{
 			name: "missing challenge visual",
@@ -117,6 +98,8 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code:   problem.InvalidRequest,
+			fields: []string{"challengeVisual"},
 		},
 		{
 			name: "missing public key",
@@ -128,6 +111,8 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code:   problem.InvalidRequest,
+			fields: []string{"publicKey"},
 		},
 		{
 			name: "missing signature",
@@ -139,6 +124,8 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code:   problem.InvalidRequest,
+			fields: []string{"signature"},
 		},
 		{
 			name: "missing challenge version",
@@ -150,6 +137,7 @@ func TestLogin_BadRequest(t *testing.T) {
 					"signature": "20f2d1a42d08c3a362be49275c3ffeeaa415fc040971985548b9f910812237bb41770bf2c8d488428799fbb7e52c11f1a3404011375e4080e077e0e42ab7a5ba02",
 				}
 			`,
+			code: problem.MalformedRequest,
 		},
 		{
 			name: "wrong challenge hidden",
@@ -162,6 +150,7 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code: problem.InvalidSignature,
 		},
 		{
 			name: "wrong challenge visual",
@@ -174,9 +163,10 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code: problem.InvalidSignature,
 		},
 		{
-			name: "missing public key",
+			name: "wrong public key",
 			body: `
 				{
 					"challengeHidden": "cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2",
@@ -186,6 +176,8 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code:   problem.InvalidRequest,
+			fields: []string{"publicKey"},
 		},
 		{
 			name: "missing signature",
@@ -198,6 +190,7 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 2
 				}
 			`,
+			code: problem.InvalidSignature,
 		},
 		{
 			name: "wrong version",
@@ -210,6 +203,7 @@ func TestLogin_BadRequest(t *testing.T) {
 					"version": 1
 				}
 			`,
+			code: problem.InvalidSignature,
 		},
 		{
 			name: "unsupported version",
@@ -219,9 +213,10 @@ func TestLogin_BadRequest(t *testing.T) {
 					"challengeVisual": "2015-03-23 17:39:22",
 					"publicKey": "023a472219ad3327b07c18273717bb3a40b39b743756bf287fbd5fa9d263237f45",
 					"signature": "20f2d1a42d08c3a362be49275c3ffeeaa415fc040971985548b9f910812237bb41770bf2c8d488428799fbb7e52c11f1a3404011375e4080e077e0e42ab7a5ba02",
-					"version": 3
+					"version": 4
 				}
 			`,
+			code: problem.UnsupportedVersion,
 		},
 	} {
 		req, err := http.NewRequest(http.MethodPost, "", strings.NewReader(tt.body))
@@ -231,6 +226,347 @@ func TestLogin_BadRequest(t *testing.T) {
 		h := http.HandlerFunc(handler.Login)
 		h.ServeHTTP(rr, req)
 
-		require.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+		assertProblem(t, rr, http.StatusBadRequest, tt.code, tt.fields...)
 	}
 }
+
+func TestLogin_InvalidFields(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "", strings.NewReader(`
+		{
+			"challengeHidden": "xyz",
+			"publicKey": "023a472219ad3327b07c18273717bb3a40b39b743756bf287fbd5fa9d263237f45",
+			"signature": "20f2d1a42d08c3a362be49275c3ffeeaa415fc040971985548b9f910812237bb41770bf2c8d488428799fbb7e52c11f1a3404011375e4080e077e0e42ab7a5ba0",
+			"version": 3,
+			"origin": "https://phobia.cloud/"
+		}
+	`))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h := http.HandlerFunc(handler.Login)
+	h.ServeHTTP(rr, req)
+
+	p := assertProblem(t, rr, http.StatusBadRequest, problem.InvalidRequest, "challengeHidden", "challengeVisual", "signature", "origin")
+	assert.Equal(t, []problem.FieldError{
+		{Field: "challengeHidden", Code: problem.FieldInvalid, Detail: "failed to decode challengeHidden: encoding/hex: invalid byte: U+0078 'x'"},
+		{Field: "challengeVisual", Code: problem.FieldMissing},
+		{Field: "signature", Code: problem.FieldInvalid, Detail: "failed to decode signature: encoding/hex: odd length hex string"},
+		{Field: "origin", Code: problem.FieldInvalid, Detail: `invalid origin: "https://phobia.cloud/"`},
+	}, p.Errors)
+}
+
+// assertProblem asserts that rr is a problem response with the status and
+// code, and with errors for exactly the fields. It returns the problem.
+func assertProblem(t *testing.T, rr *httptest.ResponseRecorder, status int, code string, fields ...string) *problem.Problem {
+	t.Helper()
+
+	assert.Equal(t, status, rr.Code)
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p), rr.Body.String())
+	assert.Equal(t, status, p.Status)
+	assert.Equal(t, code, p.Code)
+	assert.Equal(t, problem.TypePrefix+code, p.Type)
+	assert.NotEmpty(t, p.Title)
+
+	var got []string
+	for _, e := range p.Errors {
+		got = append(got, e.Field)
+	}
+	assert.Equal(t, fields, got)
+
+	return &p
+}
+
+func TestLoginHandler_Origin(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	challengeHidden, challengeVisual := login.ChallengeHidden(), login.ChallengeVisual()
+
+	loginRequest := func(origin string, version int) handler.LoginRequest {
+		return handler.LoginRequest{
+			ChallengeHidden: challengeHidden,
+			ChallengeVisual: challengeVisual,
+			PublicKey:       key.PublicKey(),
+			Signature:       key.Sign(challengeHidden, challengeVisual, origin, version),
+			Version:         version,
+			Origin:          origin,
+		}
+	}
+
+	for _, tt := range []struct {
+		name         string
+		handler      *handler.LoginHandler
+		target       string
+		originHeader string
+		req          handler.LoginRequest
+		expected     int
+		code         string
+	}{
+		{
+			name:         "no configured origins",
+			handler:      &handler.LoginHandler{},
+			target:       "http://phobia.cloud/login",
+			originHeader: "http://phobia.cloud",
+			req:          loginRequest("http://phobia.cloud", login.Version3),
+			expected:     http.StatusBadRequest,
+			code:         problem.OriginNotAllowed,
+		},
+		{
+			name:         "relayed with a spoofed host",
+			handler:      &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:       "https://phobia.club/login",
+			originHeader: "https://phobia.club",
+			req:          loginRequest("https://phobia.club", login.Version3),
+			expected:     http.StatusBadRequest,
+			code:         problem.OriginNotAllowed,
+		},
+		{
+			name:         "configured origin",
+			handler:      &handler.LoginHandler{Origins: []string{"https://phobia.cloud", "https://app.phobia.cloud"}},
+			target:       "http://api.internal/login",
+			originHeader: "https://app.phobia.cloud",
+			req:          loginRequest("https://app.phobia.cloud", login.Version3),
+			expected:     http.StatusCreated,
+		},
+		{
+			name:         "relayed from another origin",
+			handler:      &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:       "http://api.internal/login",
+			originHeader: "https://phobia.club",
+			req:          loginRequest("https://phobia.club", login.Version3),
+			expected:     http.StatusBadRequest,
+			code:         problem.OriginNotAllowed,
+		},
+		{
+			name:         "signed for another origin",
+			handler:      &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:       "http://api.internal/login",
+			originHeader: "https://phobia.cloud",
+			req: func() handler.LoginRequest {
+				req := loginRequest("https://phobia.club", login.Version3)
+				req.Origin = "https://phobia.cloud"
+				return req
+			}(),
+			expected: http.StatusBadRequest,
+			code:     problem.InvalidSignature,
+		},
+		{
+			name:         "origin header does not match",
+			handler:      &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:       "http://api.internal/login",
+			originHeader: "https://phobia.club",
+			req:          loginRequest("https://phobia.cloud", login.Version3),
+			expected:     http.StatusBadRequest,
+			code:         problem.OriginNotAllowed,
+		},
+		{
+			name:     "missing origin header",
+			handler:  &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:   "http://api.internal/login",
+			req:      loginRequest("https://phobia.cloud", login.Version3),
+			expected: http.StatusBadRequest,
+			code:     problem.OriginNotAllowed,
+		},
+		{
+			name:     "version 2",
+			handler:  &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}},
+			target:   "http://api.internal/login",
+			req:      loginRequest("", login.Version2),
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "version 2 with origin required",
+			handler:  &handler.LoginHandler{Origins: []string{"https://phobia.cloud"}, RequireOrigin: true},
+			target:   "http://api.internal/login",
+			req:      loginRequest("", login.Version2),
+			expected: http.StatusBadRequest,
+			code:     problem.UnsupportedVersion,
+		},
+	} {
+		req, err := http.NewRequest(http.MethodPost, tt.target, bytes.NewReader(mustJSON(t, tt.req)))
+		require.NoError(t, err)
+		if tt.originHeader != "" {
+			req.Header.Set("Origin", tt.originHeader)
+		}
+
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, tt.expected, rr.Code, tt.name)
+		if tt.code != "" {
+			assert.Contains(t, rr.Body.String(), `"code":"`+tt.code+`"`, tt.name)
+		}
+	}
+}
+
+func TestLoginHandler_Versions(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	h := &handler.LoginHandler{Versions: []int{login.Version2}}
+
+	for _, tt := range []struct {
+		version  int
+		expected int
+	}{
+		{version: login.Version1, expected: http.StatusBadRequest},
+		{version: login.Version2, expected: http.StatusCreated},
+	} {
+		challengeHidden, challengeVisual := login.ChallengeHidden(), login.ChallengeVisual()
+		body := mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: challengeHidden,
+			ChallengeVisual: challengeVisual,
+			PublicKey:       key.PublicKey(),
+			Signature:       key.Sign(challengeHidden, challengeVisual, "", tt.version),
+			Version:         tt.version,
+		})
+		req, err := http.NewRequest(http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(body))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if tt.expected == http.StatusCreated {
+			assert.Equal(t, tt.expected, rr.Code, tt.version)
+		} else {
+			assertProblem(t, rr, tt.expected, problem.UnsupportedVersion)
+		}
+	}
+}
+
+func TestLoginHandler_VisualTemplate(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	template := &login.VisualTemplate{Text: "Login to phobia.cloud\n{time}"}
+	h := &handler.LoginHandler{Visual: template, MaxAge: time.Minute}
+
+	for _, tt := range []struct {
+		name     string
+		issued   time.Time
+		visual   func(time.Time) string
+		expected int
+		code     string
+	}{
+		{
+			name:     "fresh",
+			issued:   time.Now(),
+			expected: http.StatusCreated,
+		},
+		{
+			name:     "expired",
+			issued:   time.Now().Add(-5 * time.Minute),
+			expected: http.StatusBadRequest,
+			code:     problem.ChallengeExpired,
+		},
+		{
+			name:     "in the future",
+			issued:   time.Now().Add(5 * time.Minute),
+			expected: http.StatusBadRequest,
+			code:     problem.InvalidRequest,
+		},
+		{
+			name:   "other template",
+			issued: time.Now(),
+			visual: func(issued time.Time) string {
+				return issued.Format("2006-01-02 15:04:05")
+			},
+			expected: http.StatusBadRequest,
+			code:     problem.InvalidRequest,
+		},
+	} {
+		challengeHidden := login.ChallengeHidden()
+		var challengeVisual string
+		if tt.visual != nil {
+			challengeVisual = tt.visual(tt.issued)
+		} else {
+			var err error
+			challengeVisual, err = template.Render(tt.issued)
+			require.NoError(t, err)
+		}
+
+		body := mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: challengeHidden,
+			ChallengeVisual: challengeVisual,
+			PublicKey:       key.PublicKey(),
+			Signature:       key.Sign(challengeHidden, challengeVisual, "", login.Version2),
+			Version:         login.Version2,
+		})
+		req, err := http.NewRequest(http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(body))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, tt.expected, rr.Code, tt.name)
+		if tt.code != "" {
+			assert.Contains(t, rr.Body.String(), `"code":"`+tt.code+`"`, tt.name)
+		}
+	}
+}
+
+func TestLoginKey(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body string
+		key  string
+	}{
+		{name: "public key", body: `{"publicKey": "023A47"}`, key: "023a47"},
+		{name: "no public key", body: `{"version": 2}`, key: ""},
+		{name: "malformed", body: `{"publicKey": `, key: ""},
+		{name: "empty", body: "", key: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "", strings.NewReader(tt.body))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.key, handler.LoginKey(req))
+
+			body := new(bytes.Buffer)
+			_, err = body.ReadFrom(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, body.String())
+		})
+	}
+}
+
+func TestLoginHandler_Abuse(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	challengeHidden, challengeVisual := login.ChallengeHidden(), login.ChallengeVisual()
+
+	policy := abuse.DefaultPolicy
+	policy.Delay, policy.MaxDelay = 0, 0
+	policy.KeyFailures = 2
+	h := &handler.LoginHandler{Abuse: abuse.NewDetector(), AbusePolicy: policy}
+
+	loginFrom := func(ip string, valid bool) *httptest.ResponseRecorder {
+		signature := key.Sign(challengeHidden, challengeVisual, "", login.Version2)
+		if !valid {
+			signature = key.Sign(login.ChallengeHidden(), challengeVisual, "", login.Version2)
+		}
+		req := httptest.NewRequest(http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: challengeHidden,
+			ChallengeVisual: challengeVisual,
+			PublicKey:       key.PublicKey(),
+			Signature:       signature,
+			Version:         login.Version2,
+		})))
+		req.RemoteAddr = ip + ":52114"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusCreated, loginFrom("203.0.113.7", true).Code)
+
+	assertProblem(t, loginFrom("198.51.100.1", false), http.StatusBadRequest, problem.InvalidSignature)
+	assertProblem(t, loginFrom("198.51.100.2", false), http.StatusBadRequest, problem.InvalidSignature)
+
+	rr := loginFrom("192.0.2.1", true)
+	p := assertProblem(t, rr, http.StatusTooManyRequests, problem.TooManyRequests)
+	assert.Equal(t, "300", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "the public key is locked out after repeated failed logins, retry in 300 seconds", p.Detail)
+	require.Len(t, h.Abuse.Lockouts(), 1)
+
+	// the key holder clears the lockout from a trusted context
+	require.Equal(t, http.StatusCreated, loginFrom("203.0.113.7", true).Code)
+	assert.Empty(t, h.Abuse.Lockouts())
+	require.Equal(t, http.StatusCreated, loginFrom("192.0.2.1", true).Code)
+}
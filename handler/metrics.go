@@ -0,0 +1,109 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"phobia.cloud/api/metrics"
+)
+
+// The outcomes of logins in the metrics.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// The classes of login errors in the metrics.
+const (
+	// ErrorClassNone is the class of successful logins.
+	ErrorClassNone = "none"
+	// ErrorClassDecode is the class of requests that cannot be decoded,
+	// e.g. invalid JSON, missing fields or fields that are not hex.
+	ErrorClassDecode = "decode"
+	// ErrorClassParse is the class of requests whose public key or
+	// signature cannot be parsed.
+	ErrorClassParse = "parse"
+	// ErrorClassInvalidSignature is the class of requests with a signature
+	// that does not verify.
+	ErrorClassInvalidSignature = "invalid_signature"
+	// ErrorClassUnsupportedVersion is the class of requests with a version
+	// that is not supported or not allowed.
+	ErrorClassUnsupportedVersion = "unsupported_version"
+	// ErrorClassOrigin is the class of requests from an origin that is not
+	// allowed.
+	ErrorClassOrigin = "origin"
+	// ErrorClassExpired is the class of requests with an expired challenge.
+	ErrorClassExpired = "expired"
+	// ErrorClassThrottled is the class of requests that are delayed or
+	// locked out after repeated failed logins.
+	ErrorClassThrottled = "throttled"
+	// ErrorClassCloned is the class of WebAuthn logins with a signature
+	// counter that did not increase.
+	ErrorClassCloned = "cloned"
+)
+
+// Metrics are the metrics of the handlers. The methods of a nil Metrics do
+// nothing.
+type Metrics struct {
+	challenges *metrics.Counter
+	logins     *metrics.Counter
+	verify     *metrics.Histogram
+	lockouts   *metrics.Counter
+}
+
+// NewMetrics registers the metrics of the handlers in r.
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		challenges: r.Counter("phobia_challenges_issued_total",
+			"Number of issued login challenges."),
+		logins: r.Counter("phobia_logins_total",
+			"Number of Trezor logins by challenge version, outcome and error class.",
+			"version", "outcome", "error"),
+		verify: r.Histogram("phobia_login_verify_duration_seconds",
+			"Duration of the verification of login signatures.", metrics.DefaultBuckets),
+		lockouts: r.Counter("phobia_login_lockouts_total",
+			"Number of lockouts after repeated failed logins by scope.",
+			"scope"),
+	}
+}
+
+func (m *Metrics) challengeIssued() {
+	if m == nil {
+		return
+	}
+	m.challenges.Inc()
+}
+
+// login counts a login with the version of the request, or 0 if it is
+// unknown, and the error class.
+func (m *Metrics) login(version int, class string) {
+	if m == nil {
+		return
+	}
+	label := "unknown"
+	if version > 0 {
+		label = strconv.Itoa(version)
+	}
+	outcome := OutcomeFailure
+	if class == ErrorClassNone {
+		outcome = OutcomeSuccess
+	}
+	m.logins.Inc(label, outcome, class)
+}
+
+func (m *Metrics) verified(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.verify.ObserveSince(start)
+}
+
+func (m *Metrics) lockout(scope string) {
+	if m == nil {
+		return
+	}
+	m.lockouts.Inc(scope)
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := handler.NewMetrics(registry)
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+
+	challenge := &handler.ChallengeHandler{Metrics: m}
+	loginHandler := &handler.LoginHandler{Metrics: m, Versions: []int{login.Version1, login.Version2}}
+
+	post := func(body string) {
+		req, err := http.NewRequest(http.MethodPost, "http://phobia.cloud/login", strings.NewReader(body))
+		require.NoError(t, err)
+		loginHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	signed := func(version, signVersion int, publicKey string) string {
+		hidden, visual := login.ChallengeHidden(), login.ChallengeVisual()
+		return string(mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: hidden,
+			ChallengeVisual: visual,
+			PublicKey:       publicKey,
+			Signature:       key.Sign(hidden, visual, "", signVersion),
+			Version:         version,
+		}))
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://phobia.cloud/challenge", nil)
+		require.NoError(t, err)
+		challenge.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	post(signed(login.Version1, login.Version1, key.PublicKey()))
+	post(signed(login.Version2, login.Version2, key.PublicKey()))
+	post(signed(login.Version2, login.Version1, key.PublicKey()))
+	post(signed(login.Version2, login.Version2, "00"))
+	post(signed(login.Version3, login.Version2, key.PublicKey()))
+	post("{")
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	require.NoError(t, err)
+	exposition := buf.String()
+
+	assert.Contains(t, exposition, "\nphobia_challenges_issued_total 2\n")
+	for _, line := range []string{
+		`phobia_logins_total{version="1",outcome="success",error="none"} 1`,
+		`phobia_logins_total{version="2",outcome="success",error="none"} 1`,
+		`phobia_logins_total{version="2",outcome="failure",error="invalid_signature"} 1`,
+		`phobia_logins_total{version="2",outcome="failure",error="parse"} 1`,
+		`phobia_logins_total{version="3",outcome="failure",error="decode"} 1`,
+		`phobia_logins_total{version="unknown",outcome="failure",error="decode"} 1`,
+		`phobia_login_verify_duration_seconds_count 4`,
+	} {
+		assert.Contains(t, exposition, line+"\n")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
+)
+
+// maxNostrBodySize is the maximum size of a request body hashed for the
+// payload tag of a NIP-98 event.
+const maxNostrBodySize = 1 << 20
+
+// NostrLogin is a HTTP handler that takes a POST request authorized with a
+// NIP-98 "Authorization: Nostr" header and logs in the user with the Nostr
+// public key of the event.
+//
+// The event must be signed for the URL of the request on one of Origins and
+// for its method, and must embed a challenge hidden issued by a
+// ChallengeHandler with the same Challenges in its "challenge" tag. Each
+// challenge can be used only once.
+type NostrLogin struct {
+	// Origins are the public origins of the server, e.g.
+	// "https://phobia.cloud". If empty, all logins are rejected. The Host
+	// header of the request is never trusted, as it is set by the client.
+	Origins []string
+	// Challenges are the issued challenges. If nil, all logins are
+	// rejected.
+	Challenges *Challenges
+	// Audit records the logins with their signed event if set.
+	Audit *audit.Log
+	// Webhooks publishes the successful logins and the lockouts if set.
+	Webhooks *webhook.Dispatcher
+	// Abuse delays and locks out the keys, clients and networks of
+	// repeated failed logins if set.
+	Abuse *abuse.Detector
+	// AbusePolicy is the policy of Abuse. If zero, abuse.DefaultPolicy is
+	// used.
+	AbusePolicy abuse.Policy
+}
+
+func (h *NostrLogin) guard() abuseGuard {
+	return abuseGuard{
+		detector: h.Abuse,
+		policy:   h.AbusePolicy,
+		method:   "nostr",
+		noun:     "public key",
+		webhooks: h.Webhooks,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *NostrLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event, err := login.ParseNostrAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Nostr")
+		problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+		return
+	}
+
+	key := strings.ToLower(event.PubKey)
+	if p := h.guard().check(w, r, key); p != nil {
+		problem.Write(w, p)
+		return
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxNostrBodySize))
+		if err != nil {
+			problem.Error(w, http.StatusBadRequest, problem.MalformedRequest, fmt.Sprintf("failed to read request: %v", err))
+			return
+		}
+	}
+
+	// the u tag is checked against the origins after the signature, so
+	// that only signed events are audited
+	u, _ := event.Tag("u")
+	err = verify(r, "nostr", func(*tracing.Span) error {
+		return login.VerifyNostrAuth(event, r.Method, u, body, time.Now())
+	})
+	if err != nil {
+		class := loginErrorClass(err)
+		h.guard().failure(r, key, class)
+		h.audit(r, event, class)
+		w.Header().Set("WWW-Authenticate", "Nostr")
+		problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, err.Error())
+		return
+	}
+
+	if !h.allowedURL(r, u) {
+		h.audit(r, event, ErrorClassOrigin)
+		problem.Error(w, http.StatusBadRequest, problem.OriginNotAllowed, fmt.Sprintf("event u tag is not the request url on an allowed origin: %q", u))
+		return
+	}
+
+	challengeHidden, err := event.Challenge()
+	if err != nil {
+		h.audit(r, event, ErrorClassParse)
+		problem.Error(w, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+	err = h.Challenges.redeem(r, challengeHidden)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			h.audit(r, event, ErrorClassExpired)
+		}
+		problem.Write(w, redeemProblem(r, err))
+		return
+	}
+
+	h.guard().success(r, key)
+	h.audit(r, event, ErrorClassNone)
+	authenticated(r, event.PubKey)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// allowedURL reports whether url is the URL of r on one of the origins.
+func (h *NostrLogin) allowedURL(r *http.Request, url string) bool {
+	for _, origin := range h.Origins {
+		if url == origin+r.URL.RequestURI() {
+			return true
+		}
+	}
+	return false
+}
+
+// NostrKey returns the public key of the NIP-98 event in the Authorization
+// header of r in lower case, or an empty string if there is no such event.
+func NostrKey(r *http.Request) string {
+	event, err := login.ParseNostrAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(event.PubKey)
+}
+
+// audit records the login with the NIP-98 event and the error class of its
+// outcome, and publishes it if it succeeded.
+func (h *NostrLogin) audit(r *http.Request, event *login.NostrEvent, class string) {
+	rec := audit.Record{
+		Event:   audit.EventLoginFailure,
+		Method:  "nostr",
+		Subject: event.PubKey,
+		Error:   class,
+	}
+	if class == ErrorClassNone {
+		rec.Event, rec.Error = audit.EventLoginSuccess, ""
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		logger(r).Error("error encoding nostr event", "error", err)
+	}
+	challengeHidden, _ := event.Tag("challenge")
+	rec.Proof = &audit.Proof{
+		ChallengeHidden: challengeHidden,
+		PublicKey:       event.PubKey,
+		Signature:       event.Sig,
+		Message:         string(message),
+	}
+	record(h.Audit, r, rec)
+	if class == ErrorClassNone {
+		publish(h.Webhooks, r, webhook.EventLoginSuccess, webhook.Data{Method: rec.Method, Subject: rec.Subject})
+	}
+}
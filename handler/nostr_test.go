@@ -0,0 +1,190 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/problem"
+)
+
+const nostrLoginURL = "http://phobia.cloud/login/nostr"
+
+var nostrOrigins = []string{"http://phobia.cloud"}
+
+var nostrKey = logintest.NewSchnorrKey([]byte("phobia.cloud nostr test key 0001"))
+
+func nostrAuthorization(t *testing.T, event *login.NostrEvent) string {
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
+
+func nostrLogin(t *testing.T, h *handler.NostrLogin, method, authorization string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, nostrLoginURL, strings.NewReader(""))
+	require.NoError(t, err)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestNostrLogin(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges}
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(),
+		[]string{"challenge", issue()})
+
+	rr := nostrLogin(t, h, http.MethodPost, nostrAuthorization(t, event))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Empty(t, rr.Body)
+}
+
+func TestNostrLogin_ChallengeReuse(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges}
+	challenge := []string{"challenge", issue()}
+
+	authorization := nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), challenge))
+	rr := nostrLogin(t, h, http.MethodPost, authorization)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// the same event is replayed
+	rr = nostrLogin(t, h, http.MethodPost, authorization)
+	assertProblem(t, rr, http.StatusBadRequest, problem.ChallengeExpired)
+
+	// a new event with the same challenge
+	authorization = nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now().Add(time.Second), challenge))
+	rr = nostrLogin(t, h, http.MethodPost, authorization)
+	assertProblem(t, rr, http.StatusBadRequest, problem.ChallengeExpired)
+
+	// a challenge that was never issued
+	authorization = nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(),
+		[]string{"challenge", login.ChallengeHidden()}))
+	rr = nostrLogin(t, h, http.MethodPost, authorization)
+	assertProblem(t, rr, http.StatusBadRequest, problem.ChallengeExpired)
+
+	// without challenges, no login is accepted
+	authorization = nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(),
+		[]string{"challenge", issue()}))
+	rr = nostrLogin(t, &handler.NostrLogin{Origins: nostrOrigins}, http.MethodPost, authorization)
+	assertProblem(t, rr, http.StatusBadRequest, problem.ChallengeExpired)
+}
+
+func TestNostrLogin_Unauthorized(t *testing.T) {
+	challenges, issue := newChallenges(t)
+	h := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges}
+	challenge := []string{"challenge", issue()}
+
+	for _, tt := range []struct {
+		name          string
+		authorization string
+	}{
+		{
+			name:          "missing authorization",
+			authorization: "",
+		},
+		{
+			name:          "other scheme",
+			authorization: "Bearer abc",
+		},
+		{
+			name:          "wrong method",
+			authorization: nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodGet, nostrLoginURL, time.Now(), challenge)),
+		},
+		{
+			name:          "expired",
+			authorization: nostrAuthorization(t, nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now().Add(-time.Hour), challenge)),
+		},
+	} {
+		rr := nostrLogin(t, h, http.MethodPost, tt.authorization)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, tt.name)
+		assert.Equal(t, "Nostr", rr.Header().Get("WWW-Authenticate"), tt.name)
+	}
+}
+
+func TestNostrLogin_Origin(t *testing.T) {
+	challenges, issue := newChallenges(t)
+
+	for _, tt := range []struct {
+		name    string
+		origins []string
+		target  string
+		url     string
+	}{
+		{
+			name:    "wrong url",
+			origins: nostrOrigins,
+			target:  nostrLoginURL,
+			url:     "http://phobia.cloud/login",
+		},
+		{
+			name:    "signed for another site with a spoofed host",
+			origins: nostrOrigins,
+			target:  "http://phobia.club/login/nostr",
+			url:     "http://phobia.club/login/nostr",
+		},
+		{
+			name:   "no configured origins",
+			target: nostrLoginURL,
+			url:    nostrLoginURL,
+		},
+	} {
+		event := nostrKey.NostrAuthEvent(http.MethodPost, tt.url, time.Now(), []string{"challenge", issue()})
+		req, err := http.NewRequest(http.MethodPost, tt.target, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", nostrAuthorization(t, event))
+
+		rr := httptest.NewRecorder()
+		(&handler.NostrLogin{Origins: tt.origins, Challenges: challenges}).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+		assert.Contains(t, rr.Body.String(), `"code":"`+problem.OriginNotAllowed+`"`, tt.name)
+	}
+}
+
+func TestNostrLogin_MissingChallenge(t *testing.T) {
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now())
+
+	rr := nostrLogin(t, &handler.NostrLogin{Origins: nostrOrigins}, http.MethodPost, nostrAuthorization(t, event))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestNostrKey(t *testing.T) {
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now())
+	for _, tt := range []struct {
+		name          string
+		authorization string
+		key           string
+	}{
+		{name: "event", authorization: nostrAuthorization(t, event), key: nostrKey.PublicKey()},
+		{name: "upper case", authorization: nostrAuthorization(t, &login.NostrEvent{PubKey: "3A47BC"}), key: "3a47bc"},
+		{name: "malformed", authorization: "Nostr e30", key: ""},
+		{name: "other scheme", authorization: "Bearer e30=", key: ""},
+		{name: "missing", authorization: "", key: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, nostrLoginURL, nil)
+			require.NoError(t, err)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			assert.Equal(t, tt.key, handler.NostrKey(req))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/qr"
+)
+
+const (
+	// qrBorder is the width of the quiet zone around QR codes in modules.
+	qrBorder = 4
+	// qrScale is the number of pixels per module in PNG QR codes.
+	qrScale = 8
+)
+
+// ChallengeQR is a HTTP handler that takes a GET request with the
+// "challengeHidden" and "challengeVisual" query parameters, as returned by
+// Challenge, and renders their login URI as a QR code.
+//
+// The optional "format" query parameter selects the image format, either
+// "svg" (default) or "png". The optional "level" query parameter selects the
+// error correction level, one of "L", "M" (default), "Q" or "H".
+func ChallengeQR(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	challengeHidden := query.Get("challengeHidden")
+	challengeVisual := query.Get("challengeVisual")
+	p := problem.New(http.StatusBadRequest, problem.InvalidRequest, "request has missing or invalid fields")
+	if challengeHidden == "" {
+		p.Field("challengeHidden", problem.FieldMissing, "")
+	} else if _, err := hex.DecodeString(challengeHidden); err != nil {
+		p.Field("challengeHidden", problem.FieldInvalid, fmt.Sprintf("failed to decode challengeHidden: %v", err))
+	}
+	if challengeVisual == "" {
+		p.Field("challengeVisual", problem.FieldMissing, "")
+	}
+	if len(p.Errors) > 0 {
+		problem.Write(w, p)
+		return
+	}
+
+	level := qr.M
+	if param := query.Get("level"); param != "" {
+		var err error
+		level, err = qr.ParseLevel(param)
+		if err != nil {
+			problem.Write(w, invalidField("level", err.Error()))
+			return
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "svg"
+	}
+	if format != "svg" && format != "png" {
+		problem.Write(w, invalidField("format", fmt.Sprintf("unsupported format: %q", format)))
+		return
+	}
+
+	uri := loginURI(r, challengeHidden, challengeVisual)
+	code, err := qr.Encode([]byte(uri.String()), level)
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+
+	switch format {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(w, qrBorder)
+	case "png":
+		var data []byte
+		data, err = code.PNG(qrScale, qrBorder)
+		if err != nil {
+			logger(r).Error("error encoding qr code", "error", err)
+			problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		logger(r).Warn("error writing response to client", "error", err)
+	}
+}
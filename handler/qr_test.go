@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+)
+
+func qrRequest(t *testing.T, method string, params url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "http://phobia.cloud/challenge/qr?"+params.Encode(), nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h := http.HandlerFunc(handler.ChallengeQR)
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestChallengeQR_SVG(t *testing.T) {
+	rr := qrRequest(t, http.MethodGet, url.Values{
+		"challengeHidden": {"cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+		"challengeVisual": {"2015-03-23 17:39:22"},
+	})
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/svg+xml", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "<?xml"))
+	assert.Contains(t, rr.Body.String(), "<svg")
+}
+
+func TestChallengeQR_PNG(t *testing.T) {
+	rr := qrRequest(t, http.MethodGet, url.Values{
+		"challengeHidden": {"cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+		"challengeVisual": {"2015-03-23 17:39:22"},
+		"format":          {"png"},
+		"level":           {"H"},
+	})
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, img.Bounds().Dx(), img.Bounds().Dy())
+	assert.Zero(t, img.Bounds().Dx()%8)
+}
+
+func TestChallengeQR_BadRequest(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+	}{
+		{
+			name: "missing challenge hidden",
+			params: url.Values{
+				"challengeVisual": {"2015-03-23 17:39:22"},
+			},
+		},
+		{
+			name: "invalid challenge hidden",
+			params: url.Values{
+				"challengeHidden": {"Xd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+				"challengeVisual": {"2015-03-23 17:39:22"},
+			},
+		},
+		{
+			name: "missing challenge visual",
+			params: url.Values{
+				"challengeHidden": {"cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+			},
+		},
+		{
+			name: "invalid format",
+			params: url.Values{
+				"challengeHidden": {"cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+				"challengeVisual": {"2015-03-23 17:39:22"},
+				"format":          {"gif"},
+			},
+		},
+		{
+			name: "invalid level",
+			params: url.Values{
+				"challengeHidden": {"cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2"},
+				"challengeVisual": {"2015-03-23 17:39:22"},
+				"level":           {"X"},
+			},
+		},
+	} {
+		rr := qrRequest(t, http.MethodGet, tt.params)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+	}
+}
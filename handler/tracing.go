@@ -0,0 +1,23 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"net/http"
+
+	"phobia.cloud/api/tracing"
+)
+
+// verify calls f to verify the signature of a login with method, e.g.
+// "nostr", within a span of the request r. f may add attributes to the
+// span. The error of f is recorded in the span and returned.
+func verify(r *http.Request, method string, f func(span *tracing.Span) error) error {
+	_, span := tracing.Start(r.Context(), "login.verify")
+	defer span.End()
+
+	span.SetAttribute("login.method", method)
+	err := f(span)
+	span.RecordError(err)
+	return err
+}
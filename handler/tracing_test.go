@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/tracing"
+)
+
+func TestLogin_Spans(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+	ctx, request := tracer.StartServer(context.Background(), "POST /v1/login", tracing.SpanContext{})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://phobia.cloud/challenge", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	(&handler.ChallengeHandler{}).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	for _, version := range []int{login.Version2, login.Version1} {
+		hidden, visual := login.ChallengeHidden(), login.ChallengeVisual()
+		body := mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: hidden,
+			ChallengeVisual: visual,
+			PublicKey:       key.PublicKey(),
+			Signature:       key.Sign(hidden, visual, "", login.Version2),
+			Version:         version,
+		})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(body))
+		require.NoError(t, err)
+		(&handler.LoginHandler{}).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	request.End()
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 4)
+	assert.Equal(t, "challenge.generate", spans[0].Name)
+
+	valid, invalid := spans[1], spans[2]
+	for _, span := range []tracing.SpanData{valid, invalid} {
+		assert.Equal(t, "login.verify", span.Name)
+		assert.Equal(t, "trezor", span.Attribute("login.method"))
+		assert.Equal(t, request.SpanContext().SpanID, span.Parent)
+	}
+	assert.Equal(t, login.Version2, valid.Attribute("login.version"))
+	assert.Equal(t, tracing.StatusUnset, valid.Status)
+	assert.Equal(t, login.Version1, invalid.Attribute("login.version"))
+	assert.Equal(t, tracing.StatusError, invalid.Status)
+}
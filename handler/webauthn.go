@@ -0,0 +1,666 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webhook"
+)
+
+// DefaultWebAuthnTTL is how long a WebAuthn challenge remains valid if
+// WebAuthn.TTL is not set.
+const DefaultWebAuthnTTL = 5 * time.Minute
+
+// maxWebAuthnBodySize limits the size of WebAuthn request bodies.
+const maxWebAuthnBodySize = 1 << 16
+
+// Base64URL is binary data encoded in JSON as unpadded base64url, as used by
+// the WebAuthn JSON serialization.
+type Base64URL []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Padding is accepted.
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	return err
+}
+
+// WebAuthnBeginRequest starts a WebAuthn ceremony for User. User may be empty
+// when logging in with a discoverable credential.
+//
+// To register another credential for a user who has credentials, Assertion
+// must log in the user with one of them, for a challenge of LoginBegin.
+type WebAuthnBeginRequest struct {
+	User      string             `json:"user"`
+	Assertion *WebAuthnAssertion `json:"assertion,omitempty"`
+}
+
+// WebAuthnCredentialDescriptor identifies a credential of the user.
+type WebAuthnCredentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   Base64URL `json:"id"`
+}
+
+// WebAuthnRelyingParty is the relying party entity of the creation options.
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUser is the user entity of the creation options.
+type WebAuthnUser struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// WebAuthnCredentialParameters is a credential type and algorithm accepted by
+// the relying party.
+type WebAuthnCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// WebAuthnAuthenticatorSelection are the authenticator requirements of the
+// creation options.
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions are the options for navigator.credentials.create.
+type WebAuthnCreationOptions struct {
+	Challenge              Base64URL                      `json:"challenge"`
+	RP                     WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	PubKeyCredParams       []WebAuthnCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	Attestation            string                         `json:"attestation"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// WebAuthnRequestOptions are the options for navigator.credentials.get.
+type WebAuthnRequestOptions struct {
+	Challenge        Base64URL                      `json:"challenge"`
+	RPID             string                         `json:"rpId"`
+	Timeout          int64                          `json:"timeout"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnRegistration is the credential returned by
+// navigator.credentials.create.
+type WebAuthnRegistration struct {
+	ID       Base64URL `json:"id"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// WebAuthnAssertion is the credential returned by navigator.credentials.get.
+type WebAuthnAssertion struct {
+	ID       Base64URL `json:"id"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// webauthnSession is the stored state of an issued WebAuthn challenge.
+type webauthnSession struct {
+	User     string `json:"user,omitempty"`
+	Ceremony string `json:"ceremony"`
+	// Enroll is set for registrations of users without credentials, which
+	// are anonymous and may only register the first credential.
+	Enroll bool `json:"enroll,omitempty"`
+}
+
+// WebAuthn handles WebAuthn (FIDO2) passkey registration and login, e.g.
+// with the FIDO2 authenticator of Trezor Model T.
+//
+// Each ceremony starts with a POST request with WebAuthnBeginRequest in the
+// body to RegisterBegin or LoginBegin, which returns the options for the
+// browser with a new ChallengeHidden as challenge. The browser passes the
+// credential returned by the authenticator to RegisterFinish or LoginFinish.
+// Each challenge can be used only once.
+//
+// Anyone can register the first credential of a user. Registering another
+// one requires logging in as the user in the request to RegisterBegin.
+type WebAuthn struct {
+	// RelyingParty is the relying party configuration. It is required, as
+	// the Host header of a request is set by the client. If nil, all
+	// requests are rejected.
+	RelyingParty *webauthn.RelyingParty
+	// Credentials keeps the registered credentials of users.
+	Credentials *webauthn.Credentials
+	// Store keeps the state of issued challenges.
+	Store store.Store
+	// TTL is how long an issued challenge remains valid. If zero,
+	// DefaultWebAuthnTTL is used.
+	TTL time.Duration
+	// Audit records the registered credentials and the logins if set.
+	Audit *audit.Log
+	// Webhooks publishes the registered credentials, the successful logins
+	// and the lockouts if set.
+	Webhooks *webhook.Dispatcher
+	// Abuse delays and locks out the users, clients and networks of
+	// repeated failed logins if set.
+	Abuse *abuse.Detector
+	// AbusePolicy is the policy of Abuse. If zero, abuse.DefaultPolicy is
+	// used.
+	AbusePolicy abuse.Policy
+}
+
+func (h *WebAuthn) guard() abuseGuard {
+	return abuseGuard{
+		detector: h.Abuse,
+		policy:   h.AbusePolicy,
+		method:   "webauthn",
+		noun:     "user",
+		webhooks: h.Webhooks,
+	}
+}
+
+// abuseKey returns the key of user for Abuse. It is prefixed, so a user
+// cannot be named after the public key of another login method to lock it
+// out.
+func abuseKey(user string) string {
+	return "webauthn:" + user
+}
+
+func (h *WebAuthn) ttl() time.Duration {
+	if h.TTL == 0 {
+		return DefaultWebAuthnTTL
+	}
+	return h.TTL
+}
+
+// decode decodes the JSON body of r into v. It answers the request and
+// returns false if the body is invalid or the relying party is not
+// configured.
+func (h *WebAuthn) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if h.RelyingParty == nil {
+		logger(r).Error("webauthn relying party is not configured")
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return false
+	}
+	return decodeWebAuthnRequest(w, r, v)
+}
+
+func (h *WebAuthn) userVerification(rp *webauthn.RelyingParty) string {
+	if rp.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func webauthnSessionKey(challenge []byte) string {
+	return "webauthn/session/" + base64.RawURLEncoding.EncodeToString(challenge)
+}
+
+// issue registers a new challenge for session and returns it.
+func (h *WebAuthn) issue(r *http.Request, session webauthnSession) ([]byte, error) {
+	challenge, err := hex.DecodeString(login.ChallengeHidden())
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.Store.Put(r.Context(), webauthnSessionKey(challenge), value, h.ttl())
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// redeem returns and removes the session of challenge. It returns
+// store.ErrNotFound if the challenge was not issued for ceremony. Of
+// concurrent requests with the same challenge, only one gets the session.
+func (h *WebAuthn) redeem(r *http.Request, ceremony string, challenge []byte) (webauthnSession, error) {
+	var session webauthnSession
+
+	value, err := h.Store.Take(r.Context(), webauthnSessionKey(challenge))
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(value, &session)
+	if err != nil {
+		return session, err
+	}
+	if session.Ceremony != ceremony {
+		return session, store.ErrNotFound
+	}
+
+	return session, nil
+}
+
+// decoyKeyKey is the key of the key of the decoy credential IDs in Store.
+const decoyKeyKey = "webauthn/decoy"
+
+// decoyCredentials returns the credential descriptor offered for user if
+// the user has no credentials. It is the same for each request, so the
+// options do not reveal whether a user exists. Its ID is derived from user
+// with a random key, which is created once and kept in Store.
+func (h *WebAuthn) decoyCredentials(r *http.Request, user string) ([]WebAuthnCredentialDescriptor, error) {
+	key, err := h.Store.Get(r.Context(), decoyKeyKey)
+	if errors.Is(err, store.ErrNotFound) {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		err = h.Store.Create(r.Context(), decoyKeyKey, key, 0)
+		if errors.Is(err, store.ErrExists) {
+			key, err = h.Store.Get(r.Context(), decoyKeyKey)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(user))
+	return []WebAuthnCredentialDescriptor{{Type: "public-key", ID: mac.Sum(nil)}}, nil
+}
+
+// redeemClientData redeems the challenge in clientDataJSON. It answers the
+// request and returns false if the challenge is invalid.
+func (h *WebAuthn) redeemClientData(w http.ResponseWriter, r *http.Request, ceremony string, clientDataJSON []byte) ([]byte, webauthnSession, bool) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		problem.Write(w, invalidField("response.clientDataJSON", err.Error()))
+		return nil, webauthnSession{}, false
+	}
+	challenge, err := clientData.ChallengeBytes()
+	if err != nil {
+		problem.Write(w, invalidField("response.clientDataJSON", err.Error()))
+		return nil, webauthnSession{}, false
+	}
+
+	session, err := h.redeem(r, ceremony, challenge)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Error(w, http.StatusBadRequest, problem.ChallengeExpired, "webauthn challenge has expired or was already used")
+		return nil, session, false
+	}
+	if err != nil {
+		logger(r).Error("error reading webauthn session", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return nil, session, false
+	}
+
+	return challenge, session, true
+}
+
+// RegisterBegin is a HTTP handler that takes a POST request with
+// WebAuthnBeginRequest in the body and returns the WebAuthnCreationOptions
+// for registering a new credential for the user. If the user has
+// credentials, requests without an assertion of one of them are rejected
+// with 401 Unauthorized.
+func (h *WebAuthn) RegisterBegin(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnBeginRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	if req.User == "" {
+		problem.Write(w, missingField("user"))
+		return
+	}
+
+	creds, err := h.Credentials.List(r.Context(), req.User)
+	if err != nil {
+		logger(r).Error("error reading webauthn credentials", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	if len(creds) > 0 {
+		if req.Assertion == nil {
+			problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, "assertion of a credential of the user is required")
+			return
+		}
+		user, ok := h.verifyAssertion(w, r, *req.Assertion)
+		if !ok {
+			return
+		}
+		if user != req.User {
+			problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, "assertion is of another user")
+			return
+		}
+		authenticated(r, user)
+	}
+
+	challenge, err := h.issue(r, webauthnSession{User: req.User, Ceremony: webauthn.ClientDataCreate, Enroll: len(creds) == 0})
+	if err != nil {
+		logger(r).Error("error issuing webauthn challenge", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	rp := h.RelyingParty
+	options := WebAuthnCreationOptions{
+		Challenge: challenge,
+		RP:        WebAuthnRelyingParty{ID: rp.ID, Name: rp.Name},
+		User: WebAuthnUser{
+			ID:          webauthn.UserHandle(req.User),
+			Name:        req.User,
+			DisplayName: req.User,
+		},
+		PubKeyCredParams: []WebAuthnCredentialParameters{
+			{Type: "public-key", Alg: webauthn.AlgES256},
+			{Type: "public-key", Alg: webauthn.AlgEdDSA},
+		},
+		Timeout:            h.ttl().Milliseconds(),
+		Attestation:        "direct",
+		ExcludeCredentials: credentialDescriptors(creds),
+		AuthenticatorSelection: WebAuthnAuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: h.userVerification(rp),
+		},
+	}
+
+	writeJSON(w, options)
+}
+
+// RegisterFinish is a HTTP handler that takes a POST request with
+// WebAuthnRegistration in the body and verifies the attestation of the new
+// credential. If it is valid, the credential is registered for the user the
+// challenge was issued to. If the challenge was issued without logging in,
+// because the user had no credentials, and the user has registered one
+// since, the request is rejected with 401 Unauthorized.
+func (h *WebAuthn) RegisterFinish(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnRegistration
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	challenge, session, ok := h.redeemClientData(w, r, webauthn.ClientDataCreate, req.Response.ClientDataJSON)
+	if !ok {
+		return
+	}
+
+	cred, err := h.RelyingParty.VerifyRegistration(challenge, req.Response.ClientDataJSON, req.Response.AttestationObject)
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+	if !bytes.Equal(cred.ID, req.ID) {
+		problem.Write(w, invalidField("id", "id does not match the attested credential"))
+		return
+	}
+
+	if session.Enroll {
+		err = h.Credentials.Enroll(r.Context(), session.User, *cred)
+	} else {
+		err = h.Credentials.Add(r.Context(), session.User, *cred)
+	}
+	if errors.Is(err, webauthn.ErrEnrolled) {
+		problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, "user has registered a credential since the challenge was issued")
+		return
+	}
+	if errors.Is(err, webauthn.ErrCredentialExists) {
+		problem.Error(w, http.StatusConflict, problem.Conflict, err.Error())
+		return
+	}
+	if err != nil {
+		logger(r).Error("error writing webauthn credential", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	record(h.Audit, r, audit.Record{
+		Event:      audit.EventCredentialRegistered,
+		Method:     "webauthn",
+		Subject:    session.User,
+		Credential: base64.RawURLEncoding.EncodeToString(cred.ID),
+	})
+	publish(h.Webhooks, r, webhook.EventCredentialRegistered, webhook.Data{
+		Method:     "webauthn",
+		Subject:    session.User,
+		Credential: base64.RawURLEncoding.EncodeToString(cred.ID),
+	})
+	authenticated(r, session.User)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// LoginBegin is a HTTP handler that takes a POST request with
+// WebAuthnBeginRequest in the body and returns the WebAuthnRequestOptions for
+// logging in. If the user is empty, any discoverable credential of the
+// relying party is accepted. Users without credentials get options with a
+// decoy credential, so the response does not reveal which users exist.
+func (h *WebAuthn) LoginBegin(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnBeginRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	var allow []WebAuthnCredentialDescriptor
+	if req.User != "" {
+		creds, err := h.Credentials.List(r.Context(), req.User)
+		if err != nil {
+			logger(r).Error("error reading webauthn credentials", "error", err)
+			problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+			return
+		}
+		allow = credentialDescriptors(creds)
+		if len(creds) == 0 {
+			allow, err = h.decoyCredentials(r, req.User)
+			if err != nil {
+				logger(r).Error("error reading webauthn decoy key", "error", err)
+				problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+				return
+			}
+		}
+	}
+
+	challenge, err := h.issue(r, webauthnSession{User: req.User, Ceremony: webauthn.ClientDataGet})
+	if err != nil {
+		logger(r).Error("error issuing webauthn challenge", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+
+	rp := h.RelyingParty
+	writeJSON(w, WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          h.ttl().Milliseconds(),
+		AllowCredentials: allow,
+		UserVerification: h.userVerification(rp),
+	})
+}
+
+// LoginFinish is a HTTP handler that takes a POST request with
+// WebAuthnAssertion in the body and verifies the assertion. If it is valid
+// it logs in the user of the credential. Assertions with a signature
+// counter that did not increase are rejected with 403 Forbidden as the
+// credential may have been cloned.
+func (h *WebAuthn) LoginFinish(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnAssertion
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	user, ok := h.verifyAssertion(w, r, req)
+	if !ok {
+		return
+	}
+
+	h.audit(r, user, req.ID, ErrorClassNone)
+	authenticated(r, user)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// verifyAssertion redeems the challenge of the assertion a, issued by
+// LoginBegin, verifies a and stores the new signature counter of its
+// credential. It returns the user of the credential, or answers the request
+// and returns false if a is invalid or the user is throttled by Abuse.
+func (h *WebAuthn) verifyAssertion(w http.ResponseWriter, r *http.Request, a WebAuthnAssertion) (string, bool) {
+	challenge, session, ok := h.redeemClientData(w, r, webauthn.ClientDataGet, a.Response.ClientDataJSON)
+	if !ok {
+		return "", false
+	}
+
+	user := session.User
+	if user == "" {
+		var err error
+		user, err = h.Credentials.User(r.Context(), a.Response.UserHandle)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, invalidField("response.userHandle", "unknown user handle"))
+			return "", false
+		}
+		if err != nil {
+			logger(r).Error("error reading webauthn user", "error", err)
+			problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+			return "", false
+		}
+	} else if len(a.Response.UserHandle) > 0 && !bytes.Equal(a.Response.UserHandle, webauthn.UserHandle(user)) {
+		problem.Write(w, invalidField("response.userHandle", "user handle does not match the user of the challenge"))
+		return "", false
+	}
+
+	key := abuseKey(user)
+	if p := h.guard().check(w, r, key); p != nil {
+		problem.Write(w, p)
+		return "", false
+	}
+
+	cred, err := h.Credentials.Get(r.Context(), user, a.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, invalidField("id", "unknown credential"))
+		return "", false
+	}
+	if err != nil {
+		logger(r).Error("error reading webauthn credential", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return "", false
+	}
+
+	signCount, err := h.RelyingParty.VerifyAssertion(cred, challenge, a.Response.ClientDataJSON, a.Response.AuthenticatorData, a.Response.Signature)
+	if errors.Is(err, webauthn.ErrCounterRegression) {
+		h.cloned(w, r, user, a.ID, err)
+		return "", false
+	}
+	if errors.Is(err, webauthn.ErrInvalidSignature) {
+		h.guard().failure(r, key, ErrorClassInvalidSignature)
+		h.audit(r, user, a.ID, ErrorClassInvalidSignature)
+		problem.Error(w, http.StatusBadRequest, problem.InvalidSignature, err.Error())
+		return "", false
+	}
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return "", false
+	}
+
+	// the counter is checked again when it is stored, as a concurrent login
+	// with the same credential may have stored a higher one since it was read
+	err = h.Credentials.UpdateSignCount(r.Context(), user, cred.ID, signCount)
+	if errors.Is(err, webauthn.ErrCounterRegression) {
+		h.cloned(w, r, user, a.ID, err)
+		return "", false
+	}
+	if err != nil {
+		logger(r).Error("error writing webauthn credential", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return "", false
+	}
+
+	h.guard().success(r, key)
+	return user, true
+}
+
+// cloned answers a login of user with the credential id whose signature
+// counter did not increase.
+func (h *WebAuthn) cloned(w http.ResponseWriter, r *http.Request, user string, id []byte, err error) {
+	logger(r).Warn("webauthn signature counter regression", "user", user)
+	h.audit(r, user, id, ErrorClassCloned)
+	problem.Error(w, http.StatusForbidden, problem.CredentialCloned, err.Error())
+}
+
+// audit records a login of user with the credential id and the error class
+// of its outcome, and publishes it if it succeeded.
+func (h *WebAuthn) audit(r *http.Request, user string, id []byte, class string) {
+	rec := audit.Record{
+		Event:      audit.EventLoginFailure,
+		Method:     "webauthn",
+		Subject:    user,
+		Credential: base64.RawURLEncoding.EncodeToString(id),
+		Error:      class,
+	}
+	if class == ErrorClassNone {
+		rec.Event, rec.Error = audit.EventLoginSuccess, ""
+	}
+	record(h.Audit, r, rec)
+	if class == ErrorClassNone {
+		publish(h.Webhooks, r, webhook.EventLoginSuccess, webhook.Data{
+			Method:     rec.Method,
+			Subject:    rec.Subject,
+			Credential: rec.Credential,
+		})
+	}
+}
+
+// decodeWebAuthnRequest decodes the JSON body of a WebAuthn request into v.
+// It returns false if the request was already answered.
+func decodeWebAuthnRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		problem.Error(w, http.StatusBadRequest, problem.MalformedRequest, "missing request body")
+		return false
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebAuthnBodySize)).Decode(v)
+	if err != nil {
+		problem.Error(w, http.StatusBadRequest, problem.MalformedRequest, fmt.Sprintf("failed to decode request: %v", err))
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return true
+}
+
+func credentialDescriptors(creds []webauthn.Credential) []WebAuthnCredentialDescriptor {
+	descriptors := make([]WebAuthnCredentialDescriptor, 0, len(creds))
+	for _, cred := range creds {
+		descriptors = append(descriptors, WebAuthnCredentialDescriptor{Type: "public-key", ID: cred.ID})
+	}
+	return descriptors
+}
@@ -0,0 +1,352 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+const webauthnOrigin = "http://phobia.cloud"
+
+func newWebAuthn() *handler.WebAuthn {
+	s := store.NewMemory()
+	return &handler.WebAuthn{
+		RelyingParty: &webauthn.RelyingParty{ID: "phobia.cloud", Name: "phobia.cloud", Origins: []string{webauthnOrigin}},
+		Credentials:  &webauthn.Credentials{Store: s},
+		Store:        s,
+	}
+}
+
+func webauthnRequest(t *testing.T, h http.HandlerFunc, method string, body interface{}) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "http://phobia.cloud/webauthn", bytes.NewReader(mustJSON(t, body)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func registerBegin(t *testing.T, h *handler.WebAuthn, user string) handler.WebAuthnCreationOptions {
+	return registerBeginRequest(t, h, handler.WebAuthnBeginRequest{User: user})
+}
+
+// registerBeginAs starts the registration of another credential for user,
+// logging in with the credential of auth.
+func registerBeginAs(t *testing.T, h *handler.WebAuthn, auth *webauthntest.Authenticator, user string) handler.WebAuthnCreationOptions {
+	a := assertion(auth, loginBegin(t, h, user).Challenge, nil)
+	return registerBeginRequest(t, h, handler.WebAuthnBeginRequest{User: user, Assertion: &a})
+}
+
+func registerBeginRequest(t *testing.T, h *handler.WebAuthn, req handler.WebAuthnBeginRequest) handler.WebAuthnCreationOptions {
+	rr := webauthnRequest(t, h.RegisterBegin, http.MethodPost, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var options handler.WebAuthnCreationOptions
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&options))
+	return options
+}
+
+func registration(auth *webauthntest.Authenticator, challenge []byte, attestation string) handler.WebAuthnRegistration {
+	var reg handler.WebAuthnRegistration
+	reg.ID = auth.CredentialID
+	reg.Type = "public-key"
+	reg.Response.ClientDataJSON, reg.Response.AttestationObject = auth.Register("phobia.cloud", webauthnOrigin, challenge, attestation)
+	return reg
+}
+
+func loginBegin(t *testing.T, h *handler.WebAuthn, user string) handler.WebAuthnRequestOptions {
+	rr := webauthnRequest(t, h.LoginBegin, http.MethodPost, handler.WebAuthnBeginRequest{User: user})
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var options handler.WebAuthnRequestOptions
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&options))
+	return options
+}
+
+func assertion(auth *webauthntest.Authenticator, challenge, userHandle []byte) handler.WebAuthnAssertion {
+	var a handler.WebAuthnAssertion
+	a.ID = auth.CredentialID
+	a.Type = "public-key"
+	a.Response.ClientDataJSON, a.Response.AuthenticatorData, a.Response.Signature = auth.Assert("phobia.cloud", webauthnOrigin, challenge)
+	a.Response.UserHandle = userHandle
+	return a
+}
+
+func TestWebAuthn(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+
+	options := registerBegin(t, h, "alice")
+	assert.Len(t, options.Challenge, 32)
+	assert.Equal(t, handler.WebAuthnRelyingParty{ID: "phobia.cloud", Name: "phobia.cloud"}, options.RP)
+	assert.Equal(t, handler.Base64URL(webauthn.UserHandle("alice")), options.User.ID)
+	assert.Equal(t, "alice", options.User.Name)
+	assert.Empty(t, options.ExcludeCredentials)
+
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationSelf))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	options = registerBeginAs(t, h, auth, "alice")
+	require.Len(t, options.ExcludeCredentials, 1)
+	assert.Equal(t, handler.Base64URL(auth.CredentialID), options.ExcludeCredentials[0].ID)
+
+	// the same credential cannot be registered twice
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	assertProblem(t, rr, http.StatusConflict, problem.Conflict)
+
+	requestOptions := loginBegin(t, h, "alice")
+	assert.Equal(t, "phobia.cloud", requestOptions.RPID)
+	require.Len(t, requestOptions.AllowCredentials, 1)
+	assert.Equal(t, handler.Base64URL(auth.CredentialID), requestOptions.AllowCredentials[0].ID)
+
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, nil))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Empty(t, rr.Body)
+
+	// discoverable credential without user name
+	requestOptions = loginBegin(t, h, "")
+	assert.Empty(t, requestOptions.AllowCredentials)
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, webauthn.UserHandle("alice")))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	cred, err := h.Credentials.Get(context.Background(), "alice", auth.CredentialID)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), cred.SignCount)
+}
+
+func TestWebAuthn_RegisterAuthorization(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	second := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{4}, 32))
+	mallory := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{5}, 32))
+
+	// the first credential of a user can be registered anonymously
+	enrollment := registerBegin(t, h, "alice")
+	concurrent := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, enrollment.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// but only one of concurrent enrollments succeeds
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(mallory, concurrent.Challenge, webauthn.AttestationNone))
+	assertProblem(t, rr, http.StatusUnauthorized, problem.Unauthorized)
+
+	// anonymous requests for an existing user are rejected
+	rr = webauthnRequest(t, h.RegisterBegin, http.MethodPost, handler.WebAuthnBeginRequest{User: "alice"})
+	assertProblem(t, rr, http.StatusUnauthorized, problem.Unauthorized)
+
+	// as are logins of another user
+	options := registerBegin(t, h, "mallory")
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(mallory, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+	a := assertion(mallory, loginBegin(t, h, "mallory").Challenge, nil)
+	rr = webauthnRequest(t, h.RegisterBegin, http.MethodPost, handler.WebAuthnBeginRequest{User: "alice", Assertion: &a})
+	assertProblem(t, rr, http.StatusUnauthorized, problem.Unauthorized)
+
+	// and invalid assertions
+	a = assertion(auth, loginBegin(t, h, "alice").Challenge, nil)
+	a.Response.Signature = mallory.Sign(a.Response.AuthenticatorData)
+	rr = webauthnRequest(t, h.RegisterBegin, http.MethodPost, handler.WebAuthnBeginRequest{User: "alice", Assertion: &a})
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidSignature)
+
+	// a logged in user can register another credential
+	options = registerBeginAs(t, h, auth, "alice")
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(second, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	creds, err := h.Credentials.List(context.Background(), "alice")
+	require.NoError(t, err)
+	require.Len(t, creds, 2)
+	assert.Equal(t, auth.CredentialID, creds[0].ID)
+	assert.Equal(t, second.CredentialID, creds[1].ID)
+}
+
+func TestWebAuthn_ChallengeReuse(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgEdDSA, bytes.Repeat([]byte{3}, 32))
+
+	options := registerBegin(t, h, "alice")
+	reg := registration(auth, options.Challenge, webauthn.AttestationNone)
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, reg)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, reg)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// a registration challenge cannot be used for login
+	options = registerBeginAs(t, h, auth, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, options.Challenge, nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	requestOptions := loginBegin(t, h, "alice")
+	a := assertion(auth, requestOptions.Challenge, nil)
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, a)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, a)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestWebAuthn_CounterRegression(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	clone := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+
+	options := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	for i := 0; i < 2; i++ {
+		requestOptions := loginBegin(t, h, "alice")
+		rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, nil))
+		require.Equal(t, http.StatusCreated, rr.Code)
+	}
+
+	requestOptions := loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(clone, requestOptions.Challenge, nil))
+	assertProblem(t, rr, http.StatusForbidden, problem.CredentialCloned)
+}
+
+func TestWebAuthn_UnknownUser(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+
+	options := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// users without credentials get the same options with a decoy credential
+	alice := loginBegin(t, h, "alice")
+	bob := loginBegin(t, h, "bob")
+	require.Len(t, bob.AllowCredentials, 1)
+	assert.Len(t, bob.AllowCredentials[0].ID, len(alice.AllowCredentials[0].ID))
+	assert.NotEqual(t, alice.AllowCredentials[0].ID, bob.AllowCredentials[0].ID)
+	assert.Equal(t, bob.AllowCredentials, loginBegin(t, h, "bob").AllowCredentials)
+	assert.NotEqual(t, bob.AllowCredentials, loginBegin(t, h, "carol").AllowCredentials)
+
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, bob.Challenge, nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestWebAuthn_BadRequest(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	other := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{4}, 32))
+
+	rr := webauthnRequest(t, h.RegisterBegin, http.MethodPost, handler.WebAuthnBeginRequest{})
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidRequest, "user")
+
+	rr = webauthnRequest(t, h.RegisterBegin, http.MethodPost, "alice")
+	assertProblem(t, rr, http.StatusBadRequest, problem.MalformedRequest)
+
+	// credential id does not match the attested credential
+	options := registerBegin(t, h, "alice")
+	reg := registration(auth, options.Challenge, webauthn.AttestationNone)
+	reg.ID = other.CredentialID
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, reg)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	options = registerBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// unknown credential
+	requestOptions := loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(other, requestOptions.Challenge, nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// user handle of another user
+	requestOptions = loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, webauthn.UserHandle("bob")))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// unknown user handle
+	requestOptions = loginBegin(t, h, "")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, webauthn.UserHandle("bob")))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// invalid signature
+	requestOptions = loginBegin(t, h, "alice")
+	a := assertion(auth, requestOptions.Challenge, nil)
+	a.Response.Signature = other.Sign(a.Response.AuthenticatorData)
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, a)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// challenge that was never issued
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, bytes.Repeat([]byte{1}, 32), nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestWebAuthn_SpoofedHost(t *testing.T) {
+	h := newWebAuthn()
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+
+	// a credential created on another site is relayed with its host
+	options := registerBegin(t, h, "alice")
+	var reg handler.WebAuthnRegistration
+	reg.ID = auth.CredentialID
+	reg.Type = "public-key"
+	reg.Response.ClientDataJSON, reg.Response.AttestationObject = auth.Register("evil.example", "http://evil.example", options.Challenge, webauthn.AttestationNone)
+	req, err := http.NewRequest(http.MethodPost, "http://evil.example/webauthn", bytes.NewReader(mustJSON(t, reg)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	h.RegisterFinish(rr, req)
+	assertProblem(t, rr, http.StatusBadRequest, problem.InvalidRequest)
+}
+
+func TestWebAuthn_NoRelyingParty(t *testing.T) {
+	h := newWebAuthn()
+	h.RelyingParty = nil
+
+	rr := webauthnRequest(t, h.RegisterBegin, http.MethodPost, handler.WebAuthnBeginRequest{User: "alice"})
+	assertProblem(t, rr, http.StatusInternalServerError, problem.Internal)
+}
+
+func TestWebAuthn_Audit(t *testing.T) {
+	l, records := openAudit(t)
+	h := newWebAuthn()
+	h.Audit = l
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	clone := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+
+	options := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	requestOptions := loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, nil))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	requestOptions = loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(clone, requestOptions.Challenge, nil))
+	assertProblem(t, rr, http.StatusForbidden, problem.CredentialCloned)
+
+	id := base64.RawURLEncoding.EncodeToString(auth.CredentialID)
+	recs := records()
+	require.Len(t, recs, 3)
+	assert.Equal(t, []string{audit.EventCredentialRegistered, audit.EventLoginSuccess, audit.EventLoginFailure},
+		[]string{recs[0].Event, recs[1].Event, recs[2].Event})
+	for _, rec := range recs {
+		assert.Equal(t, "webauthn", rec.Method)
+		assert.Equal(t, "alice", rec.Subject)
+		assert.Equal(t, id, rec.Credential)
+	}
+	assert.Equal(t, handler.ErrorClassCloned, recs[2].Error)
+}
@@ -0,0 +1,25 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler
+
+import (
+	"net/http"
+
+	"phobia.cloud/api/remote"
+	"phobia.cloud/api/webhook"
+)
+
+// publish publishes an event of type typ with data and the client of the
+// request r to webhooks. Errors are logged, so failing webhooks do not fail
+// the request.
+func publish(webhooks *webhook.Dispatcher, r *http.Request, typ string, data webhook.Data) {
+	if webhooks == nil {
+		return
+	}
+	data.ClientIP = remote.IP(r)
+	err := webhooks.Publish(r.Context(), typ, data)
+	if err != nil {
+		logger(r).Error("error publishing webhook", "event", typ, "error", err)
+	}
+}
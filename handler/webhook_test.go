@@ -0,0 +1,184 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package handler_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+	"phobia.cloud/api/webhook"
+)
+
+var webhookSecret = []byte("0123456789abcdef")
+
+// newWebhooks returns a dispatcher that delivers all events to an
+// in-process receiver and a function that waits for n verified events.
+func newWebhooks(t *testing.T) (*webhook.Dispatcher, func(n int) []webhook.Event) {
+	var mu sync.Mutex
+	var events []webhook.Event
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if err := webhook.Verify(webhookSecret, r.Header, body, time.Now(), 0); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event webhook.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(rcv.Close)
+
+	d := webhook.NewDispatcher(store.NewMemory(), []webhook.Endpoint{{URL: rcv.URL, Secret: webhookSecret}}, webhook.Policy{})
+	t.Cleanup(func() { _ = d.Close() })
+
+	return d, func(n int) []webhook.Event {
+		received := func() []webhook.Event {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]webhook.Event(nil), events...)
+		}
+		require.Eventually(t, func() bool { return len(received()) >= n }, 5*time.Second, 10*time.Millisecond)
+		return received()
+	}
+}
+
+func TestWebhooks_Login(t *testing.T) {
+	d, received := newWebhooks(t)
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	challengeHidden, challengeVisual := login.ChallengeHidden(), login.ChallengeVisual()
+
+	policy := abuse.DefaultPolicy
+	policy.Delay, policy.MaxDelay = 0, 0
+	policy.KeyFailures = 1
+	h := &handler.LoginHandler{Abuse: abuse.NewDetector(), AbusePolicy: policy, Webhooks: d}
+
+	loginFrom := func(ip string, valid bool) int {
+		signature := key.Sign(challengeHidden, challengeVisual, "", login.Version2)
+		if !valid {
+			signature = key.Sign(login.ChallengeHidden(), challengeVisual, "", login.Version2)
+		}
+		req := httptest.NewRequest(http.MethodPost, "http://phobia.cloud/login", bytes.NewReader(mustJSON(t, handler.LoginRequest{
+			ChallengeHidden: challengeHidden,
+			ChallengeVisual: challengeVisual,
+			PublicKey:       key.PublicKey(),
+			Signature:       signature,
+			Version:         login.Version2,
+		})))
+		req.RemoteAddr = ip + ":52114"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	require.Equal(t, http.StatusCreated, loginFrom("203.0.113.7", true))
+	require.Equal(t, http.StatusBadRequest, loginFrom("198.51.100.1", false))
+
+	events := received(2)
+	require.Len(t, events, 2)
+	assert.Equal(t, webhook.EventLoginSuccess, events[0].Type)
+	assert.Equal(t, webhook.Data{Method: "trezor", Subject: key.PublicKey(), ClientIP: "203.0.113.7"}, events[0].Data)
+
+	assert.Equal(t, webhook.EventLockedOut, events[1].Type)
+	assert.Equal(t, abuse.ScopeKey, events[1].Data.Scope)
+	assert.Equal(t, key.PublicKey(), events[1].Data.Subject)
+	assert.Equal(t, "198.51.100.1", events[1].Data.ClientIP)
+	require.NotNil(t, events[1].Data.Until)
+	assert.True(t, events[1].Data.Until.Equal(h.Abuse.Lockouts()[0].Until))
+}
+
+func TestWebhooks_WebAuthn(t *testing.T) {
+	d, received := newWebhooks(t)
+	h := newWebAuthn()
+	h.Webhooks = d
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{3}, 32))
+	other := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{4}, 32))
+
+	options := registerBegin(t, h, "alice")
+	rr := webauthnRequest(t, h.RegisterFinish, http.MethodPost, registration(auth, options.Challenge, webauthn.AttestationNone))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	// failed logins are not published
+	requestOptions := loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(other, requestOptions.Challenge, nil))
+	require.NotEqual(t, http.StatusCreated, rr.Code)
+
+	requestOptions = loginBegin(t, h, "alice")
+	rr = webauthnRequest(t, h.LoginFinish, http.MethodPost, assertion(auth, requestOptions.Challenge, nil))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	id := base64.RawURLEncoding.EncodeToString(auth.CredentialID)
+	events := received(2)
+	require.Len(t, events, 2)
+	assert.Equal(t, webhook.EventCredentialRegistered, events[0].Type)
+	assert.Equal(t, webhook.EventLoginSuccess, events[1].Type)
+	for _, event := range events {
+		assert.Equal(t, "webauthn", event.Data.Method)
+		assert.Equal(t, "alice", event.Data.Subject)
+		assert.Equal(t, id, event.Data.Credential)
+	}
+}
+
+func TestWebhooks_SignedMessages(t *testing.T) {
+	d, received := newWebhooks(t)
+	challenges, issue := newChallenges(t)
+
+	nostr := &handler.NostrLogin{Origins: nostrOrigins, Challenges: challenges, Webhooks: d}
+	event := nostrKey.NostrAuthEvent(http.MethodPost, nostrLoginURL, time.Now(), []string{"challenge", issue()})
+	require.Equal(t, http.StatusCreated, nostrLogin(t, nostr, http.MethodPost, nostrAuthorization(t, event)).Code)
+	// failed logins are not published
+	require.Equal(t, http.StatusBadRequest, nostrLogin(t, nostr, http.MethodPost, nostrAuthorization(t, event)).Code)
+	received(1)
+
+	ethereum := &handler.EthereumLogin{Origins: ethereumOrigins, Challenges: challenges, Webhooks: d}
+	body := signedSIWE(t, &login.SIWEMessage{
+		Domain:   "phobia.cloud",
+		Address:  ethereumKey.Address(),
+		URI:      "http://phobia.cloud/login/ethereum",
+		Version:  login.SIWEVersion,
+		ChainID:  1,
+		Nonce:    issue(),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	require.Equal(t, http.StatusCreated, ethereumLogin(t, ethereum, http.MethodPost, body).Code)
+	require.Equal(t, http.StatusBadRequest, ethereumLogin(t, ethereum, http.MethodPost, body).Code)
+	received(2)
+
+	lnurl := &handler.LNURLAuth{Store: store.NewMemory(), Webhooks: d}
+	wallet := newLNURLWallet(t)
+	callbackURL := wallet.callbackURL(t, issueLNURL(t, lnurl).LNURL)
+	require.Equal(t, http.StatusOK, serve(t, lnurl.Callback, http.MethodGet, callbackURL).Code)
+	require.Equal(t, http.StatusBadRequest, serve(t, lnurl.Callback, http.MethodGet, callbackURL).Code)
+
+	events := received(3)
+	require.Len(t, events, 3)
+	for i, data := range []webhook.Data{
+		{Method: "nostr", Subject: nostrKey.PublicKey()},
+		{Method: "ethereum", Subject: ethereumKey.Address()},
+		{Method: "lnurl", Subject: wallet.key()},
+	} {
+		assert.Equal(t, webhook.EventLoginSuccess, events[i].Type, i)
+		assert.Equal(t, data, events[i].Data, i)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Request is the state of a request that is logged by the access log.
+type Request struct {
+	// ID identifies the request in the entries of all components.
+	ID string
+
+	mu     sync.Mutex
+	fields []interface{}
+}
+
+// Set sets the field key of the request to value, e.g. the route that
+// matched the request.
+func (r *Request) Set(key string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := 0; i < len(r.fields); i += 2 {
+		if r.fields[i] == key {
+			r.fields[i+1] = value
+			return
+		}
+	}
+	r.fields = append(r.fields, key, value)
+}
+
+// Get returns the value of the field key of the request, or nil if it is
+// not set.
+func (r *Request) Get(key string) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := 0; i < len(r.fields); i += 2 {
+		if r.fields[i] == key {
+			return r.fields[i+1]
+		}
+	}
+	return nil
+}
+
+// Fields returns the fields of the request as key-value pairs.
+func (r *Request) Fields() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}(nil), r.fields...)
+}
+
+type requestKey struct{}
+
+// NewContext returns a copy of ctx that carries req.
+func NewContext(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// FromContext returns the request carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestKey{}).(*Request)
+	return req
+}
+
+// Set sets the field key of the request carried by ctx to value. It does
+// nothing if ctx carries no request.
+func Set(ctx context.Context, key string, value interface{}) {
+	if req := FromContext(ctx); req != nil {
+		req.Set(key, value)
+	}
+}
+
+// Context returns a logger that adds the ID of the request carried by ctx
+// to its entries.
+func (l *Logger) Context(ctx context.Context) *Logger {
+	req := FromContext(ctx)
+	if req == nil {
+		return l
+	}
+	return l.With("request_id", req.ID)
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Fingerprint returns a short identifier of a public key or address that
+// can be logged to correlate the requests of a user.
+func Fingerprint(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:8])
+}
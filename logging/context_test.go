@@ -0,0 +1,40 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext(t *testing.T) {
+	buf := capture(t, Levels{Default: LevelInfo})
+
+	ctx := context.Background()
+	Set(ctx, "route", "/v1/login")
+	Component("handler").Context(ctx).Info("no request")
+
+	req := &Request{ID: "9f86d081884c7d65"}
+	ctx = NewContext(ctx, req)
+	Set(ctx, "route", "/v1/challenge")
+	Set(ctx, "route", "/v1/login")
+	Set(ctx, "key", Fingerprint("023a4722"))
+	Component("handler").Context(ctx).Info("with request")
+
+	assert.Equal(t, []interface{}{"route", "/v1/login", "key", Fingerprint("023a4722")}, req.Fields())
+	assert.Equal(t, "/v1/login", req.Get("route"))
+	assert.Nil(t, req.Get("status"))
+	assert.Equal(t, `{"time":"2021-06-01T12:00:00Z","level":"info","component":"handler","msg":"no request"}`+"\n"+
+		`{"time":"2021-06-01T12:00:00Z","level":"info","component":"handler","msg":"with request","request_id":"9f86d081884c7d65"}`+"\n",
+		buf.String())
+}
+
+func TestNewRequestID(t *testing.T) {
+	id := NewRequestID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewRequestID())
+	assert.Len(t, Fingerprint("023a4722"), 16)
+}
@@ -0,0 +1,21 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package logging provides structured logging in JSON lines.
+//
+// Each entry is a JSON object with the time, level, component and message
+// followed by the fields of the entry:
+//
+//	{"time":"2021-06-01T12:00:00Z","level":"info","component":"access","msg":"request","request_id":"9f86d081884c7d65","method":"POST","route":"/v1/login","status":201}
+//
+// Loggers of components are created with Component and share the output and
+// levels set with SetOutput and SetLevels, so the levels can be changed while
+// the server is running.
+//
+// Fields whose key names a secret, such as a signature, a challenge or a
+// token, are redacted automatically.
+//
+// The request ID and fields of a request are kept in its context by the
+// access log of the server. Loggers passed the context with Context add the
+// request ID to their entries.
+package logging
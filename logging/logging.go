@@ -0,0 +1,226 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// The levels in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with name, e.g. "info".
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+// Levels are the minimum levels of the entries that are logged.
+type Levels struct {
+	// Default is the level of components not in Components.
+	Default Level
+	// Components are the levels by component.
+	Components map[string]Level
+}
+
+func (l Levels) of(component string) Level {
+	if level, ok := l.Components[component]; ok {
+		return level
+	}
+	return l.Default
+}
+
+// Redacted replaces the values of fields that name secrets.
+const Redacted = "REDACTED"
+
+// secretKeys are the parts of field keys that name secrets.
+var secretKeys = []string{"signature", "sig", "challenge", "k1", "token", "secret", "password", "authorization", "cookie"}
+
+// secret reports whether the field key names a secret.
+func secret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// output is the destination and levels shared by the loggers.
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	levels Levels
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+var std = &output{w: os.Stderr, levels: Levels{Default: LevelInfo}, now: time.Now}
+
+// SetOutput sets the destination of the entries of all loggers.
+func SetOutput(w io.Writer) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.w = w
+}
+
+// SetLevels sets the levels of all loggers.
+func SetLevels(levels Levels) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.levels = levels
+}
+
+// Logger writes entries of a component with fields.
+type Logger struct {
+	out       *output
+	component string
+	fields    []interface{}
+}
+
+// Component returns the logger of the component name, e.g. "server".
+func Component(name string) *Logger {
+	return &Logger{out: std, component: name}
+}
+
+// With returns a logger that adds the fields given as key-value pairs to
+// its entries.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(append(fields, l.fields...), kv...)
+	return &Logger{out: l.out, component: l.component, fields: fields}
+}
+
+// Enabled reports whether entries with level are logged.
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.levels.of(l.component)
+}
+
+// Debug logs msg with the fields given as key-value pairs at LevelDebug.
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+
+// Info logs msg with the fields given as key-value pairs at LevelInfo.
+func (l *Logger) Info(msg string, kv ...interface{}) { l.log(LevelInfo, msg, kv) }
+
+// Warn logs msg with the fields given as key-value pairs at LevelWarn.
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.log(LevelWarn, msg, kv) }
+
+// Error logs msg with the fields given as key-value pairs at LevelError.
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+// Log logs msg with the fields given as key-value pairs at level.
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) { l.log(level, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.levels.of(l.component) {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, l.out.now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"component":`)
+	writeValue(&buf, l.component)
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+	writeFields(&buf, l.fields)
+	writeFields(&buf, kv)
+	buf.WriteString("}\n")
+
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+// writeFields writes the key-value pairs kv as JSON members. A key without
+// value is written with a null value.
+func writeFields(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{}
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		if secret(key) && value != nil && value != "" {
+			value = Redacted
+		}
+
+		buf.WriteByte(',')
+		writeValue(buf, key)
+		buf.WriteByte(':')
+		writeValue(buf, value)
+	}
+}
+
+// writeValue writes v as JSON. Errors and values that implement
+// fmt.Stringer are written as strings.
+func writeValue(buf *bytes.Buffer, v interface{}) {
+	switch value := v.(type) {
+	case error:
+		v = value.Error()
+	case fmt.Stringer:
+		v = value.String()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// Writer returns a writer that logs each line written to it as a message
+// of l, e.g. for the output of the log package of the standard library.
+// Lines starting with "error" are logged at LevelError, others at LevelInfo.
+func (l *Logger) Writer() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			level := LevelInfo
+			if strings.HasPrefix(line, "error") {
+				level = LevelError
+			}
+			l.log(level, line, nil)
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
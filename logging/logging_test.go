@@ -0,0 +1,107 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capture makes the loggers write to the returned buffer at a fixed time
+// until the test ends.
+func capture(t *testing.T, levels Levels) *bytes.Buffer {
+	var buf bytes.Buffer
+	std.mu.Lock()
+	w, l, now := std.w, std.levels, std.now
+	std.w, std.levels = &buf, levels
+	std.now = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC) }
+	std.mu.Unlock()
+
+	t.Cleanup(func() {
+		std.mu.Lock()
+		defer std.mu.Unlock()
+		std.w, std.levels, std.now = w, l, now
+	})
+	return &buf
+}
+
+func TestLogger(t *testing.T) {
+	buf := capture(t, Levels{Default: LevelInfo})
+
+	logger := Component("server").With("addr", ":5050")
+	logger.Info("listening", "tls", true, "timeout", 5*time.Second)
+	logger.Error("failed", "error", errors.New("boom"), "odd")
+
+	assert.Equal(t, `{"time":"2021-06-01T12:00:00Z","level":"info","component":"server","msg":"listening","addr":":5050","tls":true,"timeout":"5s"}`+"\n"+
+		`{"time":"2021-06-01T12:00:00Z","level":"error","component":"server","msg":"failed","addr":":5050","error":"boom","odd":null}`+"\n",
+		buf.String())
+}
+
+func TestLogger_Levels(t *testing.T) {
+	buf := capture(t, Levels{Default: LevelWarn, Components: map[string]Level{"tls": LevelDebug}})
+
+	Component("server").Info("hidden")
+	Component("server").Warn("shown")
+	Component("tls").Debug("shown")
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("\n")))
+	assert.NotContains(t, buf.String(), "hidden")
+
+	assert.False(t, Component("server").Enabled(LevelInfo))
+	SetLevels(Levels{Default: LevelDebug})
+	assert.True(t, Component("server").Enabled(LevelInfo))
+}
+
+func TestLogger_Redaction(t *testing.T) {
+	buf := capture(t, Levels{Default: LevelInfo})
+
+	Component("handler").Info("login",
+		"signature", "20f2d1a4",
+		"challengeHidden", "cd855256",
+		"k1", "e2af6254",
+		"Authorization", "Nostr eyJ",
+		"api_token", "t0k3n",
+		"empty_secret", "",
+		"key", "023a4722",
+	)
+
+	assert.Equal(t, `{"time":"2021-06-01T12:00:00Z","level":"info","component":"handler","msg":"login",`+
+		`"signature":"REDACTED","challengeHidden":"REDACTED","k1":"REDACTED","Authorization":"REDACTED",`+
+		`"api_token":"REDACTED","empty_secret":"","key":"023a4722"}`+"\n", buf.String())
+}
+
+func TestLogger_Writer(t *testing.T) {
+	buf := capture(t, Levels{Default: LevelInfo})
+
+	std := log.New(Component("app").Writer(), "", 0)
+	std.Printf("error writing response to client: %v", errors.New("broken pipe"))
+	std.Printf("started")
+
+	assert.Equal(t, `{"time":"2021-06-01T12:00:00Z","level":"error","component":"app","msg":"error writing response to client: broken pipe"}`+"\n"+
+		`{"time":"2021-06-01T12:00:00Z","level":"info","component":"app","msg":"started"}`+"\n", buf.String())
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		level Level
+	}{
+		{"debug", LevelDebug},
+		{"INFO", LevelInfo},
+		{"warn", LevelWarn},
+		{"error", LevelError},
+	} {
+		level, err := ParseLevel(tt.name)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.level, level, tt.name)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.EqualError(t, err, `unknown log level: "verbose"`)
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBech32_Valid(t *testing.T) {
+	// test vectors from BIP-173
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	} {
+		hrp, values, err := bech32DecodeValues(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, strings.ToLower(s), bech32EncodeValues(hrp, values), s)
+	}
+}
+
+func TestBech32_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		s             string
+		expectedError string
+	}{
+		{"pzry9x0s0muk", "invalid separator position"},
+		{"1pzry9x0s0muk", "invalid separator position"},
+		{"x1b4n0q5v", `invalid character in data part: 'b'`},
+		{"li1dgmt3", "invalid separator position"},
+		{"A1G7SGD8", "invalid checksum"},
+		{"10a06t8", "invalid separator position"},
+		{"1qzzfhee", "invalid separator position"},
+		{"A12uEL5L", "mixed case"},
+		{"\x201nwldj5", `invalid character in human-readable part: ' '`},
+	} {
+		_, _, err := bech32Decode(tt.s)
+		assert.EqualError(t, err, tt.expectedError, tt.s)
+	}
+}
+
+func TestBech32_RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0},
+		{0xff, 0x00, 0x7f},
+		[]byte("https://phobia.cloud/lnurl?tag=login&k1=cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2&action=login"),
+	} {
+		s, err := bech32Encode("lnurl", data)
+		require.NoError(t, err)
+
+		hrp, decoded, err := bech32Decode(s)
+		require.NoError(t, err)
+		assert.Equal(t, "lnurl", hrp)
+		assert.Equal(t, string(data), string(decoded))
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// ChecksumAddress returns the EIP-55 mixed-case checksum encoding of a
+// hex-encoded Ethereum address, with or without the 0x prefix.
+func ChecksumAddress(address string) (string, error) {
+	hexAddress := strings.ToLower(strings.TrimPrefix(address, "0x"))
+
+	addressBytes, err := hex.DecodeString(hexAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode address: %v", err)
+	}
+	if len(addressBytes) != 20 {
+		return "", fmt.Errorf("invalid address length: %d", len(addressBytes))
+	}
+
+	hash := hex.EncodeToString(keccak256([]byte(hexAddress)))
+
+	result := []byte(hexAddress)
+	for i, c := range result {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(result), nil
+}
+
+// ValidateAddress checks that address is a 0x-prefixed hex-encoded Ethereum
+// address. Mixed-case addresses must have a valid EIP-55 checksum.
+func ValidateAddress(address string) error {
+	if !strings.HasPrefix(address, "0x") {
+		return errors.New("address has no 0x prefix")
+	}
+
+	checksummed, err := ChecksumAddress(address)
+	if err != nil {
+		return err
+	}
+
+	hexAddress := address[2:]
+	if hexAddress != strings.ToLower(hexAddress) && hexAddress != strings.ToUpper(hexAddress) && address != checksummed {
+		return errors.New("invalid address checksum")
+	}
+	return nil
+}
+
+// VerifyPersonalSign verifies if signature is a valid Ethereum personal_sign
+// (EIP-191 version 0x45) signature of message by the account with the
+// provided address.
+//
+// signature is the 65-byte signature r || s || v, hex-encoded with or
+// without the 0x prefix, where v is 27 or 28, or the recovery id 0 or 1.
+func VerifyPersonalSign(message []byte, address, signature string) error {
+	err := ValidateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	signatureBytes, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+	if len(signatureBytes) != 65 {
+		return ErrInvalidSignature
+	}
+
+	recoveryID := signatureBytes[64]
+	if recoveryID >= 27 {
+		recoveryID -= 27
+	}
+	if recoveryID > 1 {
+		return ErrInvalidSignature
+	}
+
+	// btcec expects the recovery flag first
+	compact := append([]byte{27 + recoveryID}, signatureBytes[:64]...)
+
+	recoveredKey, _, err := btcec.RecoverCompact(btcec.S256(), compact, PersonalSignHash(message))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !strings.EqualFold(ethereumAddress(recoveredKey), address) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// PersonalSignHash returns the Keccak-256 hash of message with the EIP-191
+// prefix, which is what personal_sign signs.
+func PersonalSignHash(message []byte) []byte {
+	var msg []byte
+	msg = append(msg, "\x19Ethereum Signed Message:\n"...)
+	msg = append(msg, strconv.Itoa(len(message))...)
+	msg = append(msg, message...)
+	return keccak256(msg)
+}
+
+// EthereumAddress returns the EIP-55 checksummed Ethereum address of a
+// hex-encoded secp256k1 public key in compressed or uncompressed form.
+func EthereumAddress(publicKey string) (string, error) {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode public key: %v", err)
+	}
+
+	pubKey, err := btcec.ParsePubKey(publicKeyBytes, btcec.S256())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	return ethereumAddress(pubKey), nil
+}
+
+// ethereumAddress returns the checksummed Ethereum address of pubKey.
+func ethereumAddress(pubKey *btcec.PublicKey) string {
+	hash := keccak256(pubKey.SerializeUncompressed()[1:])
+	address, err := ChecksumAddress(hex.EncodeToString(hash[12:]))
+	if err != nil {
+		// the hash is always long enough
+		panic(err)
+	}
+	return address
+}
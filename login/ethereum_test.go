@@ -0,0 +1,163 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+)
+
+var ethereumKey = logintest.NewEthereumKey([]byte("phobia.cloud ethereum test key 1"))
+
+func TestChecksumAddress(t *testing.T) {
+	// test vectors from EIP-55
+	for _, address := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		checksummed, err := login.ChecksumAddress(strings.ToLower(address))
+		require.NoError(t, err)
+		assert.Equal(t, address, checksummed)
+
+		checksummed, err = login.ChecksumAddress(strings.ToUpper(address[2:]))
+		require.NoError(t, err)
+		assert.Equal(t, address, checksummed)
+	}
+
+	_, err := login.ChecksumAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA")
+	assert.EqualError(t, err, "invalid address length: 19")
+	_, err = login.ChecksumAddress("0xX5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAe")
+	assert.EqualError(t, err, "failed to decode address: encoding/hex: invalid byte: U+0078 'x'")
+}
+
+func TestValidateAddress(t *testing.T) {
+	for _, address := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed",
+		"0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED",
+	} {
+		assert.NoError(t, login.ValidateAddress(address), address)
+	}
+
+	for _, tt := range []struct {
+		address       string
+		expectedError string
+	}{
+		{
+			address:       "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			expectedError: "address has no 0x prefix",
+		},
+		{
+			address:       "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			expectedError: "invalid address checksum",
+		},
+		{
+			address:       "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAe",
+			expectedError: "failed to decode address: encoding/hex: odd length hex string",
+		},
+	} {
+		assert.EqualError(t, login.ValidateAddress(tt.address), tt.expectedError, tt.address)
+	}
+}
+
+func TestEthereumAddress(t *testing.T) {
+	for _, publicKey := range []string{
+		// public key of secret 1, compressed and uncompressed
+		"0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		"0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+	} {
+		address, err := login.EthereumAddress(publicKey)
+		require.NoError(t, err)
+		assert.Equal(t, "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", address)
+	}
+
+	_, err := login.EthereumAddress("")
+	assert.EqualError(t, err, "failed to parse public key: pubkey string is empty")
+}
+
+func TestVerifyPersonalSign(t *testing.T) {
+	message := []byte("Sign in to phobia.cloud")
+	address := ethereumKey.Address()
+	sig := ethereumKey.PersonalSign(message)
+
+	assert.NoError(t, login.VerifyPersonalSign(message, address, sig))
+	assert.NoError(t, login.VerifyPersonalSign(message, strings.ToLower(address), sig[2:]))
+
+	// recovery id instead of v
+	v := sig[len(sig)-2:]
+	recoveryID := map[string]string{"1b": "00", "1c": "01"}[v]
+	require.NotEmpty(t, recoveryID)
+	assert.NoError(t, login.VerifyPersonalSign(message, address, sig[:len(sig)-2]+recoveryID))
+
+	other := logintest.NewEthereumKey([]byte("phobia.cloud ethereum test key 2"))
+
+	for _, tt := range []struct {
+		name          string
+		message       []byte
+		address       string
+		signature     string
+		expectedError string
+	}{
+		{
+			name:          "other message",
+			message:       []byte("Sign in to phobia.cloud!"),
+			address:       address,
+			signature:     sig,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "other address",
+			message:       message,
+			address:       other.Address(),
+			signature:     sig,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "other signer",
+			message:       message,
+			address:       address,
+			signature:     other.PersonalSign(message),
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "invalid v",
+			message:       message,
+			address:       address,
+			signature:     sig[:len(sig)-2] + "1d",
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "short signature",
+			message:       message,
+			address:       address,
+			signature:     sig[:len(sig)-2],
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "invalid signature",
+			message:       message,
+			address:       address,
+			signature:     "0xXX",
+			expectedError: "failed to decode signature: encoding/hex: invalid byte: U+0058 'X'",
+		},
+		{
+			name:          "invalid address",
+			message:       message,
+			address:       address[2:],
+			signature:     sig,
+			expectedError: "address has no 0x prefix",
+		},
+	} {
+		err := login.VerifyPersonalSign(tt.message, tt.address, tt.signature)
+		assert.EqualError(t, err, tt.expectedError, tt.name)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// keccak256Rate is the rate of Keccak-256 in bytes.
+const keccak256Rate = 136
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// keccakRotations is indexed by x+5*y.
+var keccakRotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// keccak256 computes the Keccak-256 hash of msg as used by Ethereum. It
+// differs from the standardized SHA3-256 in the padding.
+func keccak256(msg []byte) []byte {
+	return keccakSponge(msg, 0x01)
+}
+
+// keccakSponge computes a 256-bit Keccak hash with the provided domain
+// separation byte: 0x01 for Keccak-256 or 0x06 for SHA3-256.
+func keccakSponge(msg []byte, domain byte) []byte {
+	var state [25]uint64
+
+	padded := make([]byte, len(msg), len(msg)+keccak256Rate)
+	copy(padded, msg)
+	padded = append(padded, domain)
+	for len(padded)%keccak256Rate != 0 {
+		padded = append(padded, 0)
+	}
+	padded[len(padded)-1] |= 0x80
+
+	for len(padded) > 0 {
+		for i := 0; i < keccak256Rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[8*i:])
+		}
+		keccakF1600(&state)
+		padded = padded[keccak256Rate:]
+	}
+
+	result := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(result[8*i:], state[i])
+	}
+	return result
+}
+
+// keccakF1600 applies the Keccak-f[1600] permutation to the state.
+func keccakF1600(a *[25]uint64) {
+	var b [25]uint64
+	var c, d [5]uint64
+
+	for _, rc := range keccakRoundConstants {
+		// θ step
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d[x] = c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+		}
+		for i := range a {
+			a[i] ^= d[i%5]
+		}
+
+		// ρ and π steps
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+
+		// χ step
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				a[x+5*y] = b[x+5*y] ^ (^b[(x+1)%5+5*y] & b[(x+2)%5+5*y])
+			}
+		}
+
+		// ι step
+		a[0] ^= rc
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeccak256(t *testing.T) {
+	for _, tt := range []struct {
+		msg  []byte
+		hash string
+	}{
+		{
+			msg:  nil,
+			hash: "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+		},
+		{
+			msg:  []byte("abc"),
+			hash: "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45",
+		},
+	} {
+		assert.Equal(t, tt.hash, hex.EncodeToString(keccak256(tt.msg)), string(tt.msg))
+	}
+}
+
+func TestKeccakSponge_SHA3(t *testing.T) {
+	// the sponge with SHA3 padding must match SHA3-256, including messages
+	// that span several blocks or fill a block exactly
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	for _, tt := range []struct {
+		msg  []byte
+		hash string
+	}{
+		{
+			msg:  nil,
+			hash: "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a",
+		},
+		{
+			msg:  bytes.Repeat([]byte("a"), keccak256Rate),
+			hash: "3fc5559f14db8e453a0a3091edbd2bc25e11528d81c66fa570a4efdcc2695ee1",
+		},
+		{
+			msg:  long,
+			hash: "815c06bbeb8520ce61add33a5f47bc558bf00e6361a5640c972d5d4634c58101",
+		},
+	} {
+		assert.Equal(t, tt.hash, hex.EncodeToString(keccakSponge(tt.msg, 0x06)), len(tt.msg))
+	}
+}
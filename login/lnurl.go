@@ -0,0 +1,84 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// lnurlHRP is the human-readable part of bech32-encoded LNURLs.
+const lnurlHRP = "lnurl"
+
+// EncodeLNURL encodes url as a bech32 LNURL (LUD-01). The result is upper
+// case, which allows a more compact QR code.
+func EncodeLNURL(url string) (string, error) {
+	lnurl, err := bech32Encode(lnurlHRP, []byte(url))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode lnurl: %v", err)
+	}
+	return strings.ToUpper(lnurl), nil
+}
+
+// DecodeLNURL decodes a bech32 LNURL (LUD-01) to the URL it encodes.
+func DecodeLNURL(lnurl string) (string, error) {
+	hrp, data, err := bech32Decode(lnurl)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode lnurl: %v", err)
+	}
+	if hrp != lnurlHRP {
+		return "", fmt.Errorf("failed to decode lnurl: invalid human-readable part: %q", hrp)
+	}
+	return string(data), nil
+}
+
+// VerifyLNURL verifies if signature is valid for the provided LNURL-auth
+// (LUD-04) challenge and linking key.
+//
+// k1 is the challenge of the LNURL-auth request. It is a randomly generated
+// challenge by the ChallengeHidden function.
+//
+// signature is the DER-encoded signature of k1 by the wallet.
+//
+// linkingKey is the compressed public key the wallet derived for the service.
+//
+// The function expects that k1, signature, and linkingKey are hex-encoded.
+func VerifyLNURL(k1, signature, linkingKey string) error {
+	k1Bytes, err := hex.DecodeString(k1)
+	if err != nil {
+		return fmt.Errorf("failed to decode k1: %v", err)
+	}
+	if len(k1Bytes) != 32 {
+		return fmt.Errorf("invalid k1 length: %d", len(k1Bytes))
+	}
+
+	linkingKeyBytes, err := hex.DecodeString(linkingKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode linking key: %v", err)
+	}
+
+	pubKey, err := btcec.ParsePubKey(linkingKeyBytes, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("failed to parse linking key: %v", err)
+	}
+
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	sig, err := btcec.ParseDERSignature(signatureBytes, btcec.S256())
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !sig.Verify(k1Bytes, pubKey) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+)
+
+func TestEncodeLNURL(t *testing.T) {
+	// test vector from LUD-01
+	url := "https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df"
+	lnurl := "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+
+	encoded, err := login.EncodeLNURL(url)
+	require.NoError(t, err)
+	assert.Equal(t, lnurl, encoded)
+
+	decoded, err := login.DecodeLNURL(lnurl)
+	require.NoError(t, err)
+	assert.Equal(t, url, decoded)
+}
+
+func TestDecodeLNURL_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		lnurl         string
+		expectedError string
+	}{
+		{
+			lnurl:         "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNT",
+			expectedError: "failed to decode lnurl: invalid checksum",
+		},
+		{
+			lnurl:         "a12uel5l",
+			expectedError: `failed to decode lnurl: invalid human-readable part: "a"`,
+		},
+	} {
+		_, err := login.DecodeLNURL(tt.lnurl)
+		assert.EqualError(t, err, tt.expectedError, tt.lnurl)
+	}
+}
+
+func TestVerifyLNURL(t *testing.T) {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), []byte("phobia.cloud lnurl-auth test key"))
+	linkingKey := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+
+	k1Bytes, err := hex.DecodeString(challengeHidden)
+	require.NoError(t, err)
+	sig, err := privKey.Sign(k1Bytes)
+	require.NoError(t, err)
+	derSignature := hex.EncodeToString(sig.Serialize())
+
+	err = login.VerifyLNURL(challengeHidden, derSignature, linkingKey)
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		name          string
+		k1            string
+		signature     string
+		linkingKey    string
+		expectedError string
+	}{
+		{
+			name:          "invalid k1",
+			k1:            "X" + challengeHidden[1:],
+			signature:     derSignature,
+			linkingKey:    linkingKey,
+			expectedError: "failed to decode k1: encoding/hex: invalid byte: U+0058 'X'",
+		},
+		{
+			name:          "short k1",
+			k1:            challengeHidden[2:],
+			signature:     derSignature,
+			linkingKey:    linkingKey,
+			expectedError: "invalid k1 length: 31",
+		},
+		{
+			name:          "other k1",
+			k1:            "ad" + challengeHidden[2:],
+			signature:     derSignature,
+			linkingKey:    linkingKey,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "invalid linking key",
+			k1:            challengeHidden,
+			signature:     derSignature,
+			linkingKey:    "X" + linkingKey[1:],
+			expectedError: "failed to decode linking key: encoding/hex: invalid byte: U+0058 'X'",
+		},
+		{
+			name:          "unparsable linking key",
+			k1:            challengeHidden,
+			signature:     derSignature,
+			linkingKey:    "",
+			expectedError: "failed to parse linking key: pubkey string is empty",
+		},
+		{
+			name:          "other linking key",
+			k1:            challengeHidden,
+			signature:     derSignature,
+			linkingKey:    publicKey,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "invalid signature",
+			k1:            challengeHidden,
+			signature:     "X" + derSignature[1:],
+			linkingKey:    linkingKey,
+			expectedError: "failed to decode signature: encoding/hex: invalid byte: U+0058 'X'",
+		},
+		{
+			name:          "not a der signature",
+			k1:            challengeHidden,
+			signature:     "1f" + derSignature[2:],
+			linkingKey:    linkingKey,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+		{
+			name:          "compact signature",
+			k1:            challengeHidden,
+			signature:     signature,
+			linkingKey:    publicKey,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+	} {
+		err := login.VerifyLNURL(tt.k1, tt.signature, tt.linkingKey)
+		assert.EqualError(t, err, tt.expectedError, tt.name)
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package logintest provides signing utilities for testing code that
+// verifies logins with the login package.
+//
+// The signers are not hardened against side channels and must not be used
+// with real keys.
+package logintest
@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"phobia.cloud/api/login"
+)
+
+// EthereumKey is a secp256k1 key of an Ethereum account.
+type EthereumKey struct {
+	privKey *btcec.PrivateKey
+}
+
+// NewEthereumKey returns the key with the provided 32-byte secret.
+func NewEthereumKey(secret []byte) *EthereumKey {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), secret)
+	return &EthereumKey{privKey: privKey}
+}
+
+// Address returns the EIP-55 checksummed address of the account.
+func (k *EthereumKey) Address() string {
+	address, err := login.EthereumAddress(hex.EncodeToString(k.privKey.PubKey().SerializeCompressed()))
+	if err != nil {
+		panic(err)
+	}
+	return address
+}
+
+// PersonalSign returns the hex-encoded personal_sign signature of message in
+// the r || s || v form with v being 27 or 28.
+func (k *EthereumKey) PersonalSign(message []byte) string {
+	compact, err := btcec.SignCompact(btcec.S256(), k.privKey, login.PersonalSignHash(message), false)
+	if err != nil {
+		panic(err)
+	}
+	// btcec puts the recovery flag first
+	return "0x" + hex.EncodeToString(append(compact[1:], compact[0]))
+}
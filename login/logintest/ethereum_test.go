@@ -0,0 +1,26 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+)
+
+func TestEthereumKey(t *testing.T) {
+	secret := make([]byte, 32)
+	secret[31] = 1
+	key := logintest.NewEthereumKey(secret)
+
+	assert.Equal(t, "0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf", key.Address())
+
+	message := []byte("phobia.cloud")
+	sig := key.PersonalSign(message)
+	assert.Len(t, sig, 2+2*65)
+	assert.NoError(t, login.VerifyPersonalSign(message, key.Address(), sig))
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"phobia.cloud/api/login"
+)
+
+// IdentityKey is a secp256k1 key of a Trezor device dedicated for web login,
+// as used by SignIdentity.
+type IdentityKey struct {
+	privKey *btcec.PrivateKey
+}
+
+// NewIdentityKey returns the key with the provided 32-byte secret.
+func NewIdentityKey(secret []byte) *IdentityKey {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), secret)
+	return &IdentityKey{privKey: privKey}
+}
+
+// PublicKey returns the hex-encoded compressed public key.
+func (k *IdentityKey) PublicKey() string {
+	return hex.EncodeToString(k.privKey.PubKey().SerializeCompressed())
+}
+
+// Sign returns the hex-encoded signature of the challenge of the given
+// version as the Trezor device creates it. The origin is only used by
+// login.Version3.
+func (k *IdentityKey) Sign(challengeHidden, challengeVisual, origin string, version int) string {
+	hash, err := login.ChallengeHash(challengeHidden, challengeVisual, origin, version)
+	if err != nil {
+		panic(err)
+	}
+	sig, err := btcec.SignCompact(btcec.S256(), k.privKey, hash, true)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(sig)
+}
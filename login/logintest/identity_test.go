@@ -0,0 +1,27 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+)
+
+func TestIdentityKey_Sign(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	challengeHidden, challengeVisual := login.ChallengeHidden(), login.ChallengeVisual()
+
+	for _, version := range []int{login.Version1, login.Version2} {
+		sig := key.Sign(challengeHidden, challengeVisual, "", version)
+		assert.NoError(t, login.Verify(challengeHidden, challengeVisual, key.PublicKey(), sig, version), version)
+	}
+
+	sig := key.Sign(challengeHidden, challengeVisual, "https://phobia.cloud", login.Version3)
+	err := login.VerifyOrigin(challengeHidden, challengeVisual, "https://phobia.cloud", key.PublicKey(), sig, login.Version3)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+
+	"phobia.cloud/api/login"
+)
+
+// SchnorrKey is a secp256k1 key for creating BIP-340 Schnorr signatures.
+type SchnorrKey struct {
+	d *big.Int
+}
+
+// NewSchnorrKey returns the key with the provided 32-byte secret.
+func NewSchnorrKey(secret []byte) *SchnorrKey {
+	return &SchnorrKey{d: new(big.Int).SetBytes(secret)}
+}
+
+// PublicKey returns the hex-encoded x-only public key.
+func (k *SchnorrKey) PublicKey() string {
+	x, _ := btcec.S256().ScalarBaseMult(bytes32(k.d))
+	return hex.EncodeToString(bytes32(x))
+}
+
+// Sign returns the BIP-340 signature of msg with all-zero auxiliary random
+// data.
+func (k *SchnorrKey) Sign(msg []byte) []byte {
+	curve := btcec.S256()
+
+	px, py := curve.ScalarBaseMult(bytes32(k.d))
+	d := new(big.Int).Set(k.d)
+	if py.Bit(0) != 0 {
+		d.Sub(curve.N, d)
+	}
+
+	t := taggedHash("BIP0340/aux", make([]byte, 32))
+	for i, b := range bytes32(d) {
+		t[i] ^= b
+	}
+
+	kk := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, bytes32(px), msg))
+	kk.Mod(kk, curve.N)
+	rx, ry := curve.ScalarBaseMult(bytes32(kk))
+	if ry.Bit(0) != 0 {
+		kk.Sub(curve.N, kk)
+	}
+
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", bytes32(rx), bytes32(px), msg))
+	e.Mod(e, curve.N)
+
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, kk)
+	s.Mod(s, curve.N)
+
+	return append(bytes32(rx), bytes32(s)...)
+}
+
+// SignNostrEvent sets the public key, ID and signature of event.
+func (k *SchnorrKey) SignNostrEvent(event *login.NostrEvent) {
+	event.PubKey = k.PublicKey()
+	event.ID = event.ComputeID()
+
+	id, err := hex.DecodeString(event.ID)
+	if err != nil {
+		panic(err)
+	}
+	event.Sig = hex.EncodeToString(k.Sign(id))
+}
+
+// NostrAuthEvent returns a signed NIP-98 HTTP auth event for a request with
+// the provided method and URL, created at now.
+func (k *SchnorrKey) NostrAuthEvent(method, url string, now time.Time, tags ...[]string) *login.NostrEvent {
+	event := &login.NostrEvent{
+		CreatedAt: now.Unix(),
+		Kind:      login.NostrAuthKind,
+		Tags:      append([][]string{{"u", url}, {"method", method}}, tags...),
+	}
+	k.SignNostrEvent(event)
+	return event
+}
+
+func taggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
+
+func bytes32(x *big.Int) []byte {
+	result := make([]byte, 32)
+	b := x.Bytes()
+	copy(result[32-len(b):], b)
+	return result
+}
@@ -0,0 +1,39 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package logintest_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+)
+
+func TestSchnorrKey_Sign(t *testing.T) {
+	// test vector #0 from BIP-340
+	secret := make([]byte, 32)
+	secret[31] = 3
+	key := logintest.NewSchnorrKey(secret)
+
+	assert.Equal(t, "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9", key.PublicKey())
+
+	sig := key.Sign(make([]byte, 32))
+	assert.Equal(t, strings.ToLower("E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0"), hex.EncodeToString(sig))
+}
+
+func TestSchnorrKey_SignOddKey(t *testing.T) {
+	for i := byte(1); i < 10; i++ {
+		secret := make([]byte, 32)
+		secret[0], secret[31] = i, i
+		key := logintest.NewSchnorrKey(secret)
+
+		msg := []byte("phobia.cloud")
+		err := login.VerifySchnorr(key.PublicKey(), hex.EncodeToString(msg), hex.EncodeToString(key.Sign(msg)))
+		assert.NoError(t, err, i)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// NostrAuthKind is the kind of NIP-98 HTTP auth events.
+	NostrAuthKind = 27235
+	// NostrAuthMaxAge is the maximum difference between the creation time of
+	// a NIP-98 HTTP auth event and the time of its verification.
+	NostrAuthMaxAge = time.Minute
+)
+
+// NostrEvent is a signed Nostr event as defined by NIP-01.
+type NostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Serialize returns the NIP-01 serialization of the event that is hashed to
+// compute the event ID.
+func (e *NostrEvent) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[0,")
+	writeNostrString(&buf, e.PubKey)
+	buf.WriteByte(',')
+	buf.WriteString(strconv.FormatInt(e.CreatedAt, 10))
+	buf.WriteByte(',')
+	buf.WriteString(strconv.Itoa(e.Kind))
+	buf.WriteString(",[")
+	for i, tag := range e.Tags {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('[')
+		for j, value := range tag {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			writeNostrString(&buf, value)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteString("],")
+	writeNostrString(&buf, e.Content)
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// writeNostrString writes s as a JSON string escaped as NIP-01 requires:
+// only line feeds, double quotes, backslashes, carriage returns, tabs,
+// backspaces and form feeds are escaped.
+func writeNostrString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\n':
+			buf.WriteString(`\n`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// ComputeID returns the hex-encoded event ID, the SHA-256 hash of the
+// serialized event.
+func (e *NostrEvent) ComputeID() string {
+	return hex.EncodeToString(sha256(e.Serialize()))
+}
+
+// Verify verifies that the ID of the event matches its content and that the
+// ID is signed by the public key of the event.
+func (e *NostrEvent) Verify() error {
+	if e.ID != e.ComputeID() {
+		return errors.New("event id does not match event content")
+	}
+	return VerifySchnorr(e.PubKey, e.ID, e.Sig)
+}
+
+// Tag returns the first value of the first tag with the provided name.
+func (e *NostrEvent) Tag(name string) (string, bool) {
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// Challenge returns the challenge hidden embedded in the "challenge" tag of
+// the event for a login.
+func (e *NostrEvent) Challenge() (string, error) {
+	challengeHidden, ok := e.Tag("challenge")
+	if !ok {
+		return "", errors.New("event has no challenge tag")
+	}
+
+	challengeHiddenBytes, err := hex.DecodeString(challengeHidden)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode challenge hidden: %v", err)
+	}
+	if len(challengeHiddenBytes) != 32 {
+		return "", fmt.Errorf("invalid challenge hidden length: %d", len(challengeHiddenBytes))
+	}
+
+	return challengeHidden, nil
+}
+
+// ParseNostrAuthorization parses the event of a NIP-98 Authorization header
+// in the form "Nostr <base64-encoded event>".
+func ParseNostrAuthorization(header string) (*NostrEvent, error) {
+	const prefix = "Nostr "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, errors.New("not a nostr authorization")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[len(prefix):]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event: %v", err)
+	}
+	if !utf8.Valid(data) {
+		return nil, errors.New("failed to decode event: invalid utf-8")
+	}
+
+	var event NostrEvent
+	err = json.Unmarshal(data, &event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse event: %v", err)
+	}
+
+	return &event, nil
+}
+
+// VerifyNostrAuth verifies that a NIP-98 HTTP auth event authorizes a
+// request.
+//
+// method and url are the method and absolute URL of the request. body is the
+// request body, checked against the optional "payload" tag of the event. now
+// is the current time, checked against the creation time of the event.
+func VerifyNostrAuth(event *NostrEvent, method, url string, body []byte, now time.Time) error {
+	if event.Kind != NostrAuthKind {
+		return fmt.Errorf("invalid event kind: %d", event.Kind)
+	}
+
+	age := now.Sub(time.Unix(event.CreatedAt, 0))
+	if age > NostrAuthMaxAge || age < -NostrAuthMaxAge {
+		return errors.New("event created_at is not within the allowed time window")
+	}
+
+	if u, _ := event.Tag("u"); u != url {
+		return fmt.Errorf("event u tag does not match request url: %q", u)
+	}
+
+	if m, _ := event.Tag("method"); !strings.EqualFold(m, method) {
+		return fmt.Errorf("event method tag does not match request method: %q", m)
+	}
+
+	if payload, ok := event.Tag("payload"); ok {
+		if !strings.EqualFold(payload, hex.EncodeToString(sha256(body))) {
+			return errors.New("event payload tag does not match request body")
+		}
+	}
+
+	return event.Verify()
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
+)
+
+var nostrKey = logintest.NewSchnorrKey([]byte("phobia.cloud nostr test key 0001"))
+
+func TestNostrEvent_Serialize(t *testing.T) {
+	event := login.NostrEvent{
+		PubKey:    "abc",
+		CreatedAt: 1600000000,
+		Kind:      1,
+		Tags:      [][]string{{"e", "x"}, {"p", "y", "wss://relay"}},
+		Content:   "line\n\"quoted\" \\ \r\t\b\f <&> é  ",
+	}
+	assert.Equal(t,
+		`[0,"abc",1600000000,1,[["e","x"],["p","y","wss://relay"]],"line\n\"quoted\" \\ \r\t\b\f <&> é `+" "+`"]`,
+		string(event.Serialize()))
+
+	event.Tags = nil
+	assert.Equal(t, `[0,"abc",1600000000,1,[],"line\n\"quoted\" \\ \r\t\b\f <&> é `+" "+`"]`, string(event.Serialize()))
+
+	hash := sha256.Sum256(event.Serialize())
+	assert.Equal(t, hex.EncodeToString(hash[:]), event.ComputeID())
+}
+
+func TestNostrEvent_Verify(t *testing.T) {
+	event := &login.NostrEvent{
+		CreatedAt: 1600000000,
+		Kind:      1,
+		Content:   "hello",
+	}
+	nostrKey.SignNostrEvent(event)
+	assert.NoError(t, event.Verify())
+
+	tampered := *event
+	tampered.Content = "hello!"
+	assert.EqualError(t, tampered.Verify(), "event id does not match event content")
+
+	tampered = *event
+	tampered.PubKey = logintest.NewSchnorrKey([]byte("phobia.cloud nostr test key 0002")).PublicKey()
+	tampered.ID = tampered.ComputeID()
+	assert.EqualError(t, tampered.Verify(), login.ErrInvalidSignature.Error())
+}
+
+func TestNostrEvent_Challenge(t *testing.T) {
+	event := &login.NostrEvent{Tags: [][]string{{"challenge", challengeHidden}}}
+	challenge, err := event.Challenge()
+	require.NoError(t, err)
+	assert.Equal(t, challengeHidden, challenge)
+
+	for _, tt := range []struct {
+		tags          [][]string
+		expectedError string
+	}{
+		{
+			tags:          nil,
+			expectedError: "event has no challenge tag",
+		},
+		{
+			tags:          [][]string{{"challenge"}},
+			expectedError: "event has no challenge tag",
+		},
+		{
+			tags:          [][]string{{"challenge", "X" + challengeHidden[1:]}},
+			expectedError: "failed to decode challenge hidden: encoding/hex: invalid byte: U+0058 'X'",
+		},
+		{
+			tags:          [][]string{{"challenge", challengeHidden[2:]}},
+			expectedError: "invalid challenge hidden length: 31",
+		},
+	} {
+		_, err := (&login.NostrEvent{Tags: tt.tags}).Challenge()
+		assert.EqualError(t, err, tt.expectedError)
+	}
+}
+
+func TestParseNostrAuthorization(t *testing.T) {
+	event := nostrKey.NostrAuthEvent("GET", "https://phobia.cloud/login/nostr", time.Unix(1600000000, 0))
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	parsed, err := login.ParseNostrAuthorization("Nostr " + base64.StdEncoding.EncodeToString(data))
+	require.NoError(t, err)
+	assert.Equal(t, event, parsed)
+
+	for _, tt := range []struct {
+		header        string
+		expectedError string
+	}{
+		{
+			header:        "",
+			expectedError: "not a nostr authorization",
+		},
+		{
+			header:        "Bearer abc",
+			expectedError: "not a nostr authorization",
+		},
+		{
+			header:        "Nostr !",
+			expectedError: "failed to decode event: illegal base64 data at input byte 0",
+		},
+		{
+			header:        "Nostr " + base64.StdEncoding.EncodeToString([]byte("{")),
+			expectedError: "failed to parse event: unexpected end of JSON input",
+		},
+		{
+			header:        "Nostr " + base64.StdEncoding.EncodeToString([]byte{0xff}),
+			expectedError: "failed to decode event: invalid utf-8",
+		},
+	} {
+		_, err := login.ParseNostrAuthorization(tt.header)
+		assert.EqualError(t, err, tt.expectedError, tt.header)
+	}
+}
+
+func TestVerifyNostrAuth(t *testing.T) {
+	const url = "https://phobia.cloud/login/nostr?x=1"
+	now := time.Unix(1600000000, 0)
+	body := []byte(`{"hello":"world"}`)
+	bodyHash := sha256.Sum256(body)
+
+	event := nostrKey.NostrAuthEvent("POST", url, now)
+	assert.NoError(t, login.VerifyNostrAuth(event, "POST", url, nil, now))
+	assert.NoError(t, login.VerifyNostrAuth(event, "post", url, body, now.Add(login.NostrAuthMaxAge)))
+	assert.NoError(t, login.VerifyNostrAuth(event, "POST", url, nil, now.Add(-login.NostrAuthMaxAge)))
+
+	withPayload := nostrKey.NostrAuthEvent("POST", url, now, []string{"payload", hex.EncodeToString(bodyHash[:])})
+	assert.NoError(t, login.VerifyNostrAuth(withPayload, "POST", url, body, now))
+
+	wrongKind := &login.NostrEvent{CreatedAt: now.Unix(), Kind: 1, Tags: event.Tags}
+	nostrKey.SignNostrEvent(wrongKind)
+
+	tampered := *event
+	tampered.Sig = withPayload.Sig
+
+	for _, tt := range []struct {
+		name          string
+		event         *login.NostrEvent
+		method        string
+		url           string
+		body          []byte
+		now           time.Time
+		expectedError string
+	}{
+		{
+			name:          "wrong kind",
+			event:         wrongKind,
+			method:        "POST",
+			url:           url,
+			now:           now,
+			expectedError: "invalid event kind: 1",
+		},
+		{
+			name:          "expired",
+			event:         event,
+			method:        "POST",
+			url:           url,
+			now:           now.Add(login.NostrAuthMaxAge + time.Second),
+			expectedError: "event created_at is not within the allowed time window",
+		},
+		{
+			name:          "from the future",
+			event:         event,
+			method:        "POST",
+			url:           url,
+			now:           now.Add(-login.NostrAuthMaxAge - time.Second),
+			expectedError: "event created_at is not within the allowed time window",
+		},
+		{
+			name:          "wrong url",
+			event:         event,
+			method:        "POST",
+			url:           "https://phobia.cloud/login/nostr",
+			now:           now,
+			expectedError: `event u tag does not match request url: "https://phobia.cloud/login/nostr?x=1"`,
+		},
+		{
+			name:          "wrong method",
+			event:         event,
+			method:        "GET",
+			url:           url,
+			now:           now,
+			expectedError: `event method tag does not match request method: "POST"`,
+		},
+		{
+			name:          "wrong payload",
+			event:         withPayload,
+			method:        "POST",
+			url:           url,
+			body:          []byte("{}"),
+			now:           now,
+			expectedError: "event payload tag does not match request body",
+		},
+		{
+			name:          "wrong signature",
+			event:         &tampered,
+			method:        "POST",
+			url:           url,
+			now:           now,
+			expectedError: login.ErrInvalidSignature.Error(),
+		},
+	} {
+		err := login.VerifyNostrAuth(tt.event, tt.method, tt.url, tt.body, tt.now)
+		assert.EqualError(t, err, tt.expectedError, tt.name)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// VerifySchnorr verifies if signature is a valid BIP-340 Schnorr signature of
+// message by publicKey.
+//
+// publicKey is the 32-byte x-only public key, as used by Taproot and Nostr.
+//
+// signature is the 64-byte Schnorr signature.
+//
+// The function expects that publicKey, message, and signature are
+// hex-encoded.
+func VerifySchnorr(publicKey, message, signature string) error {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %v", err)
+	}
+
+	messageBytes, err := hex.DecodeString(message)
+	if err != nil {
+		return fmt.Errorf("failed to decode message: %v", err)
+	}
+
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	return verifySchnorr(publicKeyBytes, messageBytes, signatureBytes)
+}
+
+func verifySchnorr(publicKey, message, signature []byte) error {
+	if len(publicKey) != 32 {
+		return fmt.Errorf("invalid public key length: %d", len(publicKey))
+	}
+
+	curve := btcec.S256()
+	if new(big.Int).SetBytes(publicKey).Cmp(curve.P) >= 0 {
+		return errors.New("failed to parse public key: x coordinate is not less than field size")
+	}
+
+	pubKey, err := btcec.ParsePubKey(append([]byte{0x02}, publicKey...), curve)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	if len(signature) != 64 {
+		return ErrInvalidSignature
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return ErrInvalidSignature
+	}
+
+	challenge := taggedHash("BIP0340/challenge", signature[:32], publicKey, message)
+	e := new(big.Int).SetBytes(challenge)
+	e.Mod(e, curve.N)
+
+	// R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(signature[32:])
+	negE := new(big.Int).Sub(curve.N, e)
+	ex, ey := curve.ScalarMult(pubKey.X, pubKey.Y, negE.Bytes())
+	rx, ry := curve.Add(sx, sy, ex, ey)
+
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		// point at infinity
+		return ErrInvalidSignature
+	}
+	if ry.Bit(0) != 0 || rx.Cmp(r) != 0 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// taggedHash computes the BIP-340 tagged hash of the concatenation of msgs.
+func taggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256([]byte(tag))
+
+	var data []byte
+	data = append(data, tagHash...)
+	data = append(data, tagHash...)
+	for _, msg := range msgs {
+		data = append(data, msg...)
+	}
+	return sha256(data)
+}
@@ -0,0 +1,262 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SIWEVersion is the supported version of Sign-In With Ethereum messages.
+const SIWEVersion = "1"
+
+const siweHeader = " wants you to sign in with your Ethereum account:"
+
+// SIWEMessage is a Sign-In With Ethereum message as defined by EIP-4361.
+type SIWEMessage struct {
+	// Domain is the RFC 3986 authority of the relying party.
+	Domain string
+	// Address is the EIP-55 checksummed Ethereum address of the signer.
+	Address string
+	// Statement is an optional human-readable assertion of the signer.
+	Statement string
+	// URI is the RFC 3986 URI of the resource that is the subject of the
+	// signing.
+	URI string
+	// Version is the version of the message. It must be SIWEVersion.
+	Version string
+	// ChainID is the EIP-155 chain ID of the account.
+	ChainID int64
+	// Nonce is a randomized token to prevent replay attacks. It is the
+	// challenge hidden created by ChallengeHidden.
+	Nonce string
+	// IssuedAt is the time when the message was generated.
+	IssuedAt time.Time
+	// ExpirationTime is the optional time when the message expires.
+	ExpirationTime time.Time
+	// NotBefore is the optional time when the message becomes valid.
+	NotBefore time.Time
+	// RequestID is an optional system-specific identifier.
+	RequestID string
+	// Resources is an optional list of URIs the user wishes to have resolved.
+	Resources []string
+}
+
+// String returns the message in the EIP-4361 format that is signed.
+func (m *SIWEMessage) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Domain + siweHeader + "\n")
+	sb.WriteString(m.Address + "\n\n")
+	if m.Statement != "" {
+		sb.WriteString(m.Statement + "\n")
+	}
+	sb.WriteString("\n")
+	sb.WriteString("URI: " + m.URI + "\n")
+	sb.WriteString("Version: " + m.Version + "\n")
+	sb.WriteString("Chain ID: " + strconv.FormatInt(m.ChainID, 10) + "\n")
+	sb.WriteString("Nonce: " + m.Nonce + "\n")
+	sb.WriteString("Issued At: " + m.IssuedAt.Format(time.RFC3339Nano))
+	if !m.ExpirationTime.IsZero() {
+		sb.WriteString("\nExpiration Time: " + m.ExpirationTime.Format(time.RFC3339Nano))
+	}
+	if !m.NotBefore.IsZero() {
+		sb.WriteString("\nNot Before: " + m.NotBefore.Format(time.RFC3339Nano))
+	}
+	if m.RequestID != "" {
+		sb.WriteString("\nRequest ID: " + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		sb.WriteString("\nResources:")
+		for _, resource := range m.Resources {
+			sb.WriteString("\n- " + resource)
+		}
+	}
+	return sb.String()
+}
+
+// ParseSIWEMessage parses a Sign-In With Ethereum message in the EIP-4361
+// format.
+func ParseSIWEMessage(s string) (*SIWEMessage, error) {
+	p := siweParser{lines: strings.Split(s, "\n")}
+	var m SIWEMessage
+
+	header := p.next()
+	if !strings.HasSuffix(header, siweHeader) {
+		return nil, errors.New("invalid siwe message: missing header")
+	}
+	m.Domain = strings.TrimSuffix(header, siweHeader)
+	if m.Domain == "" || strings.ContainsAny(m.Domain, " /") {
+		return nil, fmt.Errorf("invalid siwe message: invalid domain: %q", m.Domain)
+	}
+
+	m.Address = p.next()
+	if err := ValidateAddress(m.Address); err != nil {
+		return nil, fmt.Errorf("invalid siwe message: %v", err)
+	}
+	if checksummed, _ := ChecksumAddress(m.Address); checksummed != m.Address {
+		return nil, errors.New("invalid siwe message: address is not in eip-55 format")
+	}
+
+	if p.next() != "" {
+		return nil, errors.New("invalid siwe message: missing empty line after address")
+	}
+	if !strings.HasPrefix(p.peek(), "URI: ") {
+		m.Statement = p.next()
+		if m.Statement != "" && p.next() != "" {
+			return nil, errors.New("invalid siwe message: missing empty line after statement")
+		}
+	}
+
+	var err error
+	if m.URI, err = p.field("URI", true); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(m.URI); err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("invalid siwe message: invalid uri: %q", m.URI)
+	}
+
+	if m.Version, err = p.field("Version", true); err != nil {
+		return nil, err
+	}
+	if m.Version != SIWEVersion {
+		return nil, fmt.Errorf("unsupported siwe version: %q", m.Version)
+	}
+
+	chainID, err := p.field("Chain ID", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); err != nil || m.ChainID < 1 {
+		return nil, fmt.Errorf("invalid siwe message: invalid chain id: %q", chainID)
+	}
+
+	if m.Nonce, err = p.field("Nonce", true); err != nil {
+		return nil, err
+	}
+	if !validNonce(m.Nonce) {
+		return nil, fmt.Errorf("invalid siwe message: invalid nonce: %q", m.Nonce)
+	}
+
+	if m.IssuedAt, err = p.timeField("Issued At", true); err != nil {
+		return nil, err
+	}
+	if m.ExpirationTime, err = p.timeField("Expiration Time", false); err != nil {
+		return nil, err
+	}
+	if m.NotBefore, err = p.timeField("Not Before", false); err != nil {
+		return nil, err
+	}
+	if m.RequestID, err = p.field("Request ID", false); err != nil {
+		return nil, err
+	}
+
+	if p.peek() == "Resources:" {
+		p.next()
+		for strings.HasPrefix(p.peek(), "- ") {
+			m.Resources = append(m.Resources, strings.TrimPrefix(p.next(), "- "))
+		}
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("invalid siwe message: unexpected line: %q", p.peek())
+	}
+
+	return &m, nil
+}
+
+// VerifySIWE parses a Sign-In With Ethereum message and verifies that
+// signature is a valid personal_sign signature of the message by the account
+// in the message.
+//
+// The caller is responsible for checking the domain, nonce and validity
+// period of the returned message.
+func VerifySIWE(message, signature string) (*SIWEMessage, error) {
+	m, err := ParseSIWEMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	err = VerifyPersonalSign([]byte(message), m.Address, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Valid checks that the message is within its validity period at now.
+func (m *SIWEMessage) Valid(now time.Time) error {
+	if !m.ExpirationTime.IsZero() && !now.Before(m.ExpirationTime) {
+		return errors.New("siwe message has expired")
+	}
+	if !m.NotBefore.IsZero() && now.Before(m.NotBefore) {
+		return errors.New("siwe message is not yet valid")
+	}
+	return nil
+}
+
+func validNonce(nonce string) bool {
+	if len(nonce) < 8 {
+		return false
+	}
+	for _, c := range nonce {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+type siweParser struct {
+	lines []string
+}
+
+func (p *siweParser) done() bool {
+	return len(p.lines) == 0
+}
+
+func (p *siweParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.lines[0]
+}
+
+func (p *siweParser) next() string {
+	line := p.peek()
+	if !p.done() {
+		p.lines = p.lines[1:]
+	}
+	return line
+}
+
+// field returns the value of the field with the provided name if it is on
+// the next line.
+func (p *siweParser) field(name string, required bool) (string, error) {
+	prefix := name + ": "
+	if !strings.HasPrefix(p.peek(), prefix) {
+		if required {
+			return "", fmt.Errorf("invalid siwe message: missing %s", strings.ToLower(name))
+		}
+		return "", nil
+	}
+	return strings.TrimPrefix(p.next(), prefix), nil
+}
+
+func (p *siweParser) timeField(name string, required bool) (time.Time, error) {
+	value, err := p.field(name, required)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid siwe message: invalid %s: %q", strings.ToLower(name), value)
+	}
+	return t, nil
+}
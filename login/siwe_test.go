@@ -0,0 +1,228 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+)
+
+func siweMessage() *login.SIWEMessage {
+	return &login.SIWEMessage{
+		Domain:         "phobia.cloud",
+		Address:        ethereumKey.Address(),
+		Statement:      "Sign in to Phobia Cloud.",
+		URI:            "https://phobia.cloud/login/ethereum",
+		Version:        login.SIWEVersion,
+		ChainID:        1,
+		Nonce:          challengeHidden,
+		IssuedAt:       time.Date(2021, 9, 30, 16, 25, 24, 0, time.UTC),
+		ExpirationTime: time.Date(2021, 9, 30, 16, 30, 24, 0, time.UTC),
+	}
+}
+
+func TestSIWEMessage_String(t *testing.T) {
+	m := siweMessage()
+	m.Address = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	m.NotBefore = m.IssuedAt
+	m.RequestID = "42"
+	m.Resources = []string{"ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/", "https://example.com/my-web2-claim.json"}
+
+	assert.Equal(t, `phobia.cloud wants you to sign in with your Ethereum account:
+0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed
+
+Sign in to Phobia Cloud.
+
+URI: https://phobia.cloud/login/ethereum
+Version: 1
+Chain ID: 1
+Nonce: cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2
+Issued At: 2021-09-30T16:25:24Z
+Expiration Time: 2021-09-30T16:30:24Z
+Not Before: 2021-09-30T16:25:24Z
+Request ID: 42
+Resources:
+- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/
+- https://example.com/my-web2-claim.json`, m.String())
+
+	m.Statement = ""
+	m.ExpirationTime = time.Time{}
+	m.NotBefore = time.Time{}
+	m.RequestID = ""
+	m.Resources = nil
+	assert.Equal(t, `phobia.cloud wants you to sign in with your Ethereum account:
+0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed
+
+
+URI: https://phobia.cloud/login/ethereum
+Version: 1
+Chain ID: 1
+Nonce: cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2
+Issued At: 2021-09-30T16:25:24Z`, m.String())
+}
+
+func TestParseSIWEMessage_RoundTrip(t *testing.T) {
+	full := siweMessage()
+	full.NotBefore = full.IssuedAt.Add(time.Second)
+	full.RequestID = "request-1"
+	full.Resources = []string{"https://phobia.cloud/a", "https://phobia.cloud/b"}
+
+	minimal := siweMessage()
+	minimal.Statement = ""
+	minimal.ExpirationTime = time.Time{}
+
+	for _, m := range []*login.SIWEMessage{siweMessage(), full, minimal} {
+		parsed, err := login.ParseSIWEMessage(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, parsed)
+	}
+
+	// messages without the empty line reserved for the statement
+	parsed, err := login.ParseSIWEMessage(strings.Replace(minimal.String(), "\n\n\n", "\n\n", 1))
+	require.NoError(t, err)
+	assert.Equal(t, minimal, parsed)
+}
+
+func TestParseSIWEMessage_Invalid(t *testing.T) {
+	valid := siweMessage().String()
+
+	for _, tt := range []struct {
+		name          string
+		old, new      string
+		expectedError string
+	}{
+		{
+			name:          "missing header",
+			old:           " wants you to sign in with your Ethereum account:",
+			new:           "",
+			expectedError: "invalid siwe message: missing header",
+		},
+		{
+			name:          "invalid domain",
+			old:           "phobia.cloud wants",
+			new:           "https://phobia.cloud wants",
+			expectedError: `invalid siwe message: invalid domain: "https://phobia.cloud"`,
+		},
+		{
+			name:          "lowercase address",
+			old:           ethereumKey.Address(),
+			new:           strings.ToLower(ethereumKey.Address()),
+			expectedError: "invalid siwe message: address is not in eip-55 format",
+		},
+		{
+			name:          "invalid address",
+			old:           ethereumKey.Address(),
+			new:           ethereumKey.Address()[2:],
+			expectedError: "invalid siwe message: address has no 0x prefix",
+		},
+		{
+			name:          "missing empty line after address",
+			old:           "\n\nSign in",
+			new:           "\nSign in",
+			expectedError: "invalid siwe message: missing empty line after address",
+		},
+		{
+			name:          "missing empty line after statement",
+			old:           "Phobia Cloud.\n\n",
+			new:           "Phobia Cloud.\n",
+			expectedError: "invalid siwe message: missing empty line after statement",
+		},
+		{
+			name:          "missing uri",
+			old:           "URI: https://phobia.cloud/login/ethereum\n",
+			new:           "",
+			expectedError: "invalid siwe message: missing uri",
+		},
+		{
+			name:          "relative uri",
+			old:           "URI: https://phobia.cloud/login/ethereum",
+			new:           "URI: /login/ethereum",
+			expectedError: `invalid siwe message: invalid uri: "/login/ethereum"`,
+		},
+		{
+			name:          "unsupported version",
+			old:           "Version: 1",
+			new:           "Version: 2",
+			expectedError: `unsupported siwe version: "2"`,
+		},
+		{
+			name:          "invalid chain id",
+			old:           "Chain ID: 1",
+			new:           "Chain ID: one",
+			expectedError: `invalid siwe message: invalid chain id: "one"`,
+		},
+		{
+			name:          "short nonce",
+			old:           "Nonce: " + challengeHidden,
+			new:           "Nonce: abc",
+			expectedError: `invalid siwe message: invalid nonce: "abc"`,
+		},
+		{
+			name:          "non-alphanumeric nonce",
+			old:           "Nonce: " + challengeHidden,
+			new:           "Nonce: abcdefgh-",
+			expectedError: `invalid siwe message: invalid nonce: "abcdefgh-"`,
+		},
+		{
+			name:          "invalid issued at",
+			old:           "Issued At: 2021-09-30T16:25:24Z",
+			new:           "Issued At: 2021-09-30 16:25:24",
+			expectedError: `invalid siwe message: invalid issued at: "2021-09-30 16:25:24"`,
+		},
+		{
+			name:          "missing issued at",
+			old:           "Issued At: 2021-09-30T16:25:24Z\n",
+			new:           "",
+			expectedError: "invalid siwe message: missing issued at",
+		},
+		{
+			name:          "invalid expiration time",
+			old:           "Expiration Time: 2021-09-30T16:30:24Z",
+			new:           "Expiration Time: tomorrow",
+			expectedError: `invalid siwe message: invalid expiration time: "tomorrow"`,
+		},
+		{
+			name:          "trailing line",
+			old:           "Expiration Time: 2021-09-30T16:30:24Z",
+			new:           "Expiration Time: 2021-09-30T16:30:24Z\nFoo: bar",
+			expectedError: `invalid siwe message: unexpected line: "Foo: bar"`,
+		},
+	} {
+		_, err := login.ParseSIWEMessage(strings.Replace(valid, tt.old, tt.new, 1))
+		assert.EqualError(t, err, tt.expectedError, tt.name)
+	}
+}
+
+func TestVerifySIWE(t *testing.T) {
+	message := siweMessage().String()
+	signature := ethereumKey.PersonalSign([]byte(message))
+
+	m, err := login.VerifySIWE(message, signature)
+	require.NoError(t, err)
+	assert.Equal(t, siweMessage(), m)
+
+	_, err = login.VerifySIWE(strings.Replace(message, "Chain ID: 1", "Chain ID: 5", 1), signature)
+	assert.EqualError(t, err, login.ErrInvalidSignature.Error())
+
+	_, err = login.VerifySIWE("", signature)
+	assert.EqualError(t, err, "invalid siwe message: missing header")
+}
+
+func TestSIWEMessage_Valid(t *testing.T) {
+	m := siweMessage()
+	m.NotBefore = m.IssuedAt.Add(time.Minute)
+
+	assert.EqualError(t, m.Valid(m.IssuedAt), "siwe message is not yet valid")
+	assert.NoError(t, m.Valid(m.NotBefore))
+	assert.NoError(t, m.Valid(m.ExpirationTime.Add(-time.Nanosecond)))
+	assert.EqualError(t, m.Valid(m.ExpirationTime), "siwe message has expired")
+
+	assert.NoError(t, (&login.SIWEMessage{}).Valid(time.Now()))
+}
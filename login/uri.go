@@ -0,0 +1,87 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// URIScheme is the default scheme of login URIs.
+const URIScheme = "trezorlogin"
+
+// URI is a login URI that carries a challenge to a device in another context,
+// typically as a deep link or a QR code.
+//
+// The URI has the following form:
+//
+//	trezorlogin://example.com?challengeHidden=...&challengeVisual=...&callback=...
+type URI struct {
+	// Scheme is the URI scheme. If empty, URIScheme is used.
+	Scheme string
+	// Host is the host of the relying party that issued the challenge.
+	Host string
+	// ChallengeHidden is the challenge hidden created by ChallengeHidden.
+	ChallengeHidden string
+	// ChallengeVisual is the challenge visual created by ChallengeVisual.
+	ChallengeVisual string
+	// Callback is the absolute URL where the signed challenge must be sent.
+	Callback string
+}
+
+// String returns the URI in its textual form.
+func (u URI) String() string {
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = URIScheme
+	}
+
+	query := url.Values{}
+	query.Set("challengeHidden", u.ChallengeHidden)
+	query.Set("challengeVisual", u.ChallengeVisual)
+	query.Set("callback", u.Callback)
+
+	return (&url.URL{
+		Scheme:   scheme,
+		Host:     u.Host,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// ParseURI parses a login URI in the form returned by URI.String.
+func ParseURI(s string) (URI, error) {
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return URI{}, fmt.Errorf("failed to parse login uri: %v", err)
+	}
+
+	if parsed.Scheme == "" {
+		return URI{}, errors.New("login uri has no scheme")
+	}
+	if parsed.Host == "" {
+		return URI{}, errors.New("login uri has no host")
+	}
+
+	query := parsed.Query()
+	u := URI{
+		Scheme:          parsed.Scheme,
+		Host:            parsed.Host,
+		ChallengeHidden: query.Get("challengeHidden"),
+		ChallengeVisual: query.Get("challengeVisual"),
+		Callback:        query.Get("callback"),
+	}
+
+	if u.ChallengeHidden == "" {
+		return URI{}, errors.New("login uri has no challenge hidden")
+	}
+	if u.ChallengeVisual == "" {
+		return URI{}, errors.New("login uri has no challenge visual")
+	}
+	if u.Callback == "" {
+		return URI{}, errors.New("login uri has no callback")
+	}
+
+	return u, nil
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+)
+
+func TestURI_String(t *testing.T) {
+	u := login.URI{
+		Host:            "phobia.cloud",
+		ChallengeHidden: challengeHidden,
+		ChallengeVisual: challengeVisual,
+		Callback:        "https://phobia.cloud/login",
+	}
+	assert.Equal(t, "trezorlogin://phobia.cloud?"+
+		"callback=https%3A%2F%2Fphobia.cloud%2Flogin&"+
+		"challengeHidden="+challengeHidden+"&"+
+		"challengeVisual=2015-03-23+17%3A39%3A22", u.String())
+
+	u.Scheme = "web+trezorlogin"
+	assert.Equal(t, "web+trezorlogin", u.String()[:len("web+trezorlogin")])
+}
+
+func TestParseURI(t *testing.T) {
+	u := login.URI{
+		Scheme:          login.URIScheme,
+		Host:            "phobia.cloud:5050",
+		ChallengeHidden: challengeHidden,
+		ChallengeVisual: challengeVisual,
+		Callback:        "https://phobia.cloud:5050/login",
+	}
+
+	parsed, err := login.ParseURI(u.String())
+	require.NoError(t, err)
+	assert.Equal(t, u, parsed)
+}
+
+func TestParseURI_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		uri           string
+		expectedError string
+	}{
+		{
+			uri:           "%",
+			expectedError: `failed to parse login uri: parse "%": invalid URL escape "%"`,
+		},
+		{
+			uri:           "//phobia.cloud?challengeHidden=a&challengeVisual=b&callback=c",
+			expectedError: "login uri has no scheme",
+		},
+		{
+			uri:           "trezorlogin:?challengeHidden=a&challengeVisual=b&callback=c",
+			expectedError: "login uri has no host",
+		},
+		{
+			uri:           "trezorlogin://phobia.cloud?challengeVisual=b&callback=c",
+			expectedError: "login uri has no challenge hidden",
+		},
+		{
+			uri:           "trezorlogin://phobia.cloud?challengeHidden=a&callback=c",
+			expectedError: "login uri has no challenge visual",
+		},
+		{
+			uri:           "trezorlogin://phobia.cloud?challengeHidden=a&challengeVisual=b",
+			expectedError: "login uri has no callback",
+		},
+	} {
+		_, err := login.ParseURI(tt.uri)
+		assert.EqualError(t, err, tt.expectedError, tt.uri)
+	}
+}
@@ -8,11 +8,45 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
 
 	"github.com/btcsuite/btcd/btcec"
 )
 
-var ErrInvalidSignature = errors.New("signature does not match public key or challenge")
+var (
+	// ErrInvalidSignature is returned if the signature was not created by
+	// the public key for the challenge.
+	ErrInvalidSignature = errors.New("signature does not match public key or challenge")
+	// ErrUnsupportedVersion is returned for an unknown challenge version.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+)
+
+// FieldError is an error in one of the arguments of VerifyOrigin, e.g. a
+// public key that is not hex-encoded.
+type FieldError struct {
+	// Field is the name of the argument: "challengeHidden", "origin",
+	// "publicKey" or "signature".
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Err.Error() }
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// Challenge versions supported by Verify.
+const (
+	// Version1 signs the concatenation of challenge hidden and challenge
+	// visual.
+	Version1 = 1
+	// Version2 signs the SHA-256 hashes of challenge hidden and challenge
+	// visual.
+	Version2 = 2
+	// Version3 is Version2 with the origin of the relying party appended to
+	// challenge hidden, which binds the signature to the origin. See
+	// OriginChallengeHidden.
+	Version3 = 3
+)
 
 // Verify verifies if signature is valid for the provided challenge and public
 // key.
@@ -30,40 +64,79 @@ var ErrInvalidSignature = errors.New("signature does not match public key or cha
 //
 // version determines how the challenge is created from challengeHidden and
 // challengeVisual. Valid versions are 1 and 2. If not sure, use version 2.
+// Version 3 requires an origin and is verified by VerifyOrigin.
 //
 // The function expects that challengeHidden, publicKey, and signature are
 // hex-encoded.
 func Verify(challengeHidden, challengeVisual, publicKey, signature string, version int) error {
-	challengeHiddenBytes, err := hex.DecodeString(challengeHidden)
+	return VerifyOrigin(challengeHidden, challengeVisual, "", publicKey, signature, version)
+}
+
+// VerifyOrigin is like Verify, but also supports Version3, in which the
+// signed challenge commits to origin, e.g. "https://phobia.cloud". A
+// signature relayed from another origin does not verify.
+//
+// The origin is ignored by versions 1 and 2. It is the responsibility of
+// the caller to check that origin is one of its own.
+func VerifyOrigin(challengeHidden, challengeVisual, origin, publicKey, signature string, version int) error {
+	hash, err := ChallengeHash(challengeHidden, challengeVisual, origin, version)
 	if err != nil {
-		return fmt.Errorf("failed to decode challenge hidden: %v", err)
+		return err
 	}
 
-	challengeVisualBytes := []byte(challengeVisual)
-
 	publicKeyBytes, err := hex.DecodeString(publicKey)
 	if err != nil {
-		return fmt.Errorf("failed to decode public key: %v", err)
+		return &FieldError{"publicKey", fmt.Errorf("failed to decode public key: %v", err)}
 	}
 
 	pubKey, err := btcec.ParsePubKey(publicKeyBytes, btcec.S256())
 	if err != nil {
-		return fmt.Errorf("failed to parse public key: %v", err)
+		return &FieldError{"publicKey", fmt.Errorf("failed to parse public key: %v", err)}
 	}
 
 	signatureBytes, err := hex.DecodeString(signature)
 	if err != nil {
-		return fmt.Errorf("failed to decode signature: %v", err)
+		return &FieldError{"signature", fmt.Errorf("failed to decode signature: %v", err)}
+	}
+
+	recoveredKey, _, err := btcec.RecoverCompact(btcec.S256(), signatureBytes, hash)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !recoveredKey.IsEqual(pubKey) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// ChallengeHash returns the hash of the Bitcoin signed message the Trezor
+// device signs for the challenge of the given version.
+func ChallengeHash(challengeHidden, challengeVisual, origin string, version int) ([]byte, error) {
+	if version == Version3 {
+		var err error
+		challengeHidden, err = OriginChallengeHidden(challengeHidden, origin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	challengeHiddenBytes, err := hex.DecodeString(challengeHidden)
+	if err != nil {
+		return nil, &FieldError{"challengeHidden", fmt.Errorf("failed to decode challenge hidden: %v", err)}
 	}
 
+	challengeVisualBytes := []byte(challengeVisual)
+
 	var challenge []byte
 	switch version {
-	case 1:
+	case Version1:
 		challenge = append(challengeHiddenBytes, challengeVisualBytes...)
-	case 2:
+	case Version2, Version3:
 		challenge = append(sha256(challengeHiddenBytes), sha256(challengeVisualBytes)...)
 	default:
-		return fmt.Errorf("unsupported version: %d", version)
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
 	}
 
 	magicBytes := []byte("Bitcoin Signed Message:\n")
@@ -73,15 +146,44 @@ func Verify(challengeHidden, challengeVisual, publicKey, signature string, versi
 	msg = append(msg, magicBytes...)
 	msg = append(msg, byte(len(challenge)))
 	msg = append(msg, challenge...)
-	hash := sha256(sha256(msg))
+	return sha256(sha256(msg)), nil
+}
 
-	recoveredKey, _, err := btcec.RecoverCompact(btcec.S256(), signatureBytes, hash)
+// OriginChallengeHidden returns the challenge hidden that commits to origin
+// in Version3. It is the hex-encoded challengeHidden followed by the bytes
+// of origin, and is what the client passes to the Trezor device as
+// challenge hidden.
+//
+// The origin is the scheme, host and optional port of the relying party,
+// e.g. "https://phobia.cloud", as in the Origin header of browsers.
+func OriginChallengeHidden(challengeHidden, origin string) (string, error) {
+	challengeHiddenBytes, err := hex.DecodeString(challengeHidden)
 	if err != nil {
-		return ErrInvalidSignature
+		return "", &FieldError{"challengeHidden", fmt.Errorf("failed to decode challenge hidden: %v", err)}
 	}
 
-	if !recoveredKey.IsEqual(pubKey) {
-		return ErrInvalidSignature
+	err = ValidateOrigin(origin)
+	if err != nil {
+		return "", &FieldError{"origin", err}
+	}
+
+	return hex.EncodeToString(append(challengeHiddenBytes, origin...)), nil
+}
+
+// ValidateOrigin checks that origin is a serialized HTTP or HTTPS origin
+// without path, query or trailing slash.
+func ValidateOrigin(origin string) error {
+	if origin == "" {
+		return errors.New("missing origin")
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("failed to parse origin: %v", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Scheme+"://"+u.Host != origin {
+		return fmt.Errorf("invalid origin: %q", origin)
 	}
 
 	return nil
@@ -4,12 +4,16 @@
 package login_test
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"phobia.cloud/api/login"
+	"phobia.cloud/api/login/logintest"
 )
 
 const ( // valid login info
@@ -26,12 +30,43 @@ func TestVerify_Valid(t *testing.T) {
 }
 
 func TestVerify_UnsupportedVersion(t *testing.T) {
-	for _, v := range []int{-1, 0, 3, 10} {
+	for _, v := range []int{-1, 0, 4, 10} {
 		err := login.Verify(challengeHidden, challengeVisual, publicKey, signature, v)
 		assert.EqualError(t, err, fmt.Sprintf("unsupported version: %d", v))
+		assert.True(t, errors.Is(err, login.ErrUnsupportedVersion))
 	}
 }
 
+func TestVerifyOrigin_FieldError(t *testing.T) {
+	for _, tt := range []struct {
+		challengeHidden string
+		origin          string
+		publicKey       string
+		signature       string
+		version         int
+		field           string
+	}{
+		{challengeHidden: "xyz", publicKey: publicKey, signature: signature, version: 2, field: "challengeHidden"},
+		{challengeHidden: challengeHidden, publicKey: publicKey, signature: signature, version: 3, field: "origin"},
+		{challengeHidden: challengeHidden, origin: "https://phobia.cloud/", publicKey: publicKey, signature: signature, version: 3, field: "origin"},
+		{challengeHidden: challengeHidden, publicKey: "xyz", signature: signature, version: 2, field: "publicKey"},
+		{challengeHidden: challengeHidden, publicKey: "0011", signature: signature, version: 2, field: "publicKey"},
+		{challengeHidden: challengeHidden, publicKey: publicKey, signature: "xyz", version: 2, field: "signature"},
+	} {
+		err := login.VerifyOrigin(tt.challengeHidden, challengeVisual, tt.origin, tt.publicKey, tt.signature, tt.version)
+		var fieldErr *login.FieldError
+		if assert.True(t, errors.As(err, &fieldErr), "%v", err) {
+			assert.Equal(t, tt.field, fieldErr.Field)
+			assert.Equal(t, err.Error(), fieldErr.Err.Error())
+		}
+	}
+}
+
+func TestVerify_VersionRequiresOrigin(t *testing.T) {
+	err := login.Verify(challengeHidden, challengeVisual, publicKey, signature, login.Version3)
+	assert.EqualError(t, err, "missing origin")
+}
+
 func TestVerify_WrongVersion(t *testing.T) {
 	err := login.Verify(challengeHidden, challengeVisual, publicKey, signature, 1)
 	assert.EqualError(t, err, login.ErrInvalidSignature.Error())
@@ -161,3 +196,58 @@ func TestVerify_InvalidSignature(t *testing.T) {
 		assert.EqualError(t, err, tt.expectedError, tt.signature)
 	}
 }
+
+func TestVerifyOrigin(t *testing.T) {
+	key := logintest.NewIdentityKey([]byte("phobia.cloud identity key secret"))
+	origin := "https://phobia.cloud"
+	sig := key.Sign(challengeHidden, challengeVisual, origin, login.Version3)
+
+	err := login.VerifyOrigin(challengeHidden, challengeVisual, origin, key.PublicKey(), sig, login.Version3)
+	assert.NoError(t, err)
+
+	// relayed from another origin
+	for _, other := range []string{"https://phobia.club", "https://phobia.cloud:8443", "http://phobia.cloud"} {
+		err = login.VerifyOrigin(challengeHidden, challengeVisual, other, key.PublicKey(), sig, login.Version3)
+		assert.Equal(t, login.ErrInvalidSignature, err, other)
+	}
+
+	// the device signs the origin challenge hidden with version 2
+	originHidden, err := login.OriginChallengeHidden(challengeHidden, origin)
+	require.NoError(t, err)
+	assert.Equal(t, challengeHidden+hex.EncodeToString([]byte(origin)), originHidden)
+	err = login.Verify(originHidden, challengeVisual, key.PublicKey(), sig, login.Version2)
+	assert.NoError(t, err)
+
+	// the origin is not bound by older versions
+	sig = key.Sign(challengeHidden, challengeVisual, "", login.Version2)
+	err = login.VerifyOrigin(challengeHidden, challengeVisual, "https://phobia.club", key.PublicKey(), sig, login.Version2)
+	assert.NoError(t, err)
+}
+
+func TestValidateOrigin(t *testing.T) {
+	for _, origin := range []string{
+		"https://phobia.cloud",
+		"http://localhost:5050",
+		"https://[::1]:8443",
+	} {
+		assert.NoError(t, login.ValidateOrigin(origin), origin)
+	}
+
+	for _, tt := range []struct {
+		origin string
+		err    string
+	}{
+		{"", "missing origin"},
+		{"phobia.cloud", `invalid origin: "phobia.cloud"`},
+		{"https://phobia.cloud/", `invalid origin: "https://phobia.cloud/"`},
+		{"https://phobia.cloud/login", `invalid origin: "https://phobia.cloud/login"`},
+		{"https://phobia.cloud?a=b", `invalid origin: "https://phobia.cloud?a=b"`},
+		{"https://user@phobia.cloud", `invalid origin: "https://user@phobia.cloud"`},
+		{"HTTPS://phobia.cloud", `invalid origin: "HTTPS://phobia.cloud"`},
+		{"ftp://phobia.cloud", `invalid origin: "ftp://phobia.cloud"`},
+		{"https://", `invalid origin: "https://"`},
+		{"https://phobia.cloud:port", `failed to parse origin: parse "https://phobia.cloud:port": invalid port ":port" after host`},
+	} {
+		assert.EqualError(t, login.ValidateOrigin(tt.origin), tt.err, tt.origin)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limits of the challenge visual text that fit the screens of all Trezor
+// models.
+const (
+	// MaxVisualLength is the maximum length of the text in bytes.
+	MaxVisualLength = 64
+	// MaxVisualLines is the maximum number of lines of the text.
+	MaxVisualLines = 3
+	// MaxVisualLineLength is the maximum number of characters of a line.
+	MaxVisualLineLength = 26
+)
+
+// VisualTimePlaceholder is replaced with the current time when rendering a
+// VisualTemplate.
+const VisualTimePlaceholder = "{time}"
+
+// DefaultVisualLayout is the time layout of a VisualTemplate if its Layout is
+// not set. It includes the offset of the time zone.
+const DefaultVisualLayout = "2006-01-02 15:04:05 -07:00"
+
+// VisualTemplate defines the challenge visual text displayed on the Trezor
+// device. The same template renders the text when issuing the challenge and
+// parses it back when verifying the login.
+//
+// The zero value renders the time in UTC in DefaultVisualLayout, e.g.
+// "2015-03-23 17:39:22 +00:00".
+type VisualTemplate struct {
+	// Text is the text with exactly one VisualTimePlaceholder, e.g.
+	// "Login to phobia.cloud\n{time}". Lines are separated by "\n". If
+	// empty, the text is only the time.
+	Text string
+	// Layout is the time layout as in time.Format. It must contain the
+	// offset of the time zone, the date and the time at least to the
+	// minute. If empty, DefaultVisualLayout is used.
+	Layout string
+	// Location is the time zone of the rendered time. If nil, UTC is used.
+	Location *time.Location
+}
+
+func (t *VisualTemplate) text() string {
+	if t.Text == "" {
+		return VisualTimePlaceholder
+	}
+	return t.Text
+}
+
+func (t *VisualTemplate) layout() string {
+	if t.Layout == "" {
+		return DefaultVisualLayout
+	}
+	return t.Layout
+}
+
+func (t *VisualTemplate) location() *time.Location {
+	if t.Location == nil {
+		return time.UTC
+	}
+	return t.Location
+}
+
+// Validate checks that the template renders a text that fits the display
+// limits and that can be parsed back to the same time.
+func (t *VisualTemplate) Validate() error {
+	if strings.Count(t.text(), VisualTimePlaceholder) != 1 {
+		return fmt.Errorf("visual template must contain %s exactly once", VisualTimePlaceholder)
+	}
+
+	layout := t.layout()
+	if !strings.Contains(layout, "-07") && !strings.Contains(layout, "Z07") {
+		return errors.New("visual template layout must contain the zone offset")
+	}
+
+	// the longest month and weekday names
+	sample := time.Date(2021, time.September, 29, 23, 59, 0, 0, time.UTC)
+
+	text := t.render(sample)
+	err := checkVisualLimits(text)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := t.Parse(text)
+	if err != nil {
+		return err
+	}
+	if !parsed.Equal(sample) {
+		return errors.New("visual template layout must contain the date and the time to the minute")
+	}
+
+	return nil
+}
+
+// Render returns the text of the template for the time now.
+func (t *VisualTemplate) Render(now time.Time) (string, error) {
+	err := t.Validate()
+	if err != nil {
+		return "", err
+	}
+	return t.render(now), nil
+}
+
+func (t *VisualTemplate) render(now time.Time) string {
+	return strings.Replace(t.text(), VisualTimePlaceholder, now.In(t.location()).Format(t.layout()), 1)
+}
+
+// Parse returns the time of a text rendered by the template.
+func (t *VisualTemplate) Parse(visual string) (time.Time, error) {
+	i := strings.Index(t.text(), VisualTimePlaceholder)
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("visual template must contain %s exactly once", VisualTimePlaceholder)
+	}
+	prefix, suffix := t.text()[:i], t.text()[i+len(VisualTimePlaceholder):]
+
+	if len(visual) < len(prefix)+len(suffix) || !strings.HasPrefix(visual, prefix) || !strings.HasSuffix(visual, suffix) {
+		return time.Time{}, errors.New("challenge visual does not match template")
+	}
+
+	parsed, err := time.ParseInLocation(t.layout(), visual[len(prefix):len(visual)-len(suffix)], t.location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse challenge visual: %v", err)
+	}
+	return parsed, nil
+}
+
+// ErrChallengeExpired is returned by VisualTemplate.CheckFresh for a challenge
+// that is older than the maximum age.
+var ErrChallengeExpired = errors.New("challenge visual has expired")
+
+// CheckFresh checks that the text rendered by the template is not older than
+// maxAge at the time now. Times up to a minute in the future are accepted
+// to allow for clock skew between servers.
+func (t *VisualTemplate) CheckFresh(visual string, now time.Time, maxAge time.Duration) error {
+	issued, err := t.Parse(visual)
+	if err != nil {
+		return err
+	}
+
+	if issued.After(now.Add(time.Minute)) {
+		return errors.New("challenge visual is in the future")
+	}
+	// the layout may truncate the time to the minute
+	if now.Sub(issued) > maxAge+time.Minute {
+		return ErrChallengeExpired
+	}
+
+	return nil
+}
+
+// checkVisualLimits checks that text fits the display limits.
+func checkVisualLimits(text string) error {
+	if len(text) > MaxVisualLength {
+		return fmt.Errorf("challenge visual is longer than %d bytes: %q", MaxVisualLength, text)
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > MaxVisualLines {
+		return fmt.Errorf("challenge visual has more than %d lines: %q", MaxVisualLines, text)
+	}
+
+	for _, line := range lines {
+		if len(line) > MaxVisualLineLength {
+			return fmt.Errorf("challenge visual line is longer than %d characters: %q", MaxVisualLineLength, line)
+		}
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("challenge visual contains a character the device cannot display: %q", c)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package login_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+)
+
+func TestVisualTemplate_Render(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	require.NoError(t, err)
+
+	now := time.Date(2015, time.March, 23, 17, 39, 22, 0, time.UTC)
+
+	for _, tt := range []struct {
+		template login.VisualTemplate
+		visual   string
+	}{
+		{
+			template: login.VisualTemplate{},
+			visual:   "2015-03-23 17:39:22 +00:00",
+		},
+		{
+			template: login.VisualTemplate{Location: sofia},
+			visual:   "2015-03-23 19:39:22 +02:00",
+		},
+		{
+			template: login.VisualTemplate{
+				Text:     "Login to phobia.cloud\n{time}",
+				Layout:   "2006-01-02 15:04Z07:00",
+				Location: time.UTC,
+			},
+			visual: "Login to phobia.cloud\n2015-03-23 17:39Z",
+		},
+		{
+			template: login.VisualTemplate{
+				Text:   "phobia.cloud\n{time}\nApprove login",
+				Layout: "Jan 2 2006 15:04:05 -0700",
+			},
+			visual: "phobia.cloud\nMar 23 2015 17:39:22 +0000\nApprove login",
+		},
+	} {
+		visual, err := tt.template.Render(now)
+		require.NoError(t, err, tt.visual)
+		assert.Equal(t, tt.visual, visual)
+	}
+}
+
+func TestVisualTemplate_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		template login.VisualTemplate
+		err      string
+	}{
+		{
+			template: login.VisualTemplate{Text: "phobia.cloud"},
+			err:      "visual template must contain {time} exactly once",
+		},
+		{
+			template: login.VisualTemplate{Text: "{time}\n{time}"},
+			err:      "visual template must contain {time} exactly once",
+		},
+		{
+			template: login.VisualTemplate{Layout: "2006-01-02 15:04:05"},
+			err:      "visual template layout must contain the zone offset",
+		},
+		{
+			template: login.VisualTemplate{Layout: "2006-01-02 MST"},
+			err:      "visual template layout must contain the zone offset",
+		},
+		{
+			template: login.VisualTemplate{Layout: "2006-01-02 15h -07:00"},
+			err:      "visual template layout must contain the date and the time to the minute",
+		},
+		{
+			template: login.VisualTemplate{Layout: "Jan 2 15:04 -07:00"},
+			err:      "visual template layout must contain the date and the time to the minute",
+		},
+		{
+			template: login.VisualTemplate{Layout: "Monday, January 2 2006 15:04:05 -07:00"},
+			err:      `challenge visual line is longer than 26 characters: "Wednesday, September 29 2021 23:59:00 +00:00"`,
+		},
+		{
+			template: login.VisualTemplate{Text: "a\nb\nc\n{time}"},
+			err:      `challenge visual has more than 3 lines: "a\nb\nc\n2021-09-29 23:59:00 +00:00"`,
+		},
+		{
+			template: login.VisualTemplate{Text: "Login to phobia.cloud now\nand later today\n{time}"},
+			err:      `challenge visual is longer than 64 bytes: "Login to phobia.cloud now\nand later today\n2021-09-29 23:59:00 +00:00"`,
+		},
+		{
+			template: login.VisualTemplate{Text: "Влез\n{time}"},
+			err:      `challenge visual contains a character the device cannot display: 'В'`,
+		},
+		{
+			template: login.VisualTemplate{Text: "\t{time}", Layout: "2006-01-02 15:04 -07"},
+			err:      `challenge visual contains a character the device cannot display: '\t'`,
+		},
+	} {
+		err := tt.template.Validate()
+		assert.EqualError(t, err, tt.err)
+
+		_, err = tt.template.Render(time.Now())
+		assert.EqualError(t, err, tt.err)
+	}
+}
+
+func TestVisualTemplate_Parse(t *testing.T) {
+	sofia, err := time.LoadLocation("Europe/Sofia")
+	require.NoError(t, err)
+
+	template := login.VisualTemplate{Text: "Login to phobia.cloud\n{time}", Location: sofia}
+	now := time.Date(2015, time.March, 23, 17, 39, 22, 0, time.UTC)
+
+	visual, err := template.Render(now)
+	require.NoError(t, err)
+
+	parsed, err := template.Parse(visual)
+	require.NoError(t, err)
+	assert.True(t, now.Equal(parsed))
+
+	// the offset in the text wins over the location of the template
+	parsed, err = template.Parse("Login to phobia.cloud\n2015-03-23 17:39:22 +00:00")
+	require.NoError(t, err)
+	assert.True(t, now.Equal(parsed))
+
+	for _, tt := range []struct {
+		visual string
+		err    string
+	}{
+		{"2015-03-23 17:39:22 +00:00", "challenge visual does not match template"},
+		{"Login to phobia.club\n2015-03-23 17:39:22 +00:00", "challenge visual does not match template"},
+		{"Login to phobia.cloud", "challenge visual does not match template"},
+		{"Login to phobia.cloud\n2015-03-23 17:39:22", `failed to parse challenge visual: parsing time "2015-03-23 17:39:22" as "2006-01-02 15:04:05 -07:00": cannot parse "" as "-07:00"`},
+	} {
+		_, err := template.Parse(tt.visual)
+		assert.EqualError(t, err, tt.err, tt.visual)
+	}
+}
+
+func TestVisualTemplate_CheckFresh(t *testing.T) {
+	template := login.VisualTemplate{Layout: "2006-01-02 15:04 -07:00"}
+	issued := time.Date(2015, time.March, 23, 17, 39, 22, 0, time.UTC)
+
+	visual, err := template.Render(issued)
+	require.NoError(t, err)
+	assert.Equal(t, "2015-03-23 17:39 +00:00", visual)
+
+	for _, tt := range []struct {
+		now time.Time
+		err string
+	}{
+		{now: issued},
+		{now: issued.Add(5 * time.Minute)},
+		{now: issued.Add(-30 * time.Second)},
+		{now: issued.Add(7 * time.Minute), err: "challenge visual has expired"},
+		{now: issued.Add(-2 * time.Minute), err: "challenge visual is in the future"},
+	} {
+		err := template.CheckFresh(visual, tt.now, 5*time.Minute)
+		if tt.err == "" {
+			assert.NoError(t, err, tt.now)
+		} else {
+			assert.EqualError(t, err, tt.err, tt.now)
+		}
+	}
+
+	err = template.CheckFresh("yesterday", issued, 5*time.Minute)
+	assert.Error(t, err)
+}
@@ -4,15 +4,393 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/audit"
+	"phobia.cloud/api/config"
 	"phobia.cloud/api/handler"
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webhook"
 )
 
+var mainLog = logging.Component("main")
+
 func main() {
-	http.HandleFunc("/challenge", handler.Challenge)
-	http.HandleFunc("/login", handler.Login)
+	flags := config.NewFlags(flag.CommandLine)
+	printConfig := flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	verifyAudit := flag.Bool("verify-audit", false, "verify the hash chain of the audit log and exit")
+	flag.Parse()
+
+	// route the log package of the standard library to the structured log
+	log.SetFlags(0)
+	log.SetOutput(logging.Component("app").Writer())
+
+	cfg, err := flags.Load(os.LookupEnv)
+	if err != nil {
+		fatal(err)
+	}
+	levels, err := cfg.LogLevels()
+	if err != nil {
+		fatal(err)
+	}
+	logging.SetLevels(levels)
+
+	if *printConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *verifyAudit {
+		err = verifyAuditLog(cfg, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	raw, err := cfg.OpenStore()
+	if err != nil {
+		fatal(err)
+	}
+	s := raw
+	health := &server.Health{}
+	if c, ok := raw.(server.Checker); ok {
+		health.Register("store", c)
+	}
+	registry := metrics.NewRegistry()
+
+	exporter, err := cfg.TracingExporter()
+	if err != nil {
+		fatal(err)
+	}
+	var tracer *tracing.Tracer
+	if exporter != nil {
+		tracer = tracing.NewTracer(exporter)
+		s = store.NewTraced(raw)
+	}
+
+	powKey, err := cfg.PoWKey()
+	if err != nil {
+		fatal(err)
+	}
+	gate, err := pow.NewGate(powKey)
+	if err != nil {
+		fatal(err)
+	}
+
+	proxies, err := cfg.TrustedProxies()
+	if err != nil {
+		fatal(err)
+	}
+	auditLog, err := cfg.OpenAudit()
+	if err != nil {
+		fatal(err)
+	}
+	webhooks, err := cfg.WebhookDispatcher(s)
+	if err != nil {
+		fatal(err)
+	}
+
+	st := &state{
+		store:    s,
+		health:   health,
+		registry: registry,
+		tracer:   tracer,
+		limits:   ratelimit.NewMemory(),
+		detector: abuse.NewDetector(),
+		gate:     gate,
+		proxies:  proxies,
+		audit:    auditLog,
+		webhooks: webhooks,
+	}
+	lifecycle, err := newLifecycle(cfg, func() (*config.Config, error) { return flags.Load(os.LookupEnv) }, st)
+	if err != nil {
+		fatal(err)
+	}
+	if webhooks != nil {
+		// the dispatcher is closed before the store of its outbox
+		lifecycle.Closers = append(lifecycle.Closers, webhooks)
+	}
+	if c, ok := raw.(io.Closer); ok {
+		lifecycle.Closers = append(lifecycle.Closers, c)
+	}
+	if tracer != nil {
+		lifecycle.Closers = append(lifecycle.Closers, tracer)
+	}
+	if auditLog != nil {
+		lifecycle.Closers = append(lifecycle.Closers, auditLog)
+	}
+
+	err = lifecycle.Run(context.Background())
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	mainLog.Error("exiting", "error", err)
+	os.Exit(1)
+}
+
+// state is the state of the server that is kept across reloads of the
+// configuration.
+type state struct {
+	// store keeps the state of the handlers.
+	store store.Store
+	// health reports the readiness of the server.
+	health *server.Health
+	// registry records the metrics.
+	registry *metrics.Registry
+	// tracer traces the requests if it is not nil.
+	tracer *tracing.Tracer
+	// limits keeps the buckets of the rate limits.
+	limits ratelimit.Store
+	// detector tracks the failed logins.
+	detector *abuse.Detector
+	// gate issues the proof-of-work puzzles of challenges.
+	gate *pow.Gate
+	// proxies are the trusted reverse proxies.
+	proxies *server.TrustedProxies
+	// audit records the authentication events if it is not nil.
+	audit *audit.Log
+	// webhooks delivers the login and account events if it is not nil.
+	webhooks *webhook.Dispatcher
+}
+
+// newLifecycle returns the lifecycle of the servers of the API configured
+// by cfg with the state st. On SIGHUP, the configuration returned by load
+// replaces cfg.
+func newLifecycle(cfg *config.Config, load func() (*config.Config, error), st *state) (*server.Lifecycle, error) {
+	h, err := newHandler(cfg, st)
+	if err != nil {
+		return nil, err
+	}
+	swap := server.NewSwapHandler(h)
+
+	srv := server.NewHTTPServer(cfg.Listen, swap, cfg.Timeouts())
+	lifecycle := &server.Lifecycle{
+		Servers:         []*http.Server{srv},
+		ShutdownTimeout: cfg.HTTP.ShutdownTimeout,
+		Reload: func() error {
+			newCfg, err := load()
+			if err != nil {
+				return err
+			}
+			h, err := newHandler(newCfg, st)
+			if err != nil {
+				return err
+			}
+			levels, err := newCfg.LogLevels()
+			if err != nil {
+				return err
+			}
+			for _, key := range cfg.Changed(newCfg) {
+				if restartRequired(key) {
+					mainLog.Warn("setting takes effect after a restart", "key", key)
+				}
+			}
+			logging.SetLevels(levels)
+			swap.Swap(h)
+			// reloads run one at a time on the goroutine of Run, so the
+			// next one compares with this configuration without a lock
+			cfg = newCfg
+			return nil
+		},
+	}
+	if cfg.Proxy.Protocol {
+		// the servers listen on their own goroutines, so the timeout is
+		// copied rather than read from cfg, which is replaced by reloads
+		timeout := cfg.HTTP.ReadHeaderTimeout
+		lifecycle.Listen = func(addr string) (net.Listener, error) {
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return &server.ProxyProtocolListener{Listener: l, Proxies: st.proxies, Timeout: timeout}, nil
+		}
+	}
+
+	tlsPolicy, err := cfg.TLSPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if tlsPolicy != nil {
+		srv.TLSConfig, err = tlsPolicy.Config()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.TLS.Redirect != "" {
+			redirect := server.NewHTTPServer(cfg.TLS.Redirect, server.RedirectHTTPS(cfg.Listen), cfg.Timeouts())
+			lifecycle.Servers = append(lifecycle.Servers, redirect)
+		}
+	}
+	return lifecycle, nil
+}
+
+// newHandler returns the handler of the API configured by cfg with the
+// state st.
+func newHandler(cfg *config.Config, st *state) (http.Handler, error) {
+	visual, err := cfg.VisualTemplate()
+	if err != nil {
+		return nil, err
+	}
+	rateLimit, err := cfg.RateLimiter(st.limits)
+	if err != nil {
+		return nil, err
+	}
+	security, err := cfg.SecurityHeaders()
+	if err != nil {
+		return nil, err
+	}
+	lnurl := &handler.LNURLAuth{
+		Store:    st.store,
+		TTL:      cfg.Challenge.LNURLTTL,
+		Audit:    st.audit,
+		Webhooks: st.webhooks,
+	}
+	handlerMetrics := handler.NewMetrics(st.registry)
+
+	login := &handler.LoginHandler{
+		Origins:       cfg.Login.Origins,
+		RequireOrigin: cfg.Login.RequireOrigin,
+		Visual:        visual,
+		MaxAge:        cfg.Challenge.MaxAge,
+		Versions:      cfg.Login.Versions,
+		Metrics:       handlerMetrics,
+		Audit:         st.audit,
+		Webhooks:      st.webhooks,
+	}
+	challenges := &handler.Challenges{Store: st.store, TTL: cfg.Challenge.MaxAge}
+	challenge := &handler.ChallengeHandler{
+		Challenges:  challenges,
+		LNURL:       lnurl,
+		Visual:      visual,
+		Metrics:     handlerMetrics,
+		Audit:       st.audit,
+		AuditLimits: st.limits,
+	}
+	if cfg.PoW.Enabled {
+		challenge.PoW = st.gate
+		challenge.PoWPolicy = cfg.PoWPolicy()
+	}
+	nostr := &handler.NostrLogin{
+		Origins:    cfg.Login.Origins,
+		Challenges: challenges,
+		Audit:      st.audit,
+		Webhooks:   st.webhooks,
+	}
+	ethereum := &handler.EthereumLogin{
+		Origins:    cfg.Login.Origins,
+		Challenges: challenges,
+		Audit:      st.audit,
+		Webhooks:   st.webhooks,
+	}
+	var webAuthn *handler.WebAuthn
+	if cfg.WebAuthn.Enabled {
+		webAuthn = &handler.WebAuthn{
+			RelyingParty: cfg.RelyingParty(),
+			Credentials:  &webauthn.Credentials{Store: st.store},
+			Store:        st.store,
+			TTL:          cfg.Challenge.WebAuthnTTL,
+			Audit:        st.audit,
+			Webhooks:     st.webhooks,
+		}
+	}
+	api := &server.API{
+		Challenge: challenge,
+		Login:     login,
+		Nostr:     nostr,
+		Ethereum:  ethereum,
+		LNURL:     lnurl,
+		WebAuthn:  webAuthn,
+		CORS:      cfg.CORSPolicy(),
+		Health:    st.health,
+		Tracer:    st.tracer,
+		Viewer:    cfg.OpenAPI.Viewer,
+		RateLimit: rateLimit,
+		Proxies:   st.proxies,
+		Security:  security,
+
+		OperatorToken: cfg.Operator.Token,
+	}
+	if cfg.Metrics.Enabled {
+		api.Metrics = st.registry
+	}
+	if cfg.Abuse.Enabled {
+		policy := cfg.AbusePolicy()
+		login.Abuse, login.AbusePolicy = st.detector, policy
+		nostr.Abuse, nostr.AbusePolicy = st.detector, policy
+		ethereum.Abuse, ethereum.AbusePolicy = st.detector, policy
+		if webAuthn != nil {
+			webAuthn.Abuse, webAuthn.AbusePolicy = st.detector, policy
+		}
+		if cfg.Abuse.Endpoint {
+			api.Lockouts = st.detector
+		}
+	}
+	if cfg.Webhook.DeadLetters {
+		api.Webhooks = st.webhooks
+	}
+	return api.Handler(), nil
+}
+
+// verifyAuditLog verifies the hash chain of the audit log of cfg and prints
+// the number of records and the hash of the last one to w.
+func verifyAuditLog(cfg *config.Config, w io.Writer) error {
+	if cfg.Audit.Path == "" {
+		return errors.New("audit.path is not set")
+	}
+	key, err := cfg.AuditKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(cfg.Audit.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	summary, err := audit.Verify(f, key)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "audit log verified: %d records, head %s\n", summary.Records, summary.Head)
+	return err
+}
 
-	log.Fatal(http.ListenAndServe(":5050", nil))
+// restartRequired reports whether a change of the setting key takes effect
+// only after a restart. The other settings are applied on SIGHUP.
+func restartRequired(key string) bool {
+	switch key {
+	case "listen", "pow.key":
+		return true
+	case "webhook.dead_letters":
+		return false
+	}
+	for _, prefix := range []string{"http.", "tls.", "storage.", "tracing.", "proxy.", "audit.", "webhook."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/config"
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+)
+
+// TestLifecycle_Reload reloads the configuration while the server is
+// listening. Run it with -race to check that the server does not read the
+// replaced configuration.
+func TestLifecycle_Reload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	load := func() (*config.Config, error) {
+		cfg := config.Default()
+		cfg.Listen = addr
+		cfg.Proxy.Trusted = []string{"127.0.0.1"}
+		cfg.Proxy.Protocol = true
+		return cfg, nil
+	}
+	cfg, err := load()
+	require.NoError(t, err)
+
+	proxies, err := cfg.TrustedProxies()
+	require.NoError(t, err)
+	gate, err := pow.NewGate(nil)
+	require.NoError(t, err)
+	st := &state{
+		store:    store.NewMemory(),
+		health:   &server.Health{},
+		registry: metrics.NewRegistry(),
+		limits:   ratelimit.NewMemory(),
+		detector: abuse.NewDetector(),
+		gate:     gate,
+		proxies:  proxies,
+	}
+
+	lifecycle, err := newLifecycle(cfg, load, st)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lifecycle.Run(ctx) }()
+
+	// give the server time to listen; connecting to it would order its
+	// goroutine before the reloads and hide a race from the race detector
+	time.Sleep(200 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, lifecycle.Reload())
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,11 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package metrics provides counters, gauges and histograms that are exposed
+// in the Prometheus text format.
+//
+// Metrics are registered in a Registry, which serves them over HTTP.
+// Registering a metric again with the same name, type and labels returns the
+// registered metric, so components can register their metrics each time they
+// are created, e.g. when the configuration is reloaded.
+package metrics
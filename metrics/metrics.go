@@ -0,0 +1,162 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the buckets of latency
+// histograms.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The types of metrics as written in the TYPE line.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// metric is a metric with a series for each combination of label values.
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the state of a metric for a combination of label values.
+type series struct {
+	values []string
+	// value is the value of a counter or gauge, or the sum of the
+	// observations of a histogram.
+	value float64
+	// counts are the number of observations per bucket of a histogram, not
+	// cumulative. The last count is of the +Inf bucket.
+	counts []uint64
+	count  uint64
+}
+
+// with returns the series of the label values, creating it if needed. It
+// panics if the number of values does not match the labels. The caller must
+// hold m.mu.
+func (m *metric) with(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, values []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(values).value += v
+}
+
+// Counter is a metric that only increases, e.g. the number of requests.
+// The methods of a nil Counter do nothing.
+type Counter struct{ m *metric }
+
+// Inc increments the counter of the label values by one.
+func (c *Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// Add adds v to the counter of the label values. It panics if v is
+// negative.
+func (c *Counter) Add(v float64, values ...string) {
+	if c == nil {
+		return
+	}
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.m.name))
+	}
+	c.m.add(v, values)
+}
+
+// Gauge is a metric that can increase and decrease, e.g. the number of
+// requests in flight. The methods of a nil Gauge do nothing.
+type Gauge struct{ m *metric }
+
+// Inc increments the gauge of the label values by one.
+func (g *Gauge) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec decrements the gauge of the label values by one.
+func (g *Gauge) Dec(values ...string) { g.Add(-1, values...) }
+
+// Add adds v to the gauge of the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	if g == nil {
+		return
+	}
+	g.m.add(v, values)
+}
+
+// Set sets the gauge of the label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	if g == nil {
+		return
+	}
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.with(values).value = v
+}
+
+// Histogram is a metric that counts observations in buckets, e.g. the
+// latency of requests. The methods of a nil Histogram do nothing.
+type Histogram struct{ m *metric }
+
+// Observe adds the observation v to the histogram of the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	if h == nil {
+		return
+	}
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	s := h.m.with(values)
+	i := sort.SearchFloat64s(h.m.buckets, v)
+	s.counts[i]++
+	s.count++
+	s.value += v
+}
+
+// ObserveSince adds the time elapsed since start in seconds to the histogram
+// of the label values.
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// validBuckets returns an error if buckets are not increasing or contain
+// +Inf, which is implied.
+func validBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("no buckets")
+	}
+	for i, b := range buckets {
+		if math.IsInf(b, 0) || math.IsNaN(b) {
+			return fmt.Errorf("invalid bucket %v", b)
+		}
+		if i > 0 && b <= buckets[i-1] {
+			return fmt.Errorf("buckets are not increasing")
+		}
+	}
+	return nil
+}
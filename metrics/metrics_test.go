@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package metrics_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/metrics"
+)
+
+// expose returns the metrics of r in the text format.
+func expose(t *testing.T, r *metrics.Registry) string {
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.Counter("logins_total", "Logins by outcome.", "outcome")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc("success")
+		}()
+	}
+	wg.Wait()
+	c.Add(2.5, "failure")
+
+	assert.Equal(t, "# HELP logins_total Logins by outcome.\n"+
+		"# TYPE logins_total counter\n"+
+		"logins_total{outcome=\"failure\"} 2.5\n"+
+		"logins_total{outcome=\"success\"} 10\n", expose(t, r))
+
+	assert.Panics(t, func() { c.Add(-1, "success") })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Inc("success", "extra") })
+}
+
+func TestGauge(t *testing.T) {
+	r := metrics.NewRegistry()
+	g := r.Gauge("in_flight", "Requests in flight.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	assert.Contains(t, expose(t, r), "\nin_flight 1\n")
+
+	g.Set(-3)
+	assert.Contains(t, expose(t, r), "\nin_flight -3\n")
+}
+
+func TestHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/login")
+	h.Observe(0.1, "/login")
+	h.Observe(0.5, "/login")
+	h.Observe(3, "/login")
+
+	assert.Equal(t, "# HELP latency_seconds Latency.\n"+
+		"# TYPE latency_seconds histogram\n"+
+		"latency_seconds_bucket{route=\"/login\",le=\"0.1\"} 2\n"+
+		"latency_seconds_bucket{route=\"/login\",le=\"1\"} 3\n"+
+		"latency_seconds_bucket{route=\"/login\",le=\"+Inf\"} 4\n"+
+		"latency_seconds_sum{route=\"/login\"} 3.65\n"+
+		"latency_seconds_count{route=\"/login\"} 4\n", expose(t, r))
+}
+
+func TestNilMetrics(t *testing.T) {
+	var c *metrics.Counter
+	var g *metrics.Gauge
+	var h *metrics.Histogram
+	assert.NotPanics(t, func() {
+		c.Inc("any")
+		g.Set(1)
+		h.Observe(1)
+	})
+}
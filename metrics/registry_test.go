@@ -0,0 +1,61 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/metrics"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.Counter("requests_total", "Requests.", "route")
+	c.Inc("/login")
+
+	// registering again returns the registered metric
+	r.Counter("requests_total", "Requests.", "route").Inc("/login")
+	assert.Contains(t, expose(t, r), "requests_total{route=\"/login\"} 2\n")
+
+	for _, tt := range []struct {
+		name     string
+		register func()
+	}{
+		{"other type", func() { r.Gauge("requests_total", "Requests.", "route") }},
+		{"other labels", func() { r.Counter("requests_total", "Requests.", "path") }},
+		{"invalid name", func() { r.Counter("requests-total", "Requests.") }},
+		{"invalid label", func() { r.Counter("other_total", "Other.", "1route") }},
+		{"reserved label", func() { r.Counter("other_total", "Other.", "__name") }},
+		{"le label", func() { r.Histogram("other_seconds", "Other.", metrics.DefaultBuckets, "le") }},
+		{"no buckets", func() { r.Histogram("other_seconds", "Other.", nil) }},
+		{"unsorted buckets", func() { r.Histogram("other_seconds", "Other.", []float64{1, 0.5}) }},
+	} {
+		assert.Panics(t, tt.register, tt.name)
+	}
+}
+
+func TestRegistry_Escaping(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("errors_total", "Errors by \\ reason\nand more.", "reason").Inc("bad \"value\"\n\\")
+
+	assert.Equal(t, "# HELP errors_total Errors by \\\\ reason\\nand more.\n"+
+		"# TYPE errors_total counter\n"+
+		"errors_total{reason=\"bad \\\"value\\\"\\n\\\\\"} 1\n", expose(t, r))
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("b_total", "B.").Inc()
+	r.Gauge("a", "A.")
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, metrics.ContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, "# HELP a A.\n# TYPE a gauge\n# HELP b_total B.\n# TYPE b_total counter\nb_total 1\n", rr.Body.String())
+}
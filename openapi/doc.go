@@ -0,0 +1,12 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package openapi provides the OpenAPI 3.1 document of the API and a viewer
+// for it.
+//
+// The document in openapi.json is written by hand, so it can carry
+// descriptions and constraints that Go types cannot express. The tests of
+// the server compare it with the routes and with the schemas that a
+// Reflector derives from the request and response types of the handlers, so
+// they fail when the document and the code drift apart.
+package openapi
@@ -0,0 +1,69 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package openapi
+
+import (
+	"crypto/sha256"
+	_ "embed" // for the document and the viewer
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"phobia.cloud/api/logging"
+)
+
+//go:embed openapi.json
+var document []byte
+
+//go:embed viewer.html
+var viewer []byte
+
+var openapiLog = logging.Component("openapi")
+
+// Document returns the OpenAPI document of the API in JSON.
+func Document() []byte {
+	return append([]byte(nil), document...)
+}
+
+// ServeDocument is a HTTP handler that answers with the OpenAPI document.
+// Any origin may read it, so tools on other sites can load it.
+func ServeDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	serve(w, r, "application/json", document)
+}
+
+// ServeViewer is a HTTP handler that answers with an HTML page that renders
+// the OpenAPI document served at /openapi.json. The page has no external
+// dependencies.
+func ServeViewer(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, "text/html; charset=utf-8", viewer)
+}
+
+// ViewerContentSecurityPolicy returns the Content-Security-Policy of the
+// viewer. It allows only the inline script and style of the viewer, by their
+// hashes, and fetching the document from the same origin.
+func ViewerContentSecurityPolicy() string {
+	return "default-src 'none'; script-src " + inlineHash("script") + "; style-src " + inlineHash("style") +
+		"; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+}
+
+// inlineHash returns the hash source of the content of the inline element
+// tag of the viewer.
+func inlineHash(tag string) string {
+	page := string(viewer)
+	start := strings.Index(page, "<"+tag+">") + len(tag) + 2
+	end := strings.Index(page, "</"+tag+">")
+	sum := sha256.Sum256([]byte(page[start:end]))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+func serve(w http.ResponseWriter, r *http.Request, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err := w.Write(data)
+	if err != nil {
+		openapiLog.Context(r.Context()).Warn("error writing response to client", "error", err)
+	}
+}
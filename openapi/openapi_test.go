@@ -0,0 +1,94 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package openapi_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/openapi"
+)
+
+func TestDocument(t *testing.T) {
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(openapi.Document(), &doc))
+	assert.Equal(t, "3.1.0", doc["openapi"])
+
+	// every reference resolves
+	refs := regexp.MustCompile(`"\$ref": "#/([^"]+)"`).FindAllStringSubmatch(string(openapi.Document()), -1)
+	require.NotEmpty(t, refs)
+	for _, ref := range refs {
+		var target interface{} = doc
+		for _, key := range strings.Split(ref[1], "/") {
+			m, ok := target.(map[string]interface{})
+			require.True(t, ok, ref[1])
+			target = m[key]
+		}
+		assert.NotNil(t, target, "unresolved reference %s", ref[1])
+	}
+
+	// operations are identified uniquely
+	ids := make(map[string]bool)
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method, op := range item.(map[string]interface{}) {
+			id, _ := op.(map[string]interface{})["operationId"].(string)
+			assert.NotEmpty(t, id, "%s %s", method, path)
+			assert.False(t, ids[id], "duplicate operation %s", id)
+			ids[id] = true
+		}
+	}
+
+	// the document cannot be modified by callers
+	openapi.Document()[0] = 'x'
+	assert.Equal(t, byte('{'), openapi.Document()[0])
+}
+
+func TestServeDocument(t *testing.T) {
+	rr := httptest.NewRecorder()
+	openapi.ServeDocument(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, openapi.Document(), rr.Body.Bytes())
+}
+
+func TestServeViewer(t *testing.T) {
+	rr := httptest.NewRecorder()
+	openapi.ServeViewer(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/docs", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	body := rr.Body.String()
+	assert.Contains(t, body, `fetch("/openapi.json")`)
+	assert.NotRegexp(t, `(src|href)="https?:`, body, "the viewer loads no external resources")
+}
+
+func TestViewerContentSecurityPolicy(t *testing.T) {
+	csp := openapi.ViewerContentSecurityPolicy()
+	assert.Contains(t, csp, "default-src 'none'")
+	assert.Contains(t, csp, "connect-src 'self'")
+
+	rr := httptest.NewRecorder()
+	openapi.ServeViewer(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/docs", nil))
+	body := rr.Body.String()
+
+	// the policy allows exactly the inline script and style
+	for _, tag := range []string{"script", "style"} {
+		blocks := regexp.MustCompile(`(?s)<`+tag+`>(.*?)</`+tag+`>`).FindAllStringSubmatch(body, -1)
+		require.Len(t, blocks, 1, tag)
+		sum := sha256.Sum256([]byte(blocks[0][1]))
+		assert.Contains(t, csp, tag+"-src 'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+	}
+	assert.NotRegexp(t, `\s(on[a-z]+|style)=`, body, "inline handlers and styles are not allowed by the policy")
+}
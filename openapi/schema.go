@@ -0,0 +1,137 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package openapi
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Schema is a JSON schema as used by OpenAPI 3.1.
+type Schema map[string]interface{}
+
+// ComponentRef returns the reference to the component schema name.
+func ComponentRef(name string) Schema {
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
+
+// Reflector derives the schemas of Go types from how encoding/json encodes
+// them. The schemas have only the structural keywords: type, properties,
+// required, items, additionalProperties, contentEncoding and $ref.
+type Reflector struct {
+	// Refs are the names of the component schemas of types. Values of
+	// these types reference the component instead of repeating its
+	// schema.
+	Refs map[reflect.Type]string
+	// Types are the schemas of types with a custom JSON encoding, e.g.
+	// types that implement json.Marshaler.
+	Types map[reflect.Type]Schema
+}
+
+// Schema returns the schema of t. The fields of structs are required unless
+// they are omitted when empty. Fields with slices and maps that are not
+// omitted when empty are nullable, as encoding/json encodes nil ones as
+// null.
+func (r *Reflector) Schema(t reflect.Type) Schema {
+	if s, ok := r.Types[t]; ok {
+		return copySchema(s)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Ptr:
+		return r.ref(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": r.ref(t.Elem())}
+	case reflect.Array:
+		return Schema{"type": "array", "items": r.ref(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": r.ref(t.Elem())}
+	case reflect.Struct:
+		return r.object(t)
+	default:
+		return Schema{}
+	}
+}
+
+// ref returns the reference to the component of t, or the schema of t if it
+// is not a component.
+func (r *Reflector) ref(t reflect.Type) Schema {
+	if name, ok := r.Refs[t]; ok {
+		return ComponentRef(name)
+	}
+	return r.Schema(t)
+}
+
+func (r *Reflector) object(t reflect.Type) Schema {
+	properties := Schema{}
+	var required []interface{}
+	r.fields(t, properties, &required)
+
+	s := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// fields adds the schemas of the encoded fields of the struct type t to
+// properties, including the fields of embedded structs.
+func (r *Reflector) fields(t reflect.Type, properties Schema, required *[]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			r.fields(f.Type, properties, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := r.ref(f.Type)
+		omitEmpty := strings.Contains(","+opts+",", ",omitempty,")
+		_, custom := r.Types[f.Type]
+		switch f.Type.Kind() {
+		case reflect.Slice, reflect.Map:
+			if !omitEmpty && !custom && s["type"] != nil {
+				s["type"] = []interface{}{s["type"], "null"}
+			}
+		}
+		properties[name] = s
+		if !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func copySchema(s Schema) Schema {
+	c := make(Schema, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package openapi_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/openapi"
+)
+
+type hexBytes []byte
+
+type embedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type item struct {
+	Name string `json:"name"`
+}
+
+type example struct {
+	embedded
+	Name     string            `json:"name"`
+	Count    int64             `json:"count,omitempty"`
+	Ratio    float64           `json:"ratio"`
+	Enabled  bool              `json:"enabled"`
+	Data     []byte            `json:"data"`
+	Hex      hexBytes          `json:"hex"`
+	Items    []item            `json:"items"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Parent   *item             `json:"parent,omitempty"`
+	Inline   struct{ A int }   `json:"inline"`
+	Untagged string
+	Ignored  string `json:"-"`
+	private  string
+}
+
+func TestReflector(t *testing.T) {
+	r := &openapi.Reflector{
+		Refs: map[reflect.Type]string{
+			reflect.TypeOf(item{}):     "Item",
+			reflect.TypeOf(hexBytes{}): "Hex",
+		},
+		Types: map[reflect.Type]openapi.Schema{
+			reflect.TypeOf(hexBytes{}): {"type": "string", "contentEncoding": "base16"},
+		},
+	}
+
+	assert.Equal(t, openapi.Schema{
+		"type": "object",
+		"properties": openapi.Schema{
+			"embedded": openapi.Schema{"type": "string"},
+			"name":     openapi.Schema{"type": "string"},
+			"count":    openapi.Schema{"type": "integer"},
+			"ratio":    openapi.Schema{"type": "number"},
+			"enabled":  openapi.Schema{"type": "boolean"},
+			"data":     openapi.Schema{"type": []interface{}{"string", "null"}, "contentEncoding": "base64"},
+			"hex":      openapi.ComponentRef("Hex"),
+			"items":    openapi.Schema{"type": []interface{}{"array", "null"}, "items": openapi.ComponentRef("Item")},
+			"tags":     openapi.Schema{"type": "array", "items": openapi.Schema{"type": "string"}},
+			"labels":   openapi.Schema{"type": []interface{}{"object", "null"}, "additionalProperties": openapi.Schema{"type": "string"}},
+			"parent":   openapi.ComponentRef("Item"),
+			"inline": openapi.Schema{
+				"type":       "object",
+				"properties": openapi.Schema{"A": openapi.Schema{"type": "integer"}},
+				"required":   []interface{}{"A"},
+			},
+			"Untagged": openapi.Schema{"type": "string"},
+		},
+		"required": []interface{}{"embedded", "name", "ratio", "enabled", "data", "hex", "items", "labels", "inline", "Untagged"},
+	}, r.Schema(reflect.TypeOf(example{})))
+
+	// components are expanded at the top level
+	assert.Equal(t, openapi.Schema{
+		"type":       "object",
+		"properties": openapi.Schema{"name": openapi.Schema{"type": "string"}},
+		"required":   []interface{}{"name"},
+	}, r.Schema(reflect.TypeOf(item{})))
+	assert.Equal(t, openapi.Schema{"type": "string", "contentEncoding": "base16"}, r.Schema(reflect.TypeOf(hexBytes{})))
+
+	// an empty reflector inlines everything
+	assert.Equal(t, openapi.Schema{
+		"type": "array",
+		"items": openapi.Schema{
+			"type":       "object",
+			"properties": openapi.Schema{"name": openapi.Schema{"type": "string"}},
+			"required":   []interface{}{"name"},
+		},
+	}, (&openapi.Reflector{}).Schema(reflect.TypeOf([]item{})))
+}
@@ -0,0 +1,15 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package pow provides a hashcash-style proof-of-work gate.
+//
+// A Gate issues puzzles of a salt and a difficulty. A client solves a
+// puzzle by finding a nonce such that the SHA-256 hash of the salt, a colon
+// and the nonce starts with at least difficulty zero bits, which takes about
+// 2^difficulty hashes. Verifying a solution takes a single hash.
+//
+// The salt carries its difficulty and expiry and is authenticated with the
+// key of the Gate, so puzzles are not stored. A solution is accepted only
+// once. The difficulty grows by one bit with each doubling of the rate of
+// solved puzzles over the target rate of the Policy.
+package pow
@@ -0,0 +1,157 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// sweepInterval is how often Gate forgets the solved puzzles that
+	// expired.
+	sweepInterval = time.Minute
+	// rateWindow is the window of the rate of solved puzzles.
+	rateWindow = time.Minute
+	// macSize is the size of the truncated HMAC of a salt.
+	macSize = 16
+	// saltSize is the size of a decoded salt: the expiry, the difficulty,
+	// random bytes and the HMAC of the other fields.
+	saltSize = 8 + 1 + 16 + macSize
+)
+
+// Gate issues and verifies puzzles. It remembers the solved puzzles and the
+// rate of solutions in memory, so they are not shared between processes.
+type Gate struct {
+	key []byte
+
+	mu        sync.Mutex
+	solved    map[string]time.Time
+	lastSweep time.Time
+	// solutions are counted in fixed windows. The rate is interpolated
+	// between the previous and the current window.
+	window   time.Time
+	current  int
+	previous int
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewGate returns a Gate that authenticates its salts with key. Gates that
+// share the key accept the puzzles of each other. If key is empty, a random
+// key is used.
+func NewGate(key []byte) (*Gate, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %v", err)
+		}
+	}
+	return &Gate{
+		key:    key,
+		solved: make(map[string]time.Time),
+		now:    time.Now,
+	}, nil
+}
+
+// Puzzle returns a new puzzle with the current difficulty of p.
+func (g *Gate) Puzzle(p Policy) (Puzzle, error) {
+	g.mu.Lock()
+	now := g.now()
+	difficulty := p.difficulty(g.rate(now))
+	g.mu.Unlock()
+
+	expires := now.Add(p.TTL).Truncate(time.Second)
+	salt := make([]byte, saltSize)
+	binary.BigEndian.PutUint64(salt, uint64(expires.Unix()))
+	salt[8] = byte(difficulty)
+	_, err := rand.Read(salt[9 : saltSize-macSize])
+	if err != nil {
+		return Puzzle{}, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	copy(salt[saltSize-macSize:], g.mac(salt[:saltSize-macSize]))
+
+	return Puzzle{
+		Salt:       base64.RawURLEncoding.EncodeToString(salt),
+		Difficulty: difficulty,
+		Expires:    expires,
+	}, nil
+}
+
+// Verify checks that nonce solves the puzzle of salt and that the puzzle
+// was not solved before. It returns ErrInvalid, ErrExpired or ErrReused if
+// not.
+func (g *Gate) Verify(salt, nonce string) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(salt)
+	if err != nil || len(decoded) != saltSize || len(nonce) > maxNonceLength {
+		return ErrInvalid
+	}
+	if !hmac.Equal(decoded[saltSize-macSize:], g.mac(decoded[:saltSize-macSize])) {
+		return ErrInvalid
+	}
+	if zeroBits(hash(salt, nonce)) < int(decoded[8]) {
+		return ErrInvalid
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	expires := time.Unix(int64(binary.BigEndian.Uint64(decoded)), 0)
+	if !now.Before(expires) {
+		return ErrExpired
+	}
+
+	g.sweep(now)
+	if _, ok := g.solved[salt]; ok {
+		return ErrReused
+	}
+	g.solved[salt] = expires
+
+	g.rate(now)
+	g.current++
+	return nil
+}
+
+// mac returns the truncated HMAC of the fields of a salt.
+func (g *Gate) mac(fields []byte) []byte {
+	h := hmac.New(sha256.New, g.key)
+	h.Write(fields)
+	return h.Sum(nil)[:macSize]
+}
+
+// rate advances the windows to now and returns the rate of solved puzzles
+// per minute. It must be called with g.mu held.
+func (g *Gate) rate(now time.Time) float64 {
+	switch elapsed := now.Sub(g.window); {
+	case elapsed >= 2*rateWindow:
+		g.window, g.previous, g.current = now, 0, 0
+	case elapsed >= rateWindow:
+		g.window, g.previous, g.current = g.window.Add(rateWindow), g.current, 0
+	}
+	weight := 1 - float64(now.Sub(g.window))/float64(rateWindow)
+	return float64(g.previous)*weight + float64(g.current)
+}
+
+// sweep forgets the solved puzzles that expired. It must be called with
+// g.mu held.
+func (g *Gate) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < sweepInterval {
+		return
+	}
+	for salt, expires := range g.solved {
+		if !now.Before(expires) {
+			delete(g.solved, salt)
+		}
+	}
+	g.lastSweep = now
+}
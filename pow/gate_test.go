@@ -0,0 +1,128 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package pow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGate returns a gate and a function that advances its clock.
+func testGate(t *testing.T, key []byte) (*Gate, func(time.Duration)) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	g, err := NewGate(key)
+	require.NoError(t, err)
+	g.now = func() time.Time { return now }
+	return g, func(d time.Duration) { now = now.Add(d) }
+}
+
+var testPolicy = Policy{Difficulty: 8, MaxDifficulty: 10, TargetRate: 2, TTL: time.Minute}
+
+func TestGate(t *testing.T) {
+	g, _ := testGate(t, nil)
+
+	puzzle, err := g.Puzzle(testPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, 8, puzzle.Difficulty)
+	assert.Equal(t, time.Date(2021, 6, 1, 12, 1, 0, 0, time.UTC), puzzle.Expires)
+
+	nonce := Solve(puzzle.Salt, puzzle.Difficulty)
+	require.NoError(t, g.Verify(puzzle.Salt, nonce))
+	assert.Equal(t, ErrReused, g.Verify(puzzle.Salt, nonce))
+
+	other, err := g.Puzzle(testPolicy)
+	require.NoError(t, err)
+	assert.NotEqual(t, puzzle.Salt, other.Salt)
+	for i := 0; ; i++ {
+		// find a nonce that does not solve the puzzle
+		nonce := string(rune('a' + i))
+		if zeroBits(hash(other.Salt, nonce)) < other.Difficulty {
+			assert.Equal(t, ErrInvalid, g.Verify(other.Salt, nonce))
+			break
+		}
+	}
+}
+
+func TestGate_Invalid(t *testing.T) {
+	g, _ := testGate(t, []byte("key"))
+	puzzle, err := g.Puzzle(testPolicy)
+	require.NoError(t, err)
+
+	// a tampered salt fails its mac
+	salt := []byte(puzzle.Salt)
+	salt[12] ^= 1
+
+	other, _ := testGate(t, []byte("other key"))
+	for name, verify := range map[string]func() error{
+		"malformed salt":    func() error { return g.Verify("not base64!", "0") },
+		"short salt":        func() error { return g.Verify("c2FsdA", Solve("c2FsdA", 0)) },
+		"tampered salt":     func() error { return g.Verify(string(salt), Solve(string(salt), 0)) },
+		"long nonce":        func() error { return g.Verify(puzzle.Salt, string(make([]byte, 65))) },
+		"key of other gate": func() error { return other.Verify(puzzle.Salt, Solve(puzzle.Salt, puzzle.Difficulty)) },
+	} {
+		assert.Equal(t, ErrInvalid, verify(), name)
+	}
+
+	shared, _ := testGate(t, []byte("key"))
+	assert.NoError(t, shared.Verify(puzzle.Salt, Solve(puzzle.Salt, puzzle.Difficulty)), "gates with the same key share puzzles")
+}
+
+func TestGate_Expired(t *testing.T) {
+	g, advance := testGate(t, nil)
+	puzzle, err := g.Puzzle(testPolicy)
+	require.NoError(t, err)
+	nonce := Solve(puzzle.Salt, puzzle.Difficulty)
+
+	advance(testPolicy.TTL)
+	assert.Equal(t, ErrExpired, g.Verify(puzzle.Salt, nonce))
+}
+
+func TestGate_Adaptive(t *testing.T) {
+	g, advance := testGate(t, nil)
+
+	solve := func(n int) {
+		for i := 0; i < n; i++ {
+			puzzle, err := g.Puzzle(testPolicy)
+			require.NoError(t, err)
+			require.NoError(t, g.Verify(puzzle.Salt, Solve(puzzle.Salt, puzzle.Difficulty)))
+		}
+	}
+	difficulty := func() int {
+		puzzle, err := g.Puzzle(testPolicy)
+		require.NoError(t, err)
+		return puzzle.Difficulty
+	}
+
+	solve(2)
+	assert.Equal(t, 8, difficulty())
+	solve(1)
+	assert.Equal(t, 9, difficulty())
+	solve(10)
+	assert.Equal(t, 10, difficulty(), "the difficulty is capped")
+
+	// the rate of the previous window fades out
+	advance(90 * time.Second)
+	assert.Equal(t, 10, difficulty())
+	advance(20 * time.Second)
+	assert.Equal(t, 9, difficulty())
+	advance(time.Minute)
+	assert.Equal(t, 8, difficulty())
+}
+
+func TestGate_Sweep(t *testing.T) {
+	g, advance := testGate(t, nil)
+	puzzle, err := g.Puzzle(testPolicy)
+	require.NoError(t, err)
+	require.NoError(t, g.Verify(puzzle.Salt, Solve(puzzle.Salt, puzzle.Difficulty)))
+	assert.Len(t, g.solved, 1)
+
+	advance(testPolicy.TTL)
+	puzzle, err = g.Puzzle(testPolicy)
+	require.NoError(t, err)
+	require.NoError(t, g.Verify(puzzle.Salt, Solve(puzzle.Salt, puzzle.Difficulty)))
+	assert.Len(t, g.solved, 1)
+}
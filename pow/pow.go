@@ -0,0 +1,109 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package pow
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+	"strconv"
+	"time"
+)
+
+// Algorithm is the hash algorithm of the puzzles.
+const Algorithm = "sha256"
+
+// maxNonceLength is the maximum length of the nonce of a solution.
+const maxNonceLength = 64
+
+// Errors of Gate.Verify.
+var (
+	// ErrInvalid is a solution that does not solve a puzzle of the Gate.
+	ErrInvalid = errors.New("invalid proof of work")
+	// ErrExpired is a solution of an expired puzzle.
+	ErrExpired = errors.New("proof of work has expired")
+	// ErrReused is a solution of a puzzle that was already solved.
+	ErrReused = errors.New("proof of work was already used")
+)
+
+// Policy configures the difficulty and lifetime of the puzzles of a Gate.
+type Policy struct {
+	// Difficulty is the number of leading zero bits of the solutions while
+	// the rate of solved puzzles is at most TargetRate.
+	Difficulty int
+	// MaxDifficulty caps the difficulty at high rates.
+	MaxDifficulty int
+	// TargetRate is the number of solved puzzles per minute above which
+	// the difficulty grows by one bit per doubling of the rate.
+	TargetRate int
+	// TTL is how long a puzzle can be solved.
+	TTL time.Duration
+}
+
+// DefaultPolicy is the default Policy. A solution of the base difficulty
+// takes about 65 thousand hashes.
+var DefaultPolicy = Policy{
+	Difficulty:    16,
+	MaxDifficulty: 24,
+	TargetRate:    60,
+	TTL:           2 * time.Minute,
+}
+
+// Validate checks that the difficulty can be encoded in a salt and the
+// puzzles can be solved.
+func (p Policy) Validate() error {
+	switch {
+	case p.Difficulty < 0 || p.MaxDifficulty < p.Difficulty || p.MaxDifficulty > 255:
+		return errors.New("difficulty must be between 0 and the max difficulty, which is at most 255")
+	case p.TargetRate <= 0:
+		return errors.New("target rate must be positive")
+	case p.TTL <= 0:
+		return errors.New("ttl must be positive")
+	}
+	return nil
+}
+
+// difficulty returns the difficulty at rate solved puzzles per minute.
+func (p Policy) difficulty(rate float64) int {
+	d := p.Difficulty
+	for r := rate; r > float64(p.TargetRate) && d < p.MaxDifficulty; r /= 2 {
+		d++
+	}
+	return d
+}
+
+// Puzzle is a puzzle issued by a Gate.
+type Puzzle struct {
+	Salt       string
+	Difficulty int
+	Expires    time.Time
+}
+
+// hash returns the hash of the solution of salt with nonce.
+func hash(salt, nonce string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(salt + ":" + nonce))
+}
+
+// zeroBits returns the number of leading zero bits of h.
+func zeroBits(h [sha256.Size]byte) int {
+	n := 0
+	for _, b := range h {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Solve returns a nonce that solves the puzzle of salt and difficulty. It is
+// the work of a client.
+func Solve(salt string, difficulty int) string {
+	for i := uint64(0); ; i++ {
+		nonce := strconv.FormatUint(i, 36)
+		if zeroBits(hash(salt, nonce)) >= difficulty {
+			return nonce
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package pow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZeroBits(t *testing.T) {
+	for _, tt := range []struct {
+		prefix []byte
+		bits   int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x7f}, 9},
+		{[]byte{0x00, 0x00, 0x10}, 19},
+	} {
+		var h [32]byte
+		copy(h[:], tt.prefix)
+		assert.Equal(t, tt.bits, zeroBits(h), "%x", tt.prefix)
+	}
+	assert.Equal(t, 256, zeroBits([32]byte{}))
+}
+
+func TestSolve(t *testing.T) {
+	for _, difficulty := range []int{0, 4, 12} {
+		nonce := Solve("salt", difficulty)
+		assert.GreaterOrEqual(t, zeroBits(hash("salt", nonce)), difficulty)
+	}
+}
+
+func TestPolicy_Difficulty(t *testing.T) {
+	p := Policy{Difficulty: 10, MaxDifficulty: 13, TargetRate: 60, TTL: time.Minute}
+	for _, tt := range []struct {
+		rate       float64
+		difficulty int
+	}{
+		{0, 10},
+		{60, 10},
+		{61, 11},
+		{120, 11},
+		{121, 12},
+		{400, 13},
+		{1e9, 13},
+	} {
+		assert.Equal(t, tt.difficulty, p.difficulty(tt.rate), "rate %v", tt.rate)
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate())
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*Policy)
+		err    string
+	}{
+		{"max under base", func(p *Policy) { p.MaxDifficulty = 8 }, "difficulty must be between 0 and the max difficulty, which is at most 255"},
+		{"max over byte", func(p *Policy) { p.MaxDifficulty = 256 }, "difficulty must be between 0 and the max difficulty, which is at most 255"},
+		{"no target rate", func(p *Policy) { p.TargetRate = 0 }, "target rate must be positive"},
+		{"no ttl", func(p *Policy) { p.TTL = 0 }, "ttl must be positive"},
+	} {
+		p := DefaultPolicy
+		tt.modify(&p)
+		assert.EqualError(t, p.Validate(), tt.err, tt.name)
+	}
+}
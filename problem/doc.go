@@ -0,0 +1,9 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package problem provides the error responses of the API in the
+// "application/problem+json" format of RFC 7807.
+//
+// Each problem has a stable machine-readable code, which clients should use
+// instead of the human-readable title and detail.
+package problem
@@ -0,0 +1,158 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package problem
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// TypePrefix is the prefix of the type URI of problems. The code of the
+// problem follows the prefix.
+const TypePrefix = "urn:phobia:problem:"
+
+// Codes of problems.
+const (
+	// MalformedRequest is a request body that cannot be decoded.
+	MalformedRequest = "malformed-request"
+	// InvalidRequest is a request with missing or invalid fields, which
+	// are listed in Problem.Errors.
+	InvalidRequest = "invalid-request"
+	// InvalidSignature is a signature that does not match the public key
+	// or the signed data.
+	InvalidSignature = "invalid-signature"
+	// UnsupportedVersion is an unknown version of the login challenge.
+	UnsupportedVersion = "unsupported-version"
+	// ChallengeExpired is a challenge that is too old or was already used.
+	ChallengeExpired = "challenge-expired"
+	// OriginNotAllowed is an origin that is not allowed by the server.
+	OriginNotAllowed = "origin-not-allowed"
+	// Unauthorized is a request without valid authentication.
+	Unauthorized = "unauthorized"
+	// CredentialCloned is an authenticator whose signature counter did not
+	// increase, which indicates a cloned credential.
+	CredentialCloned = "credential-cloned"
+	// Conflict is a resource that already exists.
+	Conflict = "conflict"
+	// NotFound is a resource or route that does not exist.
+	NotFound = "not-found"
+	// MethodNotAllowed is a method that is not allowed for the route.
+	MethodNotAllowed = "method-not-allowed"
+	// CORSRejected is a CORS preflight request that is not allowed.
+	CORSRejected = "cors-rejected"
+	// TooManyRequests is a request over the rate limit of the client.
+	TooManyRequests = "too-many-requests"
+	// ProofOfWorkRequired is a request without a valid proof of work.
+	ProofOfWorkRequired = "proof-of-work-required"
+	// Internal is an unexpected error of the server.
+	Internal = "internal-error"
+)
+
+// Codes of field errors.
+const (
+	// FieldMissing is a required field that is missing or empty.
+	FieldMissing = "missing"
+	// FieldInvalid is a field with an invalid value.
+	FieldInvalid = "invalid"
+)
+
+var titles = map[string]string{
+	MalformedRequest:    "Malformed request",
+	InvalidRequest:      "Invalid request",
+	InvalidSignature:    "Invalid signature",
+	UnsupportedVersion:  "Unsupported version",
+	ChallengeExpired:    "Challenge expired",
+	OriginNotAllowed:    "Origin not allowed",
+	Unauthorized:        "Unauthorized",
+	CredentialCloned:    "Credential may be cloned",
+	Conflict:            "Conflict",
+	NotFound:            "Not found",
+	MethodNotAllowed:    "Method not allowed",
+	CORSRejected:        "CORS request rejected",
+	TooManyRequests:     "Too many requests",
+	ProofOfWorkRequired: "Proof of work required",
+	Internal:            "Internal server error",
+}
+
+// Problem is a problem details object as defined by RFC 7807.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Code is the machine-readable code of the problem.
+	Code string `json:"code"`
+	// Errors are the errors of the individual fields of an
+	// InvalidRequest problem.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is an error of a single request field.
+type FieldError struct {
+	// Field is the name of the field as in the request.
+	Field string `json:"field"`
+	// Code is FieldMissing or FieldInvalid.
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// New returns a problem with the HTTP status, code and detail.
+func New(status int, code, detail string) *Problem {
+	title, ok := titles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	return &Problem{
+		Type:   TypePrefix + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Field adds an error of field to the problem and returns the problem.
+func (p *Problem) Field(field, code, detail string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Field: field, Code: code, Detail: detail})
+	return p
+}
+
+// Error implements error.
+func (p *Problem) Error() string {
+	var b strings.Builder
+	b.WriteString(p.Code)
+	if p.Detail != "" {
+		b.WriteString(": ")
+		b.WriteString(p.Detail)
+	}
+	for _, e := range p.Errors {
+		b.WriteString("; ")
+		b.WriteString(e.Field)
+		b.WriteString(" ")
+		b.WriteString(e.Code)
+	}
+	return b.String()
+}
+
+// Write writes p as the response to w.
+func Write(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+
+	err := json.NewEncoder(w).Encode(p)
+	if err != nil {
+		log.Printf("error writing response to client: %v", err)
+	}
+}
+
+// Error writes a problem with the HTTP status, code and detail as the
+// response to w.
+func Error(w http.ResponseWriter, status int, code, detail string) {
+	Write(w, New(status, code, detail))
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package problem_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/problem"
+)
+
+func TestNew(t *testing.T) {
+	p := problem.New(http.StatusBadRequest, problem.InvalidSignature, "signature does not match")
+	assert.Equal(t, &problem.Problem{
+		Type:   "urn:phobia:problem:invalid-signature",
+		Title:  "Invalid signature",
+		Status: http.StatusBadRequest,
+		Detail: "signature does not match",
+		Code:   problem.InvalidSignature,
+	}, p)
+	assert.EqualError(t, p, "invalid-signature: signature does not match")
+
+	// unknown codes take the title of the status
+	p = problem.New(http.StatusTeapot, "teapot", "")
+	assert.Equal(t, "I'm a teapot", p.Title)
+	assert.Equal(t, "urn:phobia:problem:teapot", p.Type)
+}
+
+func TestProblem_Field(t *testing.T) {
+	p := problem.New(http.StatusBadRequest, problem.InvalidRequest, "").
+		Field("publicKey", problem.FieldMissing, "").
+		Field("signature", problem.FieldInvalid, "odd length hex string")
+
+	assert.Equal(t, []problem.FieldError{
+		{Field: "publicKey", Code: problem.FieldMissing},
+		{Field: "signature", Code: problem.FieldInvalid, Detail: "odd length hex string"},
+	}, p.Errors)
+	assert.EqualError(t, p, "invalid-request; publicKey missing; signature invalid")
+}
+
+func TestWrite(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Content-Type", "application/json")
+
+	problem.Write(rr, problem.New(http.StatusBadRequest, problem.InvalidRequest, "request has missing or invalid fields").
+		Field("version", problem.FieldMissing, ""))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:phobia:problem:invalid-request",
+		"title": "Invalid request",
+		"status": 400,
+		"detail": "request has missing or invalid fields",
+		"code": "invalid-request",
+		"errors": [{"field": "version", "code": "missing"}]
+	}`, rr.Body.String())
+}
+
+func TestError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	problem.Error(rr, http.StatusInternalServerError, problem.Internal, "")
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.JSONEq(t, `{
+		"type": "urn:phobia:problem:internal-error",
+		"title": "Internal server error",
+		"status": 500,
+		"code": "internal-error"
+	}`, rr.Body.String())
+}
@@ -0,0 +1,9 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package qr provides a QR code encoder for rendering login URIs.
+//
+// The encoder supports all 40 versions and the four error correction levels
+// of the QR code Model 2 specification (ISO/IEC 18004). Data is always
+// encoded in byte mode.
+package qr
@@ -0,0 +1,631 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level is the error correction level of a QR code.
+type Level int
+
+const (
+	// L recovers about 7% of the codewords.
+	L Level = iota
+	// M recovers about 15% of the codewords.
+	M
+	// Q recovers about 25% of the codewords.
+	Q
+	// H recovers about 30% of the codewords.
+	H
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case L:
+		return "L"
+	case M:
+		return "M"
+	case Q:
+		return "Q"
+	case H:
+		return "H"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// formatBits returns the bits that identify the level in the format
+// information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// ParseLevel parses the name of an error correction level, case insensitive.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return L, nil
+	case "M":
+		return M, nil
+	case "Q":
+		return Q, nil
+	case "H":
+		return H, nil
+	default:
+		return 0, fmt.Errorf("invalid error correction level: %q", s)
+	}
+}
+
+const (
+	// MinVersion is the smallest QR code version.
+	MinVersion = 1
+	// MaxVersion is the largest QR code version.
+	MaxVersion = 40
+)
+
+// ErrTooLong is returned when the data does not fit in the largest version
+// at the requested error correction level.
+var ErrTooLong = errors.New("data too long for a qr code")
+
+// Code is an encoded QR code symbol.
+type Code struct {
+	// Version is the version of the symbol, between MinVersion and MaxVersion.
+	Version int
+	// Level is the error correction level of the symbol.
+	Level Level
+	// Mask is the mask pattern applied to the symbol, between 0 and 7.
+	Mask int
+	// Size is the width and height of the symbol in modules.
+	Size int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Black reports whether the module at column x and row y is dark. Modules
+// outside of the symbol are light.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Encode encodes data in the smallest QR code version that fits it at the
+// provided error correction level. The mask pattern is selected automatically.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, fmt.Errorf("invalid error correction level: %d", int(level))
+	}
+
+	version, dataBits := MinVersion, 0
+	for ; ; version++ {
+		if version > MaxVersion {
+			return nil, ErrTooLong
+		}
+		dataBits = 4 + charCountBits(version) + 8*len(data)
+		if dataBits <= 8*numDataCodewords(version, level) {
+			break
+		}
+	}
+
+	capacity := 8 * numDataCodewords(version, level)
+
+	var bb bitBuffer
+	bb.append(0x4, 4) // byte mode indicator
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	terminator := capacity - bb.len()
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-bb.len()%8)%8)
+	for pad := 0xEC; bb.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	c := newCode(version, level)
+	c.drawCodewords(c.addErrorCorrection(bb.bytes()))
+
+	minPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		penalty := c.penalty()
+		if minPenalty < 0 || penalty < minPenalty {
+			c.Mask, minPenalty = mask, penalty
+		}
+		// masks are involutions, so applying again restores the symbol
+		c.applyMask(mask)
+	}
+	c.applyMask(c.Mask)
+	c.drawFormatBits(c.Mask)
+
+	return c, nil
+}
+
+// newCode returns a symbol of the provided version with all function
+// patterns drawn.
+func newCode(version int, level Level) *Code {
+	size := 4*version + 17
+	c := &Code{
+		Version:    version,
+		Level:      level,
+		Size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.isFunction[i] = make([]bool, size)
+	}
+	c.drawFunctionPatterns()
+	return c
+}
+
+func (c *Code) setFunctionModule(x, y int, black bool) {
+	c.modules[y][x] = black
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunctionModule(6, i, i%2 == 0)
+		c.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.Size-4, 3)
+	c.drawFinderPattern(3, c.Size-4)
+
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// skip the three corners occupied by finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// reserve the format information area, drawn after masking
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinderPattern draws a finder pattern with its separator centered at
+// column x and row y.
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := chebyshev(dx, dy)
+			c.setFunctionModule(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignmentPattern draws an alignment pattern centered at column x and
+// row y.
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunctionModule(x+dx, y+dy, chebyshev(dx, dy) != 1)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInformation(c.Level, mask)
+
+	// first copy, around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.setFunctionModule(8, i, bit(bits, i))
+	}
+	c.setFunctionModule(8, 7, bit(bits, 6))
+	c.setFunctionModule(8, 8, bit(bits, 7))
+	c.setFunctionModule(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunctionModule(14-i, 8, bit(bits, i))
+	}
+
+	// second copy, split between the other two finder patterns
+	for i := 0; i < 8; i++ {
+		c.setFunctionModule(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunctionModule(8, c.Size-15+i, bit(bits, i))
+	}
+	c.setFunctionModule(8, c.Size-8, true) // dark module
+}
+
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	bits := versionInformation(c.Version)
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.setFunctionModule(a, b, bit(bits, i))
+		c.setFunctionModule(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords places the codewords in the data area following the zigzag
+// pattern, starting from the bottom right corner.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// skip the vertical timing pattern
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.isFunction[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = bit(int(codewords[i>>3]), 7-i&7)
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y][x] && maskBit(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	case 7:
+		return ((x+y)%2+x*y%3)%2 == 0
+	default:
+		panic(fmt.Sprintf("invalid mask: %d", mask))
+	}
+}
+
+// penalty computes the penalty score of the symbol as defined by the
+// specification for selecting the mask pattern.
+func (c *Code) penalty() int {
+	const (
+		n1 = 3
+		n2 = 3
+		n3 = 40
+		n4 = 10
+	)
+
+	result := 0
+
+	// adjacent modules of the same color in a row or column
+	for y := 0; y < c.Size; y++ {
+		result += runPenalty(c.Size, func(i int) bool { return c.modules[y][i] }, n1)
+		result += finderPenalty(c.Size, func(i int) bool { return c.modules[y][i] }, n3)
+	}
+	for x := 0; x < c.Size; x++ {
+		result += runPenalty(c.Size, func(i int) bool { return c.modules[i][x] }, n1)
+		result += finderPenalty(c.Size, func(i int) bool { return c.modules[i][x] }, n3)
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			color := c.modules[y][x]
+			if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+				result += n2
+			}
+		}
+	}
+
+	// balance of dark and light modules
+	dark := 0
+	for _, row := range c.modules {
+		for _, black := range row {
+			if black {
+				dark++
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * n4
+
+	return result
+}
+
+func runPenalty(size int, module func(int) bool, weight int) int {
+	result := 0
+	run := 1
+	for i := 1; i <= size; i++ {
+		if i < size && module(i) == module(i-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += weight + run - 5
+		}
+		run = 1
+	}
+	return result
+}
+
+// finderPenalty penalizes finder-like patterns 1:1:3:1:1 preceded or
+// followed by four light modules.
+func finderPenalty(size int, module func(int) bool, weight int) int {
+	pattern := []bool{true, false, true, true, true, false, true}
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < size && module(i) {
+				return false
+			}
+		}
+		return true
+	}
+
+	result := 0
+	for i := 0; i+len(pattern) <= size; i++ {
+		match := true
+		for j, black := range pattern {
+			if module(i+j) != black {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if light(i-4, i) {
+			result += weight
+		}
+		if light(i+len(pattern), i+len(pattern)+4) {
+			result += weight
+		}
+	}
+	return result
+}
+
+// addErrorCorrection splits the data codewords in blocks, appends the error
+// correction codewords to each block and interleaves the blocks.
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	numBlocks := errorCorrectionBlocks[c.Level][c.Version]
+	eccLen := errorCorrectionCodewordsPerBlock[c.Level][c.Version]
+	rawCodewords := numRawDataModules(c.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			// placeholder, skipped when interleaving
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// formatInformation returns the 15 format information bits for the level
+// and mask, protected by a BCH code.
+func formatInformation(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionInformation returns the 18 version information bits, protected by
+// a BCH code.
+func versionInformation(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// alignmentPositions returns the row and column coordinates of the centers of
+// the alignment patterns in ascending order.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, 4*version+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// numRawDataModules returns the number of modules available for data and
+// error correction codewords, including remainder bits.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// numDataCodewords returns the number of data codewords in a symbol of the
+// provided version and level.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		errorCorrectionCodewordsPerBlock[level][version]*errorCorrectionBlocks[level][version]
+}
+
+// charCountBits returns the length of the character count indicator in byte
+// mode.
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// reedSolomonDivisor returns the generator polynomial of the provided degree,
+// without the leading coefficient.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords for data.
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(2^8) modulo x^8+x^4+x^3+x^2+1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer struct {
+	bits []bool
+}
+
+func (bb *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		bb.bits = append(bb.bits, bit(value, i))
+	}
+}
+
+func (bb *bitBuffer) len() int {
+	return len(bb.bits)
+}
+
+func (bb *bitBuffer) bytes() []byte {
+	result := make([]byte, len(bb.bits)/8)
+	for i, b := range bb.bits {
+		if b {
+			result[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+	return result
+}
+
+func bit(value, i int) bool {
+	return (value>>uint(i))&1 != 0
+}
+
+func chebyshev(dx, dy int) int {
+	dx, dy = abs(dx), abs(dy)
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// errorCorrectionCodewordsPerBlock is indexed by level and version.
+var errorCorrectionCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// errorCorrectionBlocks is indexed by level and version.
+var errorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
@@ -0,0 +1,501 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode_RoundTrip(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"a",
+		"trezorlogin://phobia.cloud?callback=https%3A%2F%2Fphobia.cloud%2Flogin&" +
+			"challengeHidden=cd8552569d6e4509266ef137584d1e62c7579b5b8ed69bbafa4b864c6521e7c2&" +
+			"challengeVisual=2015-03-23+17%3A39%3A22",
+		string([]byte{0, 1, 2, 0xfe, 0xff}),
+	} {
+		for level := L; level <= H; level++ {
+			code, err := Encode([]byte(data), level)
+			require.NoError(t, err)
+			assert.Equal(t, level, code.Level)
+
+			decoded, err := decode(code.Size, code.Black)
+			require.NoError(t, err, "%q at %s", data, level)
+			assert.Equal(t, data, string(decoded))
+		}
+	}
+}
+
+func TestEncode_AllVersions(t *testing.T) {
+	for level := L; level <= H; level++ {
+		for version := MinVersion; version <= MaxVersion; version++ {
+			// the largest payload that fits the version
+			n := (8*numDataCodewords(version, level) - 4 - charCountBits(version)) / 8
+			data := bytes.Repeat([]byte{byte(version)}, n)
+
+			code, err := Encode(data, level)
+			require.NoError(t, err)
+			require.Equal(t, version, code.Version, level)
+			require.Equal(t, 4*version+17, code.Size)
+
+			decoded, err := decode(code.Size, code.Black)
+			require.NoError(t, err, "version %d at %s", version, level)
+			require.Equal(t, data, decoded)
+
+			if version < MaxVersion {
+				code, err = Encode(append(data, 0), level)
+				require.NoError(t, err)
+				require.Equal(t, version+1, code.Version, level)
+			} else {
+				_, err = Encode(append(data, 0), level)
+				require.Equal(t, ErrTooLong, err)
+			}
+		}
+	}
+}
+
+func TestEncode_ErrorCorrection(t *testing.T) {
+	data := []byte("https://phobia.cloud/login")
+	for level := L; level <= H; level++ {
+		code, err := Encode(data, level)
+		require.NoError(t, err)
+
+		// damage as many codewords in each block as the level can correct
+		numBlocks := errorCorrectionBlocks[level][code.Version]
+		correctable := errorCorrectionCodewordsPerBlock[level][code.Version] / 2
+		positions := dataPositions(code)
+		for i := 0; i < correctable*numBlocks; i++ {
+			x, y := positions[8*i][0], positions[8*i][1]
+			code.modules[y][x] = !code.modules[y][x]
+		}
+
+		decoded, err := decode(code.Size, code.Black)
+		require.NoError(t, err, level)
+		assert.Equal(t, data, decoded, level)
+	}
+}
+
+func TestEncode_InvalidLevel(t *testing.T) {
+	_, err := Encode(nil, H+1)
+	assert.EqualError(t, err, "invalid error correction level: 4")
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tt := range []struct {
+		s     string
+		level Level
+	}{
+		{"L", L}, {"m", M}, {"Q", Q}, {"h", H},
+	} {
+		level, err := ParseLevel(tt.s)
+		require.NoError(t, err)
+		assert.Equal(t, tt.level, level)
+		assert.Equal(t, string(tt.s[0]&^0x20), level.String())
+	}
+
+	_, err := ParseLevel("X")
+	assert.EqualError(t, err, `invalid error correction level: "X"`)
+}
+
+func TestFormatAndVersionInformation(t *testing.T) {
+	// values from the tables in the specification
+	assert.Equal(t, 0x77C4, formatInformation(L, 0))
+	assert.Equal(t, 0x5412, formatInformation(M, 0))
+	assert.Equal(t, 0x07C94, versionInformation(7))
+	assert.Equal(t, 0x28C69, versionInformation(40))
+}
+
+// symbol returns the modules of c as rows of "#" for dark and "." for light
+// modules.
+func symbol(c *Code) []string {
+	rows := make([]string, c.Size)
+	for y := range rows {
+		row := make([]byte, c.Size)
+		for x := range row {
+			row[x] = '.'
+			if c.Black(x, y) {
+				row[x] = '#'
+			}
+		}
+		rows[y] = string(row)
+	}
+	return rows
+}
+
+// TestEncode_KnownAnswer compares symbols with the ones of an independent
+// encoder (github.com/skip2/go-qrcode without the border). Encoders may
+// weigh the penalties of the masks differently, so the symbol is compared
+// with the mask of the reference applied.
+func TestEncode_KnownAnswer(t *testing.T) {
+	for _, tt := range []struct {
+		data    string
+		level   Level
+		version int
+		mask    int
+		symbol  []string
+	}{
+		{
+			data:    "https://phobia.cloud",
+			level:   M,
+			version: 2,
+			mask:    1,
+			symbol: []string{
+				"#######.##..#.#...#######",
+				"#.....#...#.###...#.....#",
+				"#.###.#.####.#.#..#.###.#",
+				"#.###.#...##.###..#.###.#",
+				"#.###.#...#..#....#.###.#",
+				"#.....#.##.##.#.#.#.....#",
+				"#######.#.#.#.#.#.#######",
+				".........#.##.##.........",
+				"#.#...##.#.#...##..#..#.#",
+				"#.#.#..###.....##.##.#.##",
+				"#.#.#.##.#..##.###.####.#",
+				"...#....#####..##....#...",
+				"..#####...##.##.#.#.....#",
+				".#.##..##.#..#.#..##...##",
+				"#####.#.####...#.....##.#",
+				"..#.....#....#...#####...",
+				"#####.##.#.###.######..#.",
+				"........#.#.#...#...#...#",
+				"#######.##..###.#.#.#...#",
+				"#.....#...###.###...#....",
+				"#.###.#...#..########...#",
+				"#.###.#......###.#..#.##.",
+				"#.###.#.##.#...#.#.###.##",
+				"#.....#......#..#..##....",
+				"#######.######..##...#..#",
+			},
+		},
+		{
+			// version 7 is the first with version information
+			data:    "https://phobia.cloud/login?callback=https://phobia.cloud/verify",
+			level:   H,
+			version: 7,
+			mask:    5,
+			symbol: []string{
+				"#######.#..####.###...##..#...#.....#.#######",
+				"#.....#..##....#..#.#..##.#.#..##..#..#.....#",
+				"#.###.#.###....#.#######...#######.#..#.###.#",
+				"#.###.#..#.#.#.###..###...#.##.###.##.#.###.#",
+				"#.###.#.##.#..#..#..#######.###.#.###.#.###.#",
+				"#.....#..#..#.#.....#...#......#......#.....#",
+				"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+				"........#..##.#..####...###.#...#####........",
+				".....##..#..###.##..#####.....##..#...#.#.#.#",
+				"####.#..#.###..######.###.#.#####.#######.##.",
+				".#.##.##..#.#...##...####.####..###.#.#..#.#.",
+				"#.#.#..#.###.#.#...#..###.##..#.##..#...###..",
+				".#....#.##.#.#.#..#.#####..#..#.####.#####..#",
+				"....#..##.##########.#.....##.##...###..#..##",
+				"####.##....#.#.####..#..#.###..####..####.#..",
+				"#.......##.###....#.#...###.......#.##...##..",
+				"####.###..##..#.##.#####....####.........#.##",
+				"#..##..##.#...#..#####...#..#.####.###.#..#.#",
+				".##.####.##...#..###..#...##.##..#..##.##.#.#",
+				"####...#.##.#.#.##..#.###..###..#.#####.#.##.",
+				"#...########..###########.###..#.#..#####...#",
+				"..#.#...#.###...#.#.#...###..#..#####...##...",
+				"###.#.#.###.#....##.#.#.#.##..#.###.#.#.##.#.",
+				"#..##...#...#..###.##...##.##...##..#...#####",
+				"#.##############.##.#######.....#.########...",
+				"###..#..##.#..#.##...##.##.##.##.#.##..#..#.#",
+				".##...###.....####.....##.....#.#.##.#..#.##.",
+				"#....#.......#..###...#.###.#.#..#.#....###..",
+				"...#..####..#..##...#.....#.#..#..#..##.##.##",
+				"##.#.#.###...##.##....#.#.####..##.#.###.#.##",
+				"#####.#.#.####.##..##.####.###.##....######.#",
+				"######.#..#..###..######.....#.#####..##.##..",
+				"..#..########.##....###..##.###...#..#..#..#.",
+				"#.##.#......##.#...#....####...#.###..#####..",
+				"....#.#####.##.#####..#...#..##.#####...#.##.",
+				".####...####.###..#.#..##.....#.#..#..######.",
+				"#..##.###....#.#.#..#####...###.#..######..#.",
+				"........#...##.#..#.#...#.#.###..#..#...##..#",
+				"#######..##..##..####.#.###...#####.#.#.#..#.",
+				"#.....#.#.##.#.###.##...#.####...#.##...###..",
+				"#.###.#..#.####...#######.#....#..#######....",
+				"#.###.#...###.###..#####.####....#.#...##.###",
+				"#.###.#..#.#.#...####.###.#.#.####..#..##..##",
+				"#.....#....##.#...#####.#.#####.#..#...####..",
+				"#######....#.#..#..#.##.#.####.#.###.###...#.",
+			},
+		},
+	} {
+		code, err := Encode([]byte(tt.data), tt.level)
+		require.NoError(t, err)
+		require.Equal(t, tt.version, code.Version, tt.data)
+
+		if code.Mask != tt.mask {
+			code.applyMask(code.Mask)
+			code.applyMask(tt.mask)
+			code.drawFormatBits(tt.mask)
+		}
+		assert.Equal(t, tt.symbol, symbol(code), tt.data)
+	}
+}
+
+// dataPositions returns the coordinates of the data modules of the symbol
+// in placement order.
+func dataPositions(c *Code) [][2]int {
+	var result [][2]int
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] {
+					result = append(result, [2]int{x, y})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// decode reads the data of a byte mode symbol from its modules, correcting
+// errors in the codewords if needed.
+func decode(size int, black func(x, y int) bool) ([]byte, error) {
+	if size < 21 || (size-17)%4 != 0 {
+		return nil, fmt.Errorf("invalid size: %d", size)
+	}
+	version := (size - 17) / 4
+
+	// read the first copy of the format information
+	format := 0
+	read := func(x, y, i int) {
+		if black(x, y) {
+			format |= 1 << uint(i)
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		read(8, i, i)
+	}
+	read(8, 7, 6)
+	read(8, 8, 7)
+	read(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		read(14-i, 8, i)
+	}
+
+	level, mask, best := L, 0, 16
+	for l := L; l <= H; l++ {
+		for m := 0; m < 8; m++ {
+			dist := 0
+			for diff := format ^ formatInformation(l, m); diff != 0; diff &= diff - 1 {
+				dist++
+			}
+			if dist < best {
+				level, mask, best = l, m, dist
+			}
+		}
+	}
+	if best > 3 {
+		return nil, errors.New("unreadable format information")
+	}
+
+	template := newCode(version, level)
+	var codewords []byte
+	var current byte
+	for i, pos := range dataPositions(template) {
+		x, y := pos[0], pos[1]
+		current <<= 1
+		if black(x, y) != maskBit(mask, x, y) {
+			current |= 1
+		}
+		if i%8 == 7 {
+			codewords = append(codewords, current)
+			current = 0
+		}
+	}
+
+	numBlocks := errorCorrectionBlocks[level][version]
+	eccLen := errorCorrectionCodewordsPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	codewords = codewords[:rawCodewords]
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortBlockLen-eccLen+1; i++ {
+		for j := range blocks {
+			if i == shortBlockLen-eccLen && j < numShortBlocks {
+				continue
+			}
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+
+	var data []byte
+	for i, block := range blocks {
+		err := correct(block, eccLen)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", i, err)
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+
+	var bits []bool
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, b>>uint(i)&1 == 1)
+		}
+	}
+	next := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v <<= 1
+			if bits[0] {
+				v |= 1
+			}
+			bits = bits[1:]
+		}
+		return v
+	}
+
+	if next(4) != 0x4 {
+		return nil, errors.New("not a byte mode segment")
+	}
+	n := next(charCountBits(version))
+	if 8*n > len(bits) {
+		return nil, errors.New("character count exceeds data")
+	}
+	result := make([]byte, n)
+	for i := range result {
+		result[i] = byte(next(8))
+	}
+	return result, nil
+}
+
+var gfExp, gfLog = func() (exp [512]byte, log [256]int) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(x, y byte) byte {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	return gfExp[gfLog[x]+gfLog[y]]
+}
+
+func gfInv(x byte) byte {
+	return gfExp[255-gfLog[x]]
+}
+
+// evaluate evaluates a polynomial with coefficients in ascending order.
+func evaluate(poly []byte, x byte) byte {
+	var result byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ poly[i]
+	}
+	return result
+}
+
+// correct corrects errors in a block in place with the Berlekamp-Massey
+// algorithm and the Forney formula.
+func correct(block []byte, eccLen int) error {
+	n := len(block)
+	syndromes := make([]byte, eccLen)
+	hasErrors := false
+	for j := range syndromes {
+		var s byte
+		for _, b := range block {
+			s = gfMul(s, gfExp[j]) ^ b
+		}
+		syndromes[j] = s
+		hasErrors = hasErrors || s != 0
+	}
+	if !hasErrors {
+		return nil
+	}
+
+	locator, prev := []byte{1}, []byte{1}
+	l, m, b := 0, 1, byte(1)
+	for i := 0; i < eccLen; i++ {
+		d := syndromes[i]
+		for j := 1; j <= l && j < len(locator); j++ {
+			d ^= gfMul(locator[j], syndromes[i-j])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		next := append([]byte{}, locator...)
+		coef := gfMul(d, gfInv(b))
+		for len(next) < len(prev)+m {
+			next = append(next, 0)
+		}
+		for j, p := range prev {
+			next[j+m] ^= gfMul(coef, p)
+		}
+		if 2*l <= i {
+			l, prev, b, m = i+1-l, locator, d, 1
+		} else {
+			m++
+		}
+		locator = next
+	}
+
+	omega := make([]byte, eccLen)
+	for i := range omega {
+		for j := 0; j <= i && j < len(locator); j++ {
+			omega[i] ^= gfMul(locator[j], syndromes[i-j])
+		}
+	}
+	derivative := make([]byte, len(locator))
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+
+	found := 0
+	for i := 0; i < n; i++ {
+		x := gfExp[(n-1-i)%255]
+		xInv := gfInv(x)
+		if evaluate(locator, xInv) != 0 {
+			continue
+		}
+		denominator := evaluate(derivative, xInv)
+		if denominator == 0 {
+			return errors.New("uncorrectable errors")
+		}
+		block[i] ^= gfMul(x, gfMul(evaluate(omega, xInv), gfInv(denominator)))
+		found++
+	}
+	if found != l {
+		return errors.New("uncorrectable errors")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// WriteSVG writes the symbol as an SVG image to w. Each module is drawn as a
+// unit square and border is the width of the light quiet zone in modules.
+func (c *Code) WriteSVG(w io.Writer, border int) error {
+	if border < 0 {
+		return fmt.Errorf("invalid border: %d", border)
+	}
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+border, y+border)
+			}
+		}
+	}
+
+	dim := c.Size + 2*border
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#ffffff"/>
+<path d="%s" fill="#000000"/>
+</svg>
+`, dim, dim, path.String())
+	return err
+}
+
+// Image returns the symbol as an image with scale pixels per module and a
+// light quiet zone of border modules.
+func (c *Code) Image(scale, border int) (image.Image, error) {
+	if scale < 1 {
+		return nil, fmt.Errorf("invalid scale: %d", scale)
+	}
+	if border < 0 {
+		return nil, fmt.Errorf("invalid border: %d", border)
+	}
+
+	dim := (c.Size + 2*border) * scale
+	img := image.NewPaletted(image.Rect(0, 0, dim, dim), color.Palette{color.White, color.Black})
+	for py := 0; py < dim; py++ {
+		for px := 0; px < dim; px++ {
+			if c.Black(px/scale-border, py/scale-border) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+	return img, nil
+}
+
+// PNG returns the symbol encoded as a PNG image. See Image for the meaning of
+// scale and border.
+func (c *Code) PNG(scale, border int) ([]byte, error) {
+	img, err := c.Image(scale, border)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package qr_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/qr"
+)
+
+func TestCode_WriteSVG(t *testing.T) {
+	code, err := qr.Encode([]byte("https://phobia.cloud"), qr.M)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = code.WriteSVG(&buf, 4)
+	require.NoError(t, err)
+
+	svg := buf.String()
+	assert.True(t, strings.HasPrefix(svg, `<?xml version="1.0" encoding="UTF-8"?>`))
+	assert.Contains(t, svg, `viewBox="0 0 33 33"`)
+	// top left module of the top left finder pattern
+	assert.Contains(t, svg, `d="M4,4h1v1h-1z`)
+
+	err = code.WriteSVG(&buf, -1)
+	assert.EqualError(t, err, "invalid border: -1")
+}
+
+func TestCode_PNG(t *testing.T) {
+	code, err := qr.Encode([]byte("https://phobia.cloud"), qr.M)
+	require.NoError(t, err)
+
+	data, err := code.PNG(3, 2)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 87, img.Bounds().Dx())
+	assert.Equal(t, 87, img.Bounds().Dy())
+
+	for _, tt := range []struct {
+		x, y  int
+		black bool
+	}{
+		{0, 0, false},
+		{5, 5, false},
+		{6, 6, true},
+		{8, 8, true},
+		{9, 9, false},
+		{86, 86, false},
+	} {
+		r, _, _, _ := img.At(tt.x, tt.y).RGBA()
+		assert.Equal(t, tt.black, r == 0, "(%d,%d)", tt.x, tt.y)
+	}
+
+	_, err = code.PNG(0, 2)
+	assert.EqualError(t, err, "invalid scale: 0")
+	_, err = code.PNG(1, -2)
+	assert.EqualError(t, err, "invalid border: -2")
+}
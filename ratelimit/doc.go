@@ -0,0 +1,9 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package ratelimit provides token bucket rate limits.
+//
+// A Limit allows Requests per Period on average and bursts of up to Burst
+// requests. The buckets of the limited clients are kept in a Store, so they
+// can be shared by the instances of the server.
+package ratelimit
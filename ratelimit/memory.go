@@ -0,0 +1,94 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Memory removes buckets that are full.
+const sweepInterval = time.Minute
+
+// Memory is an in-memory Store. Its buckets are not shared between
+// processes.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// bucket is a token bucket. tokens is the number of tokens at updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill adds the tokens refilled since the last update of b.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated)
+	if elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(b.limit.interval())
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+	}
+	b.updated = now
+}
+
+// full reports whether b would be full at now.
+func (b *bucket) full(now time.Time) bool {
+	missing := float64(b.limit.Burst) - b.tokens
+	return now.Sub(b.updated) >= time.Duration(missing*float64(b.limit.interval()))
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take implements Store.
+func (m *Memory) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for k, b := range m.buckets {
+			if b.full(now) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok || b.limit != l {
+		b = &bucket{tokens: float64(l.Burst), updated: now, limit: l}
+		m.buckets[key] = b
+	}
+	b.refill(now)
+
+	result := Result{Limit: l.Burst}
+	interval := float64(l.interval())
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * interval)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(l.Burst) - b.tokens) * interval)
+	return result, nil
+}
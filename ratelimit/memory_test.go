@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	limit := Limit{Requests: 6, Period: time.Minute, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		result, err := m.Take(ctx, "a", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, i, result.Remaining)
+		assert.Equal(t, time.Duration(3-i)*10*time.Second, result.Reset)
+	}
+
+	result, err := m.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+	assert.Equal(t, 30*time.Second, result.Reset)
+
+	// the buckets of other keys are independent
+	result, err = m.Take(ctx, "b", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// a token is refilled every 10 seconds
+	now = now.Add(4 * time.Second)
+	result, err = m.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 6*time.Second, result.RetryAfter)
+
+	now = now.Add(6 * time.Second)
+	result, err = m.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// the bucket does not fill beyond the burst
+	now = now.Add(time.Hour)
+	result, err = m.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Remaining)
+
+	// a changed limit starts with a full bucket
+	result, err = m.Take(ctx, "a", Limit{Requests: 1, Period: time.Minute, Burst: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Remaining)
+}
+
+func TestMemory_Sweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	limit := Limit{Requests: 1, Period: time.Hour, Burst: 1}
+
+	_, err := m.Take(ctx, "a", limit)
+	require.NoError(t, err)
+	now = now.Add(50 * time.Minute)
+	_, err = m.Take(ctx, "b", limit)
+	require.NoError(t, err)
+	assert.Len(t, m.buckets, 2)
+
+	// a is full again after an hour, b is not
+	now = now.Add(20 * time.Minute)
+	_, err = m.Take(ctx, "c", limit)
+	require.NoError(t, err)
+	assert.Len(t, m.buckets, 2)
+	assert.Contains(t, m.buckets, "b")
+	assert.Contains(t, m.buckets, "c")
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the limit of a token bucket. The bucket holds up to Burst
+// tokens and is refilled with Requests tokens per Period. Each request
+// takes a token.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// ParseLimit parses a limit in the form "requests/period[:burst]", e.g.
+// "60/1m:20". If the burst is omitted, it is the number of requests.
+func ParseLimit(s string) (Limit, error) {
+	rate, burst := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		rate, burst = s[:i], s[i+1:]
+	}
+	i := strings.IndexByte(rate, '/')
+	if i < 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected requests/period[:burst]", s)
+	}
+
+	var l Limit
+	var err error
+	l.Requests, err = strconv.Atoi(rate[:i])
+	if err != nil {
+		return Limit{}, fmt.Errorf("invalid requests in limit %q", s)
+	}
+	l.Period, err = time.ParseDuration(rate[i+1:])
+	if err != nil {
+		return Limit{}, fmt.Errorf("invalid period in limit %q", s)
+	}
+	l.Burst = l.Requests
+	if burst != "" {
+		l.Burst, err = strconv.Atoi(burst)
+		if err != nil {
+			return Limit{}, fmt.Errorf("invalid burst in limit %q", s)
+		}
+	}
+	return l, l.Validate()
+}
+
+// Validate checks that the limit allows requests.
+func (l Limit) Validate() error {
+	if l.Requests <= 0 || l.Period <= 0 || l.Burst <= 0 {
+		return errors.New("requests, period and burst of limits must be positive")
+	}
+	return nil
+}
+
+// String formats l in the form parsed by ParseLimit.
+func (l Limit) String() string {
+	s := strconv.Itoa(l.Requests) + "/" + l.Period.String()
+	if l.Burst != l.Requests {
+		s += ":" + strconv.Itoa(l.Burst)
+	}
+	return s
+}
+
+// interval returns how long refilling a token takes.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result is the state of a bucket after taking a token.
+type Result struct {
+	// Allowed reports whether a token was taken.
+	Allowed bool
+	// Limit is the capacity of the bucket.
+	Limit int
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// Reset is how long it takes until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long it takes until the next token is available
+	// if the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets by key.
+type Store interface {
+	// Take takes a token from the bucket of key, which is created full if
+	// it does not exist, with the limit l.
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+}
@@ -0,0 +1,43 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/ratelimit"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tt := range []struct {
+		limit string
+		want  ratelimit.Limit
+		err   string
+	}{
+		{limit: "60/1m", want: ratelimit.Limit{Requests: 60, Period: time.Minute, Burst: 60}},
+		{limit: "60/1m:20", want: ratelimit.Limit{Requests: 60, Period: time.Minute, Burst: 20}},
+		{limit: "5/1s:1", want: ratelimit.Limit{Requests: 5, Period: time.Second, Burst: 1}},
+		{limit: "60", err: `invalid limit "60", expected requests/period[:burst]`},
+		{limit: "x/1m", err: `invalid requests in limit "x/1m"`},
+		{limit: "60/m", err: `invalid period in limit "60/m"`},
+		{limit: "60/1m:x", err: `invalid burst in limit "60/1m:x"`},
+		{limit: "0/1m", err: "requests, period and burst of limits must be positive"},
+		{limit: "60/1m:0", err: "requests, period and burst of limits must be positive"},
+	} {
+		l, err := ratelimit.ParseLimit(tt.limit)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.limit)
+			continue
+		}
+		assert.NoError(t, err, tt.limit)
+		assert.Equal(t, tt.want, l, tt.limit)
+
+		parsed, err := ratelimit.ParseLimit(l.String())
+		assert.NoError(t, err, tt.limit)
+		assert.Equal(t, l, parsed, tt.limit)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package remote provides the client of a request as seen through the
+// trusted reverse proxies in front of the server.
+//
+// The server resolves the IP address and scheme of the client of each
+// request once and stores them in its context with NewContext. Middleware
+// and handlers read them with IP and Scheme instead of r.RemoteAddr and
+// r.TLS, which describe the connection of the nearest proxy.
+package remote
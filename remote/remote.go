@@ -0,0 +1,56 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package remote
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// Client is the client of a request.
+type Client struct {
+	// IP is the IP address of the client.
+	IP string
+	// Scheme is "https" or "http", as requested by the client.
+	Scheme string
+}
+
+type clientKey struct{}
+
+// NewContext returns a copy of ctx with the client c.
+func NewContext(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// FromRequest returns the client of r stored in its context, or the peer
+// of the connection of r if there is none.
+func FromRequest(r *http.Request) Client {
+	if c, ok := r.Context().Value(clientKey{}).(Client); ok {
+		return c
+	}
+	return Peer(r)
+}
+
+// Peer returns the peer of the connection of r.
+func Peer(r *http.Request) Client {
+	c := Client{IP: r.RemoteAddr, Scheme: "http"}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.IP = host
+	}
+	if r.TLS != nil {
+		c.Scheme = "https"
+	}
+	return c
+}
+
+// IP returns the IP address of the client of r.
+func IP(r *http.Request) string {
+	return FromRequest(r).IP
+}
+
+// Scheme returns the scheme requested by the client of r.
+func Scheme(r *http.Request) string {
+	return FromRequest(r).Scheme
+}
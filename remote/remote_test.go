@@ -0,0 +1,32 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package remote_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/remote"
+)
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/", nil)
+	req.RemoteAddr = "10.0.0.2:52114"
+	assert.Equal(t, remote.Client{IP: "10.0.0.2", Scheme: "http"}, remote.FromRequest(req))
+
+	req.TLS = &tls.ConnectionState{}
+	assert.Equal(t, "https", remote.Scheme(req))
+
+	req.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", remote.IP(req))
+
+	client := remote.Client{IP: "203.0.113.7", Scheme: "https"}
+	req = req.WithContext(remote.NewContext(req.Context(), client))
+	assert.Equal(t, client, remote.FromRequest(req))
+	assert.Equal(t, remote.Client{IP: "pipe", Scheme: "https"}, remote.Peer(req))
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/remote"
+)
+
+// RequestIDHeader is the header with the ID of a request. The ID of the
+// client is used if valid, otherwise one is generated. It is echoed in the
+// response.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+var accessLog = logging.Component("access")
+
+// AccessLog returns a handler that serves h and logs each request with its
+// ID, method, route, path, status, latency and client IP, and the fields set
+// by the handlers, e.g. the fingerprint of the authenticated key. The query
+// is not logged, as it may contain secrets.
+func AccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		req := &logging.Request{ID: id}
+		r = r.WithContext(logging.NewContext(r.Context(), req))
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		fields := []interface{}{
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.code(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip", remote.IP(r),
+		}
+		accessLog.Info("request", append(fields, req.Fields()...)...)
+	})
+}
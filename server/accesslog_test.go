@@ -0,0 +1,107 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/server"
+)
+
+// captureLog makes the loggers write to the returned buffer until the test
+// ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logging.SetOutput(&buf)
+	t.Cleanup(func() { logging.SetOutput(os.Stderr) })
+	return &buf
+}
+
+// logEntries returns the log entries in buf.
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := captureLog(t)
+
+	router := server.NewRouter("/v1")
+	router.HandleFunc(http.MethodGet, "/lnurl", func(w http.ResponseWriter, r *http.Request) {
+		logging.Set(r.Context(), "key", logging.Fingerprint("023a4722"))
+		logging.Component("handler").Context(r.Context()).Info("logged in")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := server.AccessLog(router)
+
+	req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/v1/lnurl?k1=e2af6254&sig=3045", nil)
+	req.RemoteAddr = "203.0.113.7:52114"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	id := rr.Header().Get(server.RequestIDHeader)
+	assert.Len(t, id, 16)
+	assert.NotContains(t, buf.String(), "e2af6254", "the query is not logged")
+
+	entries := logEntries(t, buf)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "handler", entries[0]["component"])
+	assert.Equal(t, id, entries[0]["request_id"])
+
+	access := entries[1]
+	assert.Equal(t, "access", access["component"])
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, id, access["request_id"])
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/v1/lnurl", access["route"])
+	assert.Equal(t, "/v1/lnurl", access["path"])
+	assert.Equal(t, float64(http.StatusAccepted), access["status"])
+	assert.Equal(t, "203.0.113.7", access["client_ip"])
+	assert.Equal(t, logging.Fingerprint("023a4722"), access["key"])
+	assert.Contains(t, access, "latency_ms")
+}
+
+func TestAccessLog_RequestID(t *testing.T) {
+	buf := captureLog(t)
+	h := server.AccessLog(server.NewRouter(""))
+
+	for _, tt := range []struct {
+		header string
+		kept   bool
+	}{
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d479", true},
+		{"", false},
+		{"has spaces", false},
+		{strings.Repeat("a", 129), false},
+	} {
+		buf.Reset()
+		rr := serve(t, h, http.MethodGet, "http://phobia.cloud/missing", server.RequestIDHeader, tt.header)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		id := rr.Header().Get(server.RequestIDHeader)
+		if tt.kept {
+			assert.Equal(t, tt.header, id)
+		} else {
+			assert.Len(t, id, 16, tt.header)
+		}
+		entries := logEntries(t, buf)
+		assert.Equal(t, id, entries[0]["request_id"], tt.header)
+		assert.Equal(t, server.UnmatchedRoute, entries[0]["route"], tt.header)
+	}
+}
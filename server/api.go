@@ -0,0 +1,225 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/openapi"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/tracing"
+	"phobia.cloud/api/webhook"
+)
+
+// APIPrefix is the path prefix of the current version of the API.
+const APIPrefix = "/v1"
+
+// API is the set of handlers served by the API.
+type API struct {
+	// Challenge issues login challenges. If nil, a ChallengeHandler with no
+	// optional login methods is used.
+	Challenge *handler.ChallengeHandler
+	// Login verifies Trezor logins. If nil, a LoginHandler with the
+	// default settings is used.
+	Login *handler.LoginHandler
+	// Nostr verifies Nostr logins. If nil, a NostrLogin redeeming the
+	// challenges of Challenge is used, which rejects all logins as it has no
+	// origins.
+	Nostr *handler.NostrLogin
+	// Ethereum verifies Sign-In With Ethereum logins. If nil, an
+	// EthereumLogin redeeming the challenges of Challenge is used, which
+	// rejects all logins as it has no origins.
+	Ethereum *handler.EthereumLogin
+	// LNURL handles LNURL-auth logins. If nil, the LNURL-auth routes are not
+	// served.
+	LNURL *handler.LNURLAuth
+	// WebAuthn handles passkey registration and login. If nil, the WebAuthn
+	// routes are not served.
+	WebAuthn *handler.WebAuthn
+	// CORS is the CORS policy of all routes. If nil, any origin is allowed
+	// without credentials.
+	CORS *CORS
+	// Health serves the health and version endpoints. If nil, a Health
+	// without readiness checks is used.
+	Health *Health
+	// Metrics is the registry of the metrics. If set, the metrics are
+	// served at /metrics and the HTTP traffic of the routes is recorded.
+	Metrics *metrics.Registry
+	// Tracer traces the requests if set.
+	Tracer *tracing.Tracer
+	// Viewer serves a viewer of the OpenAPI document at /docs.
+	Viewer bool
+	// RateLimit limits the rate of requests to the routes of the API if
+	// set. Logins are limited by their public key.
+	RateLimit *RateLimit
+	// Proxies are the trusted reverse proxies in front of the server,
+	// which forward the IP address of the client.
+	Proxies *TrustedProxies
+	// Lockouts serves the active lockouts after repeated failed logins at
+	// /lockouts if set. The endpoint reveals keys and client IPs, so it
+	// requires OperatorToken.
+	Lockouts *abuse.Detector
+	// OperatorToken is the bearer token required by the operator endpoints.
+	// If empty, all requests to them are rejected.
+	OperatorToken string
+	// Security are the security headers of all responses if set.
+	Security *Security
+	// Webhooks serves the dead letters of the webhooks at
+	// /webhooks/dead-letters and replays them if set. The events reveal keys
+	// and client IPs, so the endpoints require OperatorToken.
+	Webhooks *webhook.Dispatcher
+}
+
+// Handler returns the router of the API wrapped with its CORS policy, the
+// security headers, the access log, the resolution of the client IP and, if
+// the API has a Tracer, tracing. The operational endpoints of HealthRouter
+// are served without CORS.
+func (api *API) Handler() http.Handler {
+	cors := api.CORS
+	if cors == nil {
+		cors = &CORS{AllowedOrigins: []string{"*"}}
+	}
+	router := api.Router()
+	apiHandler := cors.Handler(router)
+	health := api.HealthRouter()
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, _ := health.match(r.URL.Path); route != nil {
+			api.Security.apply(w, r, health.label(route))
+			health.ServeHTTP(w, r)
+			return
+		}
+		route, _ := router.match(r.URL.Path)
+		api.Security.apply(w, r, router.label(route))
+		apiHandler.ServeHTTP(w, r)
+	})
+	if api.Tracer != nil {
+		h = Tracing(api.Tracer, h)
+	}
+	return RealIP(api.Proxies, AccessLog(h))
+}
+
+// HealthRouter returns the router of the health, version, metrics, lockout
+// and webhook endpoints and of the OpenAPI document. They are not versioned, so probes,
+// scrapers and tools do not change with the API.
+func (api *API) HealthRouter() *Router {
+	health := api.Health
+	if health == nil {
+		health = &Health{}
+	}
+
+	router := NewRouter("")
+	router.Metrics = api.httpMetrics()
+	router.HandleFunc(http.MethodGet, "/healthz", health.Live)
+	router.HandleFunc(http.MethodGet, "/readyz", health.Ready)
+	router.HandleFunc(http.MethodGet, "/version", health.Version)
+	if api.Metrics != nil {
+		router.Handle(http.MethodGet, "/metrics", api.Metrics)
+	}
+	if api.Lockouts != nil {
+		router.Handle(http.MethodGet, "/lockouts", api.operator(api.Lockouts))
+	}
+	if api.Webhooks != nil {
+		router.Handle(http.MethodGet, "/webhooks/dead-letters", api.operator(api.Webhooks))
+		router.Handle(http.MethodPost, "/webhooks/dead-letters/{id}/replay", api.operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			api.Webhooks.ServeReplay(w, r, Param(r, "id"))
+		})))
+	}
+	router.HandleFunc(http.MethodGet, "/openapi.json", openapi.ServeDocument)
+	if api.Viewer {
+		router.HandleFunc(http.MethodGet, "/docs", openapi.ServeViewer)
+	}
+	return router
+}
+
+// operator returns a handler that passes the requests with OperatorToken
+// as bearer token to h and rejects the others with 401 Unauthorized.
+func (api *API) operator(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || api.OperatorToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(api.OperatorToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			problem.Error(w, http.StatusUnauthorized, problem.Unauthorized, "missing or invalid operator token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of the "Authorization: Bearer" header of r.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
+
+// httpMetrics returns the metrics of the HTTP traffic, or nil if the API has
+// no metrics.
+func (api *API) httpMetrics() *HTTPMetrics {
+	if api.Metrics == nil {
+		return nil
+	}
+	return NewHTTPMetrics(api.Metrics)
+}
+
+// Router returns the router of the API. The routes are served under
+// APIPrefix and, for existing clients, at their unversioned paths.
+func (api *API) Router() *Router {
+	router := NewRouter(APIPrefix)
+	router.Aliases = true
+	router.Metrics = api.httpMetrics()
+	router.RateLimit = api.RateLimit.withKeys(map[string]func(*http.Request) string{
+		APIPrefix + "/login":          handler.LoginKey,
+		APIPrefix + "/login/nostr":    handler.NostrKey,
+		APIPrefix + "/login/ethereum": handler.EthereumKey,
+	})
+
+	challenge := api.Challenge
+	if challenge == nil {
+		challenge = &handler.ChallengeHandler{}
+	}
+	login := api.Login
+	if login == nil {
+		login = &handler.LoginHandler{}
+	}
+	nostr := api.Nostr
+	if nostr == nil {
+		nostr = &handler.NostrLogin{Challenges: challenge.Challenges}
+	}
+	ethereum := api.Ethereum
+	if ethereum == nil {
+		ethereum = &handler.EthereumLogin{Challenges: challenge.Challenges}
+	}
+
+	router.Handle(http.MethodGet, "/challenge", challenge)
+	router.HandleFunc(http.MethodGet, "/challenge/qr", handler.ChallengeQR)
+	if challenge.PoW != nil {
+		router.HandleFunc(http.MethodGet, "/challenge/pow", challenge.ProofOfWork)
+	}
+	router.Handle(http.MethodPost, "/login", login)
+	router.Handle(http.MethodPost, "/login/nostr", nostr)
+	router.Handle(http.MethodPost, "/login/ethereum", ethereum)
+
+	if api.LNURL != nil {
+		router.HandleFunc(http.MethodGet, "/lnurl", api.LNURL.Callback)
+		router.HandleFunc(http.MethodGet, "/lnurl/status", api.LNURL.Status)
+	}
+
+	if api.WebAuthn != nil {
+		router.HandleFunc(http.MethodPost, "/webauthn/register/begin", api.WebAuthn.RegisterBegin)
+		router.HandleFunc(http.MethodPost, "/webauthn/register/finish", api.WebAuthn.RegisterFinish)
+		router.HandleFunc(http.MethodPost, "/webauthn/login/begin", api.WebAuthn.LoginBegin)
+		router.HandleFunc(http.MethodPost, "/webauthn/login/finish", api.WebAuthn.LoginFinish)
+	}
+
+	return router
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webhook"
+)
+
+func TestAPI_Routes(t *testing.T) {
+	s := store.NewMemory()
+	api := &server.API{
+		LNURL: &handler.LNURLAuth{Store: s},
+		WebAuthn: &handler.WebAuthn{
+			RelyingParty: &webauthn.RelyingParty{ID: "phobia.cloud", Name: "phobia.cloud", Origins: []string{"https://phobia.cloud"}},
+			Credentials:  &webauthn.Credentials{Store: s},
+			Store:        s,
+		},
+	}
+
+	get, post := []string{http.MethodGet}, []string{http.MethodPost}
+	assert.Equal(t, []server.Route{
+		{Pattern: "/v1/challenge", Alias: "/challenge", Methods: get},
+		{Pattern: "/v1/challenge/qr", Alias: "/challenge/qr", Methods: get},
+		{Pattern: "/v1/lnurl", Alias: "/lnurl", Methods: get},
+		{Pattern: "/v1/lnurl/status", Alias: "/lnurl/status", Methods: get},
+		{Pattern: "/v1/login", Alias: "/login", Methods: post},
+		{Pattern: "/v1/login/ethereum", Alias: "/login/ethereum", Methods: post},
+		{Pattern: "/v1/login/nostr", Alias: "/login/nostr", Methods: post},
+		{Pattern: "/v1/webauthn/login/begin", Alias: "/webauthn/login/begin", Methods: post},
+		{Pattern: "/v1/webauthn/login/finish", Alias: "/webauthn/login/finish", Methods: post},
+		{Pattern: "/v1/webauthn/register/begin", Alias: "/webauthn/register/begin", Methods: post},
+		{Pattern: "/v1/webauthn/register/finish", Alias: "/webauthn/register/finish", Methods: post},
+	}, api.Router().Routes())
+
+	routes := (&server.API{}).Router().Routes()
+	assert.Len(t, routes, 5)
+}
+
+func TestAPI_Handler(t *testing.T) {
+	api := &server.API{}
+	router := api.Handler()
+
+	for _, route := range api.Router().Routes() {
+		for _, pattern := range []string{route.Pattern, route.Alias} {
+			rr := preflight(t, router, "http://phobia.cloud"+pattern, "https://example.com", http.MethodPost, "content-type")
+			assert.Equal(t, http.StatusNoContent, rr.Code, pattern)
+			assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"), pattern)
+
+			for _, method := range []string{http.MethodPut, http.MethodDelete, http.MethodHead} {
+				rr = serve(t, router, method, "http://phobia.cloud"+pattern)
+				assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, "%s %s", method, pattern)
+			}
+		}
+	}
+
+	for _, target := range []string{"/v1/challenge", "/challenge"} {
+		rr := serve(t, router, http.MethodGet, "http://phobia.cloud"+target, "Origin", "https://example.com")
+		assert.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"), target)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), target)
+	}
+
+	rr := serve(t, router, http.MethodPost, "http://phobia.cloud/v1/login")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve(t, router, http.MethodGet, "http://phobia.cloud/v1/lnurl")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAPI_Health(t *testing.T) {
+	health := &server.Health{}
+	api := &server.API{Health: health}
+	router := api.Handler()
+
+	get := []string{http.MethodGet}
+	assert.Equal(t, []server.Route{
+		{Pattern: "/healthz", Methods: get},
+		{Pattern: "/openapi.json", Methods: get},
+		{Pattern: "/readyz", Methods: get},
+		{Pattern: "/version", Methods: get},
+	}, api.HealthRouter().Routes())
+
+	for _, target := range []string{"/healthz", "/readyz", "/version"} {
+		rr := serve(t, router, http.MethodGet, "http://phobia.cloud"+target, "Origin", "https://example.com")
+		assert.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), target)
+
+		rr = serve(t, router, http.MethodGet, "http://phobia.cloud/v1"+target)
+		assert.Equal(t, http.StatusNotFound, rr.Code, target)
+	}
+
+	health.Register("store", server.CheckerFunc(func(ctx context.Context) error { return errors.New("down") }))
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestAPI_Lockouts(t *testing.T) {
+	detector := abuse.NewDetector()
+	detector.Failure(abuse.Policy{Window: time.Minute, KeyFailures: 1, Lockout: time.Minute, MaxLockout: time.Minute}, "023a47", "198.51.100.1")
+	api := &server.API{Lockouts: detector, OperatorToken: "0123456789abcdef"}
+	router := api.Handler()
+
+	for _, tt := range []struct {
+		name    string
+		headers []string
+	}{
+		{name: "anonymous"},
+		{name: "wrong token", headers: []string{"Authorization", "Bearer fedcba9876543210"}},
+		{name: "other scheme", headers: []string{"Authorization", "Basic 0123456789abcdef"}},
+		{name: "token without scheme", headers: []string{"Authorization", "0123456789abcdef"}},
+	} {
+		rr := serve(t, router, http.MethodGet, "http://phobia.cloud/lockouts", tt.headers...)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, tt.name)
+		assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"), tt.name)
+		assert.NotContains(t, rr.Body.String(), "023a47", tt.name)
+	}
+
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/lockouts", "Authorization", "Bearer 0123456789abcdef")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "023a47")
+
+	// without a token, the endpoint rejects all requests
+	rr = serve(t, (&server.API{Lockouts: detector}).Handler(), http.MethodGet, "http://phobia.cloud/lockouts", "Authorization", "Bearer ")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestAPI_Webhooks(t *testing.T) {
+	d := webhook.NewDispatcher(store.NewMemory(), nil, webhook.Policy{})
+	defer func() { _ = d.Close() }()
+	api := &server.API{Webhooks: d, OperatorToken: "0123456789abcdef"}
+	router := api.Handler()
+	authorization := []string{"Authorization", "Bearer 0123456789abcdef"}
+
+	// anonymous requests are rejected
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/webhooks/dead-letters")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serve(t, router, http.MethodPost, "http://phobia.cloud/webhooks/dead-letters/0123/replay")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr = serve(t, router, http.MethodGet, "http://phobia.cloud/webhooks/dead-letters", "Authorization", "Bearer fedcba9876543210")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = serve(t, router, http.MethodGet, "http://phobia.cloud/webhooks/dead-letters", authorization...)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"deadLetters":[]}`, rr.Body.String())
+
+	rr = serve(t, router, http.MethodPost, "http://phobia.cloud/webhooks/dead-letters/0123/replay", authorization...)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `dead letter not found: \"0123\"`)
+
+	rr = serve(t, (&server.API{}).Handler(), http.MethodGet, "http://phobia.cloud/webhooks/dead-letters")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,208 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/problem"
+)
+
+// Defaults of the CORS policy.
+var (
+	// DefaultCORSMethods are the methods allowed if CORS.AllowedMethods is
+	// empty.
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	// DefaultCORSHeaders are the request headers allowed if
+	// CORS.AllowedHeaders is empty.
+	DefaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// CORS is a cross-origin resource sharing policy applied as middleware to all
+// routes.
+//
+// Requests with an allowed origin get the origin echoed in the
+// Access-Control-Allow-Origin header. Requests from other origins are served
+// without CORS headers, so browsers do not expose the response. Preflight
+// requests from other origins, or for methods or headers that are not
+// allowed, are rejected with 403 Forbidden.
+type CORS struct {
+	// AllowedOrigins are the allowed origins. An origin is either an exact
+	// origin like "https://phobia.cloud", a wildcard subdomain like
+	// "https://*.phobia.cloud", which does not match the domain itself, or
+	// "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in preflight requests. If
+	// empty, DefaultCORSMethods are allowed.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in preflight requests.
+	// If empty, DefaultCORSHeaders are allowed.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers exposed to scripts.
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies and HTTP
+	// authentication. It cannot be combined with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses. If zero,
+	// the browser default is used.
+	MaxAge time.Duration
+}
+
+// Validate checks that the policy is well-formed.
+func (c *CORS) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("cors: the * origin cannot be combined with credentials")
+			}
+			continue
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return fmt.Errorf("cors: invalid origin: %q", origin)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors: invalid max age: %v", c.MaxAge)
+	}
+	return nil
+}
+
+// Handler returns next wrapped with the CORS policy.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !c.anyOrigin() {
+			w.Header().Add("Vary", "Origin")
+		}
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := c.allowedOrigin(origin)
+		if preflight {
+			c.preflight(w, r, allowed)
+			return
+		}
+
+		if allowed {
+			c.setOrigin(w, origin)
+			if len(c.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, allowed bool) {
+	origin := r.Header.Get("Origin")
+	if !allowed {
+		rejectPreflight(w, fmt.Sprintf("origin %s is not allowed", origin))
+		return
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !contains(c.methods(), method, false) {
+		rejectPreflight(w, fmt.Sprintf("method %s is not allowed", method))
+		return
+	}
+
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !contains(c.headers(), header, true) {
+			rejectPreflight(w, fmt.Sprintf("header %s is not allowed", header))
+			return
+		}
+	}
+
+	c.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.headers(), ", "))
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func rejectPreflight(w http.ResponseWriter, reason string) {
+	problem.Error(w, http.StatusForbidden, problem.CORSRejected, "cors preflight rejected: "+reason)
+}
+
+func (c *CORS) setOrigin(w http.ResponseWriter, origin string) {
+	if c.anyOrigin() {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) anyOrigin() bool {
+	return contains(c.AllowedOrigins, "*", false)
+}
+
+func (c *CORS) allowedOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		i := strings.Index(allowed, "://*.")
+		if i < 0 {
+			continue
+		}
+		scheme, suffix := allowed[:i+3], allowed[i+4:]
+		if !strings.HasPrefix(origin, scheme) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		// the subdomain must be a non-empty host label sequence
+		sub := strings.TrimSuffix(strings.TrimPrefix(origin, scheme), suffix)
+		if sub != "" && !strings.ContainsAny(sub, "/:@?#") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return DefaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c *CORS) headers() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return DefaultCORSHeaders
+	}
+	return c.AllowedHeaders
+}
+
+func contains(list []string, s string, ignoreCase bool) bool {
+	for _, item := range list {
+		if item == s || (ignoreCase && strings.EqualFold(item, s)) {
+			return true
+		}
+	}
+	return false
+}
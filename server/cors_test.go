@@ -0,0 +1,168 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/server"
+)
+
+func preflight(t *testing.T, h http.Handler, target, origin, method, headers string) *httptest.ResponseRecorder {
+	return serve(t, h, http.MethodOptions, target,
+		"Origin", origin,
+		"Access-Control-Request-Method", method,
+		"Access-Control-Request-Headers", headers)
+}
+
+func corsRouter(cors *server.CORS) http.Handler {
+	router := server.NewRouter("")
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.Handle(http.MethodPost, "/login", echo("login"))
+	return cors.Handler(router)
+}
+
+func TestCORS_Origins(t *testing.T) {
+	h := corsRouter(&server.CORS{
+		AllowedOrigins: []string{"https://phobia.cloud", "https://*.phobia.cloud", "http://localhost:3000"},
+	})
+
+	for _, tt := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://phobia.cloud", true},
+		{"https://app.phobia.cloud", true},
+		{"https://a.b.phobia.cloud", true},
+		{"http://localhost:3000", true},
+		{"http://phobia.cloud", false},
+		{"http://app.phobia.cloud", false},
+		{"https://phobia.cloud:8443", false},
+		{"https://app.phobia.cloud:8443", false},
+		{"https://evilphobia.cloud", false},
+		{"https://phobia.cloud.evil.com", false},
+		{"https://.phobia.cloud", false},
+		{"http://localhost:3001", false},
+		{"null", false},
+	} {
+		rr := serve(t, h, http.MethodGet, "http://phobia.cloud/challenge", "Origin", tt.origin)
+		assert.Equal(t, http.StatusOK, rr.Code, tt.origin)
+		assert.Equal(t, []string{"Origin"}, rr.Header().Values("Vary"), tt.origin)
+		if tt.allowed {
+			assert.Equal(t, tt.origin, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		} else {
+			assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		}
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"), tt.origin)
+
+		rr = preflight(t, h, "http://phobia.cloud/login", tt.origin, http.MethodPost, "")
+		if tt.allowed {
+			assert.Equal(t, http.StatusNoContent, rr.Code, tt.origin)
+			assert.Equal(t, tt.origin, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		} else {
+			assert.Equal(t, http.StatusForbidden, rr.Code, tt.origin)
+			assertProblem(t, rr, http.StatusForbidden, problem.CORSRejected, "cors preflight rejected: origin "+tt.origin+" is not allowed")
+			assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		}
+	}
+
+	// requests without origin are not affected
+	rr := serve(t, h, http.MethodGet, "http://phobia.cloud/challenge")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, rr.Header().Values("Vary"))
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	h := corsRouter(&server.CORS{
+		AllowedOrigins:   []string{"https://phobia.cloud"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders:   []string{"Content-Type", "X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	rr := preflight(t, h, "http://phobia.cloud/login", "https://phobia.cloud", http.MethodPost, "content-type, x-request-id")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://phobia.cloud", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST, DELETE", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-Request-Id", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, rr.Header().Values("Vary"))
+	assert.Empty(t, rr.Body.String())
+
+	rr = preflight(t, h, "http://phobia.cloud/login", "https://phobia.cloud", http.MethodPut, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assertProblem(t, rr, http.StatusForbidden, problem.CORSRejected, "cors preflight rejected: method PUT is not allowed")
+
+	rr = preflight(t, h, "http://phobia.cloud/login", "https://phobia.cloud", http.MethodPost, "content-type, authorization")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assertProblem(t, rr, http.StatusForbidden, problem.CORSRejected, "cors preflight rejected: header authorization is not allowed")
+
+	// OPTIONS requests that are not preflights are served by the router
+	rr = serve(t, h, http.MethodOptions, "http://phobia.cloud/login", "Origin", "https://phobia.cloud")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "POST, OPTIONS", rr.Header().Get("Allow"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORS_Defaults(t *testing.T) {
+	h := corsRouter(&server.CORS{AllowedOrigins: []string{"*"}})
+
+	rr := preflight(t, h, "http://phobia.cloud/login", "https://example.com", http.MethodPost, "Authorization,Content-Type")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, []string{"Access-Control-Request-Method", "Access-Control-Request-Headers"}, rr.Header().Values("Vary"))
+
+	rr = serve(t, h, http.MethodGet, "http://phobia.cloud/challenge", "Origin", "https://example.com")
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Header().Values("Vary"))
+}
+
+func TestCORS_ExposedHeaders(t *testing.T) {
+	h := corsRouter(&server.CORS{
+		AllowedOrigins: []string{"https://phobia.cloud"},
+		ExposedHeaders: []string{"Location", "X-Request-Id"},
+	})
+
+	rr := serve(t, h, http.MethodGet, "http://phobia.cloud/challenge", "Origin", "https://phobia.cloud")
+	assert.Equal(t, "Location, X-Request-Id", rr.Header().Get("Access-Control-Expose-Headers"))
+
+	rr = serve(t, h, http.MethodGet, "http://phobia.cloud/challenge", "Origin", "https://phobia.club")
+	assert.Empty(t, rr.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestCORS_Validate(t *testing.T) {
+	for _, cors := range []*server.CORS{
+		{},
+		{AllowedOrigins: []string{"*"}},
+		{AllowedOrigins: []string{"https://phobia.cloud", "https://*.phobia.cloud", "http://localhost:3000"}, AllowCredentials: true},
+	} {
+		assert.NoError(t, cors.Validate(), cors.AllowedOrigins)
+	}
+
+	for _, tt := range []struct {
+		cors *server.CORS
+		err  string
+	}{
+		{&server.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "cors: the * origin cannot be combined with credentials"},
+		{&server.CORS{AllowedOrigins: []string{"phobia.cloud"}}, `cors: invalid origin: "phobia.cloud"`},
+		{&server.CORS{AllowedOrigins: []string{"https://phobia.cloud/"}}, `cors: invalid origin: "https://phobia.cloud/"`},
+		{&server.CORS{AllowedOrigins: []string{"ftp://phobia.cloud"}}, `cors: invalid origin: "ftp://phobia.cloud"`},
+		{&server.CORS{MaxAge: -time.Second}, "cors: invalid max age: -1s"},
+	} {
+		assert.EqualError(t, tt.cors.Validate(), tt.err)
+	}
+}
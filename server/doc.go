@@ -0,0 +1,6 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package server provides the HTTP router of the API and the setup of the
+// web server.
+package server
@@ -0,0 +1,169 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/buildinfo"
+)
+
+// Checker is a dependency of the server, such as a store, whose readiness
+// is checked by the readiness endpoint.
+type Checker interface {
+	// Check returns an error if the dependency cannot serve requests.
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a function that implements Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check implements Checker.
+func (f CheckerFunc) Check(ctx context.Context) error { return f(ctx) }
+
+// DefaultCheckTimeout is how long a readiness check may take if
+// Health.Timeout is not set.
+const DefaultCheckTimeout = 2 * time.Second
+
+// The statuses of health responses.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Health serves the liveness, readiness and version endpoints that
+// orchestrators probe. Unlike the API, they do not consume randomness or
+// state.
+type Health struct {
+	// Timeout is how long a readiness check may take. If zero,
+	// DefaultCheckTimeout is used.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks []check
+}
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+// HealthResponse is the response of the liveness and readiness endpoints.
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the result of a readiness check.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Register registers the readiness check c with name. It panics if a check
+// with name is already registered.
+func (h *Health) Register(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, check := range h.checks {
+		if check.name == name {
+			panic(fmt.Sprintf("check %s is already registered", name))
+		}
+	}
+	h.checks = append(h.checks, check{name: name, checker: c})
+}
+
+// Live answers liveness probes. The server is live as long as it serves
+// requests.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, http.StatusOK, HealthResponse{Status: StatusOK})
+}
+
+// Ready answers readiness probes. It runs the registered checks in parallel
+// and answers 503 Service Unavailable if any of them fails.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	checks := append([]check(nil), h.checks...)
+	h.mu.Unlock()
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c.checker)
+		}(i, c)
+	}
+	wg.Wait()
+
+	status, response := http.StatusOK, HealthResponse{Status: StatusOK}
+	if len(checks) > 0 {
+		response.Checks = make(map[string]CheckResult, len(checks))
+	}
+	for i, c := range checks {
+		response.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			status, response.Status = http.StatusServiceUnavailable, StatusUnavailable
+		}
+	}
+	writeHealth(w, r, status, response)
+}
+
+// runCheck runs c and measures its latency. Checks that do not return
+// before ctx is done fail.
+func runCheck(ctx context.Context, c Checker) CheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status, result.Error = StatusUnavailable, err.Error()
+	}
+	return result
+}
+
+// Version answers with the build information of the server.
+func (h *Health) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(buildinfo.Get())
+	if err != nil {
+		serverLog.Context(r.Context()).Warn("error writing response to client", "error", err)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, status int, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		serverLog.Context(r.Context()).Warn("error writing response to client", "error", err)
+	}
+}
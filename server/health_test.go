@@ -0,0 +1,94 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/buildinfo"
+	"phobia.cloud/api/server"
+)
+
+func TestHealth_Live(t *testing.T) {
+	health := &server.Health{}
+	health.Register("broken", server.CheckerFunc(func(ctx context.Context) error {
+		return errors.New("broken")
+	}))
+
+	rr := serve(t, http.HandlerFunc(health.Live), http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"status": "ok"}`, rr.Body.String())
+}
+
+func TestHealth_Ready(t *testing.T) {
+	health := &server.Health{}
+	rr := serve(t, http.HandlerFunc(health.Ready), http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rr.Body.String())
+
+	health.Register("store", server.CheckerFunc(func(ctx context.Context) error { return nil }))
+	rr = serve(t, http.HandlerFunc(health.Ready), http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	response := decodeHealth(t, rr.Body.Bytes())
+	assert.Equal(t, server.StatusOK, response.Status)
+	assert.Equal(t, server.StatusOK, response.Checks["store"].Status)
+	assert.GreaterOrEqual(t, response.Checks["store"].LatencyMs, 0.0)
+
+	health.Register("audit", server.CheckerFunc(func(ctx context.Context) error {
+		return errors.New("disk full")
+	}))
+	rr = serve(t, http.HandlerFunc(health.Ready), http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	response = decodeHealth(t, rr.Body.Bytes())
+	assert.Equal(t, server.StatusUnavailable, response.Status)
+	assert.Equal(t, server.StatusOK, response.Checks["store"].Status)
+	assert.Equal(t, server.CheckResult{
+		Status:    server.StatusUnavailable,
+		LatencyMs: response.Checks["audit"].LatencyMs,
+		Error:     "disk full",
+	}, response.Checks["audit"])
+
+	assert.Panics(t, func() { health.Register("store", server.CheckerFunc(nil)) })
+}
+
+func TestHealth_ReadyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	health := &server.Health{Timeout: 10 * time.Millisecond}
+	health.Register("hanging", server.CheckerFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+
+	rr := serve(t, http.HandlerFunc(health.Ready), http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	response := decodeHealth(t, rr.Body.Bytes())
+	assert.Equal(t, context.DeadlineExceeded.Error(), response.Checks["hanging"].Error)
+}
+
+func TestHealth_Version(t *testing.T) {
+	rr := serve(t, http.HandlerFunc((&server.Health{}).Version), http.MethodGet, "/version")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var info buildinfo.Info
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, buildinfo.Get(), info)
+}
+
+func decodeHealth(t *testing.T, data []byte) server.HealthResponse {
+	var response server.HealthResponse
+	require.NoError(t, json.Unmarshal(data, &response), string(data))
+	return response
+}
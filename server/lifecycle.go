@@ -0,0 +1,219 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"phobia.cloud/api/logging"
+)
+
+var serverLog = logging.Component("server")
+
+// Timeouts are the limits of the HTTP servers that protect against slow and
+// idle clients.
+type Timeouts struct {
+	// ReadHeader is how long reading the request headers may take.
+	ReadHeader time.Duration
+	// Read is how long reading the whole request may take.
+	Read time.Duration
+	// Write is how long writing the response may take, measured from the
+	// end of the request headers.
+	Write time.Duration
+	// Idle is how long a keep-alive connection may wait for the next
+	// request.
+	Idle time.Duration
+	// MaxHeaderBytes is the maximum size of the request headers.
+	MaxHeaderBytes int
+}
+
+// DefaultTimeouts are the timeouts of the server if not configured.
+var DefaultTimeouts = Timeouts{
+	ReadHeader:     5 * time.Second,
+	Read:           15 * time.Second,
+	Write:          30 * time.Second,
+	Idle:           2 * time.Minute,
+	MaxHeaderBytes: 64 << 10,
+}
+
+// NewHTTPServer returns an http.Server for h listening on addr with the
+// timeouts.
+func NewHTTPServer(addr string, h http.Handler, t Timeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+		MaxHeaderBytes:    t.MaxHeaderBytes,
+	}
+}
+
+// DefaultShutdownTimeout is how long in-flight requests are waited for on
+// shutdown if Lifecycle.ShutdownTimeout is not set.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Lifecycle runs HTTP servers until the process is asked to stop and shuts
+// them down gracefully.
+//
+// On SIGINT or SIGTERM the servers stop accepting connections and in-flight
+// requests are given ShutdownTimeout to complete. Then the Closers are
+// closed, so they can flush pending state. On SIGHUP, Reload is called.
+type Lifecycle struct {
+	// Servers are the servers to run. Servers with a TLSConfig serve HTTPS.
+	Servers []*http.Server
+	// ShutdownTimeout is how long in-flight requests are waited for. If
+	// zero, DefaultShutdownTimeout is used.
+	ShutdownTimeout time.Duration
+	// Reload is called on SIGHUP, e.g. to reload the configuration. If it
+	// fails, the servers keep running with the previous state.
+	Reload func() error
+	// Closers are closed in order after the servers shut down, e.g. stores
+	// and logs that buffer writes.
+	Closers []io.Closer
+	// Listen returns the listener of a server, e.g. a ProxyProtocolListener.
+	// If nil, the servers listen on TCP.
+	Listen func(addr string) (net.Listener, error)
+}
+
+func (l *Lifecycle) shutdownTimeout() time.Duration {
+	if l.ShutdownTimeout == 0 {
+		return DefaultShutdownTimeout
+	}
+	return l.ShutdownTimeout
+}
+
+// Run runs the servers until ctx is done, the process receives SIGINT or
+// SIGTERM, or a server fails. It returns the error of the failed server or
+// of the Closers.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	if l.Reload != nil {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	failed := make(chan error, len(l.Servers))
+	for _, srv := range l.Servers {
+		go func(srv *http.Server) {
+			if err := l.serve(srv); !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(srv)
+	}
+
+	var err error
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			serverLog.Info("shutting down")
+			running = false
+		case err = <-failed:
+			running = false
+		case <-hup:
+			serverLog.Info("reloading configuration")
+			if reloadErr := l.Reload(); reloadErr != nil {
+				serverLog.Error("error reloading configuration", "error", reloadErr)
+			}
+		}
+	}
+
+	l.shutdown()
+
+	for _, c := range l.Closers {
+		if closeErr := c.Close(); closeErr != nil {
+			serverLog.Error("error closing", "error", closeErr)
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// serve serves srv on the listener returned by Listen, or on TCP.
+func (l *Lifecycle) serve(srv *http.Server) error {
+	if l.Listen == nil {
+		if srv.TLSConfig != nil {
+			return srv.ListenAndServeTLS("", "")
+		}
+		return srv.ListenAndServe()
+	}
+
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+		if srv.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+	ln, err := l.Listen(addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+// shutdown shuts the servers down in parallel, waiting for in-flight
+// requests until the shutdown timeout. Connections still active after the
+// timeout are closed.
+func (l *Lifecycle) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.shutdownTimeout())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range l.Servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				serverLog.Error("error shutting down server", "addr", srv.Addr, "error", err)
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
+
+// SwapHandler is an http.Handler that serves the handler last passed to
+// Swap. It allows replacing the handler of a running server, e.g. when the
+// configuration is reloaded.
+type SwapHandler struct {
+	h atomic.Value
+}
+
+// NewSwapHandler returns a SwapHandler that serves h.
+func NewSwapHandler(h http.Handler) *SwapHandler {
+	s := &SwapHandler{}
+	s.Swap(h)
+	return s
+}
+
+// Swap replaces the served handler by h. Requests in flight complete with
+// the previous handler.
+func (s *SwapHandler) Swap(h http.Handler) {
+	s.h.Store(&h)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load().(*http.Handler)).ServeHTTP(w, r)
+}
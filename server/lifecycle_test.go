@@ -0,0 +1,192 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/server"
+)
+
+// freeAddr returns a local address with a free port.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+// waitListening waits until addr accepts connections.
+func waitListening(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			require.NoError(t, conn.Close())
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", addr)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestNewHTTPServer(t *testing.T) {
+	h := http.NotFoundHandler()
+	srv := server.NewHTTPServer(":5050", h, server.DefaultTimeouts)
+	assert.Equal(t, ":5050", srv.Addr)
+	assert.Equal(t, 5*time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(t, 15*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 30*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, srv.IdleTimeout)
+	assert.Equal(t, 64<<10, srv.MaxHeaderBytes)
+}
+
+func TestLifecycle_GracefulShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	addr := freeAddr(t)
+	srv := server.NewHTTPServer(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	}), server.DefaultTimeouts)
+
+	var closed []string
+	lifecycle := &server.Lifecycle{
+		Servers: []*http.Server{srv},
+		Closers: []io.Closer{
+			closerFunc(func() error { closed = append(closed, "store"); return nil }),
+			closerFunc(func() error { closed = append(closed, "audit"); return nil }),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- lifecycle.Run(ctx) }()
+	waitListening(t, addr)
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result)
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		response <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	// the in-flight request completes after the shutdown started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err, "new connections are refused")
+	assert.Empty(t, closed, "closers wait for in-flight requests")
+
+	close(release)
+	r := <-response
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"store", "audit"}, closed)
+}
+
+func TestLifecycle_ShutdownTimeout(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan struct{})
+	srv := server.NewHTTPServer(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}), server.DefaultTimeouts)
+
+	lifecycle := &server.Lifecycle{
+		Servers:         []*http.Server{srv},
+		ShutdownTimeout: 50 * time.Millisecond,
+		Closers:         []io.Closer{closerFunc(func() error { return errors.New("flush failed") })},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- lifecycle.Run(ctx) }()
+	waitListening(t, addr)
+
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "flush failed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not time out")
+	}
+}
+
+func TestLifecycle_ServerFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+
+	// the address is already in use
+	lifecycle := &server.Lifecycle{
+		Servers: []*http.Server{server.NewHTTPServer(l.Addr().String(), http.NotFoundHandler(), server.DefaultTimeouts)},
+	}
+	assert.Error(t, lifecycle.Run(context.Background()))
+}
+
+func TestLifecycle_Listen(t *testing.T) {
+	addr := freeAddr(t)
+	srv := server.NewHTTPServer(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "done")
+	}), server.DefaultTimeouts)
+
+	var listened []string
+	lifecycle := &server.Lifecycle{
+		Servers: []*http.Server{srv},
+		Listen: func(addr string) (net.Listener, error) {
+			listened = append(listened, addr)
+			return net.Listen("tcp", addr)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- lifecycle.Run(ctx) }()
+	waitListening(t, addr)
+
+	resp, err := http.Get("http://" + addr)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "done", string(body))
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{addr}, listened)
+}
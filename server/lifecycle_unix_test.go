@@ -0,0 +1,63 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+//go:build !windows
+// +build !windows
+
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/server"
+)
+
+func TestLifecycle_Reload(t *testing.T) {
+	addr := freeAddr(t)
+	h := server.NewSwapHandler(echo("v1"))
+
+	reloaded := make(chan struct{})
+	lifecycle := &server.Lifecycle{
+		Servers: []*http.Server{server.NewHTTPServer(addr, h, server.DefaultTimeouts)},
+		Reload: func() error {
+			h.Swap(echo("v2"))
+			close(reloaded)
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- lifecycle.Run(ctx) }()
+	waitListening(t, addr)
+
+	get := func() string {
+		resp, err := http.Get("http://" + addr)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	assert.Equal(t, "v1", get())
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+	assert.Equal(t, "v2", get())
+
+	cancel()
+	require.NoError(t, <-done)
+}
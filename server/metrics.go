@@ -0,0 +1,93 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"phobia.cloud/api/metrics"
+)
+
+// UnmatchedRoute is the route label of requests that match no route.
+const UnmatchedRoute = "unmatched"
+
+// HTTPMetrics are the metrics of the HTTP traffic of routers. The routes are
+// labeled with their patterns, so the number of series is bounded.
+type HTTPMetrics struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+	inFlight *metrics.Gauge
+}
+
+// NewHTTPMetrics registers the metrics of the HTTP traffic in r.
+func NewHTTPMetrics(r *metrics.Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: r.Counter("phobia_http_requests_total",
+			"Number of HTTP requests by route, method and status code.",
+			"route", "method", "code"),
+		duration: r.Histogram("phobia_http_request_duration_seconds",
+			"Duration of HTTP requests by route and method.", metrics.DefaultBuckets,
+			"route", "method"),
+		inFlight: r.Gauge("phobia_http_requests_in_flight",
+			"Number of HTTP requests being served by route.",
+			"route"),
+	}
+}
+
+// instrument calls serve with a writer that records the status code and
+// records the request for route.
+func (m *HTTPMetrics) instrument(route string, w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter)) {
+	method := methodLabel(r.Method)
+	m.inFlight.Inc(route)
+	defer m.inFlight.Dec(route)
+
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	serve(sw)
+
+	m.duration.ObserveSince(start, route, method)
+	m.requests.Inc(route, method, strconv.Itoa(sw.code()))
+}
+
+// methodLabel returns the label of method. Unknown methods share a label,
+// so clients cannot create arbitrary series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// statusWriter is a http.ResponseWriter that records the status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// code returns the status code of the response.
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
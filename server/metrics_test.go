@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/server"
+)
+
+func TestRouter_Metrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	router := server.NewRouter("/v1")
+	router.Aliases = true
+	router.Metrics = server.NewHTTPMetrics(registry)
+
+	var inFlight string
+	router.HandleFunc(http.MethodGet, "/users/{user}", func(w http.ResponseWriter, r *http.Request) {
+		rr := serve(t, registry, http.MethodGet, "/metrics")
+		inFlight = rr.Body.String()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	router.Handle(http.MethodPost, "/login", echo("login"))
+
+	serve(t, router, http.MethodGet, "http://phobia.cloud/v1/users/alice")
+	serve(t, router, http.MethodGet, "http://phobia.cloud/users/bob")
+	serve(t, router, http.MethodPost, "http://phobia.cloud/login")
+	serve(t, router, http.MethodDelete, "http://phobia.cloud/login")
+	serve(t, router, "PURGE", "http://phobia.cloud/login")
+	serve(t, router, http.MethodGet, "http://phobia.cloud/missing")
+
+	assert.Contains(t, inFlight, `phobia_http_requests_in_flight{route="/v1/users/{user}"} 1`+"\n")
+
+	rr := serve(t, registry, http.MethodGet, "/metrics")
+	require.Equal(t, http.StatusOK, rr.Code)
+	for _, line := range []string{
+		`phobia_http_requests_total{route="/v1/users/{user}",method="GET",code="202"} 2`,
+		`phobia_http_requests_total{route="/v1/login",method="POST",code="200"} 1`,
+		`phobia_http_requests_total{route="/v1/login",method="DELETE",code="405"} 1`,
+		`phobia_http_requests_total{route="/v1/login",method="OTHER",code="405"} 1`,
+		`phobia_http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`phobia_http_request_duration_seconds_count{route="/v1/users/{user}",method="GET"} 2`,
+		`phobia_http_requests_in_flight{route="/v1/users/{user}"} 0`,
+	} {
+		assert.Contains(t, rr.Body.String(), line+"\n")
+	}
+}
+
+func TestAPI_Metrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	api := &server.API{Metrics: registry}
+	router := api.Handler()
+
+	serve(t, router, http.MethodGet, "http://phobia.cloud/v1/challenge")
+	serve(t, router, http.MethodGet, "http://phobia.cloud/healthz")
+
+	// handlers created again, e.g. on reload, record to the same metrics
+	router = api.Handler()
+	serve(t, router, http.MethodGet, "http://phobia.cloud/challenge")
+
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/metrics")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, metrics.ContentType, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `phobia_http_requests_total{route="/v1/challenge",method="GET",code="200"} 2`+"\n")
+	assert.Contains(t, rr.Body.String(), `phobia_http_requests_total{route="/healthz",method="GET",code="200"} 1`+"\n")
+
+	rr = serve(t, (&server.API{}).Handler(), http.MethodGet, "http://phobia.cloud/metrics")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,286 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/buildinfo"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/openapi"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webhook"
+)
+
+// documentedTypes are the Go types of the component schemas of the OpenAPI
+// document.
+var documentedTypes = map[string]interface{}{
+	"Base64URL":                      handler.Base64URL{},
+	"ChallengeResponse":              handler.ChallengeResponse{},
+	"LoginRequest":                   handler.LoginRequest{},
+	"EthereumLoginRequest":           handler.EthereumLoginRequest{},
+	"LNURLResponse":                  handler.LNURLResponse{},
+	"LNURLStatusResponse":            handler.LNURLStatusResponse{},
+	"WebAuthnBeginRequest":           handler.WebAuthnBeginRequest{},
+	"WebAuthnCredentialDescriptor":   handler.WebAuthnCredentialDescriptor{},
+	"WebAuthnRelyingParty":           handler.WebAuthnRelyingParty{},
+	"WebAuthnUser":                   handler.WebAuthnUser{},
+	"WebAuthnCredentialParameters":   handler.WebAuthnCredentialParameters{},
+	"WebAuthnAuthenticatorSelection": handler.WebAuthnAuthenticatorSelection{},
+	"WebAuthnCreationOptions":        handler.WebAuthnCreationOptions{},
+	"WebAuthnRequestOptions":         handler.WebAuthnRequestOptions{},
+	"WebAuthnRegistration":           handler.WebAuthnRegistration{},
+	"WebAuthnAssertion":              handler.WebAuthnAssertion{},
+	"Problem":                        problem.Problem{},
+	"FieldError":                     problem.FieldError{},
+	"HealthResponse":                 server.HealthResponse{},
+	"CheckResult":                    server.CheckResult{},
+	"BuildInfo":                      buildinfo.Info{},
+	"ProofOfWorkResponse":            handler.ProofOfWorkResponse{},
+	"LockoutsResponse":               abuse.LockoutsResponse{},
+	"Lockout":                        abuse.Lockout{},
+	"DeadLettersResponse":            webhook.DeadLettersResponse{},
+	"WebhookDelivery":                webhook.Delivery{},
+	"WebhookEvent":                   webhook.Event{},
+	"WebhookEventData":               webhook.Data{},
+}
+
+// documentedBodies are the JSON request and success response bodies of the
+// operations of the OpenAPI document. Nil means that the body is not a
+// documented Go type.
+var documentedBodies = map[string]struct{ request, response interface{} }{
+	"GET /v1/challenge":                       {nil, handler.ChallengeResponse{}},
+	"GET /v1/challenge/pow":                   {nil, handler.ProofOfWorkResponse{}},
+	"GET /v1/challenge/qr":                    {nil, nil},
+	"POST /v1/login":                          {handler.LoginRequest{}, nil},
+	"POST /v1/login/nostr":                    {nil, nil},
+	"POST /v1/login/ethereum":                 {handler.EthereumLoginRequest{}, nil},
+	"GET /v1/lnurl":                           {nil, handler.LNURLResponse{}},
+	"GET /v1/lnurl/status":                    {nil, handler.LNURLStatusResponse{}},
+	"POST /v1/webauthn/register/begin":        {handler.WebAuthnBeginRequest{}, handler.WebAuthnCreationOptions{}},
+	"POST /v1/webauthn/register/finish":       {handler.WebAuthnRegistration{}, nil},
+	"POST /v1/webauthn/login/begin":           {handler.WebAuthnBeginRequest{}, handler.WebAuthnRequestOptions{}},
+	"POST /v1/webauthn/login/finish":          {handler.WebAuthnAssertion{}, nil},
+	"GET /healthz":                            {nil, server.HealthResponse{}},
+	"GET /readyz":                             {nil, server.HealthResponse{}},
+	"GET /version":                            {nil, buildinfo.Info{}},
+	"GET /metrics":                            {nil, nil},
+	"GET /lockouts":                           {nil, abuse.LockoutsResponse{}},
+	"GET /webhooks/dead-letters":              {nil, webhook.DeadLettersResponse{}},
+	"POST /webhooks/dead-letters/{id}/replay": {nil, nil},
+	"GET /openapi.json":                       {nil, nil},
+	"GET /docs":                               {nil, nil},
+}
+
+// structuralKeywords are the keywords of the schemas that a Reflector
+// derives from Go types. The document may add others, e.g. descriptions.
+var structuralKeywords = []string{"type", "properties", "required", "items", "additionalProperties", "contentEncoding", "$ref"}
+
+type document struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema interface{} `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema interface{} `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+func loadDocument(t *testing.T) document {
+	var doc document
+	require.NoError(t, json.Unmarshal(openapi.Document(), &doc))
+	return doc
+}
+
+// typeName returns the name of the component schema of v.
+func typeName(t *testing.T, v interface{}) string {
+	for name, documented := range documentedTypes {
+		if reflect.TypeOf(documented) == reflect.TypeOf(v) {
+			return name
+		}
+	}
+	require.Failf(t, "type is not documented", "%T", v)
+	return ""
+}
+
+// normalize returns v as decoded from JSON.
+func normalize(t *testing.T, v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	var n interface{}
+	require.NoError(t, json.Unmarshal(data, &n))
+	return n
+}
+
+// structural returns the structural keywords of the schema.
+func structural(schema interface{}) interface{} {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+	s := make(map[string]interface{})
+	for _, key := range structuralKeywords {
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+		switch key {
+		case "properties":
+			properties := make(map[string]interface{})
+			for name, p := range value.(map[string]interface{}) {
+				properties[name] = structural(p)
+			}
+			value = properties
+		case "items", "additionalProperties":
+			value = structural(value)
+		}
+		s[key] = value
+	}
+	return s
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	s := store.NewMemory()
+	gate, err := pow.NewGate(nil)
+	require.NoError(t, err)
+	api := &server.API{
+		Challenge: &handler.ChallengeHandler{PoW: gate},
+		LNURL:     &handler.LNURLAuth{Store: s},
+		WebAuthn: &handler.WebAuthn{
+			RelyingParty: &webauthn.RelyingParty{ID: "phobia.cloud", Name: "phobia.cloud", Origins: []string{"https://phobia.cloud"}},
+			Credentials:  &webauthn.Credentials{Store: s},
+			Store:        s,
+		},
+		Metrics:  metrics.NewRegistry(),
+		Viewer:   true,
+		Lockouts: abuse.NewDetector(),
+		Webhooks: webhook.NewDispatcher(s, nil, webhook.Policy{}),
+	}
+	defer func() { _ = api.Webhooks.Close() }()
+
+	var served []string
+	for _, route := range append(api.Router().Routes(), api.HealthRouter().Routes()...) {
+		for _, method := range route.Methods {
+			served = append(served, method+" "+route.Pattern)
+		}
+	}
+
+	var documented []string
+	for path, item := range loadDocument(t).Paths {
+		for method := range item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	assert.ElementsMatch(t, served, documented)
+}
+
+func TestOpenAPI_Schemas(t *testing.T) {
+	reflector := &openapi.Reflector{
+		Refs: make(map[reflect.Type]string),
+		Types: map[reflect.Type]openapi.Schema{
+			reflect.TypeOf(handler.Base64URL{}): {"type": "string", "contentEncoding": "base64url"},
+			reflect.TypeOf(time.Time{}):         {"type": "string"},
+		},
+	}
+	for name, v := range documentedTypes {
+		reflector.Refs[reflect.TypeOf(v)] = name
+	}
+
+	schemas := loadDocument(t).Components.Schemas
+	for name := range schemas {
+		assert.Contains(t, documentedTypes, name, "schema %s describes no Go type", name)
+	}
+	for name, v := range documentedTypes {
+		require.Contains(t, schemas, name, "type %T is not documented", v)
+		generated := normalize(t, reflector.Schema(reflect.TypeOf(v)))
+		assert.Equal(t, generated, structural(schemas[name]), "schema %s drifted from %T", name, v)
+	}
+}
+
+func TestOpenAPI_Bodies(t *testing.T) {
+	doc := loadDocument(t)
+
+	// ref returns the name of the component schema referenced by the
+	// schema, or an empty string if it references none.
+	ref := func(schema interface{}) string {
+		m, _ := schema.(map[string]interface{})
+		r, _ := m["$ref"].(string)
+		return strings.TrimPrefix(r, "#/components/schemas/")
+	}
+	// want returns the name of the component schema of v, if any.
+	want := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return typeName(t, v)
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			key := strings.ToUpper(method) + " " + path
+			bodies, ok := documentedBodies[key]
+			if !assert.True(t, ok, "bodies of %s are not listed", key) {
+				continue
+			}
+
+			var request interface{}
+			if op.RequestBody != nil {
+				request = op.RequestBody.Content["application/json"].Schema
+			}
+			assert.Equal(t, want(bodies.request), ref(request), "request of %s", key)
+
+			var response interface{}
+			for status, r := range op.Responses {
+				if strings.HasPrefix(status, "2") {
+					response = r.Content["application/json"].Schema
+				}
+			}
+			assert.Equal(t, want(bodies.response), ref(response), "response of %s", key)
+		}
+	}
+}
+
+func TestAPI_OpenAPI(t *testing.T) {
+	router := (&server.API{}).Handler()
+
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/openapi.json")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, openapi.Document(), rr.Body.Bytes())
+
+	rr = serve(t, router, http.MethodGet, "http://phobia.cloud/docs")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	router = (&server.API{Viewer: true}).Handler()
+	rr = serve(t, router, http.MethodGet, "http://phobia.cloud/docs")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+}
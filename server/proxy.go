@@ -0,0 +1,186 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"phobia.cloud/api/remote"
+)
+
+// Headers of the requests forwarded by proxies.
+const (
+	// ForwardedHeader is the standard header of RFC 7239, in which proxies
+	// append the address and protocol of the client they forward a request
+	// for, e.g. "for=192.0.2.1;proto=https".
+	ForwardedHeader = "Forwarded"
+	// ForwardedForHeader is the header in which proxies append the address
+	// of the client they forward a request for.
+	ForwardedForHeader = "X-Forwarded-For"
+	// ForwardedProtoHeader is the header in which proxies set or append the
+	// scheme requested by the client they forward a request for.
+	ForwardedProtoHeader = "X-Forwarded-Proto"
+)
+
+// TrustedProxies are the reverse proxies in front of the server. Only the
+// hops that they append to the Forwarded or X-Forwarded-For header are
+// trusted, so clients cannot spoof their address with the headers.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// ParseTrustedProxies returns the proxies with the IP addresses or CIDR
+// ranges, e.g. "10.0.0.0/8".
+func ParseTrustedProxies(addrs []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address: %q", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address: %q", addr)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	return p, nil
+}
+
+// trusted reports whether ip is the address of a trusted proxy.
+func (p *TrustedProxies) trusted(ip net.IP) bool {
+	if p == nil || ip == nil {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns the client that sent r. If r comes from a trusted proxy,
+// it is the last hop of the Forwarded header, or of the X-Forwarded-For and
+// X-Forwarded-Proto headers if there is none, that is not a trusted proxy.
+func (p *TrustedProxies) Client(r *http.Request) remote.Client {
+	c := remote.Peer(r)
+	if !p.trusted(net.ParseIP(c.IP)) {
+		return c
+	}
+
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].ip == nil {
+			// the proxy does not forward the hop in a known format
+			break
+		}
+		c.IP = hops[i].ip.String()
+		if hops[i].proto != "" {
+			c.Scheme = hops[i].proto
+		}
+		if !p.trusted(hops[i].ip) {
+			break
+		}
+	}
+	return c
+}
+
+// hop is a hop of a forwarded request.
+type hop struct {
+	ip    net.IP
+	proto string
+}
+
+// forwardedHops returns the hops of r from the client to the nearest proxy.
+func forwardedHops(r *http.Request) []hop {
+	var hops []hop
+	if forwarded := r.Header.Values(ForwardedHeader); len(forwarded) > 0 {
+		for _, element := range splitList(forwarded) {
+			var h hop
+			for _, pair := range strings.Split(element, ";") {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[:i])) {
+				case "for":
+					h.ip = parseForwarded(value)
+				case "proto":
+					h.proto = parseProto(value)
+				}
+			}
+			hops = append(hops, h)
+		}
+		return hops
+	}
+
+	for _, addr := range splitList(r.Header.Values(ForwardedForHeader)) {
+		hops = append(hops, hop{ip: parseForwarded(addr)})
+	}
+	protos := splitList(r.Header.Values(ForwardedProtoHeader))
+	for i := range hops {
+		switch {
+		case len(protos) == len(hops):
+			hops[i].proto = parseProto(protos[i])
+		case len(protos) > 0:
+			// the proxies do not append a scheme per hop, so the scheme
+			// set by the nearest proxy applies to all
+			hops[i].proto = parseProto(protos[len(protos)-1])
+		}
+	}
+	return hops
+}
+
+// splitList returns the elements of the comma-separated values of a header.
+func splitList(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return strings.Split(strings.Join(values, ","), ",")
+}
+
+// parseForwarded parses an address of the Forwarded or X-Forwarded-For
+// header, which may have a port.
+func parseForwarded(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// parseProto returns the scheme of a forwarded protocol, or an empty string
+// if it is not http or https.
+func parseProto(s string) string {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "http", "https":
+		return s
+	default:
+		return ""
+	}
+}
+
+// RealIP returns a handler that resolves the client of each request with p
+// and serves h with the client in the context of the request, from where it
+// is read with remote.IP and remote.Scheme. If p is nil, the peer of the
+// connection is the client.
+func RealIP(p *TrustedProxies, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := remote.NewContext(r.Context(), p.Client(r))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/remote"
+	"phobia.cloud/api/server"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	_, err := server.ParseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "2001:db8::/32", "::1"})
+	require.NoError(t, err)
+
+	for _, addr := range []string{"proxy", "10.0.0.0/33", "10.0.0.1:80"} {
+		_, err := server.ParseTrustedProxies([]string{addr})
+		assert.EqualError(t, err, `invalid proxy address: "`+addr+`"`)
+	}
+}
+
+func TestTrustedProxies_Client(t *testing.T) {
+	proxies, err := server.ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name    string
+		proxies *server.TrustedProxies
+		remote  string
+		headers map[string][]string
+		ip      string
+		scheme  string
+	}{
+		{name: "direct", proxies: proxies, remote: "203.0.113.7:52114", ip: "203.0.113.7"},
+		{
+			name: "untrusted peer", proxies: proxies, remote: "203.0.113.7:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1"}, server.ForwardedProtoHeader: {"https"}},
+			ip:      "203.0.113.7",
+		},
+		{
+			name: "no proxies", remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1"}},
+			ip:      "10.0.0.2",
+		},
+		{
+			name: "trusted peer", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1"}, server.ForwardedProtoHeader: {"https"}},
+			ip:      "198.51.100.1", scheme: "https",
+		},
+		{
+			name: "spoofed", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"192.0.2.1, 198.51.100.1"}},
+			ip:      "198.51.100.1",
+		},
+		{
+			name: "chain", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1", "10.1.0.1"}, server.ForwardedProtoHeader: {"https, http"}},
+			ip:      "198.51.100.1", scheme: "https",
+		},
+		{
+			name: "proto set by the nearest proxy", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1, 10.1.0.1"}, server.ForwardedProtoHeader: {"https"}},
+			ip:      "198.51.100.1", scheme: "https",
+		},
+		{
+			name: "unknown proto", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1"}, server.ForwardedProtoHeader: {"wss"}},
+			ip:      "198.51.100.1",
+		},
+		{
+			name: "only proxies", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"10.0.0.3"}},
+			ip:      "10.0.0.3",
+		},
+		{
+			name: "port", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"198.51.100.1:4711"}},
+			ip:      "198.51.100.1",
+		},
+		{
+			name: "ipv6", proxies: proxies, remote: "[2001:db8::1]:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"[2001:db8::2]:4711"}},
+			ip:      "2001:db8::2",
+		},
+		{
+			name: "malformed", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedForHeader: {"unknown"}},
+			ip:      "10.0.0.2",
+		},
+		{
+			name: "forwarded", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedHeader: {`for=192.0.2.1, for="198.51.100.1:4711";proto=https;by=10.1.0.1`, "For=10.1.0.2;Proto=http"}},
+			ip:      "198.51.100.1", scheme: "https",
+		},
+		{
+			name: "forwarded ipv6", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedHeader: {`for="[2001:db8:cafe::17]:4711";proto=https`}},
+			ip:      "2001:db8:cafe::17", scheme: "https",
+		},
+		{
+			name: "forwarded obfuscated", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedHeader: {"for=_hidden;proto=https"}},
+			ip:      "10.0.0.2",
+		},
+		{
+			name: "forwarded takes precedence", proxies: proxies, remote: "10.0.0.2:52114",
+			headers: map[string][]string{server.ForwardedHeader: {"for=198.51.100.1"}, server.ForwardedForHeader: {"192.0.2.1"}},
+			ip:      "198.51.100.1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/v1/challenge", nil)
+			req.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			scheme := tt.scheme
+			if scheme == "" {
+				scheme = "http"
+			}
+			assert.Equal(t, remote.Client{IP: tt.ip, Scheme: scheme}, tt.proxies.Client(req))
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	buf := captureLog(t)
+
+	proxies, err := server.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	h := server.RealIP(proxies, server.AccessLog(echo("challenge")))
+
+	req := httptest.NewRequest(http.MethodGet, "http://phobia.cloud/v1/challenge", nil)
+	req.RemoteAddr = "10.0.0.2:52114"
+	req.Header.Set(server.ForwardedForHeader, "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logEntries(t, buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "198.51.100.1", entries[0]["client_ip"])
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts the binary header of the PROXY protocol v2.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the maximum length of the text header of the PROXY
+// protocol v1, including the CRLF.
+const proxyV1MaxLength = 107
+
+// ErrProxyHeader is returned when reading from a connection of a trusted
+// proxy that does not start with a valid PROXY protocol header.
+var ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+// ProxyProtocolListener is a net.Listener that accepts the PROXY protocol
+// v1 and v2 on the connections of trusted proxies, e.g. load balancers
+// that forward TCP, so the remote address of the connections is the
+// address of the client instead of the proxy. The connections of other
+// peers are returned unchanged.
+type ProxyProtocolListener struct {
+	net.Listener
+	// Proxies are the proxies that must send the header.
+	Proxies *TrustedProxies
+	// Timeout is how long reading the header may take. If zero, there is
+	// no limit.
+	Timeout time.Duration
+}
+
+// Accept implements net.Listener. The header is read on the first Read or
+// RemoteAddr of the connection, so a slow proxy does not block Accept.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.Proxies.trusted(addr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, timeout: l.Timeout}, nil
+}
+
+// proxyConn is a connection of a trusted proxy that starts with a PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+// init reads the header once.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			serverLog.Warn("error reading PROXY protocol header", "proxy", c.Conn.RemoteAddr().String(), "error", c.err)
+		}
+	})
+}
+
+// Read implements net.Conn. It fails if the header is invalid.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr implements net.Conn. It is the source address of the header,
+// or the address of the proxy if the header has none, e.g. for health
+// checks of the proxy.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from r and returns
+// its source address, or nil if it has none.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+	}
+	if string(prefix) == "PROXY" {
+		return readProxyV1(r)
+	}
+	prefix, err = r.Peek(len(proxyV2Signature))
+	if err != nil || !bytes.Equal(prefix, proxyV2Signature) {
+		return nil, fmt.Errorf("%w: missing signature", ErrProxyHeader)
+	}
+	return readProxyV2(r)
+}
+
+// readProxyV1 reads a text header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: line too long", ErrProxyHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: %q", ErrProxyHeader, line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrProxyHeader, line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrProxyHeader, header[12]>>4)
+	}
+	switch header[12] & 0x0f {
+	case 0:
+		// LOCAL, e.g. a health check of the proxy itself
+		return nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrProxyHeader, header[12]&0x0f)
+	}
+
+	// the addresses are followed by TLVs, which are skipped
+	var size int
+	switch header[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// UNSPEC or UNIX
+		return nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, fmt.Errorf("%w: short address block", ErrProxyHeader)
+	}
+	ip := make(net.IP, size)
+	copy(ip, payload[:size])
+	port := binary.BigEndian.Uint16(payload[2*size:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
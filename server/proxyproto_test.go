@@ -0,0 +1,136 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/server"
+)
+
+// proxyV2 returns a PROXY protocol v2 header with the command, family and
+// payload.
+func proxyV2(command, family byte, payload []byte) []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// serveProxyProtocol serves the remote address of the requests on a
+// ProxyProtocolListener that trusts proxies and returns its address.
+func serveProxyProtocol(t *testing.T, proxies []string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	trusted, err := server.ParseTrustedProxies(proxies)
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go func() {
+		_ = srv.Serve(&server.ProxyProtocolListener{Listener: l, Proxies: trusted, Timeout: time.Second})
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String()
+}
+
+// proxyRequest sends a request after header to addr and returns the status
+// and body of the response. The status is 0 if the connection is closed
+// without a response.
+func proxyRequest(t *testing.T, addr string, header []byte) (int, string) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write(append(header, "GET / HTTP/1.1\r\nHost: phobia.cloud\r\nConnection: close\r\n\r\n"...))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, ""
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	captureLog(t)
+	addr := serveProxyProtocol(t, []string{"127.0.0.1"})
+
+	ipv4 := append(net.ParseIP("192.0.2.1").To4(), net.ParseIP("198.51.100.1").To4()...)
+	ipv4 = append(ipv4, 0xdc, 0x04, 0x01, 0xbb)
+	ipv6 := append(net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::1")...)
+	ipv6 = append(ipv6, 0x12, 0x67, 0x01, 0xbb)
+
+	for _, tt := range []struct {
+		name   string
+		header []byte
+		remote string
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), remote: "192.0.2.1:56324"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::2 2001:db8::1 4711 443\r\n"), remote: "[2001:db8::2]:4711"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n"), remote: "127.0.0.1"},
+		{name: "v2 tcp4", header: proxyV2(1, 0x11, ipv4), remote: "192.0.2.1:56324"},
+		{name: "v2 tcp6", header: proxyV2(1, 0x21, ipv6), remote: "[2001:db8::2]:4711"},
+		{name: "v2 tlvs", header: proxyV2(1, 0x11, append(ipv4, 0x02, 0x00, 0x03, 'a', 'p', 'i')), remote: "192.0.2.1:56324"},
+		{name: "v2 local", header: proxyV2(0, 0x00, nil), remote: "127.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := proxyRequest(t, addr, tt.header)
+			require.Equal(t, http.StatusOK, status)
+			if host, _, err := net.SplitHostPort(body); err == nil && tt.remote == "127.0.0.1" {
+				body = host
+			}
+			assert.Equal(t, tt.remote, body)
+		})
+	}
+}
+
+func TestProxyProtocolListener_Invalid(t *testing.T) {
+	captureLog(t)
+	addr := serveProxyProtocol(t, []string{"127.0.0.1"})
+
+	for _, tt := range []struct {
+		name   string
+		header []byte
+	}{
+		{name: "missing", header: nil},
+		{name: "v1 malformed", header: []byte("PROXY TCP4 192.0.2.1\r\n")},
+		{name: "v1 family mismatch", header: []byte("PROXY TCP4 2001:db8::2 2001:db8::1 4711 443\r\n")},
+		{name: "v1 invalid port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n")},
+		{name: "v1 too long", header: append([]byte("PROXY TCP4 "), make([]byte, 120)...)},
+		{name: "v2 short address", header: proxyV2(1, 0x11, []byte{192, 0, 2, 1})},
+		{name: "v2 invalid command", header: proxyV2(2, 0x11, nil)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := proxyRequest(t, addr, tt.header)
+			assert.NotEqual(t, http.StatusOK, status)
+		})
+	}
+}
+
+func TestProxyProtocolListener_Untrusted(t *testing.T) {
+	addr := serveProxyProtocol(t, []string{"10.0.0.0/8"})
+
+	// the header is not expected from untrusted peers
+	status, body := proxyRequest(t, addr, nil)
+	require.Equal(t, http.StatusOK, status)
+	host, _, err := net.SplitHostPort(body)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+
+	status, _ = proxyRequest(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/remote"
+)
+
+// Headers of rate limited responses.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// RateLimit limits the rate of requests to routes by the IP address of the
+// client and, for routes whose requests identify a key, e.g. the public key
+// of a login, by the key. IPv6 clients are limited by their /64 network, as
+// they usually have all of its addresses.
+//
+// Requests over a limit are answered with 429 Too Many Requests and a
+// Retry-After header. The responses of limited routes carry the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of the
+// most exhausted limit. If the Store fails, requests are allowed.
+type RateLimit struct {
+	// Store keeps the token buckets.
+	Store ratelimit.Store
+	// IP are the limits per client IP by route pattern, e.g. "/v1/login".
+	IP map[string]ratelimit.Limit
+	// Key are the limits per key by route pattern. They apply only to the
+	// routes of the API whose requests identify a key.
+	Key map[string]ratelimit.Limit
+
+	// keys return the keys of requests by route pattern.
+	keys map[string]func(*http.Request) string
+}
+
+// withKeys returns a copy of l that limits the requests to routes by the
+// keys returned by the functions.
+func (l *RateLimit) withKeys(keys map[string]func(*http.Request) string) *RateLimit {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.keys = keys
+	return &c
+}
+
+// allow takes a token for r from the buckets of the route. If a limit is
+// exceeded, it answers r and returns false.
+func (l *RateLimit) allow(w http.ResponseWriter, r *http.Request, route string) bool {
+	if l == nil {
+		return true
+	}
+
+	var headers *ratelimit.Result
+	for _, scope := range []struct {
+		name   string
+		limits map[string]ratelimit.Limit
+		key    func(*http.Request) string
+	}{
+		{"ip", l.IP, ipKey},
+		{"key", l.Key, l.keys[route]},
+	} {
+		limit, ok := scope.limits[route]
+		if !ok || scope.key == nil {
+			continue
+		}
+		key := scope.key(r)
+		if key == "" {
+			continue
+		}
+
+		result, err := l.Store.Take(r.Context(), scope.name+"|"+route+"|"+key, limit)
+		if err != nil {
+			serverLog.Context(r.Context()).Error("error taking rate limit token", "error", err)
+			continue
+		}
+		if !result.Allowed {
+			setRateLimitHeaders(w, result)
+			retryAfter := seconds(result.RetryAfter)
+			w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+			logging.Set(r.Context(), "rate_limited", scope.name)
+			problem.Error(w, http.StatusTooManyRequests, problem.TooManyRequests,
+				fmt.Sprintf("rate limit exceeded, retry in %d seconds", retryAfter))
+			return false
+		}
+		if headers == nil || result.Remaining < headers.Remaining {
+			headers = &result
+		}
+	}
+
+	if headers != nil {
+		setRateLimitHeaders(w, *headers)
+	}
+	return true
+}
+
+// ipKey returns the rate limit key of the client IP of r.
+func ipKey(r *http.Request) string {
+	ip := net.ParseIP(remote.IP(r))
+	if ip == nil {
+		return remote.IP(r)
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 8*net.IPv6len))
+	}
+	return ip.String()
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+	w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+	w.Header().Set(RateLimitResetHeader, strconv.Itoa(seconds(result.Reset)))
+}
+
+// seconds returns d in whole seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/login"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/ratelimit"
+	"phobia.cloud/api/server"
+)
+
+// serveFrom serves a request from the client with the IP address and
+// returns the response.
+func serveFrom(t *testing.T, h http.Handler, method, target, ip, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.RemoteAddr = ip + ":52114"
+	if strings.Contains(ip, ":") {
+		req.RemoteAddr = "[" + ip + "]:52114"
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimit(t *testing.T) {
+	router := server.NewRouter("/v1")
+	router.Aliases = true
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.Handle(http.MethodGet, "/lnurl", echo("lnurl"))
+	router.RateLimit = &server.RateLimit{
+		Store: ratelimit.NewMemory(),
+		IP:    map[string]ratelimit.Limit{"/v1/challenge": {Requests: 2, Period: time.Minute, Burst: 2}},
+	}
+
+	rr := serveFrom(t, router, http.MethodGet, "http://phobia.cloud/v1/challenge", "203.0.113.7", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get(server.RateLimitLimitHeader))
+	assert.Equal(t, "1", rr.Header().Get(server.RateLimitRemainingHeader))
+	assert.Equal(t, "30", rr.Header().Get(server.RateLimitResetHeader))
+
+	// the alias shares the bucket of the route
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/challenge", "203.0.113.7", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get(server.RateLimitRemainingHeader))
+
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/v1/challenge", "203.0.113.7", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get(server.RetryAfterHeader))
+	assert.Equal(t, "0", rr.Header().Get(server.RateLimitRemainingHeader))
+	assert.Equal(t, "60", rr.Header().Get(server.RateLimitResetHeader))
+
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.Equal(t, problem.TooManyRequests, p.Code)
+	assert.Equal(t, "rate limit exceeded, retry in 30 seconds", p.Detail)
+
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/v1/challenge", "198.51.100.1", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "other clients have their own bucket")
+
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/v1/lnurl", "203.0.113.7", "")
+	assert.Equal(t, http.StatusOK, rr.Code, "routes without limits are not limited")
+	assert.Empty(t, rr.Header().Get(server.RateLimitLimitHeader))
+
+	rr = serveFrom(t, router, http.MethodOptions, "http://phobia.cloud/v1/challenge", "203.0.113.7", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code, "preflight requests are not limited")
+}
+
+func TestRateLimit_IPv6(t *testing.T) {
+	router := server.NewRouter("")
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.RateLimit = &server.RateLimit{
+		Store: ratelimit.NewMemory(),
+		IP:    map[string]ratelimit.Limit{"/challenge": {Requests: 1, Period: time.Minute, Burst: 1}},
+	}
+
+	rr := serveFrom(t, router, http.MethodGet, "http://phobia.cloud/challenge", "2001:db8:1:2::1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/challenge", "2001:db8:1:2::2", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "the /64 network shares a bucket")
+
+	rr = serveFrom(t, router, http.MethodGet, "http://phobia.cloud/challenge", "2001:db8:1:3::1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+type failingLimits struct{}
+
+func (failingLimits) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("unavailable")
+}
+
+func TestRateLimit_StoreError(t *testing.T) {
+	captureLog(t)
+
+	router := server.NewRouter("")
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.RateLimit = &server.RateLimit{
+		Store: failingLimits{},
+		IP:    map[string]ratelimit.Limit{"/challenge": {Requests: 1, Period: time.Minute, Burst: 1}},
+	}
+
+	for i := 0; i < 2; i++ {
+		rr := serveFrom(t, router, http.MethodGet, "http://phobia.cloud/challenge", "203.0.113.7", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestAPI_RateLimit(t *testing.T) {
+	buf := captureLog(t)
+
+	proxies, err := server.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	api := &server.API{
+		RateLimit: &server.RateLimit{
+			Store: ratelimit.NewMemory(),
+			IP:    map[string]ratelimit.Limit{"/v1/login": {Requests: 2, Period: time.Minute, Burst: 2}},
+			Key:   map[string]ratelimit.Limit{"/v1/login": {Requests: 1, Period: time.Minute, Burst: 1}},
+		},
+		Proxies: proxies,
+	}
+	h := api.Handler()
+
+	login := func(forwardedFor, publicKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://phobia.cloud/v1/login", strings.NewReader(`{"publicKey": "`+publicKey+`"}`))
+		req.RemoteAddr = "10.0.0.2:52114"
+		req.Header.Set(server.ForwardedForHeader, forwardedFor)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := login("203.0.113.7", "023a47")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "the login handler reads the body")
+	assert.Equal(t, "0", rr.Header().Get(server.RateLimitRemainingHeader), "the most exhausted limit")
+
+	rr = login("198.51.100.1", "023A47")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "the key is limited across clients")
+
+	rr = login("198.51.100.1", "03b9c1")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = login("198.51.100.1", "03b9c2")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "the forwarded client is limited")
+	rr = login("192.0.2.1", "03b9c3")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	entries := logEntries(t, buf)
+	assert.Equal(t, "key", entries[1]["rate_limited"])
+	assert.Equal(t, "ip", entries[3]["rate_limited"])
+}
+
+func TestAPI_RateLimitKeys(t *testing.T) {
+	limit := map[string]ratelimit.Limit{
+		"/v1/login/nostr":    {Requests: 1, Period: time.Minute, Burst: 1},
+		"/v1/login/ethereum": {Requests: 1, Period: time.Minute, Burst: 1},
+	}
+	h := (&server.API{RateLimit: &server.RateLimit{Store: ratelimit.NewMemory(), Key: limit}}).Handler()
+
+	nostr := func(ip, pubkey string) int {
+		event := base64.StdEncoding.EncodeToString([]byte(`{"pubkey": "` + pubkey + `"}`))
+		req := httptest.NewRequest(http.MethodPost, "http://phobia.cloud/v1/login/nostr", nil)
+		req.RemoteAddr = ip + ":52114"
+		req.Header.Set("Authorization", "Nostr "+event)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, nostr("203.0.113.7", "3a47bc"))
+	assert.Equal(t, http.StatusTooManyRequests, nostr("198.51.100.1", "3A47BC"), "the key is limited across clients")
+	assert.Equal(t, http.StatusUnauthorized, nostr("198.51.100.1", "b9c1d2"))
+
+	ethereum := func(ip, address string) int {
+		m := &login.SIWEMessage{
+			Domain:   "phobia.cloud",
+			Address:  address,
+			URI:      "http://phobia.cloud/login/ethereum",
+			Version:  login.SIWEVersion,
+			ChainID:  1,
+			Nonce:    login.ChallengeHidden(),
+			IssuedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		body, err := json.Marshal(map[string]string{"message": m.String(), "signature": "0x00"})
+		require.NoError(t, err)
+		return serveFrom(t, h, http.MethodPost, "http://phobia.cloud/v1/login/ethereum", ip, string(body)).Code
+	}
+	assert.Equal(t, http.StatusBadRequest, ethereum("203.0.113.7", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"))
+	assert.Equal(t, http.StatusTooManyRequests, ethereum("198.51.100.1", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"), "the key is limited across clients")
+	assert.Equal(t, http.StatusBadRequest, ethereum("198.51.100.1", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"))
+}
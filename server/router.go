@@ -0,0 +1,281 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/tracing"
+)
+
+// Route is an entry of the route table of a Router.
+type Route struct {
+	// Pattern is the path pattern of the route including the prefix of the
+	// router, e.g. "/v1/lnurl/status".
+	Pattern string
+	// Alias is the path pattern of the route without the prefix of the
+	// router if the router serves aliases, e.g. "/lnurl/status".
+	Alias string
+	// Methods are the allowed methods of the route in alphabetical order.
+	// OPTIONS is implied.
+	Methods []string
+}
+
+// Router is a HTTP handler that dispatches requests to the handler
+// registered for their path and method.
+//
+// Patterns are paths in which whole segments can be path parameters in
+// braces, e.g. "/users/{user}". The values of the parameters are returned by
+// Param. Static segments take precedence over parameters.
+//
+// Requests with a method that is not allowed for the route are answered with
+// 405 Method Not Allowed and an Allow header. OPTIONS requests are answered
+// with the Allow header. CORS is handled by the CORS middleware.
+type Router struct {
+	// Prefix is prepended to the patterns of all routes, e.g. "/v1".
+	Prefix string
+	// Aliases makes the router also serve the routes at their patterns
+	// without Prefix.
+	Aliases bool
+	// Metrics records the requests by route if set.
+	Metrics *HTTPMetrics
+	// RateLimit limits the rate of requests to the routes if set.
+	RateLimit *RateLimit
+
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+// NewRouter returns a router that serves its routes under prefix.
+func NewRouter(prefix string) *Router {
+	return &Router{Prefix: prefix}
+}
+
+// Handle registers h for requests with the method and path pattern. It panics
+// if the pattern is invalid or the method is already registered for it.
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	if method == "" || method == http.MethodOptions {
+		panic(fmt.Sprintf("invalid method %q for %s", method, pattern))
+	}
+
+	for _, r := range rt.routes {
+		if r.pattern != pattern {
+			continue
+		}
+		if _, ok := r.handlers[method]; ok {
+			panic(fmt.Sprintf("%s %s is already registered", method, pattern))
+		}
+		r.handlers[method] = h
+		return
+	}
+
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: segments,
+		handlers: map[string]http.Handler{method: h},
+	})
+}
+
+// HandleFunc registers the handler function h for requests with the method
+// and path pattern.
+func (rt *Router) HandleFunc(method, pattern string, h func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(method, pattern, http.HandlerFunc(h))
+}
+
+// Routes returns the route table of the router sorted by pattern.
+func (rt *Router) Routes() []Route {
+	routes := make([]Route, 0, len(rt.routes))
+	for _, r := range rt.routes {
+		route := Route{Pattern: rt.Prefix + r.pattern, Methods: r.methods()}
+		if rt.Aliases && rt.Prefix != "" {
+			route.Alias = r.pattern
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+	return routes
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params := rt.match(r.URL.Path)
+	label := rt.label(route)
+	logging.Set(r.Context(), "route", label)
+	span := tracing.SpanFromContext(r.Context())
+	span.SetName(methodLabel(r.Method) + " " + label)
+	span.SetAttribute("http.route", label)
+
+	if rt.Metrics == nil {
+		rt.serve(w, r, route, params)
+		return
+	}
+	rt.Metrics.instrument(label, w, r, func(w http.ResponseWriter) {
+		rt.serve(w, r, route, params)
+	})
+}
+
+// label returns the pattern of route including the prefix of the router, or
+// UnmatchedRoute if route is nil.
+func (rt *Router) label(route *route) string {
+	if route == nil {
+		return UnmatchedRoute
+	}
+	return rt.Prefix + route.pattern
+}
+
+// serve serves r with the handler of the matched route and method.
+func (rt *Router) serve(w http.ResponseWriter, r *http.Request, route *route, params map[string]string) {
+	if route == nil {
+		problem.Error(w, http.StatusNotFound, problem.NotFound, fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+
+	allow := strings.Join(append(route.methods(), http.MethodOptions), ", ")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h, ok := route.handlers[r.Method]
+	if !ok {
+		w.Header().Set("Allow", allow)
+		problem.Error(w, http.StatusMethodNotAllowed, problem.MethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	if !rt.RateLimit.allow(w, r, rt.Prefix+route.pattern) {
+		return
+	}
+
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+	}
+	h.ServeHTTP(w, r)
+}
+
+// match returns the route for path and the values of its parameters.
+func (rt *Router) match(path string) (*route, map[string]string) {
+	switch {
+	case rt.Prefix == "":
+	case strings.HasPrefix(path, rt.Prefix+"/"):
+		path = strings.TrimPrefix(path, rt.Prefix)
+	case !rt.Aliases:
+		return nil, nil
+	}
+
+	segments := strings.Split(path, "/")[1:]
+
+	var best *route
+	var bestParams map[string]string
+	for _, r := range rt.routes {
+		params, ok := r.match(segments)
+		if ok && (best == nil || r.moreSpecific(best)) {
+			best, bestParams = r, params
+		}
+	}
+	return best, bestParams
+}
+
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range r.segments {
+		if name, ok := paramName(s); ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// moreSpecific reports whether r has a static segment at the first position
+// where other has a parameter.
+func (r *route) moreSpecific(other *route) bool {
+	for i := range r.segments {
+		_, param := paramName(r.segments[i])
+		_, otherParam := paramName(other.segments[i])
+		if param != otherParam {
+			return otherParam
+		}
+	}
+	return false
+}
+
+func (r *route) methods() []string {
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+type paramsKey struct{}
+
+// Param returns the value of the path parameter name of the route that
+// matched r, or an empty string if there is no such parameter.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func parsePattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with a slash: %q", pattern)
+	}
+
+	segments := strings.Split(pattern, "/")[1:]
+	names := make(map[string]bool)
+	for _, s := range segments {
+		if strings.ContainsAny(s, "{}") {
+			name, ok := paramName(s)
+			if !ok || name == "" || strings.ContainsAny(name, "{}") {
+				return nil, fmt.Errorf("invalid path parameter %q in pattern %q", s, pattern)
+			}
+			if names[name] {
+				return nil, fmt.Errorf("duplicate path parameter %q in pattern %q", name, pattern)
+			}
+			names[name] = true
+		}
+	}
+	return segments, nil
+}
+
+func paramName(segment string) (string, bool) {
+	if len(segment) < 2 || segment[0] != '{' || segment[len(segment)-1] != '}' {
+		return "", false
+	}
+	return segment[1 : len(segment)-1], true
+}
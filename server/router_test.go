@@ -0,0 +1,192 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/server"
+)
+
+// serve serves a request with the headers given as name-value pairs.
+func serve(t *testing.T, h http.Handler, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, target, nil)
+	require.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+// echo responds with the name of the handler and the path parameters.
+func echo(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+		for _, p := range params {
+			fmt.Fprintf(w, " %s=%s", p, server.Param(r, p))
+		}
+	}
+}
+
+// assertProblem asserts that rr is a problem response with the status, code
+// and detail.
+func assertProblem(t *testing.T, rr *httptest.ResponseRecorder, status int, code, detail string) {
+	t.Helper()
+
+	assert.Equal(t, status, rr.Code)
+	assert.Equal(t, problem.ContentType, rr.Header().Get("Content-Type"))
+
+	var p problem.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p), rr.Body.String())
+	assert.Equal(t, code, p.Code)
+	assert.Equal(t, detail, p.Detail)
+}
+
+func TestRouter(t *testing.T) {
+	router := server.NewRouter("/v1")
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.Handle(http.MethodGet, "/users/{user}", echo("get user", "user"))
+	router.Handle(http.MethodDelete, "/users/{user}", echo("delete user", "user"))
+	router.Handle(http.MethodGet, "/users/me", echo("me"))
+	router.Handle(http.MethodGet, "/users/{user}/keys/{key}", echo("key", "user", "key"))
+
+	for _, tt := range []struct {
+		method, target string
+		code           int
+		body           string
+	}{
+		{http.MethodGet, "/v1/challenge", http.StatusOK, "challenge"},
+		{http.MethodGet, "/v1/users/alice", http.StatusOK, "get user user=alice"},
+		{http.MethodDelete, "/v1/users/alice", http.StatusOK, "delete user user=alice"},
+		{http.MethodGet, "/v1/users/me", http.StatusOK, "me"},
+		{http.MethodGet, "/v1/users/alice/keys/1", http.StatusOK, "key user=alice key=1"},
+		{http.MethodGet, "/challenge", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/challenge/", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/users/", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/users/alice/keys", http.StatusNotFound, ""},
+		{http.MethodGet, "/v2/challenge", http.StatusNotFound, ""},
+	} {
+		rr := serve(t, router, tt.method, "http://phobia.cloud"+tt.target)
+		if tt.code == http.StatusNotFound {
+			assertProblem(t, rr, tt.code, problem.NotFound, "no route for "+tt.target)
+			continue
+		}
+		assert.Equal(t, tt.code, rr.Code, "%s %s", tt.method, tt.target)
+		assert.Equal(t, tt.body, rr.Body.String(), "%s %s", tt.method, tt.target)
+	}
+}
+
+func TestRouter_Aliases(t *testing.T) {
+	router := server.NewRouter("/v1")
+	router.Aliases = true
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+
+	for _, target := range []string{"/v1/challenge", "/challenge"} {
+		rr := serve(t, router, http.MethodGet, "http://phobia.cloud"+target)
+		assert.Equal(t, http.StatusOK, rr.Code, target)
+		assert.Equal(t, "challenge", rr.Body.String(), target)
+	}
+
+	rr := serve(t, router, http.MethodGet, "http://phobia.cloud/v1/v1/challenge")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	router := server.NewRouter("")
+	router.Handle(http.MethodPost, "/login", echo("login"))
+	router.Handle(http.MethodGet, "/users/{user}", echo("get user"))
+	router.Handle(http.MethodDelete, "/users/{user}", echo("delete user"))
+
+	for _, method := range []string{
+		http.MethodConnect,
+		http.MethodDelete,
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPatch,
+		http.MethodPut,
+		http.MethodTrace,
+	} {
+		rr := serve(t, router, method, "http://phobia.cloud/login")
+		assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, method)
+		assert.Equal(t, "POST, OPTIONS", rr.Header().Get("Allow"), method)
+		assertProblem(t, rr, http.StatusMethodNotAllowed, problem.MethodNotAllowed, "method "+method+" is not allowed")
+	}
+
+	rr := serve(t, router, http.MethodPost, "http://phobia.cloud/users/alice")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "DELETE, GET, OPTIONS", rr.Header().Get("Allow"))
+}
+
+func TestRouter_Options(t *testing.T) {
+	router := server.NewRouter("")
+	router.Handle(http.MethodPost, "/login", echo("login"))
+	router.Handle(http.MethodGet, "/login", echo("login"))
+
+	rr := serve(t, router, http.MethodOptions, "http://phobia.cloud/login")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "GET, POST, OPTIONS", rr.Header().Get("Allow"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Body.String())
+
+	rr = serve(t, router, http.MethodOptions, "http://phobia.cloud/logout")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRouter_Routes(t *testing.T) {
+	router := server.NewRouter("/v1")
+	router.Handle(http.MethodPost, "/login", echo("login"))
+	router.Handle(http.MethodGet, "/challenge", echo("challenge"))
+	router.Handle(http.MethodGet, "/login", echo("login"))
+
+	assert.Equal(t, []server.Route{
+		{Pattern: "/v1/challenge", Methods: []string{http.MethodGet}},
+		{Pattern: "/v1/login", Methods: []string{http.MethodGet, http.MethodPost}},
+	}, router.Routes())
+
+	router.Aliases = true
+	assert.Equal(t, []server.Route{
+		{Pattern: "/v1/challenge", Alias: "/challenge", Methods: []string{http.MethodGet}},
+		{Pattern: "/v1/login", Alias: "/login", Methods: []string{http.MethodGet, http.MethodPost}},
+	}, router.Routes())
+}
+
+func TestRouter_InvalidRoutes(t *testing.T) {
+	router := server.NewRouter("")
+	router.Handle(http.MethodGet, "/users/{user}", echo("user"))
+
+	for _, tt := range []struct {
+		method, pattern string
+		panic           string
+	}{
+		{http.MethodGet, "users", `pattern must start with a slash: "users"`},
+		{http.MethodGet, "/users/{}", `invalid path parameter "{}" in pattern "/users/{}"`},
+		{http.MethodGet, "/users/{user", `invalid path parameter "{user" in pattern "/users/{user"`},
+		{http.MethodGet, "/users/x{user}", `invalid path parameter "x{user}" in pattern "/users/x{user}"`},
+		{http.MethodGet, "/users/{id}/keys/{id}", `duplicate path parameter "id" in pattern "/users/{id}/keys/{id}"`},
+		{"", "/users", `invalid method "" for /users`},
+		{http.MethodOptions, "/users", `invalid method "OPTIONS" for /users`},
+		{http.MethodGet, "/users/{user}", "GET /users/{user} is already registered"},
+	} {
+		assert.PanicsWithValue(t, tt.panic, func() {
+			router.Handle(tt.method, tt.pattern, echo("invalid"))
+		}, tt.pattern)
+	}
+}
+
+func TestParam_NoRoute(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://phobia.cloud/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", server.Param(req, "user"))
+}
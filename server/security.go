@@ -0,0 +1,173 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"phobia.cloud/api/openapi"
+	"phobia.cloud/api/remote"
+)
+
+// Security response headers.
+const (
+	// StrictTransportSecurityHeader makes browsers use only HTTPS for the
+	// host.
+	StrictTransportSecurityHeader = "Strict-Transport-Security"
+	// ContentTypeOptionsHeader stops browsers from guessing the content type
+	// of responses.
+	ContentTypeOptionsHeader = "X-Content-Type-Options"
+	// ReferrerPolicyHeader limits the referrer sent when following links.
+	ReferrerPolicyHeader = "Referrer-Policy"
+	// ContentSecurityPolicyHeader limits the resources that a page may load.
+	ContentSecurityPolicyHeader = "Content-Security-Policy"
+	// CacheControlHeader controls caching of responses.
+	CacheControlHeader = "Cache-Control"
+	// CrossOriginResourcePolicyHeader limits the origins that may embed a
+	// response, e.g. as an image.
+	CrossOriginResourcePolicyHeader = "Cross-Origin-Resource-Policy"
+)
+
+// Defaults of the security headers.
+var (
+	// DefaultSecurityHeaders are the headers of all routes. The content
+	// security policy allows nothing, which suits JSON.
+	DefaultSecurityHeaders = map[string]string{
+		StrictTransportSecurityHeader:   "max-age=63072000; includeSubDomains",
+		ContentTypeOptionsHeader:        "nosniff",
+		ReferrerPolicyHeader:            "no-referrer",
+		ContentSecurityPolicyHeader:     "default-src 'none'; frame-ancestors 'none'",
+		CrossOriginResourcePolicyHeader: "same-origin",
+	}
+	// DefaultSecurityRoutes are the overrides of the headers by route.
+	// Challenges, WebAuthn options and login results are never cached, the
+	// QR code of a challenge may be embedded by other sites and the viewer
+	// of the OpenAPI document may run its inline script and style.
+	DefaultSecurityRoutes = map[string]map[string]string{
+		APIPrefix + "/challenge":                {CacheControlHeader: "no-store"},
+		APIPrefix + "/challenge/qr":             {CacheControlHeader: "no-store", CrossOriginResourcePolicyHeader: "cross-origin"},
+		APIPrefix + "/challenge/pow":            {CacheControlHeader: "no-store"},
+		APIPrefix + "/login":                    {CacheControlHeader: "no-store"},
+		APIPrefix + "/login/nostr":              {CacheControlHeader: "no-store"},
+		APIPrefix + "/login/ethereum":           {CacheControlHeader: "no-store"},
+		APIPrefix + "/lnurl":                    {CacheControlHeader: "no-store"},
+		APIPrefix + "/lnurl/status":             {CacheControlHeader: "no-store"},
+		APIPrefix + "/webauthn/register/begin":  {CacheControlHeader: "no-store"},
+		APIPrefix + "/webauthn/register/finish": {CacheControlHeader: "no-store"},
+		APIPrefix + "/webauthn/login/begin":     {CacheControlHeader: "no-store"},
+		APIPrefix + "/webauthn/login/finish":    {CacheControlHeader: "no-store"},
+		"/docs":                                 {ContentSecurityPolicyHeader: openapi.ViewerContentSecurityPolicy()},
+	}
+)
+
+// Security is a set of security headers applied as middleware to the
+// responses of all routes, including errors.
+//
+// Handlers may replace the headers. Strict-Transport-Security is sent only
+// to clients that requested HTTPS, as browsers ignore it otherwise.
+type Security struct {
+	// Headers are the headers of all routes by canonical name. See Set.
+	Headers map[string]string
+	// Routes override the headers by route pattern, e.g.
+	// "/v1/challenge", and canonical name. An empty value removes a header
+	// of the route. See Override.
+	Routes map[string]map[string]string
+}
+
+// NewSecurity returns the security headers with the defaults.
+func NewSecurity() *Security {
+	s := &Security{
+		Headers: make(map[string]string, len(DefaultSecurityHeaders)),
+		Routes:  make(map[string]map[string]string, len(DefaultSecurityRoutes)),
+	}
+	for name, value := range DefaultSecurityHeaders {
+		s.Set(name, value)
+	}
+	for route, headers := range DefaultSecurityRoutes {
+		for name, value := range headers {
+			s.Override(route, name, value)
+		}
+	}
+	return s
+}
+
+// Set sets the header of all routes to value.
+func (s *Security) Set(name, value string) {
+	if s.Headers == nil {
+		s.Headers = make(map[string]string)
+	}
+	s.Headers[http.CanonicalHeaderKey(name)] = value
+}
+
+// Override sets the header of the route to value.
+func (s *Security) Override(route, name, value string) {
+	if s.Routes == nil {
+		s.Routes = make(map[string]map[string]string)
+	}
+	if s.Routes[route] == nil {
+		s.Routes[route] = make(map[string]string)
+	}
+	s.Routes[route][http.CanonicalHeaderKey(name)] = value
+}
+
+// Validate checks that the names and values of the headers are valid.
+func (s *Security) Validate() error {
+	for name, value := range s.Headers {
+		if err := validateHeader(name, value); err != nil {
+			return err
+		}
+	}
+	for route, headers := range s.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("security: invalid route: %q", route)
+		}
+		for name, value := range headers {
+			if err := validateHeader(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateHeader checks that name is a token and value has no control
+// characters.
+func validateHeader(name, value string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n:()<>@,;\\\"/[]?={}") {
+		return fmt.Errorf("security: invalid header name: %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("security: invalid value of header %s: %q", name, value)
+	}
+	return nil
+}
+
+// apply sets the headers of the route on the response to r.
+func (s *Security) apply(w http.ResponseWriter, r *http.Request, route string) {
+	if s == nil {
+		return
+	}
+	overrides := s.Routes[route]
+	for name, value := range s.Headers {
+		if _, ok := overrides[name]; !ok {
+			s.write(w, r, name, value)
+		}
+	}
+	for name, value := range overrides {
+		s.write(w, r, name, value)
+	}
+}
+
+// write sets the header on the response to r unless value is empty.
+func (s *Security) write(w http.ResponseWriter, r *http.Request, name, value string) {
+	if value == "" {
+		return
+	}
+	if name == StrictTransportSecurityHeader && remote.Scheme(r) != "https" {
+		return
+	}
+	w.Header().Set(name, value)
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/abuse"
+	"phobia.cloud/api/handler"
+	"phobia.cloud/api/metrics"
+	"phobia.cloud/api/openapi"
+	"phobia.cloud/api/pow"
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+)
+
+// serveHTTPS serves a request over HTTPS and returns the response.
+func serveHTTPS(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSecurity_Routes(t *testing.T) {
+	s := store.NewMemory()
+	gate, err := pow.NewGate(nil)
+	require.NoError(t, err)
+	api := &server.API{
+		Challenge: &handler.ChallengeHandler{PoW: gate},
+		LNURL:     &handler.LNURLAuth{Store: s},
+		WebAuthn: &handler.WebAuthn{
+			RelyingParty: &webauthn.RelyingParty{ID: "phobia.cloud", Name: "phobia.cloud", Origins: []string{"https://phobia.cloud"}},
+			Credentials:  &webauthn.Credentials{Store: s},
+			Store:        s,
+		},
+		Metrics:  metrics.NewRegistry(),
+		Viewer:   true,
+		Lockouts: abuse.NewDetector(),
+		Security: server.NewSecurity(),
+	}
+	h := api.Handler()
+
+	routes := append(api.Router().Routes(), api.HealthRouter().Routes()...)
+	routes = append(routes, server.Route{Pattern: "/v1/unknown", Methods: []string{http.MethodGet}})
+	for _, route := range routes {
+		paths := []string{route.Pattern}
+		if route.Alias != "" {
+			paths = append(paths, route.Alias)
+		}
+		for _, path := range paths {
+			for _, method := range append(route.Methods, http.MethodOptions) {
+				t.Run(method+" "+path, func(t *testing.T) {
+					rr := serveHTTPS(h, method, "https://phobia.cloud"+path)
+					header := rr.Header()
+
+					assert.Equal(t, "max-age=63072000; includeSubDomains", header.Get(server.StrictTransportSecurityHeader))
+					assert.Equal(t, "nosniff", header.Get(server.ContentTypeOptionsHeader))
+					assert.Equal(t, "no-referrer", header.Get(server.ReferrerPolicyHeader))
+
+					switch route.Pattern {
+					case "/docs":
+						assert.Equal(t, openapi.ViewerContentSecurityPolicy(), header.Get(server.ContentSecurityPolicyHeader))
+					default:
+						assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", header.Get(server.ContentSecurityPolicyHeader))
+					}
+
+					switch route.Pattern {
+					case "/v1/challenge/qr":
+						assert.Equal(t, "cross-origin", header.Get(server.CrossOriginResourcePolicyHeader))
+					default:
+						assert.Equal(t, "same-origin", header.Get(server.CrossOriginResourcePolicyHeader))
+					}
+
+					for _, prefix := range []string{"/v1/challenge", "/v1/login", "/v1/lnurl", "/v1/webauthn"} {
+						if strings.HasPrefix(route.Pattern, prefix) {
+							assert.Equal(t, "no-store", header.Get(server.CacheControlHeader))
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestSecurity_HTTP(t *testing.T) {
+	api := &server.API{Security: server.NewSecurity()}
+	rr := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://phobia.cloud/v1/challenge", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(server.StrictTransportSecurityHeader), "HSTS is sent only over HTTPS")
+	assert.Equal(t, "nosniff", rr.Header().Get(server.ContentTypeOptionsHeader))
+}
+
+func TestSecurity_Overrides(t *testing.T) {
+	security := server.NewSecurity()
+	security.Set("referrer-policy", "same-origin")
+	security.Set(server.CrossOriginResourcePolicyHeader, "")
+	security.Override("/v1/challenge", "x-content-type-options", "")
+	security.Override("/healthz", server.CacheControlHeader, "no-cache")
+	require.NoError(t, security.Validate())
+	h := (&server.API{Security: security}).Handler()
+
+	rr := serveHTTPS(h, http.MethodGet, "https://phobia.cloud/challenge")
+	assert.Equal(t, "same-origin", rr.Header().Get(server.ReferrerPolicyHeader))
+	assert.Empty(t, rr.Header().Get(server.CrossOriginResourcePolicyHeader))
+	assert.Empty(t, rr.Header().Get(server.ContentTypeOptionsHeader))
+	assert.Equal(t, "no-store", rr.Header().Get(server.CacheControlHeader))
+
+	rr = serveHTTPS(h, http.MethodGet, "https://phobia.cloud/v1/login/nostr")
+	assert.Equal(t, "nosniff", rr.Header().Get(server.ContentTypeOptionsHeader))
+
+	// the health endpoint sets its own header
+	rr = serveHTTPS(h, http.MethodGet, "https://phobia.cloud/healthz")
+	assert.Equal(t, "no-store", rr.Header().Get(server.CacheControlHeader))
+}
+
+func TestSecurity_Disabled(t *testing.T) {
+	rr := serveHTTPS((&server.API{}).Handler(), http.MethodGet, "https://phobia.cloud/v1/challenge")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(server.StrictTransportSecurityHeader))
+	assert.Empty(t, rr.Header().Get(server.ContentSecurityPolicyHeader))
+}
+
+func TestSecurity_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(s *server.Security)
+		err    string
+	}{
+		{
+			name:   "invalid name",
+			modify: func(s *server.Security) { s.Set("X Frame Options", "DENY") },
+			err:    `security: invalid header name: "X Frame Options"`,
+		},
+		{
+			name:   "invalid value",
+			modify: func(s *server.Security) { s.Set("X-Frame-Options", "DENY\r\nSet-Cookie: a=b") },
+			err:    `security: invalid value of header X-Frame-Options: "DENY\r\nSet-Cookie: a=b"`,
+		},
+		{
+			name:   "invalid route",
+			modify: func(s *server.Security) { s.Override("v1/challenge", "X-Frame-Options", "DENY") },
+			err:    `security: invalid route: "v1/challenge"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server.NewSecurity()
+			tt.modify(s)
+			assert.EqualError(t, s.Validate(), tt.err)
+		})
+	}
+}
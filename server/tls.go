@@ -0,0 +1,199 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/logging"
+)
+
+var tlsLog = logging.Component("tls")
+
+// DefaultCertReloadInterval is how often the certificate files are checked
+// for changes if TLS.ReloadInterval is not set.
+const DefaultCertReloadInterval = 10 * time.Second
+
+// TLS is the HTTPS configuration of the server.
+//
+// The certificate and key files are checked for changes at most once per
+// ReloadInterval during handshakes, and reloaded if they were modified, so
+// renewed certificates are served without a restart. If the new files
+// cannot be loaded, the previous certificate is served and the error is
+// logged.
+type TLS struct {
+	// CertFile is the path of the PEM-encoded certificate chain.
+	CertFile string
+	// KeyFile is the path of the PEM-encoded private key.
+	KeyFile string
+	// MinVersion is the minimum TLS version. If zero, TLS 1.2 is used.
+	MinVersion uint16
+	// CipherSuites are the cipher suites of TLS 1.2. If empty, the secure
+	// defaults of the crypto/tls package are used. The cipher suites of TLS
+	// 1.3 are not configurable.
+	CipherSuites []uint16
+	// ClientCAFile is the path of the PEM-encoded certificates of the
+	// authorities that issue client certificates.
+	ClientCAFile string
+	// ClientAuth is the policy for client certificates. If ClientCAFile is
+	// set, it defaults to tls.RequireAndVerifyClientCert.
+	ClientAuth tls.ClientAuthType
+	// ReloadInterval is how often the certificate files are checked for
+	// changes. If zero, DefaultCertReloadInterval is used.
+	ReloadInterval time.Duration
+}
+
+// Config returns the tls.Config of the server. It fails if the certificate
+// or the client authorities cannot be loaded.
+func (t *TLS) Config() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("tls: missing certificate or key file")
+	}
+
+	cert := &certificate{
+		certFile: t.CertFile,
+		keyFile:  t.KeyFile,
+		interval: t.ReloadInterval,
+		now:      time.Now,
+	}
+	if cert.interval == 0 {
+		cert.interval = DefaultCertReloadInterval
+	}
+	err := cert.load()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     t.MinVersion,
+		CipherSuites:   t.CipherSuites,
+		GetCertificate: cert.get,
+		ClientAuth:     t.ClientAuth,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: failed to read client ca file: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in client ca file %s", t.ClientCAFile)
+		}
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if config.ClientAuth >= tls.VerifyClientCertIfGiven {
+		return nil, errors.New("tls: verifying client certificates requires a client ca file")
+	}
+
+	return config, nil
+}
+
+// certificate is a certificate that is reloaded when its files change.
+type certificate struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// get implements tls.Config.GetCertificate.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.checked) >= c.interval {
+		c.checked = now
+		modTime, err := c.lastModified()
+		if err == nil && !modTime.Equal(c.modTime) {
+			err = c.load()
+			if err == nil {
+				tlsLog.Info("reloaded tls certificate", "cert", c.certFile)
+			}
+		}
+		if err != nil {
+			tlsLog.Error("error reloading tls certificate", "error", err)
+		}
+	}
+
+	return c.cert, nil
+}
+
+// load loads the certificate from its files.
+func (c *certificate) load() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: failed to load certificate: %v", err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate and
+// key files.
+func (c *certificate) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: failed to load certificate: %v", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// RedirectHTTPS returns a handler that permanently redirects requests to the
+// same URL on HTTPS. httpsAddr is the address the HTTPS server listens on,
+// e.g. ":443", and determines the port of the redirect.
+func RedirectHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/server"
+)
+
+// testCert is a certificate with its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate for name signed by parent, or a
+// self-signed CA certificate if parent is nil.
+func newTestCert(t *testing.T, name string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and key as PEM files to dir.
+func (c *testCert) write(t *testing.T, dir string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLS_Config(t *testing.T) {
+	certFile, keyFile := newTestCert(t, "phobia.cloud", 1, nil).write(t, t.TempDir())
+
+	config, err := (&server.TLS{CertFile: certFile, KeyFile: keyFile}).Config()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	_, err = (&server.TLS{CertFile: certFile}).Config()
+	assert.EqualError(t, err, "tls: missing certificate or key file")
+
+	_, err = (&server.TLS{CertFile: certFile, KeyFile: certFile}).Config()
+	assert.Error(t, err)
+
+	_, err = (&server.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert}).Config()
+	assert.EqualError(t, err, "tls: verifying client certificates requires a client ca file")
+
+	_, err = (&server.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}).Config()
+	assert.EqualError(t, err, "tls: no certificates in client ca file "+keyFile)
+}
+
+func TestTLS_Reload(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, "phobia.cloud", 1, nil)
+	certFile, keyFile := first.write(t, dir)
+
+	config, err := (&server.TLS{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond}).Config()
+	require.NoError(t, err)
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, first.der, cert.Certificate[0])
+
+	// a renewed certificate is served without a restart
+	second := newTestCert(t, "phobia.cloud", 2, nil)
+	second.write(t, dir)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	cert, err = config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+
+	// a broken certificate file keeps the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	cert, err = config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, second.der, cert.Certificate[0])
+}
+
+func TestTLS_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "phobia.cloud ca", 1, nil)
+	caFile, _ := ca.write(t, dir)
+	serverDir := filepath.Join(dir, "server")
+	require.NoError(t, os.Mkdir(serverDir, 0o700))
+	serverCert := newTestCert(t, "localhost", 2, ca)
+	certFile, keyFile := serverCert.write(t, serverDir)
+
+	config, err := (&server.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}).Config()
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	defer func() { _ = srv.Close() }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			ServerName:   "localhost",
+		}}}
+	}
+	url := "https://" + listener.Addr().String()
+
+	resp, err := client(newTestCert(t, "internal client", 3, ca).tlsCertificate()).Get(url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "internal client", string(body))
+
+	_, err = client().Get(url)
+	assert.Error(t, err)
+
+	_, err = client(newTestCert(t, "stranger", 4, nil).tlsCertificate()).Get(url)
+	assert.Error(t, err)
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		httpsAddr string
+		target    string
+		location  string
+	}{
+		{":443", "http://phobia.cloud/v1/challenge?uri=true", "https://phobia.cloud/v1/challenge?uri=true"},
+		{":443", "http://phobia.cloud:80/login", "https://phobia.cloud/login"},
+		{":8443", "http://phobia.cloud:8080/login", "https://phobia.cloud:8443/login"},
+		{"[::1]:8443", "http://[::1]:8080/login", "https://[::1]:8443/login"},
+		{":443", "http://[::1]/login", "https://[::1]/login"},
+	} {
+		rr := serve(t, server.RedirectHTTPS(tt.httpsAddr), http.MethodPost, tt.target)
+		assert.Equal(t, http.StatusPermanentRedirect, rr.Code, tt.target)
+		assert.Equal(t, tt.location, rr.Header().Get("Location"), tt.target)
+	}
+}
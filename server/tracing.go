@@ -0,0 +1,36 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server
+
+import (
+	"net/http"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/tracing"
+)
+
+// Tracing returns a handler that serves h within a server span of t. The
+// span continues the trace of the traceparent and tracestate headers of the
+// request, if valid, and is carried by the request context, so handlers can
+// trace their steps with tracing.Start. The router names the span by the
+// matched route. Responses with a 5xx status mark the span as failed.
+func Tracing(t *tracing.Tracer, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := tracing.Extract(r.Header)
+		ctx, span := t.StartServer(r.Context(), methodLabel(r.Method), parent)
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		logging.Set(ctx, "trace_id", span.SpanContext().TraceID.String())
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		code := sw.code()
+		span.SetAttribute("http.status_code", code)
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(code))
+		}
+	})
+}
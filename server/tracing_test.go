@@ -0,0 +1,80 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/server"
+	"phobia.cloud/api/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	buf := captureLog(t)
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+
+	router := server.NewRouter("/v1")
+	router.HandleFunc(http.MethodGet, "/users/{user}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "store.get")
+		span.End()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	h := server.AccessLog(server.Tracing(tracer, router))
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	serve(t, h, http.MethodGet, "http://phobia.cloud/v1/users/alice", "Traceparent", traceparent, "Tracestate", "vendor=a")
+	serve(t, h, "BREW", "http://phobia.cloud/coffee")
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 3)
+	step, request, unmatched := spans[0], spans[1], spans[2]
+
+	parent, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /v1/users/{user}", request.Name)
+	assert.Equal(t, tracing.KindServer, request.Kind)
+	assert.Equal(t, parent.TraceID, request.SpanContext.TraceID)
+	assert.Equal(t, parent.SpanID, request.Parent)
+	assert.Equal(t, "vendor=a", request.SpanContext.TraceState)
+	assert.Equal(t, "/v1/users/{user}", request.Attribute("http.route"))
+	assert.Equal(t, http.MethodGet, request.Attribute("http.method"))
+	assert.Equal(t, http.StatusServiceUnavailable, request.Attribute("http.status_code"))
+	assert.Equal(t, tracing.StatusError, request.Status)
+
+	assert.Equal(t, "store.get", step.Name)
+	assert.Equal(t, request.SpanContext.SpanID, step.Parent)
+
+	assert.Equal(t, "OTHER unmatched", unmatched.Name)
+	assert.NotEqual(t, parent.TraceID, unmatched.SpanContext.TraceID)
+	assert.False(t, unmatched.Parent.IsValid())
+	assert.Equal(t, http.StatusNotFound, unmatched.Attribute("http.status_code"))
+	assert.Equal(t, tracing.StatusUnset, unmatched.Status)
+
+	entries := logEntries(t, buf)
+	require.Len(t, entries, 2)
+	assert.Equal(t, parent.TraceID.String(), entries[0]["trace_id"])
+	assert.Equal(t, unmatched.SpanContext.TraceID.String(), entries[1]["trace_id"])
+}
+
+func TestAPI_Tracing(t *testing.T) {
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+	h := (&server.API{Tracer: tracer}).Handler()
+
+	serve(t, h, http.MethodGet, "http://phobia.cloud/challenge")
+	serve(t, h, http.MethodGet, "http://phobia.cloud/healthz")
+	require.NoError(t, tracer.Close())
+
+	var names []string
+	for _, span := range exporter.Spans() {
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"challenge.generate", "GET /v1/challenge", "GET /healthz"}, names)
+}
@@ -0,0 +1,6 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package store provides key-value stores with expiring entries for keeping
+// server-side login state, such as issued challenges.
+package store
@@ -0,0 +1,257 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is a Store that keeps each entry in a file of a directory, so its
+// content survives restarts of the process. It is meant for single-instance
+// deployments.
+type File struct {
+	dir string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+var _ Store = (*File)(nil)
+
+// fileHeaderSize is the size of the expiration time in Unix nanoseconds that
+// precedes the value in entry files. Zero means the entry never expires.
+const fileHeaderSize = 8
+
+// NewFile returns a store that keeps its entries in dir. The directory is
+// created if it does not exist.
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	return &File{dir: dir, now: time.Now}, nil
+}
+
+// path returns the path of the file of key. Keys are hashed, so they may
+// contain any characters.
+func (f *File) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(hash[:]))
+}
+
+// Get implements Store.
+func (f *File) Get(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, _, err := f.read(key)
+	return value, err
+}
+
+// read returns the value and expiration time of the entry of key, removing
+// it if it has expired. The caller must hold f.mu.
+func (f *File) read(key string) ([]byte, int64, error) {
+	path := f.path(key)
+	value, expires, expired, err := readEntry(path, f.now())
+	if err != nil {
+		return nil, 0, err
+	}
+	if expired {
+		_ = os.Remove(path)
+		return nil, 0, ErrNotFound
+	}
+	return value, expires, nil
+}
+
+// Put implements Store.
+func (f *File) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.put(key, value, ttl)
+}
+
+// put writes the entry of key. The caller must hold f.mu.
+func (f *File) put(key string, value []byte, ttl time.Duration) error {
+	now := f.now()
+	if now.Sub(f.lastSweep) >= sweepInterval {
+		f.sweep(now)
+		f.lastSweep = now
+	}
+
+	var expires int64
+	if ttl > 0 {
+		expires = now.Add(ttl).UnixNano()
+	}
+	return f.write(key, expires, value)
+}
+
+// write writes the entry of key with the expiration time in Unix
+// nanoseconds. The caller must hold f.mu.
+func (f *File) write(key string, expires int64, value []byte) error {
+	data := make([]byte, fileHeaderSize+len(value))
+	binary.BigEndian.PutUint64(data, uint64(expires))
+	copy(data[fileHeaderSize:], value)
+
+	// write to a temporary file first, so readers never see a partial entry
+	tmp, err := os.CreateTemp(f.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write entry: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write entry: %v", err)
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (f *File) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(f.path(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete entry: %v", err)
+	}
+	return nil
+}
+
+// Take implements Store.
+func (f *File) Take(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, _, err := f.read(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Remove(f.path(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete entry: %v", err)
+	}
+	return value, nil
+}
+
+// Create implements Store.
+func (f *File) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, _, err := f.read(key)
+	if err == nil {
+		return ErrExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return f.put(key, value, ttl)
+}
+
+// Swap implements Store.
+func (f *File) Swap(ctx context.Context, key string, old, new []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, expires, err := f.read(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, old) {
+		return ErrConflict
+	}
+	return f.write(key, expires, new)
+}
+
+// Check returns an error if entries cannot be written to the directory of
+// the store, e.g. because the disk is full or the directory was removed.
+func (f *File) Check(ctx context.Context) error {
+	tmp, err := os.CreateTemp(f.dir, ".check-")
+	if err != nil {
+		return fmt.Errorf("failed to write to store directory: %v", err)
+	}
+	_ = tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Close removes the expired entries and syncs the directory, so the entries
+// written before survive a crash of the machine. The store must not be used
+// after Close.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sweep(f.now())
+
+	dir, err := os.Open(f.dir)
+	if err != nil {
+		return fmt.Errorf("failed to sync store directory: %v", err)
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync store directory: %v", err)
+	}
+	return nil
+}
+
+// sweep removes the expired entries.
+func (f *File) sweep(now time.Time) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || len(e.Name()) != 2*sha256.Size {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		if _, _, expired, err := readEntry(path, now); err == nil && expired {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+// readEntry reads the entry file at path and returns its value and
+// expiration time, and whether it has expired at the time now.
+func readEntry(path string, now time.Time) ([]byte, int64, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, false, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to read entry: %v", err)
+	}
+	if len(data) < fileHeaderSize {
+		return nil, 0, false, fmt.Errorf("failed to read entry: corrupt file %s", filepath.Base(path))
+	}
+
+	expires := int64(binary.BigEndian.Uint64(data))
+	if expires != 0 && now.UnixNano() >= expires {
+		return nil, expires, true, nil
+	}
+	return data[fileHeaderSize:], expires, false, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := NewFile(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+
+	_, err = f.Get(ctx, "a")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, f.Put(ctx, "a", []byte("value"), 0))
+	require.NoError(t, f.Put(ctx, "webauthn/user/alice", []byte("alice"), 0))
+
+	got, err := f.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), got)
+
+	// entries survive reopening the store
+	f, err = NewFile(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+	got, err = f.Get(ctx, "webauthn/user/alice")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("alice"), got)
+
+	require.NoError(t, f.Delete(ctx, "a"))
+	_, err = f.Get(ctx, "a")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, f.Delete(ctx, "missing"))
+}
+
+func TestFile_Expiration(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	f, err := NewFile(t.TempDir())
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+
+	require.NoError(t, f.Put(ctx, "short", []byte("1"), time.Second))
+	require.NoError(t, f.Put(ctx, "long", []byte("2"), time.Hour))
+	require.NoError(t, f.Put(ctx, "forever", []byte("3"), 0))
+
+	now = now.Add(time.Second)
+	_, err = f.Get(ctx, "short")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = f.Get(ctx, "long")
+	assert.NoError(t, err)
+
+	// expired entries are swept on put
+	require.NoError(t, f.Put(ctx, "other", []byte("4"), time.Second))
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, f.Put(ctx, "last", []byte("5"), 0))
+	entries, err := os.ReadDir(f.dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = f.Get(ctx, "forever")
+	assert.NoError(t, err)
+}
+
+func TestFile_Atomic(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	f, err := NewFile(t.TempDir())
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+	testAtomic(t, f, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestFile_Close(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	f, err := NewFile(dir)
+	require.NoError(t, err)
+	f.now = func() time.Time { return now }
+
+	require.NoError(t, f.Put(ctx, "short", []byte("1"), time.Second))
+	require.NoError(t, f.Put(ctx, "forever", []byte("2"), 0))
+
+	// expired entries are swept on close
+	now = now.Add(time.Minute)
+	require.NoError(t, f.Close())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// the entries survive a restart
+	f, err = NewFile(dir)
+	require.NoError(t, err)
+	value, err := f.Get(ctx, "forever")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+}
+
+func TestFile_Check(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	f, err := NewFile(dir)
+	require.NoError(t, err)
+	require.NoError(t, f.Check(context.Background()))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, f.Check(context.Background()))
+}
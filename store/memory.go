@@ -0,0 +1,154 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Memory removes expired entries.
+const sweepInterval = time.Minute
+
+// Memory is an in-memory Store. Its content is lost when the process exits.
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, e.value...), nil
+}
+
+// Put implements Store.
+func (m *Memory) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, ttl)
+	return nil
+}
+
+// put sets the value of key. The caller must hold m.mu.
+func (m *Memory) put(key string, value []byte, ttl time.Duration) {
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		for k, e := range m.entries {
+			if e.expired(now) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	e := entry{value: append([]byte{}, value...)}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	m.entries[key] = e
+}
+
+// lookup returns the entry of key, removing it if it has expired. The
+// caller must hold m.mu.
+func (m *Memory) lookup(key string) (entry, bool) {
+	e, ok := m.entries[key]
+	if ok && e.expired(m.now()) {
+		delete(m.entries, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// Take implements Store.
+func (m *Memory) Take(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.entries, key)
+	return e.value, nil
+}
+
+// Create implements Store.
+func (m *Memory) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lookup(key); ok {
+		return ErrExists
+	}
+	m.put(key, value, ttl)
+	return nil
+}
+
+// Swap implements Store.
+func (m *Memory) Swap(ctx context.Context, key string, old, new []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return ErrNotFound
+	}
+	if !bytes.Equal(e.value, old) {
+		return ErrConflict
+	}
+	e.value = append([]byte{}, new...)
+	m.entries[key] = e
+	return nil
+}
+
+// Len returns the number of entries in the store, including expired entries
+// that have not been removed yet.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.entries)
+}
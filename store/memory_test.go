@@ -0,0 +1,68 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, err := m.Get(ctx, "a")
+	assert.Equal(t, ErrNotFound, err)
+
+	value := []byte("value")
+	require.NoError(t, m.Put(ctx, "a", value, 0))
+	value[0] = 'V'
+
+	got, err := m.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), got)
+
+	require.NoError(t, m.Delete(ctx, "a"))
+	_, err = m.Get(ctx, "a")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, m.Delete(ctx, "missing"))
+}
+
+func TestMemory_Expiration(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.Put(ctx, "short", []byte("1"), time.Second))
+	require.NoError(t, m.Put(ctx, "long", []byte("2"), time.Hour))
+	require.NoError(t, m.Put(ctx, "forever", []byte("3"), 0))
+
+	now = now.Add(time.Second)
+	_, err := m.Get(ctx, "short")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = m.Get(ctx, "long")
+	assert.NoError(t, err)
+
+	// expired entries are swept on put
+	require.NoError(t, m.Put(ctx, "other", []byte("4"), time.Second))
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, m.Put(ctx, "last", []byte("5"), 0))
+	assert.Equal(t, 2, m.Len())
+
+	_, err = m.Get(ctx, "forever")
+	assert.NoError(t, err)
+}
+
+func TestMemory_Atomic(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	testAtomic(t, m, func(d time.Duration) { now = now.Add(d) })
+}
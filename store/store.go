@@ -0,0 +1,44 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Errors of the operations of a Store.
+var (
+	// ErrNotFound is returned when a key does not exist or has expired.
+	ErrNotFound = errors.New("key not found")
+	// ErrExists is returned by Create when a key already exists.
+	ErrExists = errors.New("key already exists")
+	// ErrConflict is returned by Swap when the value of a key has changed.
+	ErrConflict = errors.New("key changed concurrently")
+)
+
+// Store is a key-value store with expiring entries.
+type Store interface {
+	// Get returns the value of key, or ErrNotFound if the key does not exist
+	// or has expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put sets the value of key. The entry expires after ttl, or never if
+	// ttl is zero.
+	Put(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key. Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Take removes key and returns its value, or ErrNotFound if the key does
+	// not exist or has expired. Of concurrent calls for the same key, only
+	// one gets the value, so it can redeem one-time values.
+	Take(ctx context.Context, key string) ([]byte, error)
+	// Create sets the value of key like Put, unless the key exists, in
+	// which case it returns ErrExists.
+	Create(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Swap sets the value of key to new if its value is old, keeping its
+	// expiration time. It returns ErrNotFound if the key does not exist or
+	// has expired and ErrConflict if its value is not old.
+	Swap(ctx context.Context, key string, old, new []byte) error
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAtomic tests the atomic operations of s. Calling advance moves the
+// clock of s forward.
+func testAtomic(t *testing.T, s Store, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	// take
+	_, err := s.Take(ctx, "once")
+	assert.Equal(t, ErrNotFound, err)
+	require.NoError(t, s.Put(ctx, "once", []byte("value"), time.Minute))
+	value, err := s.Take(ctx, "once")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	_, err = s.Take(ctx, "once")
+	assert.Equal(t, ErrNotFound, err)
+
+	// of concurrent takes, only one gets the value
+	require.NoError(t, s.Put(ctx, "race", []byte("value"), 0))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	taken := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Take(ctx, "race"); err == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, taken)
+
+	// create
+	require.NoError(t, s.Create(ctx, "unique", []byte("1"), time.Minute))
+	assert.Equal(t, ErrExists, s.Create(ctx, "unique", []byte("2"), time.Minute))
+	value, err = s.Get(ctx, "unique")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	// swap
+	assert.Equal(t, ErrNotFound, s.Swap(ctx, "missing", nil, []byte("1")))
+	require.NoError(t, s.Put(ctx, "session", []byte{}, time.Minute))
+	require.NoError(t, s.Swap(ctx, "session", []byte{}, []byte("done")))
+	assert.Equal(t, ErrConflict, s.Swap(ctx, "session", []byte{}, []byte("again")))
+	value, err = s.Get(ctx, "session")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("done"), value)
+
+	// swap keeps the expiration time, expired keys can be created again
+	advance(time.Minute)
+	_, err = s.Get(ctx, "session")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.Take(ctx, "session")
+	assert.Equal(t, ErrNotFound, err)
+	require.NoError(t, s.Create(ctx, "unique", []byte("3"), 0))
+}
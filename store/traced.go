@@ -0,0 +1,109 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"phobia.cloud/api/tracing"
+)
+
+// Traced is a Store that traces the operations of another Store as steps of
+// the request carried by their context. The keys are not recorded, as they
+// may contain challenges, only their namespace, e.g. "lnurl".
+type Traced struct {
+	Store Store
+}
+
+var _ Store = (*Traced)(nil)
+
+// NewTraced returns a Store that traces the operations of s.
+func NewTraced(s Store) *Traced {
+	return &Traced{Store: s}
+}
+
+// Get implements Store.
+func (t *Traced) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := startSpan(ctx, "store.get", key)
+	defer span.End()
+
+	value, err := t.Store.Get(ctx, key)
+	span.SetAttribute("store.found", err == nil)
+	if !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+	}
+	return value, err
+}
+
+// Put implements Store.
+func (t *Traced) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, span := startSpan(ctx, "store.put", key)
+	defer span.End()
+
+	err := t.Store.Put(ctx, key, value, ttl)
+	span.RecordError(err)
+	return err
+}
+
+// Delete implements Store.
+func (t *Traced) Delete(ctx context.Context, key string) error {
+	ctx, span := startSpan(ctx, "store.delete", key)
+	defer span.End()
+
+	err := t.Store.Delete(ctx, key)
+	span.RecordError(err)
+	return err
+}
+
+// Take implements Store.
+func (t *Traced) Take(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := startSpan(ctx, "store.take", key)
+	defer span.End()
+
+	value, err := t.Store.Take(ctx, key)
+	span.SetAttribute("store.found", err == nil)
+	if !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+	}
+	return value, err
+}
+
+// Create implements Store.
+func (t *Traced) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, span := startSpan(ctx, "store.create", key)
+	defer span.End()
+
+	err := t.Store.Create(ctx, key, value, ttl)
+	span.SetAttribute("store.created", err == nil)
+	if !errors.Is(err, ErrExists) {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// Swap implements Store.
+func (t *Traced) Swap(ctx context.Context, key string, old, new []byte) error {
+	ctx, span := startSpan(ctx, "store.swap", key)
+	defer span.End()
+
+	err := t.Store.Swap(ctx, key, old, new)
+	span.SetAttribute("store.swapped", err == nil)
+	if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrConflict) {
+		span.RecordError(err)
+	}
+	return err
+}
+
+func startSpan(ctx context.Context, name, key string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name)
+	namespace := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		namespace = key[:i]
+	}
+	span.SetAttribute("store.namespace", namespace)
+	return ctx, span
+}
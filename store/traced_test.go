@@ -0,0 +1,89 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/tracing"
+)
+
+func TestTraced(t *testing.T) {
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+	ctx, request := tracer.StartServer(context.Background(), "GET /v1/lnurl", tracing.SpanContext{})
+
+	s := NewTraced(NewMemory())
+	require.NoError(t, s.Put(ctx, "lnurl/secret", []byte("value"), 0))
+	value, err := s.Get(ctx, "lnurl/secret")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	require.NoError(t, s.Delete(ctx, "lnurl/secret"))
+	_, err = s.Get(ctx, "lnurl/secret")
+	assert.Equal(t, ErrNotFound, err)
+
+	// operations outside of traced requests are not traced
+	require.NoError(t, s.Put(context.Background(), "lnurl/other", []byte("value"), 0))
+
+	request.End()
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 5)
+	for i, name := range []string{"store.put", "store.get", "store.delete", "store.get"} {
+		assert.Equal(t, name, spans[i].Name)
+		assert.Equal(t, request.SpanContext().SpanID, spans[i].Parent)
+		assert.Equal(t, "lnurl", spans[i].Attribute("store.namespace"))
+		assert.Equal(t, tracing.StatusUnset, spans[i].Status)
+		for _, a := range spans[i].Attributes {
+			assert.NotEqual(t, "lnurl/secret", a.Value)
+		}
+	}
+	assert.Equal(t, true, spans[1].Attribute("store.found"))
+	assert.Equal(t, false, spans[3].Attribute("store.found"))
+}
+
+func TestTraced_Atomic(t *testing.T) {
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+	ctx, request := tracer.StartServer(context.Background(), "GET /v1/lnurl", tracing.SpanContext{})
+
+	s := NewTraced(NewMemory())
+	require.NoError(t, s.Create(ctx, "lnurl/secret", []byte{}, 0))
+	assert.Equal(t, ErrExists, s.Create(ctx, "lnurl/secret", []byte{}, 0))
+	require.NoError(t, s.Swap(ctx, "lnurl/secret", []byte{}, []byte("value")))
+	assert.Equal(t, ErrConflict, s.Swap(ctx, "lnurl/secret", []byte{}, []byte("value")))
+	value, err := s.Take(ctx, "lnurl/secret")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+	_, err = s.Take(ctx, "lnurl/secret")
+	assert.Equal(t, ErrNotFound, err)
+
+	request.End()
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 7)
+	for i, tt := range []struct {
+		name      string
+		attribute string
+		value     bool
+	}{
+		{"store.create", "store.created", true},
+		{"store.create", "store.created", false},
+		{"store.swap", "store.swapped", true},
+		{"store.swap", "store.swapped", false},
+		{"store.take", "store.found", true},
+		{"store.take", "store.found", false},
+	} {
+		assert.Equal(t, tt.name, spans[i].Name)
+		assert.Equal(t, "lnurl", spans[i].Attribute("store.namespace"))
+		assert.Equal(t, tt.value, spans[i].Attribute(tt.attribute), tt.name)
+		assert.Equal(t, tracing.StatusUnset, spans[i].Status)
+	}
+}
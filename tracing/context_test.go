@@ -0,0 +1,75 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/tracing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, traceparent, sc.Traceparent())
+
+	// future versions may append fields
+	sc, err = tracing.ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"0x-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := tracing.ParseTraceparent(header)
+		assert.Equal(t, tracing.ErrInvalidTraceparent, err, header)
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	header := http.Header{}
+	_, ok := tracing.Extract(header)
+	assert.False(t, ok)
+
+	header.Set(tracing.TraceparentHeader, traceparent)
+	header.Add(tracing.TracestateHeader, "vendor1=a")
+	header.Add(tracing.TracestateHeader, "vendor2=b")
+	sc, ok := tracing.Extract(header)
+	require.True(t, ok)
+	assert.Equal(t, "vendor1=a,vendor2=b", sc.TraceState)
+
+	out := http.Header{}
+	tracing.Inject(sc, out)
+	assert.Equal(t, traceparent, out.Get(tracing.TraceparentHeader))
+	assert.Equal(t, "vendor1=a,vendor2=b", out.Get(tracing.TracestateHeader))
+
+	// an oversized tracestate is not propagated
+	header.Set(tracing.TracestateHeader, "vendor="+strings.Repeat("a", 512))
+	sc, ok = tracing.Extract(header)
+	require.True(t, ok)
+	assert.Empty(t, sc.TraceState)
+
+	out = http.Header{}
+	tracing.Inject(tracing.SpanContext{}, out)
+	assert.Empty(t, out)
+}
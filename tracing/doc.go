@@ -0,0 +1,14 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package tracing provides spans that are propagated with the W3C trace
+// context headers and exported to a pluggable Exporter.
+//
+// The server starts a span for each HTTP request with StartServer, as child
+// of the span of the traceparent header if there is one. Components start
+// child spans for their steps with Start, which does nothing if the context
+// carries no span, so they need no tracer of their own.
+//
+// Ended spans are batched by the Tracer and exported by its background
+// goroutine. Close exports the remaining spans.
+package tracing
@@ -0,0 +1,38 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is an Exporter that keeps the exported spans in memory, e.g. for
+// tests.
+type Memory struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Export implements Exporter.
+func (m *Memory) Export(ctx context.Context, spans []SpanData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+// Spans returns the exported spans in the order they ended.
+func (m *Memory) Spans() []SpanData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SpanData(nil), m.spans...)
+}
+
+// Reset removes the exported spans.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = nil
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/tracing"
+)
+
+func TestOTLP(t *testing.T) {
+	var body []byte
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		var err error
+		body, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+	}))
+	defer collector.Close()
+
+	parent, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	start := time.Unix(1622548800, 0)
+	exporter := &tracing.OTLP{
+		Endpoint: collector.URL + "/v1/traces",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Service:  "phobia-api",
+	}
+	err = exporter.Export(context.Background(), []tracing.SpanData{{
+		Name:        "POST /v1/login",
+		Kind:        tracing.KindServer,
+		SpanContext: tracing.SpanContext{TraceID: parent.TraceID, SpanID: tracing.SpanID{1, 2, 3, 4, 5, 6, 7, 8}, Flags: tracing.FlagSampled},
+		Parent:      parent.SpanID,
+		Start:       start,
+		End:         start.Add(time.Millisecond),
+		Attributes: []tracing.Attribute{
+			{Key: "http.route", Value: "/v1/login"},
+			{Key: "http.status_code", Value: 400},
+			{Key: "retried", Value: false},
+			{Key: "ratio", Value: 0.5},
+		},
+		Status:        tracing.StatusError,
+		StatusMessage: "invalid signature",
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	var request map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &request))
+	resourceSpans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"attributes": []interface{}{
+		map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "phobia-api"}},
+	}}, resourceSpans["resource"])
+
+	scopeSpans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, tracing.ScopeName, scopeSpans["scope"].(map[string]interface{})["name"])
+	span := scopeSpans["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["traceId"])
+	assert.Equal(t, "0102030405060708", span["spanId"])
+	assert.Equal(t, "00f067aa0ba902b7", span["parentSpanId"])
+	assert.Equal(t, "POST /v1/login", span["name"])
+	assert.Equal(t, float64(tracing.KindServer), span["kind"])
+	assert.Equal(t, "1622548800000000000", span["startTimeUnixNano"])
+	assert.Equal(t, "1622548800001000000", span["endTimeUnixNano"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "invalid signature"}, span["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "http.route", "value": map[string]interface{}{"stringValue": "/v1/login"}},
+		map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "400"}},
+		map[string]interface{}{"key": "retried", "value": map[string]interface{}{"boolValue": false}},
+		map[string]interface{}{"key": "ratio", "value": map[string]interface{}{"doubleValue": 0.5}},
+	}, span["attributes"])
+}
+
+func TestOTLP_Error(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := &tracing.OTLP{Endpoint: collector.URL}
+	err := exporter.Export(context.Background(), []tracing.SpanData{{Name: "GET"}})
+	assert.EqualError(t, err, "failed to export spans: collector responded 503 Service Unavailable")
+}
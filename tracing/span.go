@@ -0,0 +1,173 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind is the role of a span in a trace.
+type SpanKind int
+
+// The kinds of spans, numbered as in OTLP.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span, numbered as in OTLP.
+type StatusCode int
+
+// The status codes of spans.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key-value pair of a span. Values are strings, booleans,
+// integers or floats.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the exported state of an ended span.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Attribute returns the value of the attribute key, or nil if it is not
+// set.
+func (d *SpanData) Attribute(key string) interface{} {
+	for _, a := range d.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// Span is an operation of a trace. The methods of a nil Span do nothing, so
+// steps can be traced without checking whether the request is traced.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the span context of s, or the zero SpanContext if s
+// is nil.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetName replaces the name of the span, e.g. with the route of a request
+// once it is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute sets the attribute key of the span to value.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, a := range s.data.Attributes {
+		if a.Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status, s.data.StatusMessage = code, message
+}
+
+// RecordError sets the status of the span to StatusError with the message
+// of err, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End ends the span and queues it for export if its trace is sampled.
+// Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	data.Attributes = append([]Attribute(nil), s.data.Attributes...)
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled() {
+		s.tracer.enqueue(data)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span of an internal step as child of the span carried by
+// ctx, and returns a copy of ctx that carries the new span. If ctx carries
+// no span, it returns ctx and a nil Span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.start(name, KindInternal, parent.SpanContext(), true)
+	return ContextWithSpan(ctx, s), s
+}
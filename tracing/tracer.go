@@ -0,0 +1,192 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/logging"
+)
+
+// Exporter exports ended spans, e.g. to a collector.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Default settings of the Tracer.
+const (
+	DefaultBatchSize     = 512
+	DefaultMaxQueueSize  = 2048
+	DefaultFlushInterval = 5 * time.Second
+	DefaultExportTimeout = 10 * time.Second
+)
+
+var tracingLog = logging.Component("tracing")
+
+// Tracer starts spans and exports them in batches.
+type Tracer struct {
+	exporter Exporter
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped int
+
+	// flushing serializes the exports.
+	flushing sync.Mutex
+	full     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	closed   sync.Once
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewTracer returns a tracer that exports spans to e. It exports batches of
+// DefaultBatchSize spans, or the queued spans every DefaultFlushInterval,
+// until it is closed. Spans that end while DefaultMaxQueueSize spans are
+// queued are dropped.
+func NewTracer(e Exporter) *Tracer {
+	t := &Tracer{
+		exporter: e,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		now:      time.Now,
+	}
+	go t.run()
+	return t
+}
+
+// StartServer starts the span of a request received by the server and
+// returns a copy of ctx that carries it. If parent is valid, the span
+// continues its trace, otherwise it starts a sampled trace.
+func (t *Tracer) StartServer(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	s := t.start(name, KindServer, parent, parent.IsValid())
+	return ContextWithSpan(ctx, s), s
+}
+
+// start starts a span of kind as child of parent if hasParent is set.
+func (t *Tracer) start(name string, kind SpanKind, parent SpanContext, hasParent bool) *Span {
+	sc := SpanContext{Flags: FlagSampled}
+	var parentID SpanID
+	if hasParent {
+		sc.TraceID, sc.Flags, sc.TraceState = parent.TraceID, parent.Flags, parent.TraceState
+		parentID = parent.SpanID
+	} else {
+		randomID(sc.TraceID[:])
+	}
+	randomID(sc.SpanID[:])
+
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parentID,
+			Start:       t.now(),
+		},
+	}
+}
+
+func randomID(b []byte) {
+	for {
+		_, err := rand.Read(b)
+		if err != nil {
+			panic(err)
+		}
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues an ended span for export.
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.queue) >= DefaultMaxQueueSize {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, data)
+	if len(t.queue) >= DefaultBatchSize {
+		select {
+		case t.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.full:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultExportTimeout)
+		err := t.Flush(ctx)
+		cancel()
+		if err != nil {
+			tracingLog.Warn("error exporting spans", "error", err)
+		}
+	}
+}
+
+// Flush exports the queued spans in batches.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.flushing.Lock()
+	defer t.flushing.Unlock()
+
+	for {
+		t.mu.Lock()
+		if t.dropped > 0 {
+			tracingLog.Warn("dropped spans, the export queue is full", "spans", t.dropped)
+			t.dropped = 0
+		}
+		n := len(t.queue)
+		if n > DefaultBatchSize {
+			n = DefaultBatchSize
+		}
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		if len(t.queue) == 0 {
+			t.queue = nil
+		}
+		t.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		err := t.exporter.Export(ctx, batch)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops the background export and exports the queued spans. It
+// implements io.Closer, so it can be closed on shutdown.
+func (t *Tracer) Close() error {
+	t.closed.Do(func() { close(t.stop) })
+	<-t.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultExportTimeout)
+	defer cancel()
+	return t.Flush(ctx)
+}
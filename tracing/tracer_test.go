@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/tracing"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+
+	parent, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	parent.TraceState = "vendor=a"
+
+	ctx, server := tracer.StartServer(context.Background(), "POST", parent)
+	server.SetName("POST /v1/login")
+	server.SetAttribute("http.status_code", 201)
+
+	_, step := tracing.Start(ctx, "login.verify")
+	step.SetAttribute("login.version", 2)
+	step.RecordError(errors.New("invalid signature"))
+	step.End()
+	server.End()
+	server.End()
+
+	require.NoError(t, tracer.Close())
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+
+	verify, request := spans[0], spans[1]
+	assert.Equal(t, "POST /v1/login", request.Name)
+	assert.Equal(t, tracing.KindServer, request.Kind)
+	assert.Equal(t, parent.TraceID, request.SpanContext.TraceID)
+	assert.Equal(t, parent.SpanID, request.Parent)
+	assert.Equal(t, "vendor=a", request.SpanContext.TraceState)
+	assert.Equal(t, 201, request.Attribute("http.status_code"))
+	assert.False(t, request.End.Before(request.Start))
+
+	assert.Equal(t, "login.verify", verify.Name)
+	assert.Equal(t, tracing.KindInternal, verify.Kind)
+	assert.Equal(t, parent.TraceID, verify.SpanContext.TraceID)
+	assert.Equal(t, request.SpanContext.SpanID, verify.Parent)
+	assert.Equal(t, tracing.StatusError, verify.Status)
+	assert.Equal(t, "invalid signature", verify.StatusMessage)
+	assert.Nil(t, verify.Attribute("missing"))
+}
+
+func TestTracer_RootSpan(t *testing.T) {
+	exporter := &tracing.Memory{}
+	tracer := tracing.NewTracer(exporter)
+	defer func() { require.NoError(t, tracer.Close()) }()
+
+	_, root := tracer.StartServer(context.Background(), "GET", tracing.SpanContext{})
+	assert.True(t, root.SpanContext().IsValid())
+	assert.True(t, root.SpanContext().Sampled())
+	root.End()
+
+	// spans of traces that are not sampled are not exported
+	parent, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+	_, unsampled := tracer.StartServer(context.Background(), "GET", parent)
+	unsampled.End()
+
+	require.NoError(t, tracer.Flush(context.Background()))
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.False(t, spans[0].Parent.IsValid())
+}
+
+func TestStart_NoSpan(t *testing.T) {
+	ctx := context.Background()
+	child, span := tracing.Start(ctx, "store.get")
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, child)
+	assert.NotPanics(t, func() {
+		span.SetAttribute("key", "value")
+		span.RecordError(errors.New("failed"))
+		span.End()
+	})
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+type failingExporter struct{}
+
+func (failingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	return errors.New("collector unavailable")
+}
+
+func TestTracer_ExportError(t *testing.T) {
+	tracer := tracing.NewTracer(failingExporter{})
+	_, span := tracer.StartServer(context.Background(), "GET", tracing.SpanContext{})
+	span.End()
+	assert.EqualError(t, tracer.Close(), "collector unavailable")
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation types of registered credentials.
+const (
+	// AttestationNone is a credential registered without attestation.
+	AttestationNone = "none"
+	// AttestationSelf is a credential attested by its own key.
+	AttestationSelf = "self"
+	// AttestationBasic is a credential attested by an attestation
+	// certificate of the authenticator model.
+	AttestationBasic = "basic"
+)
+
+// oidAAGUID is the X.509 extension with the AAGUID of the authenticator.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// attestationObject is the decoded CBOR attestation object.
+type attestationObject struct {
+	format    string
+	statement map[interface{}]interface{}
+	authData  *AuthenticatorData
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attestation object: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("failed to decode attestation object: trailing bytes")
+	}
+
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("failed to decode attestation object: not a map")
+	}
+
+	format, _ := m["fmt"].(string)
+	statement, ok := m["attStmt"].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("failed to decode attestation object: missing attestation statement")
+	}
+	rawAuthData, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("failed to decode attestation object: missing authenticator data")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attestationObject{format: format, statement: statement, authData: authData}, nil
+}
+
+// verify verifies the attestation statement and returns the attestation
+// type. credentialKey is the attested credential public key.
+func (att *attestationObject) verify(clientDataHash []byte, credentialKey *PublicKey) (string, error) {
+	switch att.format {
+	case "none":
+		if len(att.statement) != 0 {
+			return "", errors.New("none attestation with non-empty statement")
+		}
+		return AttestationNone, nil
+	case "packed":
+		return att.verifyPacked(clientDataHash, credentialKey)
+	default:
+		return "", fmt.Errorf("unsupported attestation format: %q", att.format)
+	}
+}
+
+func (att *attestationObject) verifyPacked(clientDataHash []byte, credentialKey *PublicKey) (string, error) {
+	alg, ok := att.statement["alg"].(int64)
+	if !ok {
+		return "", errors.New("packed attestation without algorithm")
+	}
+	sig, ok := att.statement["sig"].([]byte)
+	if !ok {
+		return "", errors.New("packed attestation without signature")
+	}
+
+	signed := append(append([]byte{}, att.authData.Raw...), clientDataHash...)
+
+	x5c, ok := att.statement["x5c"].([]interface{})
+	if !ok {
+		if alg != credentialKey.Algorithm {
+			return "", errors.New("packed self attestation algorithm does not match credential")
+		}
+		err := credentialKey.Verify(signed, sig)
+		if err != nil {
+			return "", err
+		}
+		return AttestationSelf, nil
+	}
+
+	if len(x5c) == 0 {
+		return "", errors.New("packed attestation with empty certificate chain")
+	}
+	der, ok := x5c[0].([]byte)
+	if !ok {
+		return "", errors.New("packed attestation with invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse attestation certificate: %v", err)
+	}
+
+	err = verifySignature(cert.PublicKey, alg, signed, sig)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkAttestationCertificate(cert, att.authData.AAGUID)
+	if err != nil {
+		return "", err
+	}
+
+	return AttestationBasic, nil
+}
+
+// checkAttestationCertificate checks the requirements for packed attestation
+// certificates.
+func checkAttestationCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return fmt.Errorf("invalid attestation certificate version: %d", cert.Version)
+	}
+
+	subject := cert.Subject
+	if len(subject.Country) == 0 || len(subject.Organization) == 0 || subject.CommonName == "" {
+		return errors.New("attestation certificate subject is incomplete")
+	}
+	if len(subject.OrganizationalUnit) != 1 || subject.OrganizationalUnit[0] != "Authenticator Attestation" {
+		return errors.New("invalid attestation certificate organizational unit")
+	}
+
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		return errors.New("attestation certificate must not be a ca")
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return errors.New("aaguid extension must not be critical")
+		}
+		var value []byte
+		_, err := asn1.Unmarshal(ext.Value, &value)
+		if err != nil || !bytes.Equal(value, aaguid) {
+			return errors.New("attestation certificate aaguid does not match authenticator data")
+		}
+	}
+
+	return nil
+}
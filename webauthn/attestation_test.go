@@ -0,0 +1,92 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAttestationCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	aaguid := []byte("phobia-test-auth")
+	aaguidExt, err := asn1.Marshal(aaguid)
+	require.NoError(t, err)
+
+	subject := pkix.Name{
+		Country:            []string{"BG"},
+		Organization:       []string{"Phobia Test"},
+		OrganizationalUnit: []string{"Authenticator Attestation"},
+		CommonName:         "Phobia Test Authenticator",
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(*x509.Certificate)
+		err    string
+	}{
+		{name: "valid", modify: func(*x509.Certificate) {}},
+		{
+			name:   "no aaguid extension",
+			modify: func(c *x509.Certificate) { c.ExtraExtensions = nil },
+		},
+		{
+			name:   "wrong organizational unit",
+			modify: func(c *x509.Certificate) { c.Subject.OrganizationalUnit = []string{"Other"} },
+			err:    "invalid attestation certificate organizational unit",
+		},
+		{
+			name:   "no country",
+			modify: func(c *x509.Certificate) { c.Subject.Country = nil },
+			err:    "attestation certificate subject is incomplete",
+		},
+		{
+			name:   "ca",
+			modify: func(c *x509.Certificate) { c.IsCA = true },
+			err:    "attestation certificate must not be a ca",
+		},
+		{
+			name: "other aaguid",
+			modify: func(c *x509.Certificate) {
+				value, _ := asn1.Marshal([]byte("other-test-authn"))
+				c.ExtraExtensions[0].Value = value
+			},
+			err: "attestation certificate aaguid does not match authenticator data",
+		},
+	} {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               subject,
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			BasicConstraintsValid: true,
+			ExtraExtensions:       []pkix.Extension{{Id: oidAAGUID, Value: aaguidExt}},
+		}
+		tt.modify(template)
+
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err, tt.name)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err, tt.name)
+
+		err = checkAttestationCertificate(cert, aaguid)
+		if tt.err == "" {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.EqualError(t, err, tt.err, tt.name)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Flags of the authenticator data.
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+)
+
+// AuthenticatorData is the data returned by the authenticator in both the
+// registration and the authentication ceremony.
+type AuthenticatorData struct {
+	// Raw is the encoded authenticator data.
+	Raw []byte
+	// RPIDHash is the SHA-256 hash of the relying party ID.
+	RPIDHash []byte
+	// Flags is a combination of the Flag constants.
+	Flags byte
+	// SignCount is the signature counter of the credential.
+	SignCount uint32
+
+	// AAGUID identifies the model of the authenticator. It is only set if
+	// the data contains attested credential data.
+	AAGUID []byte
+	// CredentialID is the ID of the attested credential.
+	CredentialID []byte
+	// CredentialPublicKey is the COSE_Key encoded public key of the
+	// attested credential.
+	CredentialPublicKey []byte
+}
+
+// Has reports whether all of flags are set.
+func (ad *AuthenticatorData) Has(flags byte) bool {
+	return ad.Flags&flags == flags
+}
+
+// ParseAuthenticatorData parses the binary authenticator data.
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("authenticator data too short: %d bytes", len(data))
+	}
+
+	ad := &AuthenticatorData{
+		Raw:       append([]byte{}, data...),
+		RPIDHash:  append([]byte{}, data[:32]...),
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.Has(FlagAttestedCredentialData) {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		ad.AAGUID = append([]byte{}, rest[:16]...)
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, errors.New("credential id exceeds authenticator data")
+		}
+		ad.CredentialID = append([]byte{}, rest[:idLen]...)
+		rest = rest[idLen:]
+
+		_, after, err := ParsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		ad.CredentialPublicKey = append([]byte{}, rest[:len(rest)-len(after)]...)
+		rest = after
+	}
+
+	if ad.Has(FlagExtensionData) {
+		extensions, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode extensions: %v", err)
+		}
+		if _, ok := extensions.(map[interface{}]interface{}); !ok {
+			return nil, errors.New("failed to decode extensions: not a map")
+		}
+		rest = after
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected %d trailing bytes in authenticator data", len(rest))
+	}
+
+	return ad, nil
+}
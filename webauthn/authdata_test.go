@@ -0,0 +1,74 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+func TestParseAuthenticatorData(t *testing.T) {
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{1}, 32))
+	auth.SignCount = 0x01020304
+	auth.UserVerified = true
+	rpIDHash := sha256.Sum256([]byte("phobia.cloud"))
+
+	data := auth.AuthenticatorData("phobia.cloud", false)
+	ad, err := webauthn.ParseAuthenticatorData(data)
+	require.NoError(t, err)
+	assert.Equal(t, data, ad.Raw)
+	assert.Equal(t, rpIDHash[:], ad.RPIDHash)
+	assert.True(t, ad.Has(webauthn.FlagUserPresent|webauthn.FlagUserVerified))
+	assert.False(t, ad.Has(webauthn.FlagAttestedCredentialData))
+	assert.Equal(t, uint32(0x01020304), ad.SignCount)
+	assert.Nil(t, ad.CredentialID)
+
+	ad, err = webauthn.ParseAuthenticatorData(auth.AuthenticatorData("phobia.cloud", true))
+	require.NoError(t, err)
+	assert.True(t, ad.Has(webauthn.FlagAttestedCredentialData))
+	assert.Equal(t, auth.AAGUID, ad.AAGUID)
+	assert.Equal(t, auth.CredentialID, ad.CredentialID)
+	assert.Equal(t, auth.PublicKey(), ad.CredentialPublicKey)
+}
+
+func TestParseAuthenticatorData_Extensions(t *testing.T) {
+	auth := webauthntest.NewAuthenticator(webauthn.AlgEdDSA, bytes.Repeat([]byte{1}, 32))
+
+	data := auth.AuthenticatorData("phobia.cloud", true)
+	data[32] |= webauthn.FlagExtensionData
+	extensions := webauthntest.EncodeCBOR(map[interface{}]interface{}{"credProtect": 2})
+
+	ad, err := webauthn.ParseAuthenticatorData(append(data, extensions...))
+	require.NoError(t, err)
+	assert.Equal(t, auth.CredentialID, ad.CredentialID)
+
+	_, err = webauthn.ParseAuthenticatorData(append(data, webauthntest.EncodeCBOR("ext")...))
+	assert.EqualError(t, err, "failed to decode extensions: not a map")
+}
+
+func TestParseAuthenticatorData_Invalid(t *testing.T) {
+	auth := webauthntest.NewAuthenticator(webauthn.AlgES256, bytes.Repeat([]byte{1}, 32))
+	attested := auth.AuthenticatorData("phobia.cloud", true)
+
+	for _, tt := range []struct {
+		data []byte
+		err  string
+	}{
+		{make([]byte, 36), "authenticator data too short: 36 bytes"},
+		{attested[:37+17], "attested credential data too short"},
+		{attested[:37+18+10], "credential id exceeds authenticator data"},
+		{append(auth.AuthenticatorData("phobia.cloud", false), 0), "unexpected 1 trailing bytes in authenticator data"},
+		{append(attested, 0), "unexpected 1 trailing bytes in authenticator data"},
+	} {
+		_, err := webauthn.ParseAuthenticatorData(tt.data)
+		assert.EqualError(t, err, tt.err)
+	}
+}
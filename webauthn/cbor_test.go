@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// test vectors from RFC 8949, appendix A
+	for _, tt := range []struct {
+		data  string
+		value interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"190100", int64(256)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"3903e7", int64(-1000)},
+		{"f90000", 0.0},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"f9c400", -4.0},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f97c00", math.Inf(1)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"40", []byte{}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6161", "a"},
+		{"6449455446", "IETF"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a0", map[interface{}]interface{}{}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+	} {
+		data, err := hex.DecodeString(tt.data)
+		require.NoError(t, err)
+
+		value, rest, err := decodeCBOR(append(data, 0xff))
+		require.NoError(t, err, tt.data)
+		assert.Equal(t, tt.value, value, tt.data)
+		assert.Equal(t, []byte{0xff}, rest, tt.data)
+	}
+
+	value, _, err := decodeCBOR([]byte{0xf9, 0x7e, 0x00})
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(value.(float64)))
+}
+
+func TestDecodeCBOR_Invalid(t *testing.T) {
+	nested := make([]byte, maxCBORDepth+2)
+	for i := range nested {
+		nested[i] = 0x81
+	}
+
+	for _, tt := range []struct {
+		data          string
+		expectedError string
+	}{
+		{"", "cbor: unexpected end of data"},
+		{"18", "cbor: unexpected end of data"},
+		{"1b00", "cbor: unexpected end of data"},
+		{"1bffffffffffffffff", "cbor: integer overflow"},
+		{"3bffffffffffffffff", "cbor: integer overflow"},
+		{"1c", "cbor: invalid additional information 28"},
+		{"5f", "cbor: indefinite-length items are not supported"},
+		{"4401", "cbor: unexpected end of data"},
+		{"83", "cbor: unexpected end of data"},
+		{"8201", "cbor: unexpected end of data"},
+		{"a20102", "cbor: unexpected end of data"},
+		{"a201020103", "cbor: duplicate map key 1"},
+		{"a14001", "cbor: unsupported map key type []uint8"},
+		{"f8", "cbor: unsupported simple value 24"},
+		{"f9", "cbor: unexpected end of data"},
+		{"fa00", "cbor: unexpected end of data"},
+		{"fb00", "cbor: unexpected end of data"},
+		{hex.EncodeToString(nested), "cbor: maximum nesting depth exceeded"},
+	} {
+		data, err := hex.DecodeString(tt.data)
+		require.NoError(t, err)
+
+		_, _, err = decodeCBOR(data)
+		assert.EqualError(t, err, tt.expectedError, tt.data)
+	}
+}
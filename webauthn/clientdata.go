@@ -0,0 +1,44 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Client data types of the ceremonies.
+const (
+	ClientDataCreate = "webauthn.create"
+	ClientDataGet    = "webauthn.get"
+)
+
+// CollectedClientData is the client data the browser passes to the
+// authenticator, as decoded from clientDataJSON.
+type CollectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// ParseClientData decodes clientDataJSON.
+func ParseClientData(clientDataJSON []byte) (*CollectedClientData, error) {
+	var c CollectedClientData
+	err := json.Unmarshal(clientDataJSON, &c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode client data: %v", err)
+	}
+	return &c, nil
+}
+
+// ChallengeBytes returns the decoded challenge of the ceremony.
+func (c *CollectedClientData) ChallengeBytes() ([]byte, error) {
+	challenge, err := base64.RawURLEncoding.DecodeString(c.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode challenge: %v", err)
+	}
+	return challenge, nil
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webauthn"
+)
+
+func TestParseClientData(t *testing.T) {
+	c, err := webauthn.ParseClientData([]byte(`{"type":"webauthn.get","challenge":"cGhvYmlh","origin":"https://phobia.cloud","crossOrigin":false,"other":1}`))
+	require.NoError(t, err)
+	assert.Equal(t, webauthn.ClientDataGet, c.Type)
+	assert.Equal(t, "https://phobia.cloud", c.Origin)
+	assert.False(t, c.CrossOrigin)
+
+	challenge, err := c.ChallengeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("phobia"), challenge)
+
+	c.Challenge = "cGhvYmlh="
+	_, err = c.ChallengeBytes()
+	assert.Error(t, err)
+
+	_, err = webauthn.ParseClientData([]byte(`{"type":`))
+	assert.EqualError(t, err, "failed to decode client data: unexpected end of JSON input")
+}
+
+func encodeChallenge(challenge []byte) string {
+	return base64.RawURLEncoding.EncodeToString(challenge)
+}
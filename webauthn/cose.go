@@ -0,0 +1,129 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	_sha256 "crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported credential keys.
+const (
+	// AlgES256 is ECDSA with SHA-256 on the P-256 curve.
+	AlgES256 = -7
+	// AlgEdDSA is EdDSA on the Ed25519 curve.
+	AlgEdDSA = -8
+)
+
+// COSE key parameters and values (RFC 8152).
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// ErrInvalidSignature is returned when a signature does not match the
+// public key or the signed data.
+var ErrInvalidSignature = errors.New("signature does not match public key or signed data")
+
+// PublicKey is a credential public key decoded from its COSE_Key encoding.
+type PublicKey struct {
+	// Algorithm is the COSE algorithm of the key, AlgES256 or AlgEdDSA.
+	Algorithm int64
+
+	key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key encoded credential public key at the
+// beginning of data and returns it together with the remaining bytes.
+func ParsePublicKey(data []byte) (*PublicKey, []byte, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode public key: %v", err)
+	}
+
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, errors.New("failed to decode public key: not a map")
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlgorithm)].(int64)
+	crv, _ := m[int64(coseKeyCurve)].(int64)
+	x, _ := m[int64(coseKeyX)].([]byte)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, errors.New("invalid es256 public key")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, nil, errors.New("invalid es256 public key: point is not on curve")
+		}
+		return &PublicKey{Algorithm: alg, key: pub}, rest, nil
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("invalid eddsa public key")
+		}
+		return &PublicKey{Algorithm: alg, key: ed25519.PublicKey(x)}, rest, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported public key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// Verify verifies that sig is a signature of data by the key. ES256
+// signatures are ASN.1 DER encoded.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	return verifySignature(k.key, k.Algorithm, data, sig)
+}
+
+// verifySignature verifies that sig is a signature of data by key with the
+// COSE algorithm alg.
+func verifySignature(key crypto.PublicKey, alg int64, data, sig []byte) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != AlgES256 {
+			return fmt.Errorf("algorithm %d does not match ecdsa key", alg)
+		}
+		if !ecdsa.VerifyASN1(key, sha256(data), sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != AlgEdDSA {
+			return fmt.Errorf("algorithm %d does not match ed25519 key", alg)
+		}
+		if !ed25519.Verify(key, data, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func sha256(msg []byte) []byte {
+	hash := _sha256.Sum256(msg)
+	return hash[:]
+}
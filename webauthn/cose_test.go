@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	for _, alg := range []int64{webauthn.AlgES256, webauthn.AlgEdDSA} {
+		auth := webauthntest.NewAuthenticator(alg, bytes.Repeat([]byte{1}, 32))
+
+		key, rest, err := webauthn.ParsePublicKey(append(auth.PublicKey(), 0xff))
+		require.NoError(t, err, alg)
+		assert.Equal(t, alg, key.Algorithm)
+		assert.Equal(t, []byte{0xff}, rest)
+
+		msg := []byte("phobia.cloud")
+		assert.NoError(t, key.Verify(msg, auth.Sign(msg)), alg)
+		assert.Equal(t, webauthn.ErrInvalidSignature, key.Verify([]byte("phobia.club"), auth.Sign(msg)), alg)
+	}
+}
+
+func TestParsePublicKey_Invalid(t *testing.T) {
+	x := make([]byte, 32)
+	for _, tt := range []struct {
+		key map[interface{}]interface{}
+		err string
+	}{
+		{
+			key: map[interface{}]interface{}{1: 2, 3: -7, -1: 1, -2: x},
+			err: "invalid es256 public key",
+		},
+		{
+			key: map[interface{}]interface{}{1: 2, 3: -7, -1: 1, -2: x, -3: x},
+			err: "invalid es256 public key: point is not on curve",
+		},
+		{
+			key: map[interface{}]interface{}{1: 1, 3: -8, -1: 1, -2: x},
+			err: "invalid eddsa public key",
+		},
+		{
+			key: map[interface{}]interface{}{1: 3, 3: -257},
+			err: "unsupported public key type 3 with algorithm -257",
+		},
+	} {
+		_, _, err := webauthn.ParsePublicKey(webauthntest.EncodeCBOR(tt.key))
+		assert.EqualError(t, err, tt.err)
+	}
+
+	_, _, err := webauthn.ParsePublicKey(webauthntest.EncodeCBOR("key"))
+	assert.EqualError(t, err, "failed to decode public key: not a map")
+
+	_, _, err = webauthn.ParsePublicKey(nil)
+	assert.Error(t, err)
+}
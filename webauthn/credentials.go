@@ -0,0 +1,186 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"phobia.cloud/api/store"
+)
+
+// Errors of Credentials.
+var (
+	// ErrCredentialExists is returned when adding a credential with an ID
+	// that is already registered, to any user.
+	ErrCredentialExists = errors.New("credential already registered")
+	// ErrEnrolled is returned when enrolling a user who already has
+	// credentials.
+	ErrEnrolled = errors.New("user already has credentials")
+)
+
+// UserHandle returns the WebAuthn user handle of user. The handle does not
+// reveal the user name to the authenticator.
+func UserHandle(user string) []byte {
+	return sha256([]byte(user))
+}
+
+// Credentials keeps the registered credentials of users in a store.Store.
+// Changes are written with store.Store.Swap, so concurrent changes, also by
+// other processes sharing the store, are not lost.
+type Credentials struct {
+	Store store.Store
+}
+
+func credentialsKey(user string) string {
+	return "webauthn/user/" + user
+}
+
+// credentialKey is the key of the user of the credential with the given ID,
+// which makes credential IDs unique across users.
+func credentialKey(id []byte) string {
+	return "webauthn/credential/" + hex.EncodeToString(id)
+}
+
+func handleKey(handle []byte) string {
+	return "webauthn/handle/" + hex.EncodeToString(handle)
+}
+
+// List returns the credentials of user.
+func (c *Credentials) List(ctx context.Context, user string) ([]Credential, error) {
+	value, err := c.Store.Get(ctx, credentialsKey(user))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	err = json.Unmarshal(value, &creds)
+	return creds, err
+}
+
+// Get returns the credential of user with the given ID, or
+// store.ErrNotFound.
+func (c *Credentials) Get(ctx context.Context, user string, id []byte) (*Credential, error) {
+	creds, err := c.List(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for i := range creds {
+		if bytes.Equal(creds[i].ID, id) {
+			return &creds[i], nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Add registers cred for user. It returns ErrCredentialExists if a
+// credential with the same ID is registered to any user.
+func (c *Credentials) Add(ctx context.Context, user string, cred Credential) error {
+	return c.add(ctx, user, cred, false)
+}
+
+// Enroll registers cred as the first credential of user. It returns
+// ErrEnrolled if the user already has credentials and ErrCredentialExists
+// if a credential with the same ID is registered to any user.
+func (c *Credentials) Enroll(ctx context.Context, user string, cred Credential) error {
+	return c.add(ctx, user, cred, true)
+}
+
+func (c *Credentials) add(ctx context.Context, user string, cred Credential, first bool) error {
+	err := c.Store.Create(ctx, credentialKey(cred.ID), []byte(user), 0)
+	if errors.Is(err, store.ErrExists) {
+		return ErrCredentialExists
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.Store.Put(ctx, handleKey(UserHandle(user)), []byte(user), 0)
+	if err == nil {
+		err = c.update(ctx, user, func(creds []Credential) ([]Credential, error) {
+			if first && len(creds) > 0 {
+				return nil, ErrEnrolled
+			}
+			return append(creds, cred), nil
+		})
+	}
+	if err != nil {
+		_ = c.Store.Delete(ctx, credentialKey(cred.ID))
+		return err
+	}
+	return nil
+}
+
+// UpdateSignCount sets the signature counter of the credential of user with
+// the given ID. It returns ErrCounterRegression if the counter did not
+// increase over the stored one, e.g. because a concurrent login with the
+// credential stored a higher counter after it was read.
+func (c *Credentials) UpdateSignCount(ctx context.Context, user string, id []byte, signCount uint32) error {
+	return c.update(ctx, user, func(creds []Credential) ([]Credential, error) {
+		for i := range creds {
+			if bytes.Equal(creds[i].ID, id) {
+				if !counterIncreased(creds[i].SignCount, signCount) {
+					return nil, ErrCounterRegression
+				}
+				creds[i].SignCount = signCount
+				return creds, nil
+			}
+		}
+		return nil, store.ErrNotFound
+	})
+}
+
+// User returns the user with the given user handle, or store.ErrNotFound.
+func (c *Credentials) User(ctx context.Context, handle []byte) (string, error) {
+	value, err := c.Store.Get(ctx, handleKey(handle))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// update writes the credentials of user returned by fn for the stored ones.
+// If they changed before the write, fn is called again with the new ones.
+func (c *Credentials) update(ctx context.Context, user string, fn func([]Credential) ([]Credential, error)) error {
+	key := credentialsKey(user)
+	for {
+		old, err := c.Store.Get(ctx, key)
+		found := err == nil
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		var creds []Credential
+		if found {
+			err = json.Unmarshal(old, &creds)
+			if err != nil {
+				return err
+			}
+		}
+		creds, err = fn(creds)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			err = c.Store.Swap(ctx, key, old, value)
+		} else {
+			err = c.Store.Create(ctx, key, value, 0)
+		}
+		if errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrExists) || (found && errors.Is(err, store.ErrNotFound)) {
+			continue
+		}
+		return err
+	}
+}
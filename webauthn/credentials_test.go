@@ -0,0 +1,105 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/store"
+	"phobia.cloud/api/webauthn"
+)
+
+func TestCredentials(t *testing.T) {
+	ctx := context.Background()
+	creds := &webauthn.Credentials{Store: store.NewMemory()}
+
+	list, err := creds.List(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	_, err = creds.User(ctx, webauthn.UserHandle("alice"))
+	assert.Equal(t, store.ErrNotFound, err)
+
+	first := webauthn.Credential{ID: []byte{1}, PublicKey: []byte{0xa0}, AttestationType: webauthn.AttestationNone}
+	second := webauthn.Credential{ID: []byte{2}, PublicKey: []byte{0xa0}, AttestationType: webauthn.AttestationSelf}
+	require.NoError(t, creds.Enroll(ctx, "alice", first))
+	assert.Equal(t, webauthn.ErrEnrolled, creds.Enroll(ctx, "alice", second))
+	require.NoError(t, creds.Add(ctx, "alice", second))
+	assert.Equal(t, webauthn.ErrCredentialExists, creds.Add(ctx, "alice", first))
+	// credential IDs are unique across users
+	assert.Equal(t, webauthn.ErrCredentialExists, creds.Add(ctx, "bob", first))
+	third := webauthn.Credential{ID: []byte{3}, PublicKey: []byte{0xa0}, AttestationType: webauthn.AttestationNone}
+	require.NoError(t, creds.Add(ctx, "bob", third))
+
+	list, err = creds.List(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []webauthn.Credential{first, second}, list)
+
+	user, err := creds.User(ctx, webauthn.UserHandle("alice"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user)
+
+	require.NoError(t, creds.UpdateSignCount(ctx, "alice", []byte{2}, 42))
+	cred, err := creds.Get(ctx, "alice", []byte{2})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), cred.SignCount)
+
+	// the counter is checked again when it is stored
+	assert.Equal(t, webauthn.ErrCounterRegression, creds.UpdateSignCount(ctx, "alice", []byte{2}, 42))
+
+	cred, err = creds.Get(ctx, "bob", []byte{3})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), cred.SignCount)
+
+	_, err = creds.Get(ctx, "bob", []byte{2})
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, creds.UpdateSignCount(ctx, "bob", []byte{2}, 1))
+}
+
+func TestCredentials_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	creds := &webauthn.Credentials{Store: store.NewMemory()}
+	require.NoError(t, creds.Add(ctx, "alice", webauthn.Credential{ID: []byte{1}, PublicKey: []byte{0xa0}}))
+
+	// of concurrent updates with the same counter, only one succeeds
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- creds.UpdateSignCount(ctx, "alice", []byte{1}, 7)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.Equal(t, webauthn.ErrCounterRegression, err)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// of concurrent additions, none is lost
+	for i := 2; i < 12; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			assert.NoError(t, creds.Add(ctx, "alice", webauthn.Credential{ID: []byte{id}, PublicKey: []byte{0xa0}}))
+		}(byte(i))
+	}
+	wg.Wait()
+	list, err := creds.List(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, list, 11)
+}
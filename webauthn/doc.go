@@ -0,0 +1,10 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package webauthn provides functions for implementing a WebAuthn (FIDO2)
+// relying party for passkey login, such as with the FIDO2 authenticator of
+// Trezor Model T.
+//
+// The package verifies registration and authentication ceremonies with
+// "none" and "packed" attestation and ES256 and EdDSA credentials.
+package webauthn
@@ -0,0 +1,184 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// ErrCounterRegression is returned when the signature counter of an assertion
+// does not increase over the stored counter of the credential, which
+// indicates that the credential may have been cloned.
+var ErrCounterRegression = errors.New("signature counter did not increase")
+
+// RelyingParty is the configuration of the WebAuthn relying party.
+type RelyingParty struct {
+	// ID is the relying party ID, usually the domain of the site.
+	ID string
+	// Name is the human-readable name of the relying party.
+	Name string
+	// Origins are the allowed origins of the client data, e.g.
+	// "https://phobia.cloud".
+	Origins []string
+	// RequireUserVerification rejects ceremonies in which the
+	// authenticator did not verify the user, e.g. with a PIN.
+	RequireUserVerification bool
+}
+
+// Credential is a registered WebAuthn credential.
+type Credential struct {
+	// ID is the credential ID chosen by the authenticator.
+	ID []byte `json:"id"`
+	// PublicKey is the COSE_Key encoded credential public key.
+	PublicKey []byte `json:"publicKey"`
+	// SignCount is the last seen signature counter of the credential.
+	SignCount uint32 `json:"signCount"`
+	// AAGUID identifies the model of the authenticator.
+	AAGUID []byte `json:"aaguid,omitempty"`
+	// AttestationType is one of the Attestation constants.
+	AttestationType string `json:"attestationType"`
+}
+
+// VerifyRegistration verifies the response of the authenticator to a
+// registration ceremony with challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	err := rp.verifyClientData(clientDataJSON, ClientDataCreate, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	att, err := parseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rp.verifyAuthenticatorData(att.authData)
+	if err != nil {
+		return nil, err
+	}
+	if !att.authData.Has(FlagAttestedCredentialData) {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+
+	key, _, err := ParsePublicKey(att.authData.CredentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationType, err := att.verify(sha256(clientDataJSON), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:              att.authData.CredentialID,
+		PublicKey:       att.authData.CredentialPublicKey,
+		SignCount:       att.authData.SignCount,
+		AAGUID:          att.authData.AAGUID,
+		AttestationType: attestationType,
+	}, nil
+}
+
+// VerifyAssertion verifies the response of the authenticator to an
+// authentication ceremony with challenge for the credential cred and returns
+// the new signature counter, which should be stored with the credential.
+//
+// If the signature is valid, but the counter did not increase,
+// ErrCounterRegression is returned.
+func (rp *RelyingParty) VerifyAssertion(cred *Credential, challenge, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	err := rp.verifyClientData(clientDataJSON, ClientDataGet, challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	err = rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, _, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	signed := append(append([]byte{}, authenticatorData...), sha256(clientDataJSON)...)
+	err = key.Verify(signed, signature)
+	if err != nil {
+		return 0, err
+	}
+
+	if !counterIncreased(cred.SignCount, authData.SignCount) {
+		return 0, ErrCounterRegression
+	}
+
+	return authData.SignCount, nil
+}
+
+// counterIncreased reports whether the signature counter of an assertion
+// increased over the last seen counter. Authenticators without a counter
+// always report zero.
+func counterIncreased(last, signCount uint32) bool {
+	return (signCount == 0 && last == 0) || signCount > last
+}
+
+// verifyClientData checks the type, challenge and origin of the client data.
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, typ string, challenge []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	if clientData.Type != typ {
+		return fmt.Errorf("unexpected client data type: %q", clientData.Type)
+	}
+
+	received, err := clientData.ChallengeBytes()
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return errors.New("challenge does not match")
+	}
+
+	if clientData.CrossOrigin {
+		return errors.New("cross-origin ceremonies are not allowed")
+	}
+	if !rp.allowedOrigin(clientData.Origin) {
+		return fmt.Errorf("origin not allowed: %q", clientData.Origin)
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) allowedOrigin(origin string) bool {
+	for _, o := range rp.Origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user flags of the
+// authenticator data.
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData) error {
+	if !bytes.Equal(authData.RPIDHash, sha256([]byte(rp.ID))) {
+		return errors.New("rp id hash does not match")
+	}
+	if !authData.Has(FlagUserPresent) {
+		return errors.New("user not present")
+	}
+	if rp.RequireUserVerification && !authData.Has(FlagUserVerified) {
+		return errors.New("user not verified")
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthn_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+const (
+	rpID   = "phobia.cloud"
+	origin = "https://phobia.cloud"
+)
+
+var rp = &webauthn.RelyingParty{ID: rpID, Name: "Phobia", Origins: []string{origin}}
+
+var challenge = bytes.Repeat([]byte{0xcd}, 32)
+
+func newAuthenticator(alg int64) *webauthntest.Authenticator {
+	return webauthntest.NewAuthenticator(alg, bytes.Repeat([]byte{7}, 32))
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	for _, alg := range []int64{webauthn.AlgES256, webauthn.AlgEdDSA} {
+		for _, attestation := range []string{webauthn.AttestationNone, webauthn.AttestationSelf, webauthn.AttestationBasic} {
+			auth := newAuthenticator(alg)
+
+			clientData, attestationObject := auth.Register(rpID, origin, challenge, attestation)
+			cred, err := rp.VerifyRegistration(challenge, clientData, attestationObject)
+			require.NoError(t, err, "%d %s", alg, attestation)
+			assert.Equal(t, auth.CredentialID, cred.ID)
+			assert.Equal(t, auth.PublicKey(), cred.PublicKey)
+			assert.Equal(t, auth.AAGUID, cred.AAGUID)
+			assert.Equal(t, uint32(0), cred.SignCount)
+			assert.Equal(t, attestation, cred.AttestationType)
+		}
+	}
+}
+
+func TestVerifyRegistration_Invalid(t *testing.T) {
+	auth := newAuthenticator(webauthn.AlgES256)
+	clientData, attestationObject := auth.Register(rpID, origin, challenge, webauthn.AttestationSelf)
+
+	for _, tt := range []struct {
+		name              string
+		rp                *webauthn.RelyingParty
+		challenge         []byte
+		clientData        []byte
+		attestationObject []byte
+		err               string
+	}{
+		{
+			name:       "wrong type",
+			clientData: webauthntest.ClientDataJSON(webauthn.ClientDataGet, challenge, origin),
+			err:        `unexpected client data type: "webauthn.get"`,
+		},
+		{
+			name:      "wrong challenge",
+			challenge: bytes.Repeat([]byte{0xab}, 32),
+			err:       "challenge does not match",
+		},
+		{
+			name:       "wrong origin",
+			clientData: webauthntest.ClientDataJSON(webauthn.ClientDataCreate, challenge, "https://phobia.club"),
+			err:        `origin not allowed: "https://phobia.club"`,
+		},
+		{
+			name:       "cross origin",
+			clientData: []byte(`{"type":"webauthn.create","challenge":"` + encodeChallenge(challenge) + `","origin":"https://phobia.cloud","crossOrigin":true}`),
+			err:        "cross-origin ceremonies are not allowed",
+		},
+		{
+			name: "wrong rp id",
+			rp:   &webauthn.RelyingParty{ID: "phobia.club", Origins: []string{origin}},
+			err:  "rp id hash does not match",
+		},
+		{
+			name: "user not verified",
+			rp:   &webauthn.RelyingParty{ID: rpID, Origins: []string{origin}, RequireUserVerification: true},
+			err:  "user not verified",
+		},
+		{
+			name:              "signature of other client data",
+			clientData:        webauthntest.ClientDataJSON(webauthn.ClientDataCreate, challenge, origin+"/"),
+			rp:                &webauthn.RelyingParty{ID: rpID, Origins: []string{origin + "/"}},
+			attestationObject: attestationObject,
+			err:               webauthn.ErrInvalidSignature.Error(),
+		},
+		{
+			name: "none with statement",
+			attestationObject: webauthntest.EncodeCBOR(map[interface{}]interface{}{
+				"fmt":      "none",
+				"attStmt":  map[interface{}]interface{}{"alg": -7},
+				"authData": auth.AuthenticatorData(rpID, true),
+			}),
+			err: "none attestation with non-empty statement",
+		},
+		{
+			name: "unsupported format",
+			attestationObject: webauthntest.EncodeCBOR(map[interface{}]interface{}{
+				"fmt":      "tpm",
+				"attStmt":  map[interface{}]interface{}{},
+				"authData": auth.AuthenticatorData(rpID, true),
+			}),
+			err: `unsupported attestation format: "tpm"`,
+		},
+		{
+			name: "self attestation with other algorithm",
+			attestationObject: webauthntest.EncodeCBOR(map[interface{}]interface{}{
+				"fmt":      "packed",
+				"attStmt":  map[interface{}]interface{}{"alg": -8, "sig": []byte{1}},
+				"authData": auth.AuthenticatorData(rpID, true),
+			}),
+			err: "packed self attestation algorithm does not match credential",
+		},
+		{
+			name: "no attested credential",
+			attestationObject: webauthntest.EncodeCBOR(map[interface{}]interface{}{
+				"fmt":      "none",
+				"attStmt":  map[interface{}]interface{}{},
+				"authData": auth.AuthenticatorData(rpID, false),
+			}),
+			err: "authenticator data has no attested credential",
+		},
+		{
+			name:              "not a map",
+			attestationObject: webauthntest.EncodeCBOR("none"),
+			err:               "failed to decode attestation object: not a map",
+		},
+	} {
+		r := rp
+		if tt.rp != nil {
+			r = tt.rp
+		}
+		c := challenge
+		if tt.challenge != nil {
+			c = tt.challenge
+		}
+		cd := clientData
+		if tt.clientData != nil {
+			cd = tt.clientData
+		}
+		ao := attestationObject
+		if tt.attestationObject != nil {
+			ao = tt.attestationObject
+		}
+
+		_, err := r.VerifyRegistration(c, cd, ao)
+		assert.EqualError(t, err, tt.err, tt.name)
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	for _, alg := range []int64{webauthn.AlgES256, webauthn.AlgEdDSA} {
+		auth := newAuthenticator(alg)
+		clientData, attestationObject := auth.Register(rpID, origin, challenge, webauthn.AttestationNone)
+		cred, err := rp.VerifyRegistration(challenge, clientData, attestationObject)
+		require.NoError(t, err)
+
+		for i := uint32(1); i <= 3; i++ {
+			clientData, authData, sig := auth.Assert(rpID, origin, challenge)
+			count, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig)
+			require.NoError(t, err, alg)
+			assert.Equal(t, i, count)
+			cred.SignCount = count
+		}
+
+		clientData, authData, sig := auth.Assert(rpID, origin, challenge)
+		_, err = rp.VerifyAssertion(cred, challenge, clientData, authData, append([]byte{}, sig[:len(sig)-1]...))
+		assert.Equal(t, webauthn.ErrInvalidSignature, err, alg)
+
+		_, err = rp.VerifyAssertion(cred, bytes.Repeat([]byte{0xab}, 32), clientData, authData, sig)
+		assert.EqualError(t, err, "challenge does not match", alg)
+
+		clientData = webauthntest.ClientDataJSON(webauthn.ClientDataCreate, challenge, origin)
+		_, err = rp.VerifyAssertion(cred, challenge, clientData, authData, sig)
+		assert.EqualError(t, err, `unexpected client data type: "webauthn.create"`, alg)
+	}
+}
+
+func TestVerifyAssertion_CounterRegression(t *testing.T) {
+	auth := newAuthenticator(webauthn.AlgES256)
+	cred := &webauthn.Credential{ID: auth.CredentialID, PublicKey: auth.PublicKey(), SignCount: 5}
+
+	for _, tt := range []struct {
+		stored, current uint32
+		err             error
+	}{
+		{stored: 5, current: 6},
+		{stored: 5, current: 5, err: webauthn.ErrCounterRegression},
+		{stored: 5, current: 4, err: webauthn.ErrCounterRegression},
+		{stored: 5, current: 0, err: webauthn.ErrCounterRegression},
+		{stored: 0, current: 1},
+		// authenticators without a counter always report zero
+		{stored: 0, current: 0},
+	} {
+		cred.SignCount = tt.stored
+		auth.SignCount = tt.current - 1
+
+		clientData, authData, sig := auth.Assert(rpID, origin, challenge)
+		count, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig)
+		assert.Equal(t, tt.err, err, "%d -> %d", tt.stored, tt.current)
+		if tt.err == nil {
+			assert.Equal(t, tt.current, count)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"phobia.cloud/api/webauthn"
+)
+
+// Authenticator is a simulated WebAuthn authenticator with a single
+// credential.
+type Authenticator struct {
+	// Algorithm is the COSE algorithm of the credential, webauthn.AlgES256
+	// or webauthn.AlgEdDSA.
+	Algorithm int64
+	// AAGUID identifies the model of the authenticator.
+	AAGUID []byte
+	// CredentialID is the ID of the credential.
+	CredentialID []byte
+	// SignCount is the signature counter, incremented by Assert.
+	SignCount uint32
+	// UserVerified sets the user verified flag in the authenticator data.
+	UserVerified bool
+
+	ecKey *ecdsa.PrivateKey
+	edKey ed25519.PrivateKey
+}
+
+// NewAuthenticator returns an authenticator with a credential of the COSE
+// algorithm alg derived from the provided 32-byte secret.
+func NewAuthenticator(alg int64, secret []byte) *Authenticator {
+	a := &Authenticator{
+		Algorithm:    alg,
+		AAGUID:       []byte("phobia-test-auth"),
+		CredentialID: sha256Sum(append([]byte("credential"), secret...)),
+	}
+
+	switch alg {
+	case webauthn.AlgES256:
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(secret)
+		d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+		d.Add(d, big.NewInt(1))
+		a.ecKey = &ecdsa.PrivateKey{D: d}
+		a.ecKey.PublicKey.Curve = curve
+		a.ecKey.PublicKey.X, a.ecKey.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	case webauthn.AlgEdDSA:
+		a.edKey = ed25519.NewKeyFromSeed(secret)
+	default:
+		panic(fmt.Sprintf("unsupported algorithm %d", alg))
+	}
+
+	return a
+}
+
+// PublicKey returns the COSE_Key encoded public key of the credential.
+func (a *Authenticator) PublicKey() []byte {
+	if a.ecKey != nil {
+		return EncodeCBOR(map[interface{}]interface{}{
+			1:  2,
+			3:  a.Algorithm,
+			-1: 1,
+			-2: pad32(a.ecKey.X.Bytes()),
+			-3: pad32(a.ecKey.Y.Bytes()),
+		})
+	}
+	return EncodeCBOR(map[interface{}]interface{}{
+		1:  1,
+		3:  a.Algorithm,
+		-1: 6,
+		-2: []byte(a.edKey.Public().(ed25519.PublicKey)),
+	})
+}
+
+// Sign signs data with the credential key. ES256 signatures are ASN.1 DER
+// encoded.
+func (a *Authenticator) Sign(data []byte) []byte {
+	if a.ecKey != nil {
+		sig, err := ecdsa.SignASN1(rand.Reader, a.ecKey, sha256Sum(data))
+		if err != nil {
+			panic(err)
+		}
+		return sig
+	}
+	return ed25519.Sign(a.edKey, data)
+}
+
+// AuthenticatorData returns the authenticator data for the relying party
+// rpID with the current signature counter. If attested is true, the data
+// contains the attested credential.
+func (a *Authenticator) AuthenticatorData(rpID string, attested bool) []byte {
+	flags := byte(webauthn.FlagUserPresent)
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	if attested {
+		flags |= webauthn.FlagAttestedCredentialData
+	}
+
+	data := append(sha256Sum([]byte(rpID)), flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.SignCount)
+
+	if attested {
+		data = append(data, a.AAGUID...)
+		data = append(data, byte(len(a.CredentialID)>>8), byte(len(a.CredentialID)))
+		data = append(data, a.CredentialID...)
+		data = append(data, a.PublicKey()...)
+	}
+
+	return data
+}
+
+// Register returns the client data and the attestation object of a
+// registration ceremony with challenge. attestation is one of the
+// webauthn.Attestation constants and selects the "none" or the "packed"
+// attestation format.
+func (a *Authenticator) Register(rpID, origin string, challenge []byte, attestation string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = ClientDataJSON(webauthn.ClientDataCreate, challenge, origin)
+	authData := a.AuthenticatorData(rpID, true)
+	signed := append(append([]byte{}, authData...), sha256Sum(clientDataJSON)...)
+
+	var format string
+	statement := map[interface{}]interface{}{}
+	switch attestation {
+	case webauthn.AttestationNone:
+		format = "none"
+	case webauthn.AttestationSelf:
+		format = "packed"
+		statement["alg"] = a.Algorithm
+		statement["sig"] = a.Sign(signed)
+	case webauthn.AttestationBasic:
+		format = "packed"
+		key, cert := a.attestationCertificate()
+		sig, err := ecdsa.SignASN1(rand.Reader, key, sha256Sum(signed))
+		if err != nil {
+			panic(err)
+		}
+		statement["alg"] = webauthn.AlgES256
+		statement["sig"] = sig
+		statement["x5c"] = []interface{}{cert}
+	default:
+		panic(fmt.Sprintf("unsupported attestation %q", attestation))
+	}
+
+	attestationObject = EncodeCBOR(map[interface{}]interface{}{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	return clientDataJSON, attestationObject
+}
+
+// Assert increments the signature counter and returns the client data, the
+// authenticator data and the signature of an authentication ceremony with
+// challenge.
+func (a *Authenticator) Assert(rpID, origin string, challenge []byte) (clientDataJSON, authenticatorData, signature []byte) {
+	a.SignCount++
+	clientDataJSON = ClientDataJSON(webauthn.ClientDataGet, challenge, origin)
+	authenticatorData = a.AuthenticatorData(rpID, false)
+	signed := append(append([]byte{}, authenticatorData...), sha256Sum(clientDataJSON)...)
+	return clientDataJSON, authenticatorData, a.Sign(signed)
+}
+
+// ClientDataJSON returns the client data of a ceremony of type typ as the
+// browser would collect it.
+func ClientDataJSON(typ string, challenge []byte, origin string) []byte {
+	data, err := json.Marshal(webauthn.CollectedClientData{
+		Type:      typ,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// attestationCertificate returns a new attestation key and its DER encoded
+// certificate issued by a throwaway CA.
+func (a *Authenticator) attestationCertificate() (*ecdsa.PrivateKey, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	aaguid, err := asn1.Marshal(a.AAGUID)
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Phobia Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Country:            []string{"BG"},
+			Organization:       []string{"Phobia Test"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Phobia Test Authenticator",
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguid},
+		},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		panic(err)
+	}
+	return key, cert
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func sha256Sum(msg []byte) []byte {
+	hash := sha256.Sum256(msg)
+	return hash[:]
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthntest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webauthn"
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+func TestAuthenticator(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "phobia.cloud", Origins: []string{"https://phobia.cloud"}}
+	challenge := bytes.Repeat([]byte{1}, 32)
+
+	for _, alg := range []int64{webauthn.AlgES256, webauthn.AlgEdDSA} {
+		auth := webauthntest.NewAuthenticator(alg, bytes.Repeat([]byte{2}, 32))
+		same := webauthntest.NewAuthenticator(alg, bytes.Repeat([]byte{2}, 32))
+		assert.Equal(t, auth.PublicKey(), same.PublicKey(), alg)
+		assert.Equal(t, auth.CredentialID, same.CredentialID, alg)
+
+		clientData, attestationObject := auth.Register(rp.ID, rp.Origins[0], challenge, webauthn.AttestationBasic)
+		cred, err := rp.VerifyRegistration(challenge, clientData, attestationObject)
+		require.NoError(t, err, alg)
+
+		clientData, authData, sig := auth.Assert(rp.ID, rp.Origins[0], challenge)
+		count, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig)
+		require.NoError(t, err, alg)
+		assert.Equal(t, uint32(1), count)
+		assert.Equal(t, uint32(1), auth.SignCount)
+	}
+}
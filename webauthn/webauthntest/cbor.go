@@ -0,0 +1,99 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthntest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// EncodeCBOR encodes v in the CTAP2 canonical CBOR encoding. It supports
+// integers, strings, byte strings, booleans, []interface{} and
+// map[interface{}]interface{}.
+func EncodeCBOR(v interface{}) []byte {
+	var buf bytes.Buffer
+	encodeCBOR(&buf, v)
+	return buf.Bytes()
+}
+
+func encodeCBOR(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case int:
+		encodeCBORInt(buf, int64(v))
+	case int64:
+		encodeCBORInt(buf, v)
+	case uint32:
+		encodeCBORHead(buf, 0, uint64(v))
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case []byte:
+		encodeCBORHead(buf, 2, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		encodeCBORHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		encodeCBORHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			encodeCBOR(buf, item)
+		}
+	case map[interface{}]interface{}:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(v))
+		for key, value := range v {
+			entries = append(entries, entry{EncodeCBOR(key), EncodeCBOR(value)})
+		}
+		// keys are sorted by length first and then bytewise
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return bytes.Compare(a, b) < 0
+		})
+		encodeCBORHead(buf, 5, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			buf.Write(e.value)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported cbor type %T", v))
+	}
+}
+
+func encodeCBORInt(buf *bytes.Buffer, v int64) {
+	if v < 0 {
+		encodeCBORHead(buf, 1, uint64(-1-v))
+	} else {
+		encodeCBORHead(buf, 0, uint64(v))
+	}
+}
+
+func encodeCBORHead(buf *bytes.Buffer, major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(major | byte(arg))
+	case arg <= 0xff:
+		buf.Write([]byte{major | 24, byte(arg)})
+	case arg <= 0xffff:
+		b := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(arg))
+		buf.Write(b)
+	case arg <= 0xffffffff:
+		b := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(arg))
+		buf.Write(b)
+	default:
+		b := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], arg)
+		buf.Write(b)
+	}
+}
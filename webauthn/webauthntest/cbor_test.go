@@ -0,0 +1,42 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webauthntest_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"phobia.cloud/api/webauthn/webauthntest"
+)
+
+func TestEncodeCBOR(t *testing.T) {
+	// test vectors from RFC 8949, appendix A
+	for _, tt := range []struct {
+		value interface{}
+		data  string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{int64(1000000000000), "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{false, "f4"},
+		{true, "f5"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]interface{}{1, []interface{}{2, 3}, []interface{}{4, 5}}, "8301820203820405"},
+		{map[interface{}]interface{}{1: 2, 3: 4}, "a201020304"},
+		{map[interface{}]interface{}{"a": 1, "b": []interface{}{2, 3}}, "a26161016162820203"},
+		// canonical order sorts shorter keys first
+		{map[interface{}]interface{}{"fmt": 0, -1: 0, 3: 0, "sig": 0}, "a40300200063666d74006373696700"},
+	} {
+		assert.Equal(t, tt.data, hex.EncodeToString(webauthntest.EncodeCBOR(tt.value)), "%v", tt.value)
+	}
+}
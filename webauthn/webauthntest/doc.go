@@ -0,0 +1,9 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package webauthntest provides a simulated WebAuthn authenticator for
+// testing code that verifies ceremonies with the webauthn package.
+//
+// The authenticator is not hardened in any way and must not be used with
+// real keys.
+package webauthntest
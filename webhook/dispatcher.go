@@ -0,0 +1,771 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"phobia.cloud/api/logging"
+	"phobia.cloud/api/problem"
+	"phobia.cloud/api/store"
+)
+
+// Defaults and limits of the Dispatcher.
+const (
+	// DefaultPollInterval is how often the outbox is checked for due
+	// deliveries.
+	DefaultPollInterval = time.Second
+	// MaxOutbox is the maximum number of queued deliveries.
+	MaxOutbox = 10000
+	// MaxDeadLetters is the maximum number of kept dead letters. The oldest
+	// are dropped first.
+	MaxDeadLetters = 1000
+	// maxResponseSize is how much of a response body is read, so the
+	// connection can be reused.
+	maxResponseSize = 64 << 10
+)
+
+// Keys of the outbox and the dead letters in the store. Each queued delivery
+// is kept under its own key with a sequence number, so publishing an event
+// writes only its own deliveries. The outbox holds the sequence numbers from
+// the head up to, but not including, the tail.
+const (
+	outboxPrefix   = "webhook/outbox/"
+	outboxHeadKey  = "webhook/outbox/head"
+	outboxTailKey  = "webhook/outbox/tail"
+	deadLettersKey = "webhook/dead-letters"
+)
+
+// ErrOutboxFull is returned by Publish when MaxOutbox deliveries are queued.
+var ErrOutboxFull = errors.New("webhook outbox is full")
+
+var webhookLog = logging.Component("webhook")
+
+// Policy is the retry policy of the deliveries.
+type Policy struct {
+	// MaxAttempts is the number of attempts before a delivery is moved to
+	// the dead letters.
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt. It doubles with
+	// each further failed attempt.
+	Backoff time.Duration
+	// MaxBackoff is the maximum delay between attempts.
+	MaxBackoff time.Duration
+	// Timeout is how long an attempt may take.
+	Timeout time.Duration
+}
+
+// DefaultPolicy retries a delivery for about two hours.
+var DefaultPolicy = Policy{
+	MaxAttempts: 8,
+	Backoff:     10 * time.Second,
+	MaxBackoff:  time.Hour,
+	Timeout:     10 * time.Second,
+}
+
+// Validate checks that the values of p are positive and consistent.
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max attempts must be positive, got %d", p.MaxAttempts)
+	case p.Backoff <= 0:
+		return fmt.Errorf("backoff must be positive, got %v", p.Backoff)
+	case p.MaxBackoff < p.Backoff:
+		return fmt.Errorf("max backoff must not be less than backoff %v, got %v", p.Backoff, p.MaxBackoff)
+	case p.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive, got %v", p.Timeout)
+	}
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (p Policy) backoff(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Delivery is an event queued for an endpoint.
+type Delivery struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	Event    Event  `json:"event"`
+	// Attempts is the number of failed attempts.
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// queued is a delivery in the outbox.
+type queued struct {
+	seq      uint64
+	endpoint string
+}
+
+// Dispatcher delivers events to endpoints. The methods of a nil Dispatcher
+// do nothing.
+type Dispatcher struct {
+	store     store.Store
+	endpoints []Endpoint
+	policy    Policy
+	client    *http.Client
+
+	// mu serializes the changes of the outbox and the dead letters.
+	mu sync.Mutex
+	// opened is set once queued and tail are loaded from the store.
+	opened bool
+	// queued are the deliveries in the outbox by sequence number.
+	queued []queued
+	// tail is the sequence number of the next delivery.
+	tail uint64
+	// busy holds a token for each endpoint with deliveries in progress, so
+	// the deliveries to an endpoint are attempted in order while a slow
+	// endpoint does not hold up the others.
+	busy map[string]chan struct{}
+
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closed  sync.Once
+	flushes sync.WaitGroup
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewDispatcher returns a dispatcher that keeps its outbox and dead letters
+// in s and delivers the events to endpoints with policy. If policy is zero,
+// DefaultPolicy is used. It delivers the due deliveries when an event is
+// published and every DefaultPollInterval until it is closed.
+//
+// Redirects are not followed, so an endpoint must answer with a 2xx status
+// itself.
+func NewDispatcher(s store.Store, endpoints []Endpoint, policy Policy) *Dispatcher {
+	if policy == (Policy{}) {
+		policy = DefaultPolicy
+	}
+	d := &Dispatcher{
+		store:     s,
+		endpoints: endpoints,
+		policy:    policy,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		busy: make(map[string]chan struct{}),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		now:  time.Now,
+	}
+	go d.run()
+	return d
+}
+
+// Publish queues a delivery of an event of type typ with data for each
+// endpoint subscribed to typ.
+func (d *Dispatcher) Publish(ctx context.Context, typ string, data Data) error {
+	if d == nil {
+		return nil
+	}
+	now := d.now().UTC()
+	event := Event{ID: newID(), Type: typ, Time: now, Data: data}
+	var deliveries []Delivery
+	for _, e := range d.endpoints {
+		if e.Subscribed(typ) {
+			deliveries = append(deliveries, Delivery{ID: newID(), Endpoint: e.URL, Event: event, NextAttempt: now})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.open(ctx)
+	if err != nil {
+		return err
+	}
+	if len(d.queued)+len(deliveries) > MaxOutbox {
+		return ErrOutboxFull
+	}
+	err = d.enqueue(ctx, deliveries...)
+	if err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// DeadLetters returns the deliveries that failed MaxAttempts times, newest
+// first.
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]Delivery, error) {
+	if d == nil {
+		return nil, nil
+	}
+	dead, err := d.load(ctx, deadLettersKey)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(dead)-1; i < j; i, j = i+1, j-1 {
+		dead[i], dead[j] = dead[j], dead[i]
+	}
+	return dead, nil
+}
+
+// Replay moves the dead letter with the given ID back to the outbox with
+// its attempts reset. It returns store.ErrNotFound if there is no such dead
+// letter.
+func (d *Dispatcher) Replay(ctx context.Context, id string) error {
+	if d == nil {
+		return store.ErrNotFound
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dead, err := d.load(ctx, deadLettersKey)
+	if err != nil {
+		return err
+	}
+	i := indexOf(dead, id)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	delivery := dead[i]
+	delivery.Attempts, delivery.LastError, delivery.NextAttempt = 0, "", d.now().UTC()
+
+	err = d.open(ctx)
+	if err != nil {
+		return err
+	}
+	if len(d.queued) >= MaxOutbox {
+		return ErrOutboxFull
+	}
+	// the outbox is written first, so a failure duplicates the delivery
+	// instead of losing it
+	err = d.enqueue(ctx, delivery)
+	if err != nil {
+		return err
+	}
+	err = d.save(ctx, deadLettersKey, append(dead[:i], dead[i+1:]...))
+	if err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// Flush attempts the due deliveries of the outbox. The deliveries to each
+// endpoint are attempted in order, concurrently with those to the other
+// endpoints. Failed attempts are logged and retried later; only errors of
+// the store are returned.
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	return d.flush(ctx, true)
+}
+
+// flush attempts the due deliveries of the outbox. If wait is false, the
+// endpoints with deliveries in progress are skipped instead of waited for.
+func (d *Dispatcher) flush(ctx context.Context, wait bool) error {
+	d.mu.Lock()
+	err := d.open(ctx)
+	var endpoints []string
+	seqs := make(map[string][]uint64)
+	for _, q := range d.queued {
+		if seqs[q.endpoint] == nil {
+			endpoints = append(endpoints, q.endpoint)
+		}
+		seqs[q.endpoint] = append(seqs[q.endpoint], q.seq)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	now := d.now()
+	errs := make(chan error, len(endpoints))
+	for _, endpoint := range endpoints {
+		go func(endpoint string) {
+			errs <- d.deliver(ctx, endpoint, seqs[endpoint], now, wait)
+		}(endpoint)
+	}
+	for range endpoints {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// deliver attempts the deliveries with seqs to endpoint that are due at now.
+func (d *Dispatcher) deliver(ctx context.Context, endpoint string, seqs []uint64, now time.Time, wait bool) error {
+	d.mu.Lock()
+	token, ok := d.busy[endpoint]
+	if !ok {
+		token = make(chan struct{}, 1)
+		d.busy[endpoint] = token
+	}
+	d.mu.Unlock()
+
+	if wait {
+		select {
+		case token <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		select {
+		case token <- struct{}{}:
+		default:
+			return nil
+		}
+	}
+	defer func() { <-token }()
+
+	for _, seq := range seqs {
+		// the delivery is read again, as it may have been attempted since
+		// the outbox was read
+		delivery, err := d.get(ctx, seq)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if delivery.NextAttempt.After(now) {
+			continue
+		}
+		sendErr := d.send(ctx, delivery)
+		if ctx.Err() != nil {
+			// the attempt was interrupted, e.g. on shutdown, so it is
+			// not counted
+			return ctx.Err()
+		}
+		err = d.settle(ctx, seq, delivery, sendErr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the background deliveries. The queued deliveries stay in the
+// outbox. It implements io.Closer, so it can be closed on shutdown.
+func (d *Dispatcher) Close() error {
+	if d == nil {
+		return nil
+	}
+	d.closed.Do(func() { close(d.stop) })
+	<-d.done
+	d.flushes.Wait()
+	return nil
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(DefaultPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		// the flushes skip the endpoints still busy with a previous one
+		d.flushes.Add(1)
+		go func() {
+			defer d.flushes.Done()
+			err := d.flush(ctx, false)
+			if err != nil && ctx.Err() == nil {
+				webhookLog.Warn("error delivering webhooks", "error", err)
+			}
+		}()
+	}
+}
+
+// notify wakes up the background deliveries.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send posts the event of delivery to its endpoint.
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery) error {
+	endpoint, ok := d.endpoint(delivery.Endpoint)
+	if !ok {
+		return errors.New("endpoint is not configured")
+	}
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.policy.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, delivery.Event.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// settle removes the delivery with seq from the outbox after a successful
+// attempt, or counts a failed attempt and schedules the next one or moves
+// the delivery to the dead letters.
+func (d *Dispatcher) settle(ctx context.Context, seq uint64, delivery Delivery, sendErr error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if sendErr == nil {
+		return d.remove(ctx, seq)
+	}
+
+	delivery.Attempts++
+	delivery.LastError = sendErr.Error()
+	if delivery.Attempts < d.policy.MaxAttempts {
+		delivery.NextAttempt = d.now().UTC().Add(d.policy.backoff(delivery.Attempts))
+		webhookLog.Warn("error delivering webhook",
+			"event", delivery.Event.ID,
+			"endpoint", delivery.Endpoint,
+			"attempts", delivery.Attempts,
+			"error", sendErr)
+		return d.put(ctx, seq, delivery)
+	}
+
+	webhookLog.Error("webhook moved to dead letters",
+		"event", delivery.Event.ID,
+		"endpoint", delivery.Endpoint,
+		"attempts", delivery.Attempts,
+		"error", sendErr)
+	dead, err := d.load(ctx, deadLettersKey)
+	if err != nil {
+		return err
+	}
+	dead = append(dead, delivery)
+	if len(dead) > MaxDeadLetters {
+		dead = dead[len(dead)-MaxDeadLetters:]
+	}
+	// the dead letters are written first, so a failure duplicates the
+	// delivery instead of losing it
+	err = d.save(ctx, deadLettersKey, dead)
+	if err != nil {
+		return err
+	}
+	return d.remove(ctx, seq)
+}
+
+// open loads the queued deliveries and the tail of the outbox on first use.
+// The caller must hold d.mu.
+func (d *Dispatcher) open(ctx context.Context) error {
+	if d.opened {
+		return nil
+	}
+	head, err := d.counter(ctx, outboxHeadKey)
+	if err != nil {
+		return err
+	}
+	tail, err := d.counter(ctx, outboxTailKey)
+	if err != nil {
+		return err
+	}
+	var q []queued
+	for seq := head; seq < tail; seq++ {
+		delivery, err := d.get(ctx, seq)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		q = append(q, queued{seq: seq, endpoint: delivery.Endpoint})
+	}
+	d.queued, d.tail, d.opened = q, tail, true
+	return nil
+}
+
+// enqueue appends deliveries to the outbox. The caller must hold d.mu.
+func (d *Dispatcher) enqueue(ctx context.Context, deliveries ...Delivery) error {
+	// the tail is written first, so a failure leaves a gap instead of a
+	// delivery past the tail
+	seq := d.tail
+	err := d.setCounter(ctx, outboxTailKey, seq+uint64(len(deliveries)))
+	if err != nil {
+		return err
+	}
+	d.tail = seq + uint64(len(deliveries))
+	for i, delivery := range deliveries {
+		err = d.put(ctx, seq+uint64(i), delivery)
+		if err != nil {
+			return err
+		}
+		d.queued = append(d.queued, queued{seq: seq + uint64(i), endpoint: delivery.Endpoint})
+	}
+	return nil
+}
+
+// remove deletes the delivery with seq from the outbox and moves the head
+// past it if it was the first. The caller must hold d.mu.
+func (d *Dispatcher) remove(ctx context.Context, seq uint64) error {
+	err := d.store.Delete(ctx, deliveryKey(seq))
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", deliveryKey(seq), err)
+	}
+	i := 0
+	for i < len(d.queued) && d.queued[i].seq != seq {
+		i++
+	}
+	if i == len(d.queued) {
+		return nil
+	}
+	d.queued = append(d.queued[:i], d.queued[i+1:]...)
+	if i > 0 {
+		return nil
+	}
+	head := d.tail
+	if len(d.queued) > 0 {
+		head = d.queued[0].seq
+	}
+	return d.setCounter(ctx, outboxHeadKey, head)
+}
+
+// outbox returns the queued deliveries in order.
+func (d *Dispatcher) outbox(ctx context.Context) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []Delivery
+	for _, q := range d.queued {
+		delivery, err := d.get(ctx, q.seq)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// deliveryKey returns the key of the delivery with seq in the store.
+func deliveryKey(seq uint64) string {
+	return outboxPrefix + strconv.FormatUint(seq, 10)
+}
+
+// get returns the delivery with seq in the outbox.
+func (d *Dispatcher) get(ctx context.Context, seq uint64) (Delivery, error) {
+	key := deliveryKey(seq)
+	value, err := d.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return Delivery{}, err
+	}
+	if err != nil {
+		return Delivery{}, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	var delivery Delivery
+	err = json.Unmarshal(value, &delivery)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return delivery, nil
+}
+
+// put writes the delivery with seq in the outbox.
+func (d *Dispatcher) put(ctx context.Context, seq uint64, delivery Delivery) error {
+	key := deliveryKey(seq)
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	err = d.store.Put(ctx, key, value, 0)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// counter returns the sequence number under key in the store, or zero.
+func (d *Dispatcher) counter(ctx context.Context, key string) (uint64, error) {
+	value, err := d.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	n, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return n, nil
+}
+
+// setCounter writes the sequence number n under key in the store.
+func (d *Dispatcher) setCounter(ctx context.Context, key string, n uint64) error {
+	err := d.store.Put(ctx, key, []byte(strconv.FormatUint(n, 10)), 0)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// endpoint returns the configured endpoint with url.
+func (d *Dispatcher) endpoint(url string) (Endpoint, bool) {
+	for _, e := range d.endpoints {
+		if e.URL == url {
+			return e, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// load returns the deliveries under key in the store.
+func (d *Dispatcher) load(ctx context.Context, key string) ([]Delivery, error) {
+	value, err := d.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	var deliveries []Delivery
+	err = json.Unmarshal(value, &deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return deliveries, nil
+}
+
+// save writes the deliveries under key in the store.
+func (d *Dispatcher) save(ctx context.Context, key string, deliveries []Delivery) error {
+	if len(deliveries) == 0 {
+		return d.store.Delete(ctx, key)
+	}
+	value, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+	err = d.store.Put(ctx, key, value, 0)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// DeadLettersResponse is the response of Dispatcher.ServeHTTP.
+type DeadLettersResponse struct {
+	DeadLetters []Delivery `json:"deadLetters"`
+}
+
+// ServeHTTP implements http.Handler. It responds with the dead letters as
+// DeadLettersResponse. The events are not redacted, so it must be served
+// only to operators.
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dead, err := d.DeadLetters(r.Context())
+	if err != nil {
+		webhookLog.Context(r.Context()).Error("error reading dead letters", "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+	if dead == nil {
+		dead = []Delivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(DeadLettersResponse{DeadLetters: dead})
+	if err != nil {
+		webhookLog.Warn("error writing response to client", "error", err)
+	}
+}
+
+// ServeReplay is a HTTP handler that replays the dead letter with the given
+// ID. It responds with 202 Accepted once the delivery is queued.
+func (d *Dispatcher) ServeReplay(w http.ResponseWriter, r *http.Request, id string) {
+	err := d.Replay(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Error(w, http.StatusNotFound, problem.NotFound, fmt.Sprintf("dead letter not found: %q", id))
+		return
+	}
+	if errors.Is(err, ErrOutboxFull) {
+		problem.Error(w, http.StatusServiceUnavailable, problem.Internal, err.Error())
+		return
+	}
+	if err != nil {
+		webhookLog.Context(r.Context()).Error("error replaying dead letter", "id", id, "error", err)
+		problem.Error(w, http.StatusInternalServerError, problem.Internal, "")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// indexOf returns the index of the delivery with id, or -1.
+func indexOf(deliveries []Delivery, id string) int {
+	for i := range deliveries {
+		if deliveries[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// newID returns a random ID.
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,420 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/store"
+)
+
+var testSecret = []byte("0123456789abcdef")
+
+// receiver is an in-process webhook endpoint that verifies the deliveries.
+type receiver struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	status  int
+	events  []Event
+	headers []http.Header
+	errs    []error
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rcv := &receiver{status: http.StatusNoContent}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.headers = append(rcv.headers, r.Header.Clone())
+		if err := Verify(testSecret, r.Header, body, time.Now(), time.Hour); err != nil {
+			rcv.errs = append(rcv.errs, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if rcv.status/100 == 2 {
+			var event Event
+			require.NoError(t, json.Unmarshal(body, &event))
+			rcv.events = append(rcv.events, event)
+		}
+		w.WriteHeader(rcv.status)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) setStatus(status int) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.status = status
+}
+
+func (rcv *receiver) received() []Event {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]Event(nil), rcv.events...)
+}
+
+func (rcv *receiver) attempts() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.headers)
+}
+
+// clock is a settable clock that starts at the current time, so the
+// timestamps of the deliveries are within the tolerance of the receiver.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestDispatcher returns a dispatcher without background deliveries, so
+// the test flushes it with a settable clock.
+func newTestDispatcher(t *testing.T, s store.Store, endpoints []Endpoint, policy Policy) (*Dispatcher, *clock) {
+	d := NewDispatcher(s, endpoints, policy)
+	require.NoError(t, d.Close())
+	c := &clock{now: time.Now()}
+	d.now = c.Now
+	return d, c
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t)
+	until := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDispatcher(store.NewMemory(), []Endpoint{{URL: rcv.URL, Secret: testSecret}}, Policy{})
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Method: "trezor", Subject: "key", ClientIP: "192.0.2.1"}))
+	require.NoError(t, d.Publish(ctx, EventLockedOut, Data{Scope: "ip", Subject: "192.0.2.1", Until: &until}))
+
+	require.Eventually(t, func() bool { return len(rcv.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	events := rcv.received()
+	assert.Equal(t, EventLoginSuccess, events[0].Type)
+	assert.Equal(t, Data{Method: "trezor", Subject: "key", ClientIP: "192.0.2.1"}, events[0].Data)
+	assert.Equal(t, EventLockedOut, events[1].Type)
+	require.NotNil(t, events[1].Data.Until)
+	assert.True(t, until.Equal(*events[1].Data.Until))
+	assert.NotEqual(t, events[0].ID, events[1].ID)
+
+	rcv.mu.Lock()
+	assert.Empty(t, rcv.errs)
+	assert.Equal(t, events[0].ID, rcv.headers[0].Get(IDHeader))
+	assert.Equal(t, "application/json", rcv.headers[0].Get("Content-Type"))
+	rcv.mu.Unlock()
+
+	// delivered events leave the outbox
+	require.Eventually(t, func() bool {
+		outbox, err := d.outbox(ctx)
+		require.NoError(t, err)
+		return len(outbox) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDispatcher_Filter(t *testing.T) {
+	ctx := context.Background()
+	logins, lockouts := newReceiver(t), newReceiver(t)
+	d, _ := newTestDispatcher(t, store.NewMemory(), []Endpoint{
+		{URL: logins.URL, Events: []string{EventLoginSuccess, EventCredentialRegistered}, Secret: testSecret},
+		{URL: lockouts.URL, Events: []string{EventLockedOut}, Secret: testSecret},
+	}, Policy{})
+
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Publish(ctx, EventLockedOut, Data{Subject: "192.0.2.1"}))
+	require.NoError(t, d.Publish(ctx, EventCredentialRegistered, Data{Subject: "bob"}))
+	require.NoError(t, d.Flush(ctx))
+
+	var types []string
+	for _, e := range logins.received() {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{EventLoginSuccess, EventCredentialRegistered}, types)
+	require.Len(t, lockouts.received(), 1)
+	assert.Equal(t, EventLockedOut, lockouts.received()[0].Type)
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t)
+	rcv.setStatus(http.StatusInternalServerError)
+	policy := Policy{MaxAttempts: 5, Backoff: time.Minute, MaxBackoff: 3 * time.Minute, Timeout: 5 * time.Second}
+	d, c := newTestDispatcher(t, store.NewMemory(), []Endpoint{{URL: rcv.URL, Secret: testSecret}}, policy)
+
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Flush(ctx))
+	assert.Equal(t, 1, rcv.attempts())
+
+	// exponential backoff: 1m, 2m, 3m (capped)
+	for i, backoff := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		outbox, err := d.outbox(ctx)
+		require.NoError(t, err)
+		require.Len(t, outbox, 1)
+		assert.Equal(t, i+1, outbox[0].Attempts)
+		assert.Equal(t, "unexpected status 500", outbox[0].LastError)
+		assert.True(t, c.Now().UTC().Add(backoff).Equal(outbox[0].NextAttempt), "attempt %d", i+1)
+
+		// not due yet
+		c.Add(backoff - time.Second)
+		require.NoError(t, d.Flush(ctx))
+		assert.Equal(t, i+1, rcv.attempts())
+
+		c.Add(time.Second)
+		require.NoError(t, d.Flush(ctx))
+		assert.Equal(t, i+2, rcv.attempts())
+	}
+
+	rcv.setStatus(http.StatusOK)
+	c.Add(3 * time.Minute)
+	require.NoError(t, d.Flush(ctx))
+	require.Len(t, rcv.received(), 1)
+
+	outbox, err := d.outbox(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, outbox)
+	dead, err := d.DeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestDispatcher_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t)
+	rcv.setStatus(http.StatusServiceUnavailable)
+	policy := Policy{MaxAttempts: 2, Backoff: time.Minute, MaxBackoff: time.Minute, Timeout: 5 * time.Second}
+	s := store.NewMemory()
+	d, c := newTestDispatcher(t, s, []Endpoint{{URL: rcv.URL, Secret: testSecret}}, policy)
+
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Publish(ctx, EventCredentialRegistered, Data{Subject: "bob"}))
+	require.NoError(t, d.Flush(ctx))
+	c.Add(time.Minute)
+	require.NoError(t, d.Flush(ctx))
+	assert.Equal(t, 4, rcv.attempts())
+
+	outbox, err := d.outbox(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, outbox)
+	dead, err := d.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 2)
+	assert.Equal(t, EventCredentialRegistered, dead[0].Event.Type, "newest first")
+	assert.Equal(t, EventLoginSuccess, dead[1].Event.Type)
+	assert.Equal(t, 2, dead[1].Attempts)
+	assert.Equal(t, "unexpected status 503", dead[1].LastError)
+
+	// the dead letters survive a restart
+	restarted, _ := newTestDispatcher(t, s, []Endpoint{{URL: rcv.URL, Secret: testSecret}}, policy)
+	persisted, err := restarted.DeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, dead, persisted)
+
+	// replaying queues the delivery again with its attempts reset
+	rcv.setStatus(http.StatusOK)
+	require.ErrorIs(t, restarted.Replay(ctx, "unknown"), store.ErrNotFound)
+	require.NoError(t, restarted.Replay(ctx, dead[1].ID))
+	require.ErrorIs(t, restarted.Replay(ctx, dead[1].ID), store.ErrNotFound)
+	require.NoError(t, restarted.Flush(ctx))
+
+	received := rcv.received()
+	require.Len(t, received, 1)
+	assert.Equal(t, dead[1].Event.ID, received[0].ID, "the event keeps its ID")
+	remaining, err := restarted.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, dead[0].ID, remaining[0].ID)
+}
+
+func TestDispatcher_Outbox(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t)
+	s := store.NewMemory()
+	endpoints := []Endpoint{{URL: rcv.URL, Secret: testSecret}}
+	d, _ := newTestDispatcher(t, s, endpoints, Policy{})
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+
+	// the outbox survives a restart
+	restarted, _ := newTestDispatcher(t, s, endpoints, Policy{})
+	require.NoError(t, restarted.Flush(ctx))
+	require.Len(t, rcv.received(), 1)
+	assert.Equal(t, "alice", rcv.received()[0].Data.Subject)
+
+	// the delivered events do not survive a restart
+	require.NoError(t, restarted.Publish(ctx, EventLoginSuccess, Data{Subject: "bob"}))
+	restarted, _ = newTestDispatcher(t, s, endpoints, Policy{})
+	require.NoError(t, restarted.Flush(ctx))
+	require.Len(t, rcv.received(), 2)
+	assert.Equal(t, "bob", rcv.received()[1].Data.Subject)
+}
+
+// puts counts the writes to a store.
+type puts struct {
+	store.Store
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *puts) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+	return s.Store.Put(ctx, key, value, ttl)
+}
+
+func TestDispatcher_OutboxKeys(t *testing.T) {
+	ctx := context.Background()
+	s := &puts{Store: store.NewMemory()}
+	d, _ := newTestDispatcher(t, s, []Endpoint{{URL: "http://127.0.0.1:1", Secret: testSecret}}, Policy{})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	}
+
+	// publishing writes the tail and its own delivery regardless of the
+	// queued deliveries
+	s.keys = nil
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "bob"}))
+	assert.Equal(t, []string{outboxTailKey, deliveryKey(100)}, s.keys)
+}
+
+func TestDispatcher_SlowEndpoint(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slow.Close()
+	defer close(release)
+	rcv := newReceiver(t)
+
+	endpoints := []Endpoint{{URL: slow.URL, Secret: testSecret}, {URL: rcv.URL, Secret: testSecret}}
+	d := NewDispatcher(store.NewMemory(), endpoints, Policy{})
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// the deliveries to the slow endpoint do not hold up the others
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "bob"}))
+	require.Eventually(t, func() bool { return len(rcv.received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDispatcher_UnknownEndpoint(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	d, _ := newTestDispatcher(t, s, []Endpoint{{URL: "http://127.0.0.1:1/removed", Secret: testSecret}}, Policy{})
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+
+	// the endpoint was removed from the configuration before a restart
+	restarted, _ := newTestDispatcher(t, s, nil, Policy{MaxAttempts: 1, Backoff: time.Minute, MaxBackoff: time.Minute, Timeout: time.Second})
+	require.NoError(t, restarted.Flush(ctx))
+	dead, err := restarted.DeadLetters(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "endpoint is not configured", dead[0].LastError)
+}
+
+func TestDispatcher_Redirect(t *testing.T) {
+	ctx := context.Background()
+	target := newReceiver(t)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	d, _ := newTestDispatcher(t, store.NewMemory(), []Endpoint{{URL: redirect.URL, Secret: testSecret}}, Policy{})
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Flush(ctx))
+
+	assert.Zero(t, target.attempts(), "redirects are not followed")
+	outbox, err := d.outbox(ctx)
+	require.NoError(t, err)
+	require.Len(t, outbox, 1)
+	assert.Equal(t, "unexpected status 307", outbox[0].LastError)
+}
+
+func TestDispatcher_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	rcv := newReceiver(t)
+	rcv.setStatus(http.StatusBadGateway)
+	d, _ := newTestDispatcher(t, store.NewMemory(), []Endpoint{{URL: rcv.URL, Secret: testSecret}}, Policy{MaxAttempts: 1, Backoff: time.Second, MaxBackoff: time.Second, Timeout: 5 * time.Second})
+
+	rr := httptest.NewRecorder()
+	d.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"deadLetters":[]}`, rr.Body.String())
+
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{Subject: "alice"}))
+	require.NoError(t, d.Flush(ctx))
+
+	rr = httptest.NewRecorder()
+	d.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var resp DeadLettersResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.DeadLetters, 1)
+	assert.Equal(t, rcv.URL, resp.DeadLetters[0].Endpoint)
+
+	rr = httptest.NewRecorder()
+	d.ServeReplay(rr, httptest.NewRequest(http.MethodPost, "/", nil), "unknown")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	d.ServeReplay(rr, httptest.NewRequest(http.MethodPost, "/", nil), resp.DeadLetters[0].ID)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+}
+
+func TestDispatcher_Nil(t *testing.T) {
+	ctx := context.Background()
+	var d *Dispatcher
+	require.NoError(t, d.Publish(ctx, EventLoginSuccess, Data{}))
+	require.NoError(t, d.Flush(ctx))
+	dead, err := d.DeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+	require.ErrorIs(t, d.Replay(ctx, "id"), store.ErrNotFound)
+	require.NoError(t, d.Close())
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	require.NoError(t, DefaultPolicy.Validate())
+	for _, tt := range []struct {
+		name   string
+		modify func(p *Policy)
+		err    string
+	}{
+		{name: "max attempts", modify: func(p *Policy) { p.MaxAttempts = 0 }, err: "max attempts must be positive, got 0"},
+		{name: "backoff", modify: func(p *Policy) { p.Backoff = 0 }, err: "backoff must be positive, got 0s"},
+		{name: "max backoff", modify: func(p *Policy) { p.MaxBackoff = time.Second }, err: "max backoff must not be less than backoff 10s, got 1s"},
+		{name: "timeout", modify: func(p *Policy) { p.Timeout = -time.Second }, err: "timeout must be positive, got -1s"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultPolicy
+			tt.modify(&p)
+			assert.EqualError(t, p.Validate(), tt.err)
+		})
+	}
+}
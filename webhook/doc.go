@@ -0,0 +1,18 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+// Package webhook delivers login and account events to HTTP endpoints of
+// operators.
+//
+// A Dispatcher queues a Delivery of each published Event for every Endpoint
+// that subscribed to its type in a persistent outbox in a store.Store, and
+// posts the queued deliveries from background goroutines, in order for
+// each endpoint and concurrently across endpoints. Each request
+// carries the event as JSON, its ID, a timestamp and an HMAC-SHA256
+// signature of the timestamp and the body with the secret of the endpoint,
+// which receivers check with Verify.
+//
+// Failed deliveries are retried with exponential backoff. Deliveries that
+// still fail after the maximum number of attempts are moved to a
+// dead-letter list, from which operators can replay them.
+package webhook
@@ -0,0 +1,165 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types.
+const (
+	// EventLoginSuccess is published when a user logs in.
+	EventLoginSuccess = "login.success"
+	// EventLockedOut is published when a public key, client IP or subnet is
+	// locked out after repeated failed logins.
+	EventLockedOut = "login.locked_out"
+	// EventCredentialRegistered is published when a user registers a
+	// passkey.
+	EventCredentialRegistered = "credential.registered"
+)
+
+// EventTypes are the event types that endpoints can subscribe to.
+var EventTypes = []string{EventLoginSuccess, EventLockedOut, EventCredentialRegistered}
+
+// Headers of the deliveries.
+const (
+	// IDHeader is the ID of the event, which is the same for all attempts,
+	// so receivers can ignore duplicates.
+	IDHeader = "Webhook-Id"
+	// TimestampHeader is the time of the attempt in Unix seconds.
+	TimestampHeader = "Webhook-Timestamp"
+	// SignatureHeader is the signature of the attempt. See Sign.
+	SignatureHeader = "Webhook-Signature"
+)
+
+// signaturePrefix is the prefix of the hex-encoded signature.
+const signaturePrefix = "sha256="
+
+// DefaultTolerance is how far the timestamp of a delivery may be from the
+// time of the receiver in Verify.
+const DefaultTolerance = 5 * time.Minute
+
+// Errors of Verify.
+var (
+	// ErrInvalidSignature is returned when the signature of a delivery is
+	// missing or does not match.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrStaleTimestamp is returned when the timestamp of a delivery is
+	// missing or outside the tolerance, e.g. for a replayed request.
+	ErrStaleTimestamp = errors.New("webhook timestamp outside tolerance")
+)
+
+// Event is a login or account event, which is the body of a delivery.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data Data      `json:"data"`
+}
+
+// Data are the details of an Event.
+type Data struct {
+	// Method is the login method, e.g. "trezor" or "webauthn".
+	Method string `json:"method,omitempty"`
+	// Subject is the public key or user of a login or registration, or the
+	// locked out public key, client IP or subnet.
+	Subject  string `json:"subject,omitempty"`
+	ClientIP string `json:"clientIp,omitempty"`
+	// Credential is the base64url ID of a WebAuthn credential.
+	Credential string `json:"credential,omitempty"`
+	// Scope is the scope of a lockout, e.g. "key".
+	Scope string `json:"scope,omitempty"`
+	// Until is the end of a lockout.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Endpoint is a receiver of events.
+type Endpoint struct {
+	// URL is the HTTP or HTTPS URL the events are posted to.
+	URL string
+	// Events are the event types delivered to the endpoint. If empty, all
+	// events are delivered.
+	Events []string
+	// Secret is the key of the signatures.
+	Secret []byte
+}
+
+// Subscribed reports whether events of type typ are delivered to e.
+func (e Endpoint) Subscribed(typ string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, event := range e.Events {
+		if event == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that e has an absolute HTTP(S) URL and known event
+// types.
+func (e Endpoint) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL: %q", e.URL)
+	}
+	for _, event := range e.Events {
+		if !knownEvent(event) {
+			return fmt.Errorf("unknown event type %q, must be one of %s", event, strings.Join(EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func knownEvent(typ string) bool {
+	for _, known := range EventTypes {
+		if typ == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature of body sent at timestamp, in Unix seconds,
+// with secret: "sha256=" followed by the hex HMAC-SHA256 of the timestamp,
+// a dot and the body.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp in the header of a delivery
+// with body received at now. The timestamp may differ from now by at most
+// tolerance; if zero, DefaultTolerance is used.
+func Verify(secret []byte, header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	diff := now.Sub(time.Unix(timestamp, 0))
+	if diff > tolerance || diff < -tolerance {
+		return ErrStaleTimestamp
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2021 Kaloyan Raev
+// See LICENSE for copying information.
+
+package webhook_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"phobia.cloud/api/webhook"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t,
+		"sha256=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28",
+		webhook.Sign([]byte("secret"), 1600000000, []byte("{}")))
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"1"}`)
+	now := time.Unix(1600000000, 0)
+
+	header := func(timestamp int64, signature string) http.Header {
+		h := http.Header{}
+		h.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		h.Set(webhook.SignatureHeader, signature)
+		return h
+	}
+	valid := webhook.Sign(secret, now.Unix(), body)
+
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		body   []byte
+		err    error
+	}{
+		{name: "valid", header: header(now.Unix(), valid), body: body},
+		{name: "within tolerance", header: header(now.Unix()-60, webhook.Sign(secret, now.Unix()-60, body)), body: body},
+		{name: "modified body", header: header(now.Unix(), valid), body: []byte(`{"id":"2"}`), err: webhook.ErrInvalidSignature},
+		{name: "other secret", header: header(now.Unix(), webhook.Sign([]byte("other"), now.Unix(), body)), body: body, err: webhook.ErrInvalidSignature},
+		{name: "modified timestamp", header: header(now.Unix()+1, valid), body: body, err: webhook.ErrInvalidSignature},
+		{name: "missing signature", header: header(now.Unix(), ""), body: body, err: webhook.ErrInvalidSignature},
+		{name: "stale", header: header(now.Unix()-600, webhook.Sign(secret, now.Unix()-600, body)), body: body, err: webhook.ErrStaleTimestamp},
+		{name: "future", header: header(now.Unix()+600, webhook.Sign(secret, now.Unix()+600, body)), body: body, err: webhook.ErrStaleTimestamp},
+		{name: "missing timestamp", header: http.Header{webhook.SignatureHeader: {valid}}, body: body, err: webhook.ErrStaleTimestamp},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(secret, tt.header, tt.body, now, 0)
+			if tt.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEndpoint_Subscribed(t *testing.T) {
+	all := webhook.Endpoint{URL: "https://example.com/hook"}
+	for _, typ := range webhook.EventTypes {
+		assert.True(t, all.Subscribed(typ), typ)
+	}
+
+	logins := webhook.Endpoint{URL: "https://example.com/hook", Events: []string{webhook.EventLoginSuccess}}
+	assert.True(t, logins.Subscribed(webhook.EventLoginSuccess))
+	assert.False(t, logins.Subscribed(webhook.EventLockedOut))
+}
+
+func TestEndpoint_Validate(t *testing.T) {
+	secret := []byte("secret")
+	for _, tt := range []struct {
+		name     string
+		endpoint webhook.Endpoint
+		err      string
+	}{
+		{name: "valid", endpoint: webhook.Endpoint{URL: "https://example.com/hook", Events: webhook.EventTypes, Secret: secret}},
+		{name: "relative URL", endpoint: webhook.Endpoint{URL: "/hook", Secret: secret}, err: `invalid endpoint URL: "/hook"`},
+		{name: "other scheme", endpoint: webhook.Endpoint{URL: "ftp://example.com/hook", Secret: secret}, err: `invalid endpoint URL: "ftp://example.com/hook"`},
+		{
+			name:     "unknown event",
+			endpoint: webhook.Endpoint{URL: "https://example.com/hook", Events: []string{"login.failure"}, Secret: secret},
+			err:      `unknown event type "login.failure", must be one of login.success, login.locked_out, credential.registered`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.endpoint.Validate()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}